@@ -0,0 +1,194 @@
+package acl
+
+/*
+ * acl 实现了 ACL 用户、AUTH 认证以及命令和 key 的权限检查
+ */
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DefaultUser 是新连接默认使用的用户，AUTH password 也认证这个用户
+const DefaultUser = "default"
+
+// Manager 保存所有的 ACL 用户
+type Manager struct {
+	mu    sync.RWMutex
+	users map[string]*User
+}
+
+// MakeManager 新建 Manager，只包含 default 用户
+// requirePass 为空时 default 用户不需要密码，否则使用 requirePass 作为密码
+func MakeManager(requirePass string) *Manager {
+	return &Manager{
+		users: map[string]*User{DefaultUser: makeDefaultUser(requirePass)},
+	}
+}
+
+func makeDefaultUser(requirePass string) *User {
+	u := newUser(DefaultUser)
+	rules := []string{"on", "allkeys", "allcommands", "nopass"}
+	if requirePass != "" {
+		rules[3] = ">" + requirePass
+	}
+	for _, rule := range rules {
+		_ = u.applyRule(rule)
+	}
+	return u
+}
+
+// GetUser 返回用户，用户不存在时返回 nil
+func (m *Manager) GetUser(name string) *User {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.users[name]
+}
+
+// Users 返回按照用户名排序的所有用户
+func (m *Manager) Users() []*User {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	users := make([]*User, 0, len(m.users))
+	for _, u := range m.users {
+		users = append(users, u)
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].name < users[j].name
+	})
+	return users
+}
+
+// Authenticate 检查用户名和密码，用户不存在、被禁用或者密码错误时返回 false
+func (m *Manager) Authenticate(name string, password string) bool {
+	u := m.GetUser(name)
+	return u != nil && u.enabled && u.checkPassword(password)
+}
+
+// RuleError 是 ACL SETUSER 中某条规则的错误
+type RuleError struct {
+	Rule string
+	Err  error
+}
+
+func (e *RuleError) Error() string {
+	return fmt.Sprintf("Error in ACL SETUSER modifier '%s': %s", e.Rule, e.Err)
+}
+
+// SetUser 创建用户或者修改已有的用户，任意一条规则有误时不做任何修改
+func (m *Manager) SetUser(name string, rules []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var u *User
+	if old, ok := m.users[name]; ok {
+		u = old.clone()
+	} else {
+		u = newUser(name)
+	}
+	for _, rule := range rules {
+		if err := u.applyRule(rule); err != nil {
+			return &RuleError{Rule: rule, Err: err}
+		}
+	}
+	m.users[name] = u
+	return nil
+}
+
+// DelUser 删除用户，返回删除的用户数
+func (m *Manager) DelUser(names []string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, name := range names {
+		if name == DefaultUser {
+			return 0, errors.New("The 'default' user cannot be removed")
+		}
+	}
+	deleted := 0
+	for _, name := range names {
+		if _, ok := m.users[name]; ok {
+			delete(m.users, name)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// SetRequirePass 修改 default 用户的密码，requirePass 为空时 default 用户不再需要密码
+func (m *Manager) SetRequirePass(requirePass string) {
+	rules := []string{"resetpass", "nopass"}
+	if requirePass != "" {
+		rules[1] = ">" + requirePass
+	}
+	_ = m.SetUser(DefaultUser, rules)
+}
+
+// LoadFile 从 aclfile 加载用户并替换现有的所有用户
+// 文件中每行为一个用户，格式与 ACL LIST 相同，以 # 开头的行是注释
+// 文件中没有 default 用户时使用默认的 default 用户，任意一行有误时不做任何修改
+func (m *Manager) LoadFile(filename string, requirePass string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	users := make(map[string]*User)
+	scanner := bufio.NewScanner(file)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] != "user" {
+			return fmt.Errorf("%s:%d: line should start with user keyword", filename, lineNum)
+		}
+		name := fields[1]
+		if _, ok := users[name]; ok {
+			return fmt.Errorf("%s:%d: duplicate user '%s' found", filename, lineNum, name)
+		}
+		u := newUser(name)
+		for _, rule := range fields[2:] {
+			if err := u.applyRule(rule); err != nil {
+				return fmt.Errorf("%s:%d: %s. Error in user declaration '%s'", filename, lineNum, err, rule)
+			}
+		}
+		users[name] = u
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if _, ok := users[DefaultUser]; !ok {
+		users[DefaultUser] = makeDefaultUser(requirePass)
+	}
+
+	m.mu.Lock()
+	m.users = users
+	m.mu.Unlock()
+	return nil
+}
+
+// SaveFile 将所有用户写入 aclfile，先写入临时文件再替换，避免写入失败时损坏原来的文件
+func (m *Manager) SaveFile(filename string) error {
+	var builder strings.Builder
+	for _, u := range m.Users() {
+		builder.WriteString(u.Describe())
+		builder.WriteString("\n")
+	}
+	tmpFile := filename + ".tmp"
+	if err := os.WriteFile(tmpFile, []byte(builder.String()), 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpFile, filename); err != nil {
+		_ = os.Remove(tmpFile)
+		return err
+	}
+	return nil
+}
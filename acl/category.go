@@ -0,0 +1,108 @@
+package acl
+
+import "sort"
+
+// categories 是命令的分类，用于 +@category 和 -@category 规则
+// all 分类包含所有命令，包括没有出现在任何分类中的命令
+// flushdb、keys 和 scan 没有 key 参数，会访问 key 模式之外的 key，只属于 dangerous 分类，
+// 只能通过 +@dangerous、+@all 或者单独授权获得
+var categories = map[string][]string{
+	"keyspace": {
+		"del", "exists", "type", "rename", "renamenx",
+		"expire", "pexpire", "expireat", "pexpireat", "ttl", "pttl", "expiretime", "pexpiretime", "persist",
+	},
+	"read": {
+		"exists", "type", "ttl", "pttl", "expiretime", "pexpiretime",
+		"get", "mget", "strlen", "getrange",
+		"getbit", "bitcount", "bitpos", "bitfield_ro",
+		"pfcount",
+		"geopos", "geodist", "geohash", "geosearch", "georadius", "georadiusbymember",
+		"llen", "lindex", "lrange",
+		"hget", "hmget", "hexists", "hlen", "hstrlen", "hkeys", "hvals", "hgetall", "hrandfield",
+		"sismember", "scard", "smembers", "sinter", "sunion", "sdiff", "sscan", "srandmember",
+		"zscore", "zmscore", "zcard", "zcount", "zrank", "zrevrank", "zrange", "zrevrange",
+		"zrangebyscore", "zrevrangebyscore",
+		"xlen", "xrange", "xrevrange", "xread", "xpending", "xinfo",
+	},
+	"write": {
+		"del", "rename", "renamenx", "expire", "pexpire", "expireat", "pexpireat", "persist",
+		"set", "setnx", "setex", "psetex", "mset", "msetnx", "getset", "incr", "incrby", "decr", "decrby",
+		"append", "setrange",
+		"setbit", "bitop", "bitfield",
+		"pfadd", "pfmerge",
+		"geoadd", "geosearchstore", "georadius", "georadiusbymember",
+		"lpush", "lpushx", "rpush", "rpushx", "lpop", "rpop", "rpoplpush", "lrem", "lset",
+		"blpop", "brpop", "brpoplpush", "blmove", "blmpop",
+		"hset", "hmset", "hsetnx", "hdel", "hincrby", "hincrbyfloat",
+		"sadd", "srem", "spop", "sinterstore", "sunionstore", "sdiffstore",
+		"zadd", "zincrby", "zrem", "zremrangebyrank", "zremrangebyscore", "zpopmin", "zpopmax",
+		"zunionstore", "zinterstore", "zdiffstore",
+		"xadd", "xtrim", "xdel", "xsetid", "xreadgroup", "xack", "xclaim", "xautoclaim", "xgroup",
+	},
+	"string": {
+		"set", "setnx", "setex", "psetex", "mset", "mget", "msetnx", "get", "getset",
+		"incr", "incrby", "decr", "decrby", "strlen", "append", "setrange", "getrange",
+	},
+	"bitmap":      {"setbit", "getbit", "bitcount", "bitpos", "bitop", "bitfield", "bitfield_ro"},
+	"hyperloglog": {"pfadd", "pfcount", "pfmerge"},
+	"geo": {
+		"geoadd", "geopos", "geodist", "geohash", "geosearch", "geosearchstore", "georadius", "georadiusbymember",
+	},
+	"list": {
+		"lpush", "lpushx", "rpush", "rpushx", "lpop", "rpop", "rpoplpush", "lrem", "llen", "lindex", "lset", "lrange",
+		"blpop", "brpop", "brpoplpush", "blmove", "blmpop",
+	},
+	"hash": {
+		"hset", "hmset", "hsetnx", "hget", "hmget", "hdel", "hexists", "hlen", "hstrlen",
+		"hkeys", "hvals", "hgetall", "hincrby", "hincrbyfloat", "hrandfield",
+	},
+	"set": {
+		"sadd", "sismember", "srem", "spop", "scard", "smembers", "sinter", "sinterstore",
+		"sunion", "sunionstore", "sdiff", "sdiffstore", "sscan", "srandmember",
+	},
+	"sortedset": {
+		"zadd", "zincrby", "zrem", "zscore", "zmscore", "zcard", "zcount", "zrank", "zrevrank",
+		"zrange", "zrevrange", "zrangebyscore", "zrevrangebyscore", "zremrangebyrank", "zremrangebyscore",
+		"zpopmin", "zpopmax", "zunionstore", "zinterstore", "zdiffstore",
+	},
+	"stream": {
+		"xadd", "xtrim", "xlen", "xdel", "xrange", "xrevrange", "xsetid", "xread", "xreadgroup",
+		"xack", "xpending", "xclaim", "xautoclaim", "xgroup", "xinfo",
+	},
+	"blocking":    {"blpop", "brpop", "brpoplpush", "blmove", "blmpop", "xread", "xreadgroup"},
+	"pubsub":      {"subscribe", "unsubscribe", "psubscribe", "punsubscribe", "publish", "pubsub"},
+	"transaction": {"multi", "exec", "discard", "watch", "unwatch"},
+	"connection":  {"ping", "select", "client", "auth"},
+	"admin":       {"acl", "client", "info", "config", "slowlog", "monitor", "save", "bgsave", "bgrewriteaof"},
+	"dangerous":   {"acl", "client", "info", "config", "slowlog", "monitor", "save", "bgsave", "lastsave", "bgrewriteaof", "keys", "scan", "flushdb"},
+}
+
+// CategoryCommands 返回分类中的命令，分类不存在时 ok 为 false
+func CategoryCommands(category string) (commands []string, ok bool) {
+	if category != "all" {
+		commands, ok = categories[category]
+		return commands, ok
+	}
+	seen := make(map[string]struct{})
+	for _, cmds := range categories {
+		for _, cmd := range cmds {
+			if _, ok := seen[cmd]; !ok {
+				seen[cmd] = struct{}{}
+				commands = append(commands, cmd)
+			}
+		}
+	}
+	sort.Strings(commands)
+	return commands, true
+}
+
+// CategoryNames 返回所有分类的名称
+func CategoryNames() []string {
+	names := make([]string, 0, len(categories)+1)
+	names = append(names, "all")
+	for name := range categories {
+		names = append(names, name)
+	}
+	sort.Strings(names[1:])
+	return names
+}
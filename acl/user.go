@@ -0,0 +1,310 @@
+package acl
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"github.com/jujunwang/Mudis/lib/wildcard"
+	"sort"
+	"strings"
+)
+
+// User 是一个 ACL 用户
+// User 创建后不再修改，ACL SETUSER 会在副本上修改后替换原来的用户，因此可以不加锁读取
+type User struct {
+	name    string
+	enabled bool
+	// nopass 为 true 时任意密码都可以认证
+	nopass bool
+	// passwords 保存密码的 sha256 摘要
+	passwords map[string]struct{}
+
+	// keyPatterns 是允许访问的 key 的模式，allKeys 为 true 时可以访问所有 key
+	allKeys     bool
+	keyPatterns []string
+	keyMatchers []*wildcard.Pattern
+
+	// allCommands 是命令权限的基础，commands 中的命令按照其中的值允许或禁止
+	allCommands bool
+	commands    map[string]bool
+	// cmdRules 是 allCommands 之后依次应用的命令规则，用于 ACL LIST 展示
+	cmdRules []string
+}
+
+var (
+	errSyntax          = errors.New("Syntax error")
+	errUnknownCategory = errors.New("Unknown command or category name in ACL")
+	errBadHash         = errors.New("The password hash must be exactly 64 characters and contain only lowercase hexadecimal characters")
+	errNoSuchPassword  = errors.New("no such password")
+)
+
+func newUser(name string) *User {
+	return &User{
+		name:      name,
+		passwords: make(map[string]struct{}),
+		commands:  make(map[string]bool),
+	}
+}
+
+// Name 返回用户名
+func (u *User) Name() string {
+	return u.name
+}
+
+// Enabled 返回用户是否启用
+func (u *User) Enabled() bool {
+	return u.enabled
+}
+
+// NoPass 返回用户是否不需要密码
+func (u *User) NoPass() bool {
+	return u.nopass
+}
+
+// clone 返回用户的副本
+func (u *User) clone() *User {
+	c := *u
+	c.passwords = make(map[string]struct{}, len(u.passwords))
+	for hash := range u.passwords {
+		c.passwords[hash] = struct{}{}
+	}
+	c.keyPatterns = append([]string(nil), u.keyPatterns...)
+	c.keyMatchers = append([]*wildcard.Pattern(nil), u.keyMatchers...)
+	c.commands = make(map[string]bool, len(u.commands))
+	for cmd, allowed := range u.commands {
+		c.commands[cmd] = allowed
+	}
+	c.cmdRules = append([]string(nil), u.cmdRules...)
+	return &c
+}
+
+// checkPassword 检查密码是否正确
+func (u *User) checkPassword(password string) bool {
+	if u.nopass {
+		return true
+	}
+	_, ok := u.passwords[hashPassword(password)]
+	return ok
+}
+
+// CanRun 判断用户是否可以执行命令，cmdName 为小写的命令名
+func (u *User) CanRun(cmdName string) bool {
+	if allowed, ok := u.commands[cmdName]; ok {
+		return allowed
+	}
+	return u.allCommands
+}
+
+// CanAccessKey 判断用户是否可以访问 key
+func (u *User) CanAccessKey(key string) bool {
+	if u.allKeys {
+		return true
+	}
+	for _, matcher := range u.keyMatchers {
+		if matcher.IsMatch(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// applyRule 修改用户的一条规则
+func (u *User) applyRule(rule string) error {
+	if rule == "" {
+		return errSyntax
+	}
+	switch strings.ToLower(rule) {
+	case "on":
+		u.enabled = true
+		return nil
+	case "off":
+		u.enabled = false
+		return nil
+	case "nopass":
+		u.nopass = true
+		u.passwords = make(map[string]struct{})
+		return nil
+	case "resetpass":
+		u.nopass = false
+		u.passwords = make(map[string]struct{})
+		return nil
+	case "allkeys":
+		u.allKeys = true
+		u.keyPatterns = nil
+		u.keyMatchers = nil
+		return nil
+	case "resetkeys":
+		u.allKeys = false
+		u.keyPatterns = nil
+		u.keyMatchers = nil
+		return nil
+	case "allcommands":
+		u.setAllCommands(true)
+		return nil
+	case "nocommands":
+		u.setAllCommands(false)
+		return nil
+	case "reset":
+		for _, r := range []string{"resetpass", "resetkeys", "off", "nocommands"} {
+			_ = u.applyRule(r)
+		}
+		return nil
+	}
+	value := rule[1:]
+	switch rule[0] {
+	case '>':
+		u.passwords[hashPassword(value)] = struct{}{}
+		u.nopass = false
+	case '<':
+		hash := hashPassword(value)
+		if _, ok := u.passwords[hash]; !ok {
+			return errNoSuchPassword
+		}
+		delete(u.passwords, hash)
+	case '#':
+		if !isValidHash(value) {
+			return errBadHash
+		}
+		u.passwords[value] = struct{}{}
+		u.nopass = false
+	case '!':
+		if !isValidHash(value) {
+			return errBadHash
+		}
+		if _, ok := u.passwords[value]; !ok {
+			return errNoSuchPassword
+		}
+		delete(u.passwords, value)
+	case '~':
+		u.addKeyPattern(value)
+	case '+', '-':
+		return u.applyCommandRule(rule)
+	default:
+		return errSyntax
+	}
+	return nil
+}
+
+func (u *User) addKeyPattern(pattern string) {
+	if u.allKeys {
+		return
+	}
+	if pattern == "*" {
+		u.allKeys = true
+		u.keyPatterns = nil
+		u.keyMatchers = nil
+		return
+	}
+	for _, p := range u.keyPatterns {
+		if p == pattern {
+			return
+		}
+	}
+	u.keyPatterns = append(u.keyPatterns, pattern)
+	u.keyMatchers = append(u.keyMatchers, wildcard.CompilePattern(pattern))
+}
+
+// setAllCommands 允许或禁止所有命令，并清除之前的命令规则
+func (u *User) setAllCommands(allowed bool) {
+	u.allCommands = allowed
+	u.commands = make(map[string]bool)
+	u.cmdRules = nil
+}
+
+// applyCommandRule 应用 +command、-command、+@category 和 -@category 规则
+func (u *User) applyCommandRule(rule string) error {
+	allowed := rule[0] == '+'
+	name := strings.ToLower(rule[1:])
+	if name == "" {
+		return errSyntax
+	}
+	if name[0] != '@' {
+		u.commands[name] = allowed
+		u.cmdRules = append(u.cmdRules, rule[:1]+name)
+		return nil
+	}
+	if name == "@all" {
+		u.setAllCommands(allowed)
+		return nil
+	}
+	commands, ok := CategoryCommands(name[1:])
+	if !ok {
+		return errUnknownCategory
+	}
+	for _, cmd := range commands {
+		u.commands[cmd] = allowed
+	}
+	u.cmdRules = append(u.cmdRules, rule[:1]+name)
+	return nil
+}
+
+// CommandRules 返回用户的命令规则，例如 +@all -@dangerous
+func (u *User) CommandRules() string {
+	base := "-@all"
+	if u.allCommands {
+		base = "+@all"
+	}
+	return strings.Join(append([]string{base}, u.cmdRules...), " ")
+}
+
+// KeyRules 返回用户的 key 规则，例如 ~app:* ~cache:*
+func (u *User) KeyRules() string {
+	if u.allKeys {
+		return "~*"
+	}
+	rules := make([]string, 0, len(u.keyPatterns))
+	for _, pattern := range u.keyPatterns {
+		rules = append(rules, "~"+pattern)
+	}
+	return strings.Join(rules, " ")
+}
+
+// PasswordHashes 返回排序后的密码摘要
+func (u *User) PasswordHashes() []string {
+	hashes := make([]string, 0, len(u.passwords))
+	for hash := range u.passwords {
+		hashes = append(hashes, hash)
+	}
+	sort.Strings(hashes)
+	return hashes
+}
+
+// Describe 返回 ACL LIST 和 aclfile 格式的用户描述，例如 user default on nopass ~* +@all
+func (u *User) Describe() string {
+	parts := []string{"user", u.name}
+	if u.enabled {
+		parts = append(parts, "on")
+	} else {
+		parts = append(parts, "off")
+	}
+	if u.nopass {
+		parts = append(parts, "nopass")
+	}
+	for _, hash := range u.PasswordHashes() {
+		parts = append(parts, "#"+hash)
+	}
+	if keys := u.KeyRules(); keys != "" {
+		parts = append(parts, keys)
+	} else {
+		parts = append(parts, "resetkeys")
+	}
+	parts = append(parts, u.CommandRules())
+	return strings.Join(parts, " ")
+}
+
+func hashPassword(password string) string {
+	sum := sha256.Sum256([]byte(password))
+	return hex.EncodeToString(sum[:])
+}
+
+func isValidHash(hash string) bool {
+	if len(hash) != sha256.Size*2 {
+		return false
+	}
+	for _, c := range hash {
+		if !(c >= '0' && c <= '9') && !(c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
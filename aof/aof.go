@@ -1,17 +1,21 @@
 package aof
 
 import (
+	"bufio"
+	"fmt"
 	"github.com/jujunwang/Mudis/config"
 	databaseface "github.com/jujunwang/Mudis/interface/database"
 	"github.com/jujunwang/Mudis/lib/logger"
 	"github.com/jujunwang/Mudis/lib/utils"
 	"github.com/jujunwang/Mudis/resp/connection"
-	"github.com/jujunwang/Mudis/resp/parser"
 	"github.com/jujunwang/Mudis/resp/reply"
 	"io"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"sync"
+	"time"
 )
 
 // CmdLine 代表命令
@@ -19,138 +23,490 @@ type CmdLine = [][]byte
 
 const (
 	aofQueueSize = 1 << 16
+	// retryInterval 是写入失败后重试以及 everysec 策略下 fsync 的间隔
+	retryInterval = time.Second
 )
 
+// appendfsync 支持的策略
+const (
+	// FsyncAlways 在回复客户端之前将命令写入文件并 fsync
+	FsyncAlways = "always"
+	// FsyncEverySec 每秒 fsync 一次
+	FsyncEverySec = "everysec"
+	// FsyncNo 不主动 fsync，由操作系统决定何时写入磁盘
+	FsyncNo = "no"
+)
+
+// IsFsyncPolicy 判断是否是支持的 appendfsync 策略
+func IsFsyncPolicy(policy string) bool {
+	return policy == FsyncAlways || policy == FsyncEverySec || policy == FsyncNo
+}
+
+// payload 中的多条命令会被连续写入 AOF 文件，例如事务中的 MULTI ... EXEC
 type payload struct {
-	cmdLine CmdLine
-	dbIndex int
+	cmdLines []CmdLine
+	dbIndex  int
+	// done 不为 nil 时在命令写入文件并 fsync 后收到写入的结果，用于 appendfsync always
+	done chan error
 }
 
 // AofHandler 从channel中获取数据，向AOF文件中写入数据
 type AofHandler struct {
-	db          databaseface.Database
-	aofChan     chan *payload
-	aofFile     *os.File
-	aofFilename string
+	db databaseface.Database
+	// tmpDBMaker 新建重写 AOF 时用于重放 AOF 的临时数据库
+	tmpDBMaker func() databaseface.DBEngine
+	aofChan    chan *payload
+	// dir 是保存 AOF 文件和清单的目录
+	dir string
+	// manifest 记录目录中的 base 文件和 incr 文件，修改时需要持有 pausingAof 的写锁
+	manifest *manifest
+	// aofFile 是正在写入的 incr 文件，即清单中最后一个 incr 文件
+	aofFile *os.File
+	// sealedSize 是清单中除了正在写入的 incr 文件之外的文件大小之和
+	sealedSize int64
 	// 当AOF 执行完毕时，AOF goroutine会通过这个管道向主程序发送消息
 	aofFinished chan struct{}
-	// 解析 AOF 文件的时候，需要加锁
+	// 写入 AOF 文件时持有读锁，重写 AOF 时持有写锁暂停写入
 	pausingAof sync.RWMutex
 	// 记录上一条指令工作在哪个db，以此来判断需不需要select
 	currentDB int
+	// buf 是还没有写入文件的数据，写入失败时保留在这里等待重试
+	buf []byte
+	// aofSize 是文件中完整写入的数据的大小，只写入了部分数据时将文件截断到这个大小
+	aofSize int64
+	// lastWriteErr 和 lastFsyncErr 是最近一次写入和 fsync 的错误，重试成功后清空
+	statusMu     sync.Mutex
+	lastWriteErr error
+	lastFsyncErr error
+	// rewrite 记录 AOF 重写的状态
+	rewrite rewriteState
+}
+
+// Exists 判断是否有 AOF 数据，即 AOF 目录中有清单或者存在旧版本的单个 AOF 文件
+func Exists() bool {
+	if _, err := os.Stat(filepath.Join(config.Properties().AppendDirName,
+		manifestName(filepath.Base(config.Properties().AppendFilename)))); err == nil {
+		return true
+	}
+	info, err := os.Stat(config.Properties().AppendFilename)
+	return err == nil && !info.IsDir()
 }
 
-// NewAOFHandler 新建一个新的 aof.AofHandler
-func NewAOFHandler(db databaseface.Database) (*AofHandler, error) {
-	handler := &AofHandler{}
-	handler.aofFilename = config.Properties.AppendFilename
-	handler.db = db
-	handler.LoadAof(0)
-	aofFile, err := os.OpenFile(handler.aofFilename, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0600)
+func makeHandler(db databaseface.Database, tmpDBMaker func() databaseface.DBEngine) *AofHandler {
+	return &AofHandler{
+		db:         db,
+		tmpDBMaker: tmpDBMaker,
+		dir:        config.Properties().AppendDirName,
+	}
+}
+
+// NewAOFHandler 新建一个新的 aof.AofHandler，并按照清单中的顺序加载 AOF 文件
+// 存在旧版本的单个 AOF 文件时，加载后将它移动到 AOF 目录中作为 base 文件
+func NewAOFHandler(db databaseface.Database, tmpDBMaker func() databaseface.DBEngine) (*AofHandler, error) {
+	handler := makeHandler(db, tmpDBMaker)
+	basename := filepath.Base(config.Properties().AppendFilename)
+	m, err := readManifest(handler.dir, basename)
 	if err != nil {
 		return nil, err
 	}
+	if m == nil {
+		m = &manifest{basename: basename}
+		if err := handler.upgrade(m, config.Properties().AppendFilename); err != nil {
+			return nil, err
+		}
+	} else {
+		files := m.files()
+		for i, info := range files {
+			// 只有最后一个文件的末尾可能是不完整的命令
+			last := i == len(files)-1
+			dbIndex, err := handler.loadFile(filepath.Join(handler.dir, info.name), last)
+			if err != nil {
+				return nil, err
+			}
+			if last && info.fileType == incrFile {
+				handler.currentDB = dbIndex
+			}
+		}
+	}
+	// 删除上次重写中断时留下的临时文件
+	_ = os.Remove(handler.rewriteTmpName(m))
+	if err := handler.start(m, false); err != nil {
+		return nil, err
+	}
+	// 加载后的文件大小作为自动重写的基准
+	handler.rewrite.baseSize = handler.FileSize()
+	return handler, nil
+}
+
+// upgrade 加载旧版本的单个 AOF 文件，并将它移动到 AOF 目录中作为 base 文件
+// 先在 AOF 目录中创建 base 文件并保存清单，再删除旧文件，中途崩溃时旧文件或者清单总有一个可以加载
+func (handler *AofHandler) upgrade(m *manifest, filename string) error {
+	info, err := os.Stat(filename)
+	if err != nil || info.IsDir() {
+		return nil
+	}
+	if _, err := handler.loadFile(filename, true); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(handler.dir, 0755); err != nil {
+		return err
+	}
+	base := m.newBase()
+	baseName := filepath.Join(handler.dir, base.name)
+	if err := linkOrCopy(filename, baseName); err != nil {
+		return err
+	}
+	if err := m.persist(handler.dir); err != nil {
+		return err
+	}
+	if err := os.Remove(filename); err != nil {
+		logger.Warn("remove " + filename + " error: " + err.Error())
+	}
+	logger.Info("moved " + filename + " to " + baseName)
+	return nil
+}
+
+// linkOrCopy 为 src 创建硬链接 dst，无法创建硬链接时(例如不在同一个文件系统)复制文件
+// dst 已经存在时覆盖，返回前将 dst 写入磁盘
+func linkOrCopy(src string, dst string) error {
+	_ = os.Remove(dst)
+	if err := os.Link(src, dst); err != nil {
+		if err := copyFile(src, dst); err != nil {
+			_ = os.Remove(dst)
+			return err
+		}
+	}
+	file, err := os.Open(dst)
+	if err != nil {
+		return err
+	}
+	err = file.Sync()
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func copyFile(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// NewEmptyAOFHandler 新建 AofHandler 并使用一个新的空 incr 文件，不加载 AOF 文件
+// 用于运行时开启 AOF，由调用方将当前的数据写入 AOF，原来的 AOF 文件在新的清单生效后删除
+func NewEmptyAOFHandler(db databaseface.Database, tmpDBMaker func() databaseface.DBEngine) (*AofHandler, error) {
+	handler := makeHandler(db, tmpDBMaker)
+	basename := filepath.Base(config.Properties().AppendFilename)
+	old, err := readManifest(handler.dir, basename)
+	if err != nil {
+		logger.Warn("ignore AOF manifest: " + err.Error())
+		old = nil
+	}
+	m := &manifest{basename: basename}
+	if old != nil {
+		// 继续使用之前的序号，避免新文件与待删除的文件重名
+		m.baseSeq = old.baseSeq
+		m.incrSeq = old.incrSeq
+	}
+	if err := handler.start(m, true); err != nil {
+		return nil, err
+	}
+	if old != nil {
+		handler.removeFiles(old.files())
+	}
+	return handler, nil
+}
+
+// start 打开清单中最后一个 incr 文件(没有时新建一个)，保存清单并启动写入 AOF 的 goroutine
+func (handler *AofHandler) start(m *manifest, newIncr bool) error {
+	if err := os.MkdirAll(handler.dir, 0755); err != nil {
+		return err
+	}
+	incr := m.lastIncr()
+	if incr == nil || newIncr {
+		incr = m.newIncr()
+	}
+	aofFile, err := os.OpenFile(filepath.Join(handler.dir, incr.name), os.O_APPEND|os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := aofFile.Stat()
+	if err == nil {
+		err = m.persist(handler.dir)
+	}
+	if err != nil {
+		_ = aofFile.Close()
+		return err
+	}
+	handler.manifest = m
 	handler.aofFile = aofFile
+	handler.aofSize = info.Size()
+	handler.sealedSize = handler.filesSize(m.files()[:len(m.files())-1])
 	handler.aofChan = make(chan *payload, aofQueueSize)
 	handler.aofFinished = make(chan struct{})
 	go func() {
 		handler.handleAof()
 	}()
-	return handler, nil
+	return nil
+}
+
+// filesSize 返回 AOF 目录中 files 的大小之和
+func (handler *AofHandler) filesSize(files []*aofFileInfo) int64 {
+	var size int64
+	for _, file := range files {
+		if info, err := os.Stat(filepath.Join(handler.dir, file.name)); err == nil {
+			size += info.Size()
+		}
+	}
+	return size
 }
 
-// AddAof 将命令塞到 channel 里
-func (handler *AofHandler) AddAof(dbIndex int, cmdLine CmdLine) {
-	if config.Properties.AppendOnly && handler.aofChan != nil {
-		handler.aofChan <- &payload{
-			cmdLine: cmdLine,
-			dbIndex: dbIndex,
+// removeFiles 删除 AOF 目录中已经不在清单中的文件
+func (handler *AofHandler) removeFiles(files []*aofFileInfo) {
+	for _, file := range files {
+		if err := os.Remove(filepath.Join(handler.dir, file.name)); err != nil && !os.IsNotExist(err) {
+			logger.Warn("remove AOF file error: " + err.Error())
 		}
 	}
 }
 
+// AddAof 将命令塞到 channel 里，同一次调用中的多条命令保证连续写入
+// appendfsync 为 always 时返回的 channel 在命令写入文件并 fsync 后收到写入的错误，否则返回 nil
+// 调用方可以先释放 key 的锁再等待，命令在 AOF 中的顺序由调用 AddAof 的顺序决定
+// 运行时关闭 AOF 会关闭 AofHandler，因此这里不再检查 appendonly 配置
+func (handler *AofHandler) AddAof(dbIndex int, cmdLines ...CmdLine) <-chan error {
+	return handler.addAof(dbIndex, config.Properties().AppendFsync == FsyncAlways, cmdLines)
+}
+
+// AddAofNoWait 与 AddAof 相同，但是不等待 fsync，用于一次写入大量已有的数据
+func (handler *AofHandler) AddAofNoWait(dbIndex int, cmdLines ...CmdLine) {
+	handler.addAof(dbIndex, false, cmdLines)
+}
+
+func (handler *AofHandler) addAof(dbIndex int, wait bool, cmdLines []CmdLine) <-chan error {
+	if handler.aofChan == nil || len(cmdLines) == 0 {
+		return nil
+	}
+	p := &payload{
+		cmdLines: cmdLines,
+		dbIndex:  dbIndex,
+	}
+	if wait {
+		p.done = make(chan error, 1)
+	}
+	handler.aofChan <- p
+	return p.done
+}
+
 // handleAof 从 channel 里读命令并且将命令写入 AOF 文件
+// 同时定期重试写入失败的数据，并在 appendfsync 为 everysec 时每秒 fsync
 func (handler *AofHandler) handleAof() {
-	handler.currentDB = 0
-	for p := range handler.aofChan {
-		//防止其他 goroutine 暂停 aof 过程
-		handler.pausingAof.RLock()
-		if p.dbIndex != handler.currentDB {
-			// 切换db
-			data := reply.MakeMultiBulkReply(utils.ToCmdLine("SELECT", strconv.Itoa(p.dbIndex))).ToBytes()
-			_, err := handler.aofFile.Write(data)
-			if err != nil {
-				logger.Warn(err)
-				continue
+	aofChan := handler.aofChan
+	ticker := time.NewTicker(retryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case p, ok := <-aofChan:
+			if !ok {
+				// 关闭前写入剩余的数据并 fsync
+				handler.pausingAof.RLock()
+				handler.flush(true)
+				handler.pausingAof.RUnlock()
+				handler.aofFinished <- struct{}{}
+				return
 			}
-			handler.currentDB = p.dbIndex
+			handler.writePayload(p)
+		case <-ticker.C:
+			handler.pausingAof.RLock()
+			handler.flush(config.Properties().AppendFsync == FsyncEverySec || handler.fsyncFailed())
+			handler.pausingAof.RUnlock()
 		}
-		// 将用户的指令变成 RESP 协议的格式
-		data := reply.MakeMultiBulkReply(p.cmdLine).ToBytes()
-		_, err := handler.aofFile.Write(data)
+	}
+}
+
+// writePayload 将命令转换为 RESP 格式并写入文件
+func (handler *AofHandler) writePayload(p *payload) {
+	//防止其他 goroutine 暂停 aof 过程
+	handler.pausingAof.RLock()
+	defer handler.pausingAof.RUnlock()
+	if p.dbIndex != handler.currentDB {
+		// 切换db
+		handler.buf = append(handler.buf,
+			reply.MakeMultiBulkReply(utils.ToCmdLine("SELECT", strconv.Itoa(p.dbIndex))).ToBytes()...)
+		handler.currentDB = p.dbIndex
+	}
+	for _, cmdLine := range p.cmdLines {
+		handler.buf = append(handler.buf, reply.MakeMultiBulkReply(cmdLine).ToBytes()...)
+	}
+	err := handler.flush(config.Properties().AppendFsync == FsyncAlways)
+	if p.done != nil {
+		// 写入失败时数据仍在 buf 中等待重试，此时不能告诉客户端命令已经持久化
+		p.done <- err
+	}
+}
+
+// flush 将 buf 写入文件，sync 为 true 时之后进行 fsync，调用方需要持有 pausingAof 的读锁
+// 写入失败时数据保留在 buf 中，由 handleAof 定期重试，返回写入或 fsync 的错误
+func (handler *AofHandler) flush(sync bool) error {
+	if len(handler.buf) > 0 {
+		n, err := handler.aofFile.Write(handler.buf)
 		if err != nil {
-			logger.Warn(err)
+			// 不完整的命令会导致 AOF 无法加载，截断后整体重试
+			if n > 0 {
+				if truncErr := handler.aofFile.Truncate(handler.aofSize); truncErr != nil {
+					logger.Warn("truncate aof error: " + truncErr.Error())
+				}
+			}
+			handler.setWriteErr(err)
+			logger.Warn("write aof error: " + err.Error())
+			return err
 		}
-		handler.pausingAof.RUnlock()
+		handler.aofSize += int64(n)
+		handler.buf = handler.buf[:0]
+		handler.setWriteErr(nil)
 	}
-	handler.aofFinished <- struct{}{}
+	if sync {
+		err := handler.aofFile.Sync()
+		handler.setFsyncErr(err)
+		if err != nil {
+			logger.Warn("fsync aof error: " + err.Error())
+			return err
+		}
+	}
+	return nil
 }
 
-// LoadAof 读 aof 文件
-func (handler *AofHandler) LoadAof(maxBytes int) {
-	// 删除 aofChan 防止再次重写
-	aofChan := handler.aofChan
-	handler.aofChan = nil
-	defer func(aofChan chan *payload) {
-		handler.aofChan = aofChan
-	}(aofChan)
+func (handler *AofHandler) setWriteErr(err error) {
+	handler.statusMu.Lock()
+	handler.lastWriteErr = err
+	handler.statusMu.Unlock()
+}
+
+func (handler *AofHandler) setFsyncErr(err error) {
+	handler.statusMu.Lock()
+	handler.lastFsyncErr = err
+	handler.statusMu.Unlock()
+}
+
+func (handler *AofHandler) fsyncFailed() bool {
+	handler.statusMu.Lock()
+	defer handler.statusMu.Unlock()
+	return handler.lastFsyncErr != nil
+}
 
-	file, err := os.Open(handler.aofFilename)
+// LastWriteErr 返回最近一次写入或 fsync AOF 文件的错误，重试成功后返回 nil
+func (handler *AofHandler) LastWriteErr() error {
+	handler.statusMu.Lock()
+	defer handler.statusMu.Unlock()
+	if handler.lastWriteErr != nil {
+		return handler.lastWriteErr
+	}
+	return handler.lastFsyncErr
+}
+
+// QueueLen 返回还在队列中等待写入 AOF 文件的命令批数
+func (handler *AofHandler) QueueLen() int {
+	return len(handler.aofChan)
+}
+
+// FileSize 返回清单中所有 AOF 文件的大小之和
+func (handler *AofHandler) FileSize() int64 {
+	// 重写时会替换 aofFile
+	handler.pausingAof.RLock()
+	defer handler.pausingAof.RUnlock()
+	if handler.aofFile == nil {
+		return 0
+	}
+	info, err := handler.aofFile.Stat()
 	if err != nil {
-		if _, ok := err.(*os.PathError); ok {
-			return
-		}
-		logger.Warn(err)
-		return
+		return handler.sealedSize
 	}
-	defer file.Close()
+	return handler.sealedSize + info.Size()
+}
 
-	var reader io.Reader
-	if maxBytes > 0 {
-		reader = io.LimitReader(file, int64(maxBytes))
-	} else {
-		reader = file
+// loadFile 加载一个 AOF 文件，返回文件末尾所在的 DB，每个文件都从 DB 0 开始
+// 文件末尾的命令不完整时，allowTruncated 为 true 且 aof-load-truncated 为 yes 时截断文件，否则返回错误
+// 文件中间损坏时返回包含位置的错误
+func (handler *AofHandler) loadFile(filename string, allowTruncated bool) (int, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return 0, err
 	}
-	// 解析AOF文件
-	ch := parser.ParseStream(reader)
+	defer file.Close()
+
+	cr := &cmdReader{r: bufio.NewReader(file)}
 	//用来记录工作在哪个db，以判断用不用切换db
 	fakeConn := &connection.FakeConn{}
-	for p := range ch {
-		if p.Err != nil {
-			if p.Err == io.EOF {
-				break
+	// validOffset 是最后一条完整命令的结束位置，txStart 是还没有结束的事务的 MULTI 的位置
+	var validOffset int64
+	txStart := int64(-1)
+	for {
+		cmdStart := cr.offset
+		cmdLine, err := cr.readCommand()
+		if err == io.EOF {
+			break
+		}
+		if err == errTruncated {
+			txStart = -1
+			if err := handleTruncated(filename, validOffset, allowTruncated); err != nil {
+				return 0, err
 			}
-			logger.Error("parse error: " + p.Err.Error())
-			continue
+			break
+		}
+		if err != nil {
+			return 0, fmt.Errorf("%s: %v", filename, err)
+		}
+		cmdName := strings.ToLower(string(cmdLine[0]))
+		if cmdName == "multi" {
+			txStart = cmdStart
 		}
-		if p.Data == nil {
-			logger.Error("empty payload")
-			continue
+		ret := handler.db.Exec(fakeConn, cmdLine)
+		if errReply, ok := ret.(reply.ErrorReply); ok {
+			logger.Error(fmt.Sprintf("%s: exec '%s' at offset %d err: %s", filename, cmdName, cmdStart, errReply.Error()))
 		}
-		r, ok := p.Data.(*reply.MultiBulkReply)
-		if !ok {
-			logger.Error("require multi bulk reply")
-			continue
+		if cmdName == "exec" || cmdName == "discard" {
+			txStart = -1
 		}
-		ret := handler.db.Exec(fakeConn, r.Args)
-		if reply.IsErrorReply(ret) {
-			logger.Error("exec err", err)
+		if txStart < 0 {
+			validOffset = cr.offset
+		}
+	}
+	if txStart >= 0 {
+		// 事务只写入了一部分，其中的命令没有执行
+		if err := handleTruncated(filename, validOffset, allowTruncated); err != nil {
+			return 0, err
 		}
 	}
+	// 之后追加的命令从文件末尾所在的 DB 开始，事务中不会有 SELECT
+	return fakeConn.GetDBIndex(), nil
+}
+
+// handleTruncated 处理末尾不完整的 AOF 文件，aof-load-truncated 为 yes 时将文件截断到 validOffset
+func handleTruncated(filename string, validOffset int64, allowTruncated bool) error {
+	if !allowTruncated || !config.Properties().AofLoadTruncated {
+		return fmt.Errorf("unexpected end of append only file %s, the last valid command ends at offset %d; "+
+			"set aof-load-truncated to yes to truncate the file and start", filename, validOffset)
+	}
+	logger.Warn(fmt.Sprintf("!!! Warning: short read while loading the AOF file %s, truncating it to offset %d",
+		filename, validOffset))
+	return os.Truncate(filename, validOffset)
 }
 
-// Close 优雅地停止一个持久化过程
+// Close 优雅地停止一个持久化过程，正在进行的重写会先完成
 func (handler *AofHandler) Close() {
+	handler.waitRewrite()
 	if handler.aofFile != nil {
 		close(handler.aofChan)
 		//等待AOF过程结束
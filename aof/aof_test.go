@@ -0,0 +1,186 @@
+package aof
+
+import (
+	"github.com/jujunwang/Mudis/config"
+	"github.com/jujunwang/Mudis/interface/resp"
+	"github.com/jujunwang/Mudis/resp/reply"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// recordingDB 记录加载 AOF 时执行的命令
+type recordingDB struct {
+	cmds []string
+}
+
+func (db *recordingDB) Exec(c resp.Connection, args [][]byte) resp.Reply {
+	cmdName := strings.ToLower(string(args[0]))
+	if cmdName == "select" {
+		index, _ := strconv.Atoi(string(args[1]))
+		c.SelectDB(index)
+	}
+	parts := make([]string, 0, len(args))
+	for _, arg := range args {
+		parts = append(parts, string(arg))
+	}
+	db.cmds = append(db.cmds, strings.Join(parts, " "))
+	return reply.MakeOkReply()
+}
+
+func (db *recordingDB) AfterClientClose(c resp.Connection) {}
+func (db *recordingDB) Close()                             {}
+func (db *recordingDB) Info(section string) []string       { return nil }
+func (db *recordingDB) ResetStats()                        {}
+func (db *recordingDB) SetAppendOnly(enabled bool) error   { return nil }
+
+func TestLoadFile(t *testing.T) {
+	first := setCmdText("a", "1")
+	multi := "*1\r\n$5\r\nMULTI\r\n"
+	exec := "*1\r\n$4\r\nEXEC\r\n"
+	selectDB := "*2\r\n$6\r\nSELECT\r\n$1\r\n3\r\n"
+	tests := []struct {
+		name  string
+		input string
+		// allowTruncated 是 loadFile 的参数，aofLoadTruncated 是 aof-load-truncated 配置
+		allowTruncated   bool
+		aofLoadTruncated bool
+		// cmds 是应当执行的命令
+		cmds []string
+		// dbIndex 是文件末尾所在的 DB
+		dbIndex int
+		// size 是加载后的文件大小，-1 表示文件不应被修改
+		size int
+		// err 为空表示应当加载成功，否则是错误信息中应当包含的内容
+		err string
+	}{
+		{
+			name:             "complete",
+			input:            first + selectDB + multi + setCmdText("b", "2") + exec,
+			allowTruncated:   true,
+			aofLoadTruncated: true,
+			cmds:             []string{"SET a 1", "SELECT 3", "MULTI", "SET b 2", "EXEC"},
+			dbIndex:          3,
+			size:             -1,
+		},
+		{
+			name:             "cut in bulk, truncate",
+			input:            first + "*3\r\n$3\r\nSET\r\n$1\r\nb\r\n$5\r\nva",
+			allowTruncated:   true,
+			aofLoadTruncated: true,
+			cmds:             []string{"SET a 1"},
+			size:             len(first),
+		},
+		{
+			name:             "cut in bulk, aof-load-truncated no",
+			input:            first + "*3\r\n$3\r\nSET\r\n$1\r\nb\r\n$5\r\nva",
+			allowTruncated:   true,
+			aofLoadTruncated: false,
+			cmds:             []string{"SET a 1"},
+			size:             -1,
+			err:              "the last valid command ends at offset " + strconv.Itoa(len(first)),
+		},
+		{
+			name:             "cut in bulk, not the last file",
+			input:            first + "*3\r\n$3\r\nSET\r\n$1\r\nb\r\n$5\r\nva",
+			allowTruncated:   false,
+			aofLoadTruncated: true,
+			cmds:             []string{"SET a 1"},
+			size:             -1,
+			err:              "the last valid command ends at offset " + strconv.Itoa(len(first)),
+		},
+		{
+			name:             "cut in MULTI, truncate",
+			input:            first + multi + setCmdText("b", "2"),
+			allowTruncated:   true,
+			aofLoadTruncated: true,
+			cmds:             []string{"SET a 1", "MULTI", "SET b 2"},
+			size:             len(first),
+		},
+		{
+			name:             "cut in command inside MULTI, truncate",
+			input:            first + multi + "*3\r\n$3\r\nSET\r\n$1",
+			allowTruncated:   true,
+			aofLoadTruncated: true,
+			cmds:             []string{"SET a 1", "MULTI"},
+			size:             len(first),
+		},
+		{
+			name:             "cut in MULTI, aof-load-truncated no",
+			input:            first + multi + setCmdText("b", "2"),
+			allowTruncated:   true,
+			aofLoadTruncated: false,
+			cmds:             []string{"SET a 1", "MULTI", "SET b 2"},
+			size:             -1,
+			err:              "the last valid command ends at offset " + strconv.Itoa(len(first)),
+		},
+		{
+			name:             "bad array header in the middle",
+			input:            first + "+OK\r\n" + setCmdText("b", "2"),
+			allowTruncated:   true,
+			aofLoadTruncated: true,
+			cmds:             []string{"SET a 1"},
+			size:             -1,
+			err:              "at offset " + strconv.Itoa(len(first)) + ": expected '*'",
+		},
+		{
+			name:             "bad bulk header in the middle",
+			input:            first + "*3\r\n#3\r\nSET\r\n" + setCmdText("b", "2"),
+			allowTruncated:   true,
+			aofLoadTruncated: true,
+			cmds:             []string{"SET a 1"},
+			size:             -1,
+			err:              "at offset " + strconv.Itoa(len(first)+len("*3\r\n")) + ": expected '$'",
+		},
+	}
+	defer func(old bool) {
+		_ = config.Set("aof-load-truncated", boolToYesNo(old))
+	}(config.Properties().AofLoadTruncated)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := config.Set("aof-load-truncated", boolToYesNo(tt.aofLoadTruncated)); err != nil {
+				t.Fatal(err)
+			}
+			filename := filepath.Join(t.TempDir(), "appendonly.aof")
+			if err := os.WriteFile(filename, []byte(tt.input), 0600); err != nil {
+				t.Fatal(err)
+			}
+			db := &recordingDB{}
+			handler := &AofHandler{db: db}
+			dbIndex, err := handler.loadFile(filename, tt.allowTruncated)
+			if tt.err == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if dbIndex != tt.dbIndex {
+					t.Errorf("db index is %d, want %d", dbIndex, tt.dbIndex)
+				}
+			} else if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("got error %v, want error containing %q", err, tt.err)
+			}
+			if strings.Join(db.cmds, ",") != strings.Join(tt.cmds, ",") {
+				t.Errorf("executed %q, want %q", db.cmds, tt.cmds)
+			}
+			info, err := os.Stat(filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			size := tt.size
+			if size < 0 {
+				size = len(tt.input)
+			}
+			if info.Size() != int64(size) {
+				t.Errorf("file size is %d, want %d", info.Size(), size)
+			}
+		})
+	}
+}
+
+func boolToYesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
@@ -0,0 +1,213 @@
+package aof
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// AOF 文件的类型
+const (
+	// baseFile 是重写 AOF 时生成的数据快照
+	baseFile = 'b'
+	// incrFile 是 base 文件之后追加的命令
+	incrFile = 'i'
+)
+
+// aofFileInfo 是清单中的一个文件
+type aofFileInfo struct {
+	name     string
+	seq      int64
+	fileType byte
+}
+
+// manifest 记录 AOF 目录中的文件，加载时先加载 base 文件，再按照顺序加载 incr 文件
+// 清单文件的每一行描述一个文件，例如:
+//
+//	file appendonly.aof.1.base.aof seq 1 type b
+//	file appendonly.aof.1.incr.aof seq 1 type i
+type manifest struct {
+	// basename 是 appendfilename，文件名由它和序号组成
+	basename string
+	base     *aofFileInfo
+	incrs    []*aofFileInfo
+	// baseSeq 和 incrSeq 是已经使用的最大序号
+	baseSeq int64
+	incrSeq int64
+}
+
+func manifestName(basename string) string {
+	return basename + ".manifest"
+}
+
+// readManifest 读取 dir 中的清单，清单不存在时返回 nil
+func readManifest(dir string, basename string) (*manifest, error) {
+	file, err := os.Open(filepath.Join(dir, manifestName(basename)))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+	m := &manifest{basename: basename}
+	scanner := bufio.NewScanner(file)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		info, err := parseManifestLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("invalid AOF manifest at line %d: %v", lineNum, err)
+		}
+		if info.fileType == baseFile {
+			if m.base != nil {
+				return nil, fmt.Errorf("invalid AOF manifest at line %d: found duplicate base file", lineNum)
+			}
+			m.base = info
+			if info.seq > m.baseSeq {
+				m.baseSeq = info.seq
+			}
+		} else {
+			if len(m.incrs) > 0 && info.seq <= m.incrs[len(m.incrs)-1].seq {
+				return nil, fmt.Errorf("invalid AOF manifest at line %d: incr files are not in order", lineNum)
+			}
+			m.incrs = append(m.incrs, info)
+			m.incrSeq = info.seq
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// parseManifestLine 解析 "file <name> seq <seq> type <b|i>"
+func parseManifestLine(line string) (*aofFileInfo, error) {
+	fields := strings.Fields(line)
+	if len(fields)%2 != 0 {
+		return nil, errors.New("wrong number of fields")
+	}
+	info := &aofFileInfo{}
+	for i := 0; i < len(fields); i += 2 {
+		value := fields[i+1]
+		switch fields[i] {
+		case "file":
+			if strings.ContainsAny(value, `/\`) {
+				return nil, errors.New("file name can't be a path")
+			}
+			info.name = value
+		case "seq":
+			seq, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || seq <= 0 {
+				return nil, errors.New("invalid seq " + strconv.Quote(value))
+			}
+			info.seq = seq
+		case "type":
+			if value != string(baseFile) && value != string(incrFile) {
+				return nil, errors.New("invalid type " + strconv.Quote(value))
+			}
+			info.fileType = value[0]
+		}
+	}
+	if info.name == "" || info.seq == 0 || info.fileType == 0 {
+		return nil, errors.New("missing file, seq or type")
+	}
+	return info, nil
+}
+
+// files 返回按照加载顺序排列的所有文件
+func (m *manifest) files() []*aofFileInfo {
+	files := make([]*aofFileInfo, 0, len(m.incrs)+1)
+	if m.base != nil {
+		files = append(files, m.base)
+	}
+	return append(files, m.incrs...)
+}
+
+func (m *manifest) clone() *manifest {
+	c := *m
+	c.incrs = append([]*aofFileInfo(nil), m.incrs...)
+	return &c
+}
+
+// newBase 使用下一个序号生成新的 base 文件并替换原来的 base 文件
+func (m *manifest) newBase() *aofFileInfo {
+	m.baseSeq++
+	m.base = &aofFileInfo{
+		name:     m.basename + "." + strconv.FormatInt(m.baseSeq, 10) + ".base.aof",
+		seq:      m.baseSeq,
+		fileType: baseFile,
+	}
+	return m.base
+}
+
+// newIncr 使用下一个序号生成新的 incr 文件并追加到清单末尾
+func (m *manifest) newIncr() *aofFileInfo {
+	m.incrSeq++
+	info := &aofFileInfo{
+		name:     m.basename + "." + strconv.FormatInt(m.incrSeq, 10) + ".incr.aof",
+		seq:      m.incrSeq,
+		fileType: incrFile,
+	}
+	m.incrs = append(m.incrs, info)
+	return info
+}
+
+// lastIncr 返回正在写入的 incr 文件，没有 incr 文件时返回 nil
+func (m *manifest) lastIncr() *aofFileInfo {
+	if len(m.incrs) == 0 {
+		return nil
+	}
+	return m.incrs[len(m.incrs)-1]
+}
+
+func (m *manifest) String() string {
+	var builder strings.Builder
+	for _, info := range m.files() {
+		builder.WriteString(fmt.Sprintf("file %s seq %d type %c\n", info.name, info.seq, info.fileType))
+	}
+	return builder.String()
+}
+
+// persist 将清单写入临时文件后替换原来的清单，保证清单总是完整的
+func (m *manifest) persist(dir string) error {
+	name := filepath.Join(dir, manifestName(m.basename))
+	tmpName := filepath.Join(dir, "temp-"+manifestName(m.basename))
+	file, err := os.OpenFile(tmpName, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = file.WriteString(m.String())
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpName, name)
+	}
+	if err != nil {
+		_ = os.Remove(tmpName)
+		return err
+	}
+	return syncDir(dir)
+}
+
+// syncDir 将目录中文件的创建、重命名写入磁盘
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,160 @@
+package aof
+
+import (
+	"github.com/jujunwang/Mudis/datastruct/dict"
+	List "github.com/jujunwang/Mudis/datastruct/list"
+	"github.com/jujunwang/Mudis/datastruct/set"
+	"github.com/jujunwang/Mudis/datastruct/sortedset"
+	"github.com/jujunwang/Mudis/datastruct/stream"
+	"github.com/jujunwang/Mudis/interface/database"
+	"strconv"
+)
+
+// EntityToCmds 将 DataEntity 序列化为能够重建它的命令
+// 流需要多条命令才能重建元素、消费者组和待确认列表，其它类型只需要一条命令
+func EntityToCmds(key string, entity *database.DataEntity) []CmdLine {
+	if entity == nil {
+		return nil
+	}
+	if s, ok := entity.Data.(*stream.Stream); ok {
+		return streamToCmds(key, s)
+	}
+	cmd := EntityToCmd(key, entity)
+	if cmd == nil {
+		return nil
+	}
+	return []CmdLine{cmd}
+}
+
+// EntityToCmd 将能够用一条命令表示的 DataEntity 序列化为命令
+func EntityToCmd(key string, entity *database.DataEntity) CmdLine {
+	if entity == nil {
+		return nil
+	}
+	var cmd CmdLine
+	switch val := entity.Data.(type) {
+	case []byte:
+		cmd = stringToCmd(key, val)
+	case *List.LinkedList:
+		cmd = listToCmd(key, val)
+	case *set.Set:
+		cmd = setToCmd(key, val)
+	case dict.Dict:
+		cmd = hashToCmd(key, val)
+	case *sortedset.SortedSet:
+		cmd = zSetToCmd(key, val)
+	}
+	return cmd
+}
+
+var setCmd = []byte("SET")
+
+func stringToCmd(key string, bytes []byte) CmdLine {
+	// 复制一份，避免 SETRANGE 等原地修改影响已经生成的命令
+	value := make([]byte, len(bytes))
+	copy(value, bytes)
+	return CmdLine{setCmd, []byte(key), value}
+}
+
+var rPushAllCmd = []byte("RPUSH")
+
+func listToCmd(key string, list *List.LinkedList) CmdLine {
+	args := make(CmdLine, 2, 2+list.Len())
+	args[0] = rPushAllCmd
+	args[1] = []byte(key)
+	list.ForEach(func(i int, val interface{}) bool {
+		bytes, _ := val.([]byte)
+		args = append(args, bytes)
+		return true
+	})
+	return args
+}
+
+var sAddCmd = []byte("SADD")
+
+func setToCmd(key string, set *set.Set) CmdLine {
+	args := make(CmdLine, 2, 2+set.Len())
+	args[0] = sAddCmd
+	args[1] = []byte(key)
+	set.ForEach(func(val string) bool {
+		args = append(args, []byte(val))
+		return true
+	})
+	return args
+}
+
+var hSetCmd = []byte("HSET")
+
+func hashToCmd(key string, hash dict.Dict) CmdLine {
+	args := make(CmdLine, 2, 2+hash.Len()*2)
+	args[0] = hSetCmd
+	args[1] = []byte(key)
+	hash.ForEach(func(field string, val interface{}) bool {
+		bytes, _ := val.([]byte)
+		args = append(args, []byte(field), bytes)
+		return true
+	})
+	return args
+}
+
+var zAddCmd = []byte("ZADD")
+
+func zSetToCmd(key string, zset *sortedset.SortedSet) CmdLine {
+	args := make(CmdLine, 2, 2+zset.Len()*2)
+	args[0] = zAddCmd
+	args[1] = []byte(key)
+	zset.ForEach(sortedset.NegativeInfBorder, sortedset.PositiveInfBorder, 0, -1, false, func(element *sortedset.Element) bool {
+		score := strconv.FormatFloat(element.Score, 'f', -1, 64)
+		args = append(args, []byte(score), []byte(element.Member))
+		return true
+	})
+	return args
+}
+
+func toCmdLine(args ...string) CmdLine {
+	cmd := make(CmdLine, len(args))
+	for i, arg := range args {
+		cmd[i] = []byte(arg)
+	}
+	return cmd
+}
+
+// streamToCmds 按照 redis 重写 aof 的方式序列化流
+// 依次为 XADD 每个元素，XSETID 恢复 ID 信息，XGROUP CREATE 和 CREATECONSUMER 恢复消费者组，XCLAIM 恢复待确认列表
+func streamToCmds(key string, s *stream.Stream) []CmdLine {
+	cmds := make([]CmdLine, 0, s.Len()+2)
+	if s.Len() == 0 {
+		// 利用 MAXLEN 0 创建一个空的流，XADD 不接受 0-0，实际的 ID 由下面的 XSETID 恢复
+		id := s.LastID()
+		if id.IsZero() {
+			id.Seq = 1
+		}
+		cmds = append(cmds, toCmdLine("XADD", key, "MAXLEN", "0", id.String(), "x", "y"))
+	}
+	s.ForEach(func(entry *stream.Entry) bool {
+		cmd := make(CmdLine, 3, 3+len(entry.Fields))
+		cmd[0] = []byte("XADD")
+		cmd[1] = []byte(key)
+		cmd[2] = []byte(entry.ID.String())
+		cmd = append(cmd, entry.Fields...)
+		cmds = append(cmds, cmd)
+		return true
+	})
+	cmds = append(cmds, toCmdLine("XSETID", key, s.LastID().String(),
+		"ENTRIESADDED", strconv.FormatUint(s.EntriesAdded(), 10),
+		"MAXDELETEDID", s.MaxDeletedID().String()))
+	for _, group := range s.Groups() {
+		cmds = append(cmds, toCmdLine("XGROUP", "CREATE", key, group.Name, group.LastID.String(),
+			"ENTRIESREAD", strconv.FormatInt(group.EntriesRead, 10)))
+		for _, consumer := range group.Consumers() {
+			cmds = append(cmds, toCmdLine("XGROUP", "CREATECONSUMER", key, group.Name, consumer.Name))
+		}
+		for _, pe := range group.Pending(stream.MinID, stream.MaxID, 0) {
+			cmds = append(cmds, toCmdLine("XCLAIM", key, group.Name, pe.Consumer.Name, "0", pe.ID.String(),
+				"TIME", strconv.FormatInt(pe.DeliveryTime, 10),
+				"RETRYCOUNT", strconv.FormatInt(pe.DeliveryCount, 10),
+				"FORCE", "JUSTID"))
+		}
+	}
+	return cmds
+}
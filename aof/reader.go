@@ -0,0 +1,99 @@
+package aof
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// maxBulkLen 是 AOF 中单个参数的最大长度，超过时认为文件已损坏
+const maxBulkLen = 512 << 20
+
+// errTruncated 表示 AOF 文件在一条命令的中间结束
+var errTruncated = errors.New("unexpected end of append only file")
+
+// cmdReader 逐条读取 AOF 中的命令，并记录已经读取的字节数
+// 与 parser.ParseStream 不同，它只接受 RESP 数组格式的命令，遇到错误时停止并报告错误的位置
+type cmdReader struct {
+	r      *bufio.Reader
+	offset int64
+}
+
+// readCommand 读取一条命令
+// 文件恰好在两条命令之间结束时返回 io.EOF，在命令中间结束时返回 errTruncated
+func (cr *cmdReader) readCommand() (CmdLine, error) {
+	start := cr.offset
+	line, err := cr.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 || line[0] != '*' {
+		return nil, cr.corrupt(start, "expected '*'")
+	}
+	argc, err := strconv.ParseUint(string(line[1:]), 10, 32)
+	if err != nil || argc == 0 {
+		return nil, cr.corrupt(start, "invalid argument count "+strconv.Quote(string(line[1:])))
+	}
+	cmdLine := make(CmdLine, 0, argc)
+	for i := uint64(0); i < argc; i++ {
+		lineStart := cr.offset
+		line, err := cr.readLine()
+		if err == io.EOF {
+			return nil, errTruncated
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 || line[0] != '$' {
+			return nil, cr.corrupt(lineStart, "expected '$'")
+		}
+		n, err := strconv.ParseInt(string(line[1:]), 10, 64)
+		if err != nil || n < 0 || n > maxBulkLen {
+			return nil, cr.corrupt(lineStart, "invalid bulk length "+strconv.Quote(string(line[1:])))
+		}
+		bodyStart := cr.offset
+		body := make([]byte, n+2)
+		read, err := io.ReadFull(cr.r, body)
+		cr.offset += int64(read)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, errTruncated
+		}
+		if err != nil {
+			return nil, err
+		}
+		if body[n] != '\r' || body[n+1] != '\n' {
+			return nil, cr.corrupt(bodyStart+n, "bulk string does not end with CRLF")
+		}
+		cmdLine = append(cmdLine, body[:n])
+	}
+	return cmdLine, nil
+}
+
+// readLine 读取以 CRLF 结尾的一行，返回的内容不包含 CRLF，在下一次读取之前有效
+func (cr *cmdReader) readLine() ([]byte, error) {
+	start := cr.offset
+	line, err := cr.r.ReadSlice('\n')
+	cr.offset += int64(len(line))
+	if err == io.EOF {
+		if len(line) == 0 {
+			return nil, io.EOF
+		}
+		return nil, errTruncated
+	}
+	if err == bufio.ErrBufferFull {
+		return nil, cr.corrupt(start, "line too long")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return nil, cr.corrupt(start, "line does not end with CRLF")
+	}
+	return line[:len(line)-2], nil
+}
+
+func (cr *cmdReader) corrupt(offset int64, reason string) error {
+	return fmt.Errorf("bad file format reading the append only file at offset %d: %s", offset, reason)
+}
@@ -0,0 +1,105 @@
+package aof
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// setCmdText 返回 SET key value 在 AOF 中的格式
+func setCmdText(key string, value string) string {
+	return "*3\r\n$3\r\nSET\r\n$" + strconv.Itoa(len(key)) + "\r\n" + key + "\r\n$" +
+		strconv.Itoa(len(value)) + "\r\n" + value + "\r\n"
+}
+
+func TestCmdReader(t *testing.T) {
+	first := setCmdText("a", "1")
+	tests := []struct {
+		name  string
+		input string
+		// cmds 是出错之前读出的命令数
+		cmds int
+		// err 为空表示正常读到文件末尾，否则是错误信息中应当包含的内容
+		err string
+		// truncated 表示应当返回 errTruncated
+		truncated bool
+	}{
+		{name: "empty", input: ""},
+		{name: "complete", input: first + setCmdText("b", "2"), cmds: 2},
+		{name: "cut in argument count", input: first + "*3", cmds: 1, truncated: true},
+		{name: "cut after argument count", input: first + "*3\r\n", cmds: 1, truncated: true},
+		{name: "cut in bulk header", input: first + "*3\r\n$3\r\nSET\r\n$", cmds: 1, truncated: true},
+		{name: "cut in bulk body", input: first + "*3\r\n$3\r\nSET\r\n$1\r\nb\r\n$5\r\nva", cmds: 1, truncated: true},
+		{name: "cut before bulk CRLF", input: first + "*3\r\n$3\r\nSET\r\n$1\r\nb\r\n$1\r\n2", cmds: 1, truncated: true},
+		{name: "cut in MULTI", input: first + "*1\r\n$5\r\nMULTI\r\n*3\r\n$3\r\nSET", cmds: 2, truncated: true},
+		{
+			name:  "bad array header",
+			input: first + "+OK\r\n" + setCmdText("b", "2"),
+			cmds:  1,
+			err:   "at offset " + strconv.Itoa(len(first)) + ": expected '*'",
+		},
+		{
+			name:  "bad argument count",
+			input: first + "*x\r\n",
+			cmds:  1,
+			err:   "at offset " + strconv.Itoa(len(first)) + ": invalid argument count",
+		},
+		{
+			name:  "bad bulk header",
+			input: first + "*3\r\n#3\r\nSET\r\n" + setCmdText("b", "2"),
+			cmds:  1,
+			err:   "at offset " + strconv.Itoa(len(first)+len("*3\r\n")) + ": expected '$'",
+		},
+		{
+			name:  "bad bulk length",
+			input: first + "*3\r\n$3\r\nSET\r\n$-2\r\n",
+			cmds:  1,
+			err:   "at offset " + strconv.Itoa(len(first)+len("*3\r\n$3\r\nSET\r\n")) + ": invalid bulk length",
+		},
+		{
+			name:  "bulk without CRLF",
+			input: first + "*1\r\n$3\r\nSETxx" + setCmdText("b", "2"),
+			cmds:  1,
+			err:   "at offset " + strconv.Itoa(len(first)+len("*1\r\n$3\r\nSET")) + ": bulk string does not end with CRLF",
+		},
+		{
+			name:  "line without CR",
+			input: first + "*1\n",
+			cmds:  1,
+			err:   "at offset " + strconv.Itoa(len(first)) + ": line does not end with CRLF",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cr := &cmdReader{r: bufio.NewReader(strings.NewReader(tt.input))}
+			cmds := 0
+			var err error
+			for {
+				_, err = cr.readCommand()
+				if err != nil {
+					break
+				}
+				cmds++
+			}
+			if cmds != tt.cmds {
+				t.Errorf("read %d commands, want %d", cmds, tt.cmds)
+			}
+			switch {
+			case tt.truncated:
+				if err != errTruncated {
+					t.Errorf("got error %v, want errTruncated", err)
+				}
+			case tt.err != "":
+				if err == nil || err == io.EOF || err == errTruncated || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("got error %v, want error containing %q", err, tt.err)
+				}
+			default:
+				if err != io.EOF {
+					t.Errorf("got error %v, want io.EOF", err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,268 @@
+package aof
+
+import (
+	"bufio"
+	"errors"
+	"github.com/jujunwang/Mudis/config"
+	databaseface "github.com/jujunwang/Mudis/interface/database"
+	"github.com/jujunwang/Mudis/lib/logger"
+	"github.com/jujunwang/Mudis/lib/utils"
+	"github.com/jujunwang/Mudis/resp/reply"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// rewriteRetryDelay 是自动重写失败后再次尝试前等待的时间
+const rewriteRetryDelay = 5 * time.Second
+
+var (
+	// ErrRewriteInProgress 表示已经有一个重写正在进行
+	ErrRewriteInProgress = errors.New("Background append only file rewriting already in progress")
+	errHandlerClosed     = errors.New("append only file is closed")
+)
+
+// rewriteState 记录 AOF 重写的状态
+type rewriteState struct {
+	mu         sync.Mutex
+	inProgress bool
+	closed     bool
+	// baseSize 是上次重写后(或启动时) AOF 文件的大小，用于判断是否需要自动重写
+	baseSize int64
+	lastTry  time.Time
+	lastErr  error
+	// running 在后台重写结束时完成，关闭 AofHandler 时等待它
+	running sync.WaitGroup
+}
+
+// rewriteCtx 保存一次重写的上下文
+type rewriteCtx struct {
+	tmpFile *os.File
+	// files 是开始重写时清单中的文件，重写后由新的 base 文件代替
+	files []*aofFileInfo
+}
+
+// BGRewrite 在后台重写 AOF
+// 开始时切换到新的 incr 文件，重写过程中的命令照常写入新的 incr 文件，
+// 之前的文件在后台重放后生成新的 base 文件，结束时在清单中用它代替之前的文件
+func (handler *AofHandler) BGRewrite() error {
+	state := &handler.rewrite
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	if state.closed {
+		return errHandlerClosed
+	}
+	if state.inProgress {
+		return ErrRewriteInProgress
+	}
+	state.inProgress = true
+	state.lastTry = time.Now()
+	state.running.Add(1)
+	go func() {
+		defer state.running.Done()
+		err := handler.doRewrite()
+		state.mu.Lock()
+		state.inProgress = false
+		state.lastErr = err
+		state.mu.Unlock()
+		if err != nil {
+			logger.Error("background AOF rewrite error: " + err.Error())
+			return
+		}
+		logger.Info("background AOF rewrite finished successfully")
+	}()
+	return nil
+}
+
+// RewriteIfNeeded 在 AOF 文件相对上次重写后的大小增长超过 auto-aof-rewrite-percentage，
+// 且不小于 auto-aof-rewrite-min-size 时开始重写
+func (handler *AofHandler) RewriteIfNeeded() {
+	percentage := config.Properties().AutoAofRewritePercentage
+	if percentage <= 0 {
+		return
+	}
+	size := handler.FileSize()
+	if size < config.Properties().AutoAofRewriteMinSize {
+		return
+	}
+	state := &handler.rewrite
+	state.mu.Lock()
+	if state.inProgress || (state.lastErr != nil && time.Since(state.lastTry) < rewriteRetryDelay) {
+		state.mu.Unlock()
+		return
+	}
+	base := state.baseSize
+	state.mu.Unlock()
+	if base <= 0 {
+		base = 1
+	}
+	growth := (size - base) * 100 / base
+	if growth >= int64(percentage) {
+		logger.Info("starting automatic rewriting of AOF on " + strconv.FormatInt(growth, 10) + "% growth")
+		_ = handler.BGRewrite()
+	}
+}
+
+// RewriteStatus 返回是否正在重写、上次重写的错误和自动重写的基准大小
+func (handler *AofHandler) RewriteStatus() (inProgress bool, lastErr error, baseSize int64) {
+	state := &handler.rewrite
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	return state.inProgress, state.lastErr, state.baseSize
+}
+
+// waitRewrite 禁止开始新的重写并等待正在进行的重写结束
+func (handler *AofHandler) waitRewrite() {
+	state := &handler.rewrite
+	state.mu.Lock()
+	state.closed = true
+	state.mu.Unlock()
+	state.running.Wait()
+}
+
+func (handler *AofHandler) doRewrite() error {
+	ctx, err := handler.startRewrite()
+	if err != nil {
+		return err
+	}
+	err = handler.writeRewrite(ctx)
+	if err == nil {
+		err = handler.finishRewrite(ctx)
+	}
+	if err != nil {
+		_ = ctx.tmpFile.Close()
+		_ = os.Remove(ctx.tmpFile.Name())
+	}
+	return err
+}
+
+// rewriteTmpName 返回重写时生成 base 文件使用的临时文件名
+func (handler *AofHandler) rewriteTmpName(m *manifest) string {
+	return filepath.Join(handler.dir, "temp-"+m.basename+".rewrite")
+}
+
+// startRewrite 暂停写入，记录清单中当前的文件，切换到新的 incr 文件并创建临时文件
+func (handler *AofHandler) startRewrite() (*rewriteCtx, error) {
+	handler.pausingAof.Lock()
+	defer handler.pausingAof.Unlock()
+	// 还没有写入文件的数据属于重写之前的部分，写入失败时不能开始重写
+	handler.flush(false)
+	if len(handler.buf) > 0 {
+		return nil, handler.LastWriteErr()
+	}
+	// 之前的文件不会再修改，写入磁盘后再切换
+	if err := handler.aofFile.Sync(); err != nil {
+		return nil, err
+	}
+	m := handler.manifest.clone()
+	files := m.files()
+	incr := m.newIncr()
+	aofFile, err := os.OpenFile(filepath.Join(handler.dir, incr.name), os.O_APPEND|os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.persist(handler.dir); err != nil {
+		_ = aofFile.Close()
+		_ = os.Remove(aofFile.Name())
+		return nil, err
+	}
+	_ = handler.aofFile.Close()
+	handler.manifest = m
+	handler.aofFile = aofFile
+	handler.sealedSize += handler.aofSize
+	handler.aofSize = 0
+	// 加载每个文件时都从 DB 0 开始
+	handler.currentDB = 0
+
+	tmpFile, err := os.OpenFile(handler.rewriteTmpName(m), os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &rewriteCtx{
+		tmpFile: tmpFile,
+		files:   files,
+	}, nil
+}
+
+// writeRewrite 将开始重写时的文件重放到临时数据库，再将其中的数据以最少的命令写入临时文件
+func (handler *AofHandler) writeRewrite(ctx *rewriteCtx) error {
+	tmpDB := handler.tmpDBMaker()
+	loader := &AofHandler{db: tmpDB}
+	for _, file := range ctx.files {
+		// 这些文件已经完整写入，不应该有不完整的命令
+		if _, err := loader.loadFile(filepath.Join(handler.dir, file.name), false); err != nil {
+			return err
+		}
+	}
+	writer := bufio.NewWriter(ctx.tmpFile)
+	currentDB := 0
+	var err error
+	writeCmd := func(cmdLine CmdLine) {
+		if err == nil {
+			_, err = writer.Write(reply.MakeMultiBulkReply(cmdLine).ToBytes())
+		}
+	}
+	for i := 0; i < config.Properties().Databases; i++ {
+		tmpDB.ForEach(i, func(key string, entity *databaseface.DataEntity, expiration *time.Time) bool {
+			if currentDB != i {
+				writeCmd(utils.ToCmdLine("SELECT", strconv.Itoa(i)))
+				currentDB = i
+			}
+			for _, cmdLine := range EntityToCmds(key, entity) {
+				writeCmd(cmdLine)
+			}
+			if expiration != nil {
+				writeCmd(utils.ToCmdLine("PEXPIREAT", key, strconv.FormatInt(expiration.UnixMilli(), 10)))
+			}
+			return err == nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	if err := ctx.tmpFile.Sync(); err != nil {
+		return err
+	}
+	return ctx.tmpFile.Close()
+}
+
+// finishRewrite 暂停写入，用临时文件作为新的 base 文件，并从清单中去掉重写之前的文件
+// 新的清单写入磁盘后才删除之前的文件，中途失败时原来的清单仍然完整
+func (handler *AofHandler) finishRewrite(ctx *rewriteCtx) error {
+	handler.pausingAof.Lock()
+	defer handler.pausingAof.Unlock()
+
+	m := handler.manifest.clone()
+	base := m.newBase()
+	if err := os.Rename(ctx.tmpFile.Name(), filepath.Join(handler.dir, base.name)); err != nil {
+		return err
+	}
+	rewritten := make(map[string]bool, len(ctx.files))
+	for _, file := range ctx.files {
+		rewritten[file.name] = true
+	}
+	incrs := m.incrs[:0]
+	for _, incr := range m.incrs {
+		if !rewritten[incr.name] {
+			incrs = append(incrs, incr)
+		}
+	}
+	m.incrs = incrs
+	if err := m.persist(handler.dir); err != nil {
+		_ = os.Remove(filepath.Join(handler.dir, base.name))
+		return err
+	}
+	handler.manifest = m
+	handler.removeFiles(ctx.files)
+
+	handler.sealedSize = handler.filesSize(m.files()[:len(m.files())-1])
+	handler.rewrite.mu.Lock()
+	handler.rewrite.baseSize = handler.sealedSize + handler.aofSize
+	handler.rewrite.mu.Unlock()
+	return nil
+}
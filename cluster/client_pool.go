@@ -4,7 +4,10 @@ import (
 	"context"
 	"errors"
 	pool "github.com/jolestar/go-commons-pool/v2"
+	"github.com/jujunwang/Mudis/config"
+	"github.com/jujunwang/Mudis/lib/utils"
 	"github.com/jujunwang/Mudis/resp/client"
+	"github.com/jujunwang/Mudis/resp/reply"
 )
 
 type connectionFactory struct {
@@ -17,6 +20,14 @@ func (f *connectionFactory) MakeObject(ctx context.Context) (*pool.PooledObject,
 		return nil, err
 	}
 	c.Start()
+	// 节点之间使用 requirepass 认证
+	if password := config.Properties().RequirePass; password != "" {
+		r := c.Send(utils.ToCmdLine("auth", password))
+		if errReply, ok := r.(reply.ErrorReply); ok {
+			c.Close()
+			return nil, errors.New("auth failed: " + errReply.Error())
+		}
+	}
 	return pool.NewPooledObject(c), nil
 }
 
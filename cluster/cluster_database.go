@@ -7,13 +7,13 @@ import (
 	pool "github.com/jolestar/go-commons-pool/v2"
 	"github.com/jujunwang/Mudis/config"
 	"github.com/jujunwang/Mudis/database"
-	databaseface "github.com/jujunwang/Mudis/interface/database"
 	"github.com/jujunwang/Mudis/interface/resp"
 	"github.com/jujunwang/Mudis/lib/consistenthash"
 	"github.com/jujunwang/Mudis/lib/logger"
 	"github.com/jujunwang/Mudis/resp/reply"
 	"runtime/debug"
 	"strings"
+	"time"
 )
 
 // ClusterDatabase 代表集群的一个节点
@@ -24,26 +24,29 @@ type ClusterDatabase struct {
 	nodes          []string
 	peerPicker     *consistenthash.NodeMap
 	peerConnection map[string]*pool.ObjectPool
-	db             databaseface.Database
+	db             *database.StandaloneDatabase
 }
 
 // MakeClusterDatabase 创建并启动集群的一个节点
 func MakeClusterDatabase() *ClusterDatabase {
+	db := database.NewStandaloneDatabase()
+	// 命令的执行统计在集群层记录，这样转发到其他节点的命令也会被统计
+	db.DisableCommandStats()
 	cluster := &ClusterDatabase{
-		self: config.Properties.Self,
+		self: config.Properties().Self,
 
-		db:             database.NewStandaloneDatabase(),
+		db:             db,
 		peerPicker:     consistenthash.NewNodeMap(nil),
 		peerConnection: make(map[string]*pool.ObjectPool),
 	}
-	nodes := make([]string, 0, len(config.Properties.Peers)+1)
-	for _, peer := range config.Properties.Peers {
+	nodes := make([]string, 0, len(config.Properties().Peers)+1)
+	for _, peer := range config.Properties().Peers {
 		nodes = append(nodes, peer)
 	}
-	nodes = append(nodes, config.Properties.Self)
+	nodes = append(nodes, config.Properties().Self)
 	cluster.peerPicker.AddNode(nodes...)
 	ctx := context.Background()
-	for _, peer := range config.Properties.Peers {
+	for _, peer := range config.Properties().Peers {
 		cluster.peerConnection[peer] = pool.NewObjectPoolWithDefaultConfig(ctx, &connectionFactory{
 			Peer: peer,
 		})
@@ -77,10 +80,27 @@ func (cluster *ClusterDatabase) Exec(c resp.Connection, cmdLine [][]byte) (resul
 	if !ok {
 		return reply.MakeErrReply("ERR unknown command '" + cmdName + "', or not supported in cluster mode")
 	}
+	start := time.Now()
 	result = cmdFunc(cluster, c, cmdLine)
+	cluster.db.RecordCommand(c, cmdLine, result, time.Since(start))
 	return
 }
 
+// Info 返回当前节点的统计信息
+func (cluster *ClusterDatabase) Info(section string) []string {
+	return cluster.db.Info(section)
+}
+
+// ResetStats 清空当前节点的统计信息
+func (cluster *ClusterDatabase) ResetStats() {
+	cluster.db.ResetStats()
+}
+
+// SetAppendOnly 开启或关闭当前节点的 AOF
+func (cluster *ClusterDatabase) SetAppendOnly(enabled bool) error {
+	return cluster.db.SetAppendOnly(enabled)
+}
+
 // AfterClientClose 做关闭后的清理工作
 func (cluster *ClusterDatabase) AfterClientClose(c resp.Connection) {
 	cluster.db.AfterClientClose(c)
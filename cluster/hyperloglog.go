@@ -0,0 +1,22 @@
+package cluster
+
+import (
+	"github.com/jujunwang/Mudis/interface/resp"
+	"github.com/jujunwang/Mudis/resp/reply"
+)
+
+// PFCount 统计一个或多个 HyperLogLog 的基数，多个 key 需要映射在同一个节点
+func PFCount(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) < 2 {
+		return reply.MakeArgNumErrReply("pfcount")
+	}
+	return relayToOneNode(cluster, c, args, keysOf(args[1:]))
+}
+
+// PFMerge 合并多个 HyperLogLog，目标 key 和源 key 需要映射在同一个节点
+func PFMerge(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) < 2 {
+		return reply.MakeArgNumErrReply("pfmerge")
+	}
+	return relayToOneNode(cluster, c, args, keysOf(args[1:]))
+}
@@ -1,8 +1,10 @@
 package cluster
 
 import (
+	"github.com/jujunwang/Mudis/database"
 	"github.com/jujunwang/Mudis/interface/resp"
 	"github.com/jujunwang/Mudis/resp/reply"
+	"strings"
 )
 
 // FlushDB 删除当前数据库的所有数据
@@ -20,3 +22,34 @@ func FlushDB(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Re
 	}
 	return reply.MakeErrReply("error occurs: " + errReply.Error())
 }
+
+// relayToOneNode 将涉及多个 key 的命令转发到 key 所在的节点
+// 所有 key 需要通过一致性哈希映射在同一个节点，否则返回错误
+func relayToOneNode(cluster *ClusterDatabase, c resp.Connection, args [][]byte, keys []string) resp.Reply {
+	peer := cluster.peerPicker.PickNode(keys[0])
+	for _, key := range keys[1:] {
+		if cluster.peerPicker.PickNode(key) != peer {
+			return reply.MakeErrReply("ERR " + strings.ToLower(string(args[0])) + " must within one slot in cluster mode")
+		}
+	}
+	return cluster.relay(peer, c, args)
+}
+
+// keysOf 将参数转换为 key
+func keysOf(args [][]byte) []string {
+	keys := make([]string, len(args))
+	for i, arg := range args {
+		keys[i] = string(arg)
+	}
+	return keys
+}
+
+// relayByKeys 转发 key 不在第一个参数的命令，例如 XREAD、XGROUP，命令中的 key 需要映射在同一个节点
+// 参数错误或没有 key 的命令(例如 XGROUP HELP)在本节点执行，返回与单机模式相同的回复
+func relayByKeys(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	keys := database.CommandKeys(args)
+	if len(keys) == 0 {
+		return cluster.db.Exec(c, args)
+	}
+	return relayToOneNode(cluster, c, args, keys)
+}
@@ -0,0 +1,50 @@
+package cluster
+
+import (
+	"github.com/jujunwang/Mudis/interface/resp"
+	"github.com/jujunwang/Mudis/resp/reply"
+)
+
+// relayPublish 是节点之间转发 PUBLISH 时使用的内部命令，避免收到转发的节点再次广播
+const relayPublish = "publish_"
+
+var publishCmd = []byte("publish")
+
+// Publish 将消息发布到集群中的所有节点，返回收到消息的客户端总数
+func Publish(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) != 3 {
+		return reply.MakeArgNumErrReply("publish")
+	}
+	var count int64 = 0
+	for _, node := range cluster.nodes {
+		var r resp.Reply
+		if node == cluster.self {
+			r = cluster.db.Exec(c, args)
+		} else {
+			relayArgs := make([][]byte, len(args))
+			copy(relayArgs, args)
+			relayArgs[0] = []byte(relayPublish)
+			r = cluster.relay(node, c, relayArgs)
+		}
+		if reply.IsErrorReply(r) {
+			return reply.MakeErrReply("error occurs: " + string(r.ToBytes()[1:]))
+		}
+		if intReply, ok := r.(*reply.IntReply); ok {
+			count += intReply.Code
+		}
+	}
+	return reply.MakeIntReply(count)
+}
+
+// onRelayedPublish 处理其他节点转发来的 PUBLISH，只发布给本节点的订阅者
+func onRelayedPublish(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	publishArgs := make([][]byte, len(args))
+	copy(publishArgs, args)
+	publishArgs[0] = publishCmd
+	return cluster.db.Exec(c, publishArgs)
+}
+
+// execLocal 在本节点执行命令，订阅关系保存在客户端所连接的节点上
+func execLocal(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	return cluster.db.Exec(c, args)
+}
@@ -20,9 +20,106 @@ func makeRouter() map[string]CmdFunc {
 	routerMap["setnx"] = defaultFunc
 	routerMap["get"] = defaultFunc
 	routerMap["getset"] = defaultFunc
+	routerMap["setex"] = defaultFunc
+	routerMap["psetex"] = defaultFunc
+
+	routerMap["expire"] = defaultFunc
+	routerMap["pexpire"] = defaultFunc
+	routerMap["expireat"] = defaultFunc
+	routerMap["pexpireat"] = defaultFunc
+	routerMap["ttl"] = defaultFunc
+	routerMap["pttl"] = defaultFunc
+	routerMap["expiretime"] = defaultFunc
+	routerMap["pexpiretime"] = defaultFunc
+	routerMap["persist"] = defaultFunc
+
+	routerMap["hset"] = defaultFunc
+	routerMap["hmset"] = defaultFunc
+	routerMap["hsetnx"] = defaultFunc
+	routerMap["hget"] = defaultFunc
+	routerMap["hmget"] = defaultFunc
+	routerMap["hdel"] = defaultFunc
+	routerMap["hexists"] = defaultFunc
+	routerMap["hlen"] = defaultFunc
+	routerMap["hstrlen"] = defaultFunc
+	routerMap["hkeys"] = defaultFunc
+	routerMap["hvals"] = defaultFunc
+	routerMap["hgetall"] = defaultFunc
+	routerMap["hincrby"] = defaultFunc
+	routerMap["hincrbyfloat"] = defaultFunc
+	routerMap["hrandfield"] = defaultFunc
+
+	routerMap["sscan"] = defaultFunc
+
+	routerMap["zadd"] = defaultFunc
+	routerMap["zincrby"] = defaultFunc
+	routerMap["zrem"] = defaultFunc
+	routerMap["zscore"] = defaultFunc
+	routerMap["zmscore"] = defaultFunc
+	routerMap["zcard"] = defaultFunc
+	routerMap["zcount"] = defaultFunc
+	routerMap["zrank"] = defaultFunc
+	routerMap["zrevrank"] = defaultFunc
+	routerMap["zrange"] = defaultFunc
+	routerMap["zrevrange"] = defaultFunc
+	routerMap["zrangebyscore"] = defaultFunc
+	routerMap["zrevrangebyscore"] = defaultFunc
+	routerMap["zremrangebyrank"] = defaultFunc
+	routerMap["zremrangebyscore"] = defaultFunc
+	routerMap["zpopmin"] = defaultFunc
+	routerMap["zpopmax"] = defaultFunc
+
+	routerMap["setbit"] = defaultFunc
+	routerMap["getbit"] = defaultFunc
+	routerMap["bitcount"] = defaultFunc
+	routerMap["bitpos"] = defaultFunc
+	routerMap["bitfield"] = defaultFunc
+	routerMap["bitfield_ro"] = defaultFunc
+
+	routerMap["pfadd"] = defaultFunc
+	routerMap["pfcount"] = PFCount
+	routerMap["pfmerge"] = PFMerge
+
+	routerMap["geoadd"] = defaultFunc
+	routerMap["geopos"] = defaultFunc
+	routerMap["geodist"] = defaultFunc
+	routerMap["geohash"] = defaultFunc
+	routerMap["geosearch"] = defaultFunc
+	routerMap["georadius"] = defaultFunc
+	routerMap["georadiusbymember"] = defaultFunc
+
+	routerMap["xadd"] = defaultFunc
+	routerMap["xtrim"] = defaultFunc
+	routerMap["xlen"] = defaultFunc
+	routerMap["xdel"] = defaultFunc
+	routerMap["xrange"] = defaultFunc
+	routerMap["xrevrange"] = defaultFunc
+	routerMap["xsetid"] = defaultFunc
+	routerMap["xack"] = defaultFunc
+	routerMap["xpending"] = defaultFunc
+	routerMap["xclaim"] = defaultFunc
+	routerMap["xautoclaim"] = defaultFunc
+	routerMap["xgroup"] = relayByKeys
+	routerMap["xinfo"] = relayByKeys
+	routerMap["xread"] = relayByKeys
+	routerMap["xreadgroup"] = relayByKeys
 
 	routerMap["flushdb"] = FlushDB
 
+	routerMap["subscribe"] = execLocal
+	routerMap["unsubscribe"] = execLocal
+	routerMap["psubscribe"] = execLocal
+	routerMap["punsubscribe"] = execLocal
+	routerMap["pubsub"] = execLocal
+	routerMap["publish"] = Publish
+	routerMap[relayPublish] = onRelayedPublish
+
+	routerMap["slowlog"] = execLocal
+	routerMap["save"] = execLocal
+	routerMap["bgsave"] = execLocal
+	routerMap["lastsave"] = execLocal
+	routerMap["bgrewriteaof"] = execLocal
+
 	return routerMap
 }
 
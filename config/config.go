@@ -2,12 +2,14 @@ package config
 
 import (
 	"bufio"
+	"errors"
 	"github.com/jujunwang/Mudis/lib/logger"
 	"io"
 	"os"
 	"reflect"
 	"strconv"
 	"strings"
+	"sync/atomic"
 )
 
 // ServerProperties 定义全局的配置属性
@@ -18,26 +20,85 @@ type ServerProperties struct {
 	AppendFilename string `cfg:"appendFilename"`
 	MaxClients     int    `cfg:"maxclients"`
 	RequirePass    string `cfg:"requirepass"`
-	Databases      int    `cfg:"databases"`
+	// AclFile 是保存 ACL 用户的文件，设置后启动时从中加载用户
+	AclFile   string `cfg:"aclfile"`
+	Databases int    `cfg:"databases"`
+	// LogLevel 是日志级别，可选 debug、verbose、notice、warning 和 nothing
+	LogLevel string `cfg:"loglevel"`
+
+	// MaxMemory 是数据占用内存的上限(字节)，0 表示不限制
+	MaxMemory int64 `cfg:"maxmemory"`
+	// MaxMemoryPolicy 是内存达到上限时的淘汰策略，默认为 noeviction
+	MaxMemoryPolicy string `cfg:"maxmemory-policy"`
+	// MaxMemorySamples 是每次淘汰时抽样的 key 数，默认为 5
+	MaxMemorySamples int `cfg:"maxmemory-samples"`
+
+	// SlowLogSlowerThan 是记录慢查询的阈值(微秒)，负数表示不记录，0 表示记录所有命令
+	SlowLogSlowerThan int `cfg:"slowlog-log-slower-than"`
+	// SlowLogMaxLen 是慢查询日志保留的最大条数
+	SlowLogMaxLen int `cfg:"slowlog-max-len"`
+
+	// NotifyKeyspaceEvents 是发送的键空间通知类型，例如 KEA，为空表示不发送
+	NotifyKeyspaceEvents string `cfg:"notify-keyspace-events"`
+
+	// DbFilename 是快照文件的文件名，默认为 dump.rdb
+	DbFilename string `cfg:"dbfilename"`
+	// Save 是自动保存快照的规则，格式为 "<seconds> <changes> ..."，例如 "900 1 300 10"
+	// 表示 900 秒内至少有 1 次修改或 300 秒内至少有 10 次修改时执行 BGSAVE，为空表示不自动保存
+	Save string `cfg:"save"`
+
+	// AppendDirName 是保存 AOF 文件和清单的目录，默认为 appendonlydir
+	AppendDirName string `cfg:"appenddirname"`
+	// AppendFsync 是 AOF 的 fsync 策略，可选 always、everysec 和 no，默认为 everysec
+	AppendFsync string `cfg:"appendfsync"`
+	// AofLoadTruncated 为 true 时，启动时将末尾命令不完整的 AOF 文件截断后继续加载，否则拒绝启动
+	AofLoadTruncated bool `cfg:"aof-load-truncated"`
+	// AutoAofRewritePercentage 是自动重写 AOF 的增长比例，AOF 文件比上次重写后增长超过这个比例时重写，0 表示不自动重写
+	AutoAofRewritePercentage int `cfg:"auto-aof-rewrite-percentage"`
+	// AutoAofRewriteMinSize 是自动重写 AOF 时文件的最小大小(字节)
+	AutoAofRewriteMinSize int64 `cfg:"auto-aof-rewrite-min-size"`
 
 	Peers []string `cfg:"peers"`
 	Self  string   `cfg:"self"`
 }
 
-// Properties 保存全局的配置属性
-var Properties *ServerProperties
+// properties 保存当前生效的 *ServerProperties
+// CONFIG SET 修改配置时替换为修改后的副本，已经发布的 ServerProperties 不再修改，读取时不需要加锁
+var properties atomic.Value
 
-func init() {
-	// 默认配置
-	Properties = &ServerProperties{
-		Bind:       "127.0.0.1",
-		Port:       6379,
-		AppendOnly: false,
+// Properties 返回当前生效的配置属性，返回的配置属性是只读的，修改配置需要使用 Set
+func Properties() *ServerProperties {
+	return properties.Load().(*ServerProperties)
+}
+
+// defaultProperties 返回默认配置，配置文件中没有出现的配置项使用默认值
+func defaultProperties() *ServerProperties {
+	return &ServerProperties{
+		Bind:                     "0.0.0.0",
+		Port:                     6379,
+		AppendOnly:               false,
+		Databases:                16,
+		DbFilename:               "dump.rdb",
+		AppendDirName:            "appendonlydir",
+		AppendFsync:              "everysec",
+		AofLoadTruncated:         true,
+		AutoAofRewritePercentage: 100,
+		AutoAofRewriteMinSize:    64 << 20,
+		LogLevel:                 "notice",
+		MaxMemoryPolicy:          "noeviction",
+		MaxMemorySamples:         5,
+		SlowLogSlowerThan:        10000,
+		SlowLogMaxLen:            128,
 	}
 }
 
+func init() {
+	properties.Store(defaultProperties())
+}
+
 func parse(src io.Reader) *ServerProperties {
-	config := &ServerProperties{}
+	// 没有出现在配置文件中的配置项使用默认值，CONFIG GET 能看到实际生效的值
+	config := defaultProperties()
 
 	// 读取配置文件
 	rawMap := make(map[string]string)
@@ -49,9 +110,17 @@ func parse(src io.Reader) *ServerProperties {
 		}
 		pivot := strings.IndexAny(line, " ")
 		if pivot > 0 && pivot < len(line)-1 { // separator found
-			key := line[0:pivot]
+			key := strings.ToLower(line[0:pivot])
 			value := strings.Trim(line[pivot+1:], " ")
-			rawMap[strings.ToLower(key)] = value
+			if key == "save" {
+				// 每个 save 指令是一条规则，与 redis.conf 一样可以出现多次，save "" 清空之前的规则
+				if value == `""` {
+					value = ""
+				} else if rules := rawMap[key]; rules != "" {
+					value = rules + " " + value
+				}
+			}
+			rawMap[key] = value
 		}
 	}
 	if err := scanner.Err(); err != nil {
@@ -80,6 +149,12 @@ func parse(src io.Reader) *ServerProperties {
 				if err == nil {
 					fieldVal.SetInt(intValue)
 				}
+			case reflect.Int64:
+				// int64 类型的配置项表示内存大小，支持 kb、mb、gb 等单位
+				memValue, err := ParseMemory(value)
+				if err == nil {
+					fieldVal.SetInt(memValue)
+				}
 			case reflect.Bool:
 				boolValue := "yes" == value
 				fieldVal.SetBool(boolValue)
@@ -94,6 +169,41 @@ func parse(src io.Reader) *ServerProperties {
 	return config
 }
 
+// memoryUnits 是内存大小支持的单位，与 redis 一致：k 为 1000，kb 为 1024
+var memoryUnits = []struct {
+	suffix string
+	unit   int64
+}{
+	{"kb", 1 << 10},
+	{"mb", 1 << 20},
+	{"gb", 1 << 30},
+	{"k", 1000},
+	{"m", 1000 * 1000},
+	{"g", 1000 * 1000 * 1000},
+	{"b", 1},
+}
+
+// ParseMemory 解析带单位的内存大小，例如 100mb
+func ParseMemory(value string) (int64, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	unit := int64(1)
+	for _, u := range memoryUnits {
+		if strings.HasSuffix(value, u.suffix) {
+			value = strings.TrimSuffix(value, u.suffix)
+			unit = u.unit
+			break
+		}
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if n < 0 {
+		return 0, errors.New("negative memory size")
+	}
+	return n * unit, nil
+}
+
 // SetupConfig 读取配置文件并且保存配置到 Properties
 func SetupConfig(configFilename string) {
 	file, err := os.Open(configFilename)
@@ -101,5 +211,6 @@ func SetupConfig(configFilename string) {
 		panic(err)
 	}
 	defer file.Close()
-	Properties = parse(file)
+	properties.Store(parse(file))
+	configFile = configFilename
 }
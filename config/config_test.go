@@ -0,0 +1,71 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseSave(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{
+			name:  "single line",
+			input: "save 900 1 300 10\n",
+			want:  "900 1 300 10",
+		},
+		{
+			name:  "three lines",
+			input: "save 3600 1\nport 6380\nsave 300 100\nsave 60 10000\n",
+			want:  "3600 1 300 100 60 10000",
+		},
+		{
+			name:  "empty string clears earlier rules",
+			input: "save 3600 1\nsave \"\"\nsave 60 10000\n",
+			want:  "60 10000",
+		},
+		{
+			name:  "disabled",
+			input: "save 3600 1\nsave \"\"\n",
+			want:  "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parse(strings.NewReader(tt.input)).Save; got != tt.want {
+				t.Fatalf("Save = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRewrite(t *testing.T) {
+	t.Cleanup(func() {
+		properties.Store(defaultProperties())
+		configFile = ""
+	})
+	filename := filepath.Join(t.TempDir(), "redis.conf")
+	if err := os.WriteFile(filename, []byte("# comment\nport 6380\ndatabases 16\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	SetupConfig(filename)
+	if err := Set("maxmemory", "100mb"); err != nil {
+		t.Fatal(err)
+	}
+	if err := Rewrite(); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 文件中已有的配置项即使等于默认值也保留，没有修改过的默认值不追加
+	want := "# comment\nport 6380\ndatabases 16\n# Generated by CONFIG REWRITE\nmaxmemory 104857600\n"
+	if string(content) != want {
+		t.Fatalf("rewritten config = %q, want %q", content, want)
+	}
+}
@@ -0,0 +1,216 @@
+package config
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/jujunwang/Mudis/lib/wildcard"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+/*
+ * 运行时读取和修改配置，用于 CONFIG GET/SET/REWRITE
+ */
+
+var (
+	// setMu 保证 CONFIG SET 和 CONFIG REWRITE 串行执行
+	setMu sync.Mutex
+	// configFile 是启动时读取的配置文件，没有配置文件时为空
+	configFile string
+)
+
+// ErrNoConfigFile 表示服务器启动时没有使用配置文件
+var ErrNoConfigFile = errors.New("The server is running without a config file")
+
+// field 返回 p 中配置项对应的字段，name 不区分大小写
+func field(p *ServerProperties, name string) (reflect.Value, bool) {
+	name = strings.ToLower(name)
+	t := reflect.TypeOf(p).Elem()
+	v := reflect.ValueOf(p).Elem()
+	for i := 0; i < t.NumField(); i++ {
+		if fieldName(t.Field(i)) == name {
+			return v.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// fieldName 返回字段对应的小写配置项名称
+func fieldName(f reflect.StructField) string {
+	key, ok := f.Tag.Lookup("cfg")
+	if !ok {
+		key = f.Name
+	}
+	return strings.ToLower(key)
+}
+
+// formatValue 将配置项的值格式化为配置文件中的格式
+func formatValue(v reflect.Value) string {
+	switch v.Kind() {
+	case reflect.String:
+		return v.String()
+	case reflect.Int, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10)
+	case reflect.Bool:
+		if v.Bool() {
+			return "yes"
+		}
+		return "no"
+	case reflect.Slice:
+		if slice, ok := v.Interface().([]string); ok {
+			return strings.Join(slice, ",")
+		}
+	}
+	return ""
+}
+
+// Names 返回所有配置项的名称
+func Names() []string {
+	t := reflect.TypeOf(ServerProperties{})
+	names := make([]string, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		names = append(names, fieldName(t.Field(i)))
+	}
+	return names
+}
+
+// Get 返回配置项的值，配置项不存在时 ok 为 false
+func Get(name string) (value string, ok bool) {
+	v, ok := field(Properties(), name)
+	if !ok {
+		return "", false
+	}
+	return formatValue(v), true
+}
+
+// MatchNames 返回名称匹配 pattern 的所有配置项
+func MatchNames(pattern string) []string {
+	matcher := wildcard.CompilePattern(strings.ToLower(pattern))
+	names := make([]string, 0)
+	for _, name := range Names() {
+		if matcher.IsMatch(name) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// Set 解析并修改配置项的值，解析失败时不做任何修改
+// 修改的是当前配置的副本，修改完成后整体替换，正在读取旧配置的请求不受影响
+func Set(name string, value string) error {
+	setMu.Lock()
+	defer setMu.Unlock()
+	p := *Properties()
+	v, ok := field(&p, name)
+	if !ok {
+		return fmt.Errorf("unknown config '%s'", name)
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(value)
+	case reflect.Int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return errors.New("argument couldn't be parsed into an integer")
+		}
+		v.SetInt(int64(n))
+	case reflect.Int64:
+		n, err := ParseMemory(value)
+		if err != nil {
+			return errors.New("argument must be a memory value")
+		}
+		v.SetInt(n)
+	case reflect.Bool:
+		switch strings.ToLower(value) {
+		case "yes":
+			v.SetBool(true)
+		case "no":
+			v.SetBool(false)
+		default:
+			return errors.New("argument must be 'yes' or 'no'")
+		}
+	case reflect.Slice:
+		v.Set(reflect.ValueOf(strings.Split(value, ",")))
+	default:
+		return errors.New("unsupported config type")
+	}
+	properties.Store(&p)
+	return nil
+}
+
+// Rewrite 将当前的配置写回启动时读取的配置文件
+// 保留注释、未知的配置项和原来的顺序，只修改已有配置项的值，文件中没有的、不同于默认值的非空配置项追加到末尾
+func Rewrite() error {
+	setMu.Lock()
+	defer setMu.Unlock()
+	if configFile == "" {
+		return ErrNoConfigFile
+	}
+	file, err := os.Open(configFile)
+	if err != nil {
+		return err
+	}
+	lines := make([]string, 0)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	_ = file.Close()
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	written := make(map[string]bool)
+	result := make([]string, 0, len(lines))
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || trimmed[0] == '#' {
+			result = append(result, line)
+			continue
+		}
+		key := strings.Fields(trimmed)[0]
+		name := strings.ToLower(key)
+		value, ok := Get(name)
+		if !ok {
+			result = append(result, line)
+			continue
+		}
+		// 同一个配置项出现多次时只保留第一行，值为空的配置项删除
+		if written[name] || value == "" {
+			continue
+		}
+		written[name] = true
+		result = append(result, key+" "+value)
+	}
+	// 只追加与默认值不同的配置项，否则默认值会被固定在文件中，之后修改默认值不再生效
+	defaults := defaultProperties()
+	appended := make([]string, 0)
+	for _, name := range Names() {
+		if value, _ := Get(name); !written[name] && value != "" {
+			if v, _ := field(defaults, name); formatValue(v) != value {
+				appended = append(appended, name+" "+value)
+			}
+		}
+	}
+	if len(appended) > 0 {
+		sort.Strings(appended)
+		result = append(result, "# Generated by CONFIG REWRITE")
+		result = append(result, appended...)
+	}
+
+	tmpFile := configFile + ".tmp"
+	content := strings.Join(result, "\n") + "\n"
+	if err := os.WriteFile(tmpFile, []byte(content), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpFile, configFile); err != nil {
+		_ = os.Remove(tmpFile)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,217 @@
+package database
+
+import (
+	"github.com/jujunwang/Mudis/aof"
+	"github.com/jujunwang/Mudis/interface/database"
+	"github.com/jujunwang/Mudis/interface/resp"
+	"github.com/jujunwang/Mudis/lib/logger"
+	"github.com/jujunwang/Mudis/resp/reply"
+	"strings"
+	"time"
+)
+
+// addAof 将 DB 产生的命令写入 AOF，没有开启 AOF 时忽略，同时累计上次保存快照之后的修改次数
+// appendfsync 为 always 时返回的 channel 收到写入的结果，见 aof.AofHandler.AddAof
+func (mdb *StandaloneDatabase) addAof(dbIndex int, lines ...CmdLine) <-chan error {
+	mdb.dirty.Add(int64(len(lines)))
+	mdb.aofMu.RLock()
+	defer mdb.aofMu.RUnlock()
+	if mdb.aofHandler != nil {
+		return mdb.aofHandler.AddAof(dbIndex, lines...)
+	}
+	return nil
+}
+
+// cmdBuffer 暂存一条命令(或一个事务)执行期间产生的 AOF 命令和键空间通知
+type cmdBuffer struct {
+	aof    []CmdLine
+	events []keyEvent
+}
+
+// keyEvent 是一条键空间通知，参数与 DB.notify 相同
+type keyEvent struct {
+	class int
+	event string
+	key   string
+}
+
+// withBuffer 返回一个与 db 共享数据的副本，它产生的 AOF 命令和键空间通知会追加到 buf 中
+func (db *DB) withBuffer(buf *cmdBuffer) *DB {
+	cmdDB := *db
+	cmdDB.addAof = func(lines ...CmdLine) <-chan error {
+		buf.aof = append(buf.aof, lines...)
+		return nil
+	}
+	cmdDB.notify = func(class int, event string, key string) {
+		buf.events = append(buf.events, keyEvent{class: class, event: event, key: key})
+	}
+	return &cmdDB
+}
+
+// discard 丢弃回滚的事务产生的 AOF 命令和键空间通知
+func (buf *cmdBuffer) discard() {
+	buf.aof = nil
+	buf.events = nil
+}
+
+// flushEvents 发送 buf 中的键空间通知
+// 需要在释放 key 的锁之后调用，避免订阅者接收过慢时阻塞写入这些 key 的命令
+func (db *DB) flushEvents(buf *cmdBuffer) {
+	for _, e := range buf.events {
+		db.notify(e.class, e.event, e.key)
+	}
+}
+
+// flushAof 将 buf 中的命令作为一批写入 AOF
+// 调用方需要持有 key 的锁，以保证 AOF 中命令的顺序与执行顺序一致
+func (db *DB) flushAof(buf *cmdBuffer) <-chan error {
+	if len(buf.aof) == 0 {
+		return nil
+	}
+	return db.addAof(buf.aof...)
+}
+
+// waitAof 在释放 key 的锁之后等待 appendfsync always 的写入结果，写入失败时用 MISCONF 错误代替 result
+func waitAof(done <-chan error, result resp.Reply) resp.Reply {
+	if done == nil {
+		return result
+	}
+	if err := <-done; err != nil {
+		return reply.MakeErrReply("MISCONF Errors writing to the AOF file: " + err.Error())
+	}
+	return result
+}
+
+// aof 返回 AOF 持久化处理器，没有开启 AOF 时返回 nil
+func (mdb *StandaloneDatabase) aof() *aof.AofHandler {
+	mdb.aofMu.RLock()
+	defer mdb.aofMu.RUnlock()
+	return mdb.aofHandler
+}
+
+// SetAppendOnly 在运行时开启或关闭 AOF
+// 开启时清空 AOF 文件并写入当前所有的数据，写入期间所有访问 key 的命令都会等待
+func (mdb *StandaloneDatabase) SetAppendOnly(enabled bool) error {
+	if !enabled {
+		mdb.aofMu.Lock()
+		handler := mdb.aofHandler
+		mdb.aofHandler = nil
+		mdb.aofMu.Unlock()
+		if handler != nil {
+			// 等待队列中的命令写入文件
+			handler.Close()
+		}
+		return nil
+	}
+
+	// 持有所有 key 的锁，保证写入 AOF 的数据与之后追加的命令之间没有遗漏或重复
+	for _, db := range mdb.dbSet {
+		db.locker.LockAll()
+	}
+	defer func() {
+		for i := len(mdb.dbSet) - 1; i >= 0; i-- {
+			mdb.dbSet[i].locker.UnLockAll()
+		}
+	}()
+	mdb.aofMu.Lock()
+	defer mdb.aofMu.Unlock()
+	if mdb.aofHandler != nil {
+		return nil
+	}
+	handler, err := aof.NewEmptyAOFHandler(mdb, newAuxiliaryDatabase)
+	if err != nil {
+		return err
+	}
+	for _, db := range mdb.dbSet {
+		db.dumpAof(handler)
+	}
+	mdb.aofHandler = handler
+	logger.Info("append only file enabled")
+	return nil
+}
+
+// dumpAof 将 DB 中所有未过期的 key 以命令的形式写入 AOF，调用方需要持有所有 key 的锁
+func (db *DB) dumpAof(handler *aof.AofHandler) {
+	db.data.ForEach(func(key string, val interface{}) bool {
+		if db.isExpiredNoDel(key) {
+			return true
+		}
+		cmdLines := aof.EntityToCmds(key, val.(*database.DataEntity))
+		if len(cmdLines) == 0 {
+			return true
+		}
+		if expireTime, ok := db.ExpireTime(key); ok {
+			cmdLines = append(cmdLines, makeExpireCmd(key, expireTime))
+		}
+		handler.AddAofNoWait(db.index, cmdLines...)
+		return true
+	})
+}
+
+// checkAofWriteErr 在写入或 fsync AOF 失败后拒绝写命令，直到重试写入成功
+func (mdb *StandaloneDatabase) checkAofWriteErr(c resp.Connection, cmdLine [][]byte) resp.Reply {
+	handler := mdb.aof()
+	if handler == nil || mdb.loading {
+		return nil
+	}
+	err := handler.LastWriteErr()
+	if err == nil {
+		return nil
+	}
+	cmdName := strings.ToLower(string(cmdLine[0]))
+	write := false
+	if cmdName == "exec" {
+		if c.InMultiState() && len(c.GetTxErrors()) == 0 {
+			for _, queued := range c.GetQueuedCmdLine() {
+				if IsWriteCommand(queued) {
+					write = true
+					break
+				}
+			}
+		}
+	} else {
+		write = IsWriteCommand(cmdLine)
+	}
+	if !write {
+		return nil
+	}
+	errReply := reply.MakeErrReply("MISCONF Errors writing to the AOF file: " + err.Error())
+	if c.InMultiState() {
+		if cmdName == "exec" {
+			return abortExec(c, errReply)
+		}
+		c.AddTxError(errReply)
+	}
+	return errReply
+}
+
+// execBGRewriteAof BGREWRITEAOF
+func (mdb *StandaloneDatabase) execBGRewriteAof(args [][]byte) resp.Reply {
+	if len(args) != 0 {
+		return reply.MakeArgNumErrReply("bgrewriteaof")
+	}
+	handler := mdb.aof()
+	if handler == nil {
+		return reply.MakeErrReply("ERR Background append only file rewriting is only possible when appendonly is enabled")
+	}
+	if err := handler.BGRewrite(); err != nil {
+		return reply.MakeErrReply("ERR " + err.Error())
+	}
+	return reply.MakeStatusReply("Background append only file rewriting started")
+}
+
+// ForEach 遍历编号为 dbIndex 的 DB 中所有未过期的 key
+// 遍历时不加锁，只用于重写 AOF 时的临时数据库
+func (mdb *StandaloneDatabase) ForEach(dbIndex int, cb func(key string, entity *database.DataEntity, expiration *time.Time) bool) {
+	db := mdb.dbSet[dbIndex]
+	db.data.ForEach(func(key string, val interface{}) bool {
+		if db.isExpiredNoDel(key) {
+			return true
+		}
+		var expiration *time.Time
+		if expireTime, ok := db.ExpireTime(key); ok {
+			expiration = &expireTime
+		}
+		return cb(key, val.(*database.DataEntity), expiration)
+	})
+}
@@ -0,0 +1,553 @@
+package database
+
+import (
+	"github.com/jujunwang/Mudis/datastruct/bitmap"
+	"github.com/jujunwang/Mudis/interface/database"
+	"github.com/jujunwang/Mudis/interface/resp"
+	"github.com/jujunwang/Mudis/lib/utils"
+	"github.com/jujunwang/Mudis/resp/reply"
+	"math/big"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+// maxBitOffset 与 redis 一致，string 最大为 512MB
+const maxBitOffset = 1<<32 - 1
+
+func parseBitOffset(arg []byte) (int64, reply.ErrorReply) {
+	offset, err := strconv.ParseInt(string(arg), 10, 64)
+	if err != nil || offset < 0 || offset > maxBitOffset {
+		return 0, reply.MakeErrReply("ERR bit offset is not an integer or out of range")
+	}
+	return offset, nil
+}
+
+// putBitmap 将修改后的位图写回 DB，保留原有的过期时间
+func (db *DB) putBitmap(key string, bm *bitmap.BitMap) {
+	db.PutEntity(key, &database.DataEntity{
+		Data: bm.ToBytes(),
+	})
+}
+
+// execSetBit SETBIT key offset value
+func execSetBit(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	offset, errReply := parseBitOffset(args[1])
+	if errReply != nil {
+		return errReply
+	}
+	valStr := string(args[2])
+	if valStr != "0" && valStr != "1" {
+		return reply.MakeErrReply("ERR bit is not an integer or out of range")
+	}
+	bytes, errReply := db.getAsString(key)
+	if errReply != nil {
+		return errReply
+	}
+	// 修改副本，已经返回给其它客户端的回复可能仍在使用原数组
+	bm := bitmap.FromBytes(append([]byte(nil), bytes...))
+	former := bm.GetBit(offset)
+	bm.SetBit(offset, valStr[0]-'0')
+	db.putBitmap(key, bm)
+	db.addAof(utils.ToCmdLine3("setbit", args...))
+	return reply.MakeIntReply(int64(former))
+}
+
+// execGetBit GETBIT key offset
+func execGetBit(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	offset, errReply := parseBitOffset(args[1])
+	if errReply != nil {
+		return errReply
+	}
+	bytes, errReply := db.getAsString(key)
+	if errReply != nil {
+		return errReply
+	}
+	bm := bitmap.FromBytes(bytes)
+	return reply.MakeIntReply(int64(bm.GetBit(offset)))
+}
+
+// bitRange 将 [start, end] 转换为位的范围 [begin, stop)
+// isBit 为 false 时 start 和 end 以字节为单位，支持负数下标
+func bitRange(start int64, end int64, byteLen int64, isBit bool) (begin int64, stop int64) {
+	size := byteLen
+	if isBit {
+		size = byteLen * 8
+	}
+	if start < 0 {
+		start += size
+	}
+	if end < 0 {
+		end += size
+	}
+	if start < 0 {
+		start = 0
+	}
+	if end < 0 {
+		end = 0
+	}
+	if end >= size {
+		end = size - 1
+	}
+	if start > end {
+		return 0, 0
+	}
+	if isBit {
+		return start, end + 1
+	}
+	return start * 8, (end + 1) * 8
+}
+
+// parseRangeUnit 解析可选的 BYTE|BIT 参数
+func parseRangeUnit(args [][]byte) (isBit bool, errReply reply.ErrorReply) {
+	if len(args) == 0 {
+		return false, nil
+	}
+	if len(args) > 1 {
+		return false, reply.MakeSyntaxErrReply()
+	}
+	switch strings.ToUpper(string(args[0])) {
+	case "BYTE":
+		return false, nil
+	case "BIT":
+		return true, nil
+	}
+	return false, reply.MakeSyntaxErrReply()
+}
+
+func parseRangeIndex(arg []byte) (int64, reply.ErrorReply) {
+	index, err := strconv.ParseInt(string(arg), 10, 64)
+	if err != nil {
+		return 0, reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	return index, nil
+}
+
+// execBitCount BITCOUNT key [start end [BYTE|BIT]]
+func execBitCount(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	if len(args) == 2 || len(args) > 4 {
+		return reply.MakeSyntaxErrReply()
+	}
+	bytes, errReply := db.getAsString(key)
+	if errReply != nil {
+		return errReply
+	}
+	begin, stop := int64(0), int64(len(bytes))*8
+	if len(args) > 1 {
+		start, errReply := parseRangeIndex(args[1])
+		if errReply != nil {
+			return errReply
+		}
+		end, errReply := parseRangeIndex(args[2])
+		if errReply != nil {
+			return errReply
+		}
+		isBit, errReply := parseRangeUnit(args[3:])
+		if errReply != nil {
+			return errReply
+		}
+		begin, stop = bitRange(start, end, int64(len(bytes)), isBit)
+	}
+	bm := bitmap.FromBytes(bytes)
+	var count int64
+	for offset := begin; offset < stop; {
+		// 按字节对齐时直接统计整个字节
+		if offset%8 == 0 && offset+8 <= stop {
+			count += int64(bits.OnesCount8(bytes[offset/8]))
+			offset += 8
+			continue
+		}
+		count += int64(bm.GetBit(offset))
+		offset++
+	}
+	return reply.MakeIntReply(count)
+}
+
+// execBitPos BITPOS key bit [start [end [BYTE|BIT]]]
+func execBitPos(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	bitStr := string(args[1])
+	if bitStr != "0" && bitStr != "1" {
+		return reply.MakeErrReply("ERR The bit argument must be 1 or 0.")
+	}
+	if len(args) > 5 {
+		return reply.MakeSyntaxErrReply()
+	}
+	target := bitStr[0] - '0'
+	bytes, errReply := db.getAsString(key)
+	if errReply != nil {
+		return errReply
+	}
+	if bytes == nil {
+		if target == 0 {
+			return reply.MakeIntReply(0)
+		}
+		return reply.MakeIntReply(-1)
+	}
+	byteLen := int64(len(bytes))
+	start, end := int64(0), int64(-1)
+	isBit := false
+	endGiven := false
+	if len(args) > 2 {
+		start, errReply = parseRangeIndex(args[2])
+		if errReply != nil {
+			return errReply
+		}
+	}
+	if len(args) > 3 {
+		end, errReply = parseRangeIndex(args[3])
+		if errReply != nil {
+			return errReply
+		}
+		endGiven = true
+		isBit, errReply = parseRangeUnit(args[4:])
+		if errReply != nil {
+			return errReply
+		}
+	}
+	begin, stop := bitRange(start, end, byteLen, isBit)
+	bm := bitmap.FromBytes(bytes)
+	for offset := begin; offset < stop; offset++ {
+		if bm.GetBit(offset) == target {
+			return reply.MakeIntReply(offset)
+		}
+	}
+	// 查找 0 且没有指定结束位置时，认为字符串右侧是无限个 0
+	if target == 0 && !endGiven && begin < stop {
+		return reply.MakeIntReply(stop)
+	}
+	return reply.MakeIntReply(-1)
+}
+
+func prepareBitOp(args [][]byte) ([]string, []string) {
+	dest := string(args[1])
+	keys := make([]string, 0, len(args)-2)
+	for _, arg := range args[2:] {
+		keys = append(keys, string(arg))
+	}
+	return []string{dest}, keys
+}
+
+func undoBitOp(db *DB, args [][]byte) []CmdLine {
+	return rollbackGivenKeys(db, string(args[1]))
+}
+
+// execBitOp BITOP AND|OR|XOR|NOT destkey key [key ...]
+func execBitOp(db *DB, args [][]byte) resp.Reply {
+	op := strings.ToUpper(string(args[0]))
+	dest := string(args[1])
+	srcKeys := args[2:]
+	switch op {
+	case "AND", "OR", "XOR":
+	case "NOT":
+		if len(srcKeys) != 1 {
+			return reply.MakeErrReply("ERR BITOP NOT must be called with a single source key.")
+		}
+	default:
+		return reply.MakeSyntaxErrReply()
+	}
+
+	values := make([][]byte, len(srcKeys))
+	maxLen := 0
+	for i, arg := range srcKeys {
+		bytes, errReply := db.getAsString(string(arg))
+		if errReply != nil {
+			return errReply
+		}
+		values[i] = bytes
+		if len(bytes) > maxLen {
+			maxLen = len(bytes)
+		}
+	}
+
+	// 较短的字符串在右侧补 0
+	result := make([]byte, maxLen)
+	for i := 0; i < maxLen; i++ {
+		var b byte
+		for j, value := range values {
+			var v byte
+			if i < len(value) {
+				v = value[i]
+			}
+			switch {
+			case op == "NOT":
+				b = ^v
+			case j == 0:
+				b = v
+			case op == "AND":
+				b &= v
+			case op == "OR":
+				b |= v
+			case op == "XOR":
+				b ^= v
+			}
+		}
+		result[i] = b
+	}
+
+	if maxLen == 0 {
+		db.Remove(dest)
+	} else {
+		db.PutEntity(dest, &database.DataEntity{
+			Data: result,
+		})
+		db.Persist(dest)
+	}
+	db.addAof(utils.ToCmdLine3("bitop", args...))
+	return reply.MakeIntReply(int64(maxLen))
+}
+
+/* ---- BITFIELD ---- */
+
+const (
+	overflowWrap = iota
+	overflowSat
+	overflowFail
+)
+
+// bitFieldType 是 BITFIELD 中的整数类型，例如 i8, u16
+type bitFieldType struct {
+	signed bool
+	width  int
+}
+
+func parseBitFieldType(arg []byte) (*bitFieldType, reply.ErrorReply) {
+	s := strings.ToLower(string(arg))
+	errReply := reply.MakeErrReply("ERR Invalid bitfield type. Use something like i16 u8. Note that u64 is not supported but i64 is.")
+	if len(s) < 2 || (s[0] != 'i' && s[0] != 'u') {
+		return nil, errReply
+	}
+	width, err := strconv.Atoi(s[1:])
+	if err != nil || width < 1 {
+		return nil, errReply
+	}
+	signed := s[0] == 'i'
+	if (signed && width > 64) || (!signed && width > 63) {
+		return nil, errReply
+	}
+	return &bitFieldType{signed: signed, width: width}, nil
+}
+
+// parseBitFieldOffset 解析偏移量，#N 代表 N 倍的类型宽度
+func parseBitFieldOffset(arg []byte, t *bitFieldType) (int64, reply.ErrorReply) {
+	s := string(arg)
+	multiply := false
+	if strings.HasPrefix(s, "#") {
+		multiply = true
+		s = s[1:]
+	}
+	offset, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || offset < 0 {
+		return 0, reply.MakeErrReply("ERR bit offset is not an integer or out of range")
+	}
+	// 先检查范围再计算，避免乘法和加法溢出
+	width := int64(t.width)
+	if multiply {
+		if offset > maxBitOffset/width {
+			return 0, reply.MakeErrReply("ERR bit offset is not an integer or out of range")
+		}
+		offset *= width
+	}
+	if offset > maxBitOffset-width+1 {
+		return 0, reply.MakeErrReply("ERR bit offset is not an integer or out of range")
+	}
+	return offset, nil
+}
+
+func (t *bitFieldType) min() *big.Int {
+	if !t.signed {
+		return big.NewInt(0)
+	}
+	return new(big.Int).Neg(new(big.Int).Lsh(big.NewInt(1), uint(t.width-1)))
+}
+
+func (t *bitFieldType) max() *big.Int {
+	if !t.signed {
+		return new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), uint(t.width)), big.NewInt(1))
+	}
+	return new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), uint(t.width-1)), big.NewInt(1))
+}
+
+// decode 将读出的 width 位转换为整数
+func (t *bitFieldType) decode(raw uint64) int64 {
+	if t.signed && t.width < 64 && raw&(1<<uint(t.width-1)) != 0 {
+		return int64(raw) - int64(1)<<uint(t.width)
+	}
+	return int64(raw)
+}
+
+// encode 将整数转换为 width 位
+func (t *bitFieldType) encode(value int64) uint64 {
+	if t.width == 64 {
+		return uint64(value)
+	}
+	return uint64(value) & (uint64(1)<<uint(t.width) - 1)
+}
+
+// fit 按照溢出策略将 value 转换到类型的取值范围内，FAIL 策略下溢出返回 false
+func (t *bitFieldType) fit(value *big.Int, overflow int) (int64, bool) {
+	min, max := t.min(), t.max()
+	if value.Cmp(min) >= 0 && value.Cmp(max) <= 0 {
+		return value.Int64(), true
+	}
+	switch overflow {
+	case overflowSat:
+		if value.Cmp(min) < 0 {
+			return min.Int64(), true
+		}
+		return max.Int64(), true
+	case overflowFail:
+		return 0, false
+	}
+	// WRAP: 对 2^width 取模
+	modulus := new(big.Int).Lsh(big.NewInt(1), uint(t.width))
+	wrapped := new(big.Int).Mod(value, modulus)
+	if t.signed && wrapped.Cmp(max) > 0 {
+		wrapped.Sub(wrapped, modulus)
+	}
+	return wrapped.Int64(), true
+}
+
+// bitFieldOp 是 BITFIELD 中的一个子命令
+type bitFieldOp struct {
+	name     string // get, set, incrby
+	t        *bitFieldType
+	offset   int64
+	value    int64
+	overflow int
+}
+
+func parseBitFieldOps(args [][]byte, readOnly bool) ([]*bitFieldOp, reply.ErrorReply) {
+	ops := make([]*bitFieldOp, 0)
+	overflow := overflowWrap
+	for i := 0; i < len(args); {
+		name := strings.ToLower(string(args[i]))
+		if readOnly && name != "get" {
+			return nil, reply.MakeErrReply("ERR BITFIELD_RO only supports the GET subcommand")
+		}
+		switch name {
+		case "overflow":
+			if i+1 >= len(args) {
+				return nil, reply.MakeSyntaxErrReply()
+			}
+			switch strings.ToUpper(string(args[i+1])) {
+			case "WRAP":
+				overflow = overflowWrap
+			case "SAT":
+				overflow = overflowSat
+			case "FAIL":
+				overflow = overflowFail
+			default:
+				return nil, reply.MakeErrReply("ERR Invalid OVERFLOW type specified")
+			}
+			i += 2
+		case "get", "set", "incrby":
+			argCount := 3
+			if name == "get" {
+				argCount = 2
+			}
+			if i+argCount >= len(args) {
+				return nil, reply.MakeSyntaxErrReply()
+			}
+			t, errReply := parseBitFieldType(args[i+1])
+			if errReply != nil {
+				return nil, errReply
+			}
+			offset, errReply := parseBitFieldOffset(args[i+2], t)
+			if errReply != nil {
+				return nil, errReply
+			}
+			op := &bitFieldOp{
+				name:     name,
+				t:        t,
+				offset:   offset,
+				overflow: overflow,
+			}
+			if name != "get" {
+				value, err := strconv.ParseInt(string(args[i+3]), 10, 64)
+				if err != nil {
+					return nil, reply.MakeErrReply("ERR value is not an integer or out of range")
+				}
+				op.value = value
+			}
+			ops = append(ops, op)
+			i += argCount + 1
+		default:
+			return nil, reply.MakeSyntaxErrReply()
+		}
+	}
+	return ops, nil
+}
+
+func execBitField0(db *DB, args [][]byte, readOnly bool) resp.Reply {
+	key := string(args[0])
+	ops, errReply := parseBitFieldOps(args[1:], readOnly)
+	if errReply != nil {
+		return errReply
+	}
+	bytes, errReply := db.getAsString(key)
+	if errReply != nil {
+		return errReply
+	}
+	if !readOnly {
+		// 修改副本，已经返回给其它客户端的回复可能仍在使用原数组
+		bytes = append([]byte(nil), bytes...)
+	}
+	bm := bitmap.FromBytes(bytes)
+	results := make([]resp.Reply, 0, len(ops))
+	modified := false
+	for _, op := range ops {
+		current := op.t.decode(bm.GetBits(op.offset, op.t.width))
+		switch op.name {
+		case "get":
+			results = append(results, reply.MakeIntReply(current))
+		case "set":
+			value, ok := op.t.fit(big.NewInt(op.value), op.overflow)
+			if !ok {
+				results = append(results, &reply.NullBulkReply{})
+				continue
+			}
+			bm.SetBits(op.offset, op.t.width, op.t.encode(value))
+			modified = true
+			results = append(results, reply.MakeIntReply(current))
+		case "incrby":
+			sum := new(big.Int).Add(big.NewInt(current), big.NewInt(op.value))
+			value, ok := op.t.fit(sum, op.overflow)
+			if !ok {
+				results = append(results, &reply.NullBulkReply{})
+				continue
+			}
+			bm.SetBits(op.offset, op.t.width, op.t.encode(value))
+			modified = true
+			results = append(results, reply.MakeIntReply(value))
+		}
+	}
+	if modified {
+		db.putBitmap(key, bm)
+		db.addAof(utils.ToCmdLine3("bitfield", args...))
+	}
+	return reply.MakeMultiRawReply(results)
+}
+
+// execBitField BITFIELD key [GET type offset] [SET type offset value] [INCRBY type offset increment] [OVERFLOW WRAP|SAT|FAIL] ...
+func execBitField(db *DB, args [][]byte) resp.Reply {
+	return execBitField0(db, args, false)
+}
+
+// execBitFieldRO BITFIELD_RO key [GET type offset ...]
+func execBitFieldRO(db *DB, args [][]byte) resp.Reply {
+	return execBitField0(db, args, true)
+}
+
+func init() {
+	RegisterCommand("SetBit", execSetBit, writeFirstKey, rollbackFirstKey, 4)
+	RegisterCommand("GetBit", execGetBit, readFirstKey, nil, 3)
+	RegisterCommand("BitCount", execBitCount, readFirstKey, nil, -2)
+	RegisterCommand("BitPos", execBitPos, readFirstKey, nil, -3)
+	RegisterCommand("BitOp", execBitOp, prepareBitOp, undoBitOp, -4)
+	RegisterCommand("BitField", execBitField, writeFirstKey, rollbackFirstKey, -2)
+	RegisterCommand("BitField_RO", execBitFieldRO, readFirstKey, nil, -2)
+}
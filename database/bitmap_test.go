@@ -0,0 +1,34 @@
+package database
+
+import (
+	"github.com/jujunwang/Mudis/lib/utils"
+	"github.com/jujunwang/Mudis/resp/reply"
+	"testing"
+)
+
+func TestBitmapWritesDoNotModifyReturnedValue(t *testing.T) {
+	tests := []struct {
+		name    string
+		cmdLine []string
+	}{
+		{"setbit", []string{"setbit", "k", "7", "1"}},
+		{"bitfield set", []string{"bitfield", "k", "set", "u8", "0", "255"}},
+		{"bitfield incrby", []string{"bitfield", "k", "incrby", "u8", "0", "1"}},
+		{"setrange", []string{"setrange", "k", "0", "b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := makeDB()
+			db.Exec(nil, utils.ToCmdLine("set", "k", "a"))
+			// GET 的回复可能在释放锁之后才写给客户端
+			got, ok := db.Exec(nil, utils.ToCmdLine("get", "k")).(*reply.BulkReply)
+			if !ok {
+				t.Fatal("GET did not return a bulk reply")
+			}
+			db.Exec(nil, utils.ToCmdLine(tt.cmdLine...))
+			if string(got.Arg) != "a" {
+				t.Fatalf("returned value changed to %q", got.Arg)
+			}
+		})
+	}
+}
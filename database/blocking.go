@@ -0,0 +1,260 @@
+package database
+
+import (
+	"github.com/jujunwang/Mudis/datastruct/list"
+	"github.com/jujunwang/Mudis/interface/resp"
+	"github.com/jujunwang/Mudis/resp/reply"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TryFunc 尝试非阻塞地执行阻塞命令
+// ready 判断当前客户端能否从 key 中弹出元素(保证等待者之间的 FIFO 顺序)
+// 没有可弹出的元素时返回 false，此时 reply 为超时后应返回的空回复
+type TryFunc func(db *DB, args [][]byte, ready func(key string) bool) (resp.Reply, bool)
+
+type blockingCommand struct {
+	try TryFunc
+	// keys 返回需要等待的 key
+	keys func(args [][]byte) []string
+	// timeout 解析超时时间，block 为 false 时命令不阻塞，按普通命令执行
+	timeout func(args [][]byte) (timeout time.Duration, block bool, errReply resp.Reply)
+}
+
+var blockingCmdTable = make(map[string]*blockingCommand)
+
+// registerBlockingCommand 注册一个阻塞命令
+// 在事务中阻塞命令不会阻塞，没有元素时直接返回空回复
+func registerBlockingCommand(name string, try TryFunc, keys func(args [][]byte) []string,
+	timeout func(args [][]byte) (time.Duration, bool, resp.Reply), prepare PreFunc, arity int) {
+	name = strings.ToLower(name)
+	executor := func(db *DB, args [][]byte) resp.Reply {
+		result, _ := try(db, args, alwaysReady)
+		return result
+	}
+	RegisterCommand(name, executor, prepare, rollbackPreparedKeys(prepare), arity)
+	blockingCmdTable[name] = &blockingCommand{
+		try:     try,
+		keys:    keys,
+		timeout: timeout,
+	}
+}
+
+func alwaysReady(key string) bool {
+	return true
+}
+
+// waiter 是一个阻塞在若干个 key 上的客户端
+type waiter struct {
+	conn resp.Connection
+	keys []string
+	// key 有新元素时收到通知
+	notify chan struct{}
+}
+
+// blockingQueues 保存每个 key 上按到达顺序排列的等待者
+type blockingQueues struct {
+	mu sync.Mutex
+	// key -> *list.LinkedList(*waiter)
+	queues map[string]*list.LinkedList
+	// connection -> *waiter
+	waiters map[resp.Connection]*waiter
+}
+
+func makeBlockingQueues() *blockingQueues {
+	return &blockingQueues{
+		queues:  make(map[string]*list.LinkedList),
+		waiters: make(map[resp.Connection]*waiter),
+	}
+}
+
+// block 将客户端加入 keys 的等待队列末尾，调用方需要持有 keys 的锁以免错过通知
+// 连接已经关闭时不再排队，返回 nil
+func (b *blockingQueues) block(conn resp.Connection, keys []string) *waiter {
+	select {
+	case <-conn.Closed():
+		return nil
+	default:
+	}
+	w := &waiter{
+		conn:   conn,
+		keys:   keys,
+		notify: make(chan struct{}, 1),
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, key := range keys {
+		queue, ok := b.queues[key]
+		if !ok {
+			queue = list.Make()
+			b.queues[key] = queue
+		}
+		queue.Add(w)
+	}
+	b.waiters[conn] = w
+	return w
+}
+
+// unblock 将等待者移出所有队列，并唤醒排在它后面的等待者
+func (b *blockingQueues) unblock(w *waiter) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, key := range w.keys {
+		queue, ok := b.queues[key]
+		if !ok {
+			continue
+		}
+		queue.RemoveByVal(w, 1)
+		if queue.Len() == 0 {
+			delete(b.queues, key)
+			continue
+		}
+		signalQueue(queue)
+	}
+	if b.waiters[w.conn] == w {
+		delete(b.waiters, w.conn)
+	}
+}
+
+// signal 通知 key 上的等待者重新尝试
+func (b *blockingQueues) signal(key string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	queue, ok := b.queues[key]
+	if !ok {
+		return
+	}
+	signalQueue(queue)
+}
+
+func signalQueue(queue *list.LinkedList) {
+	queue.ForEach(func(i int, val interface{}) bool {
+		w, _ := val.(*waiter)
+		select {
+		case w.notify <- struct{}{}:
+		default:
+		}
+		return true
+	})
+}
+
+// isReady 只有排在队首的等待者才能从 key 中弹出元素，新来的客户端需要等待队列为空
+func (b *blockingQueues) isReady(key string, w *waiter) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	queue, ok := b.queues[key]
+	if !ok || queue.Len() == 0 {
+		return true
+	}
+	return queue.Get(0) == w
+}
+
+// count 返回正在阻塞等待的客户端数
+func (b *blockingQueues) count() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.waiters)
+}
+
+// signalKey 通知阻塞在 key 上的客户端，在向列表或流中添加元素之后调用
+func (db *DB) signalKey(key string) {
+	db.blocking.signal(key)
+}
+
+func parseTimeout(arg []byte) (time.Duration, resp.Reply) {
+	seconds, err := strconv.ParseFloat(string(arg), 64)
+	if err != nil || math.IsNaN(seconds) || math.IsInf(seconds, 0) {
+		return 0, reply.MakeErrReply("ERR timeout is not a float or out of range")
+	}
+	if seconds < 0 {
+		return 0, reply.MakeErrReply("ERR timeout is negative")
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// timeoutInFirstArg 第一个参数为以秒为单位的超时时间
+func timeoutInFirstArg(args [][]byte) (time.Duration, bool, resp.Reply) {
+	timeout, errReply := parseTimeout(args[0])
+	return timeout, true, errReply
+}
+
+// timeoutInLastArg 最后一个参数为以秒为单位的超时时间
+func timeoutInLastArg(args [][]byte) (time.Duration, bool, resp.Reply) {
+	timeout, errReply := parseTimeout(args[len(args)-1])
+	return timeout, true, errReply
+}
+
+// execBlockingCommand 执行阻塞命令
+// 没有可弹出的元素时在 key 上排队等待，直到被 push 唤醒、超时或者连接断开
+func (db *DB) execBlockingCommand(c resp.Connection, bcmd *blockingCommand, cmdLine [][]byte) resp.Reply {
+	cmdName := strings.ToLower(string(cmdLine[0]))
+	cmd := cmdTable[cmdName]
+	if !validateArity(cmd.arity, cmdLine) {
+		return reply.MakeArgNumErrReply(cmdName)
+	}
+	args := cmdLine[1:]
+	timeout, block, errReply := bcmd.timeout(args)
+	if errReply != nil {
+		return errReply
+	}
+	if !block {
+		return db.execNormalCommand(cmdLine)
+	}
+	var deadline <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+
+	write, read := cmd.prepare(args)
+	var w *waiter
+	defer func() {
+		if w != nil {
+			db.blocking.unblock(w)
+		}
+	}()
+	ready := func(key string) bool {
+		return db.blocking.isReady(key, w)
+	}
+	try := func(buf *cmdBuffer) (resp.Reply, bool, <-chan error) {
+		db.RWLocks(write, read)
+		defer db.RWUnLocks(write, read)
+		result, ok := bcmd.try(db.withBuffer(buf), args, ready)
+		if ok {
+			db.addVersion(write...)
+			db.updateMemory(write...)
+		} else if w == nil {
+			w = db.blocking.block(c, bcmd.keys(args))
+		}
+		return result, ok, db.flushAof(buf)
+	}
+	for {
+		buf := &cmdBuffer{}
+		result, ok, done := try(buf)
+		db.flushEvents(buf)
+		if ok {
+			return waitAof(done, result)
+		}
+		if w == nil {
+			// 连接已经关闭
+			return result
+		}
+		select {
+		case <-w.notify:
+		case <-deadline:
+			return result
+		case <-c.Closed():
+			return result
+		}
+		// 同时收到通知和连接关闭时，不再为已经关闭的连接弹出元素
+		select {
+		case <-c.Closed():
+			return result
+		default:
+		}
+	}
+}
@@ -6,18 +6,92 @@ import (
 
 var cmdTable = make(map[string]*command)
 
+// lockAllCommands 中的命令会修改所有 key，执行时对所有 key 加写锁
+var lockAllCommands = map[string]struct{}{
+	"flushdb": {},
+}
+
 type command struct {
 	executor ExecFunc
+	// prepare 返回命令需要加写锁和读锁的 key
+	prepare PreFunc
+	// undo 生成撤销该命令的命令，为 nil 代表命令不需要回滚(例如只读命令)
+	undo UndoFunc
 	// 合法的命令args的长度，当arity < 0代表 args 的长度 >= arity
 	arity int
 }
 
 // RegisterCommand 注册一个新命令
 // arity 表示合法的cmdArgs长度, arity < 0 意味着 len(args) >= -arity. 例如: `get` 是 2, `mget` 是 -2
-func RegisterCommand(name string, executor ExecFunc, arity int) {
+func RegisterCommand(name string, executor ExecFunc, prepare PreFunc, rollback UndoFunc, arity int) {
 	name = strings.ToLower(name)
 	cmdTable[name] = &command{
 		executor: executor,
+		prepare:  prepare,
+		undo:     rollback,
 		arity:    arity,
 	}
 }
+
+// IsWriteCommand 判断命令是否会修改数据，包括写入 key 的命令和 FLUSHDB
+func IsWriteCommand(cmdLine [][]byte) bool {
+	cmdName := strings.ToLower(string(cmdLine[0]))
+	if cmdName == "flushdb" {
+		return true
+	}
+	cmd, ok := cmdTable[cmdName]
+	if !ok || !validateArity(cmd.arity, cmdLine) {
+		return false
+	}
+	write, _ := cmd.prepare(cmdLine[1:])
+	return len(write) > 0
+}
+
+// CommandKeys 返回命令读写的所有 key，用于 ACL 检查 key 的访问权限
+// 未知的命令和参数个数错误的命令返回 nil
+func CommandKeys(cmdLine [][]byte) []string {
+	cmdName := strings.ToLower(string(cmdLine[0]))
+	cmd, ok := cmdTable[cmdName]
+	if !ok || !validateArity(cmd.arity, cmdLine) {
+		return nil
+	}
+	write, read := cmd.prepare(cmdLine[1:])
+	return append(write, read...)
+}
+
+/* ---- 常用的 PreFunc ---- */
+
+// noPrepare 不需要对任何 key 加锁
+func noPrepare(args [][]byte) ([]string, []string) {
+	return nil, nil
+}
+
+// readFirstKey 对第一个 key 加读锁
+func readFirstKey(args [][]byte) ([]string, []string) {
+	key := string(args[0])
+	return nil, []string{key}
+}
+
+// writeFirstKey 对第一个 key 加写锁
+func writeFirstKey(args [][]byte) ([]string, []string) {
+	key := string(args[0])
+	return []string{key}, nil
+}
+
+// readAllKeys 对所有参数加读锁
+func readAllKeys(args [][]byte) ([]string, []string) {
+	keys := make([]string, len(args))
+	for i, v := range args {
+		keys[i] = string(v)
+	}
+	return nil, keys
+}
+
+// writeAllKeys 对所有参数加写锁
+func writeAllKeys(args [][]byte) ([]string, []string) {
+	keys := make([]string, len(args))
+	for i, v := range args {
+		keys[i] = string(v)
+	}
+	return keys, nil
+}
@@ -5,26 +5,61 @@ import (
 	"github.com/jujunwang/Mudis/datastruct/dict"
 	"github.com/jujunwang/Mudis/interface/database"
 	"github.com/jujunwang/Mudis/interface/resp"
+	"github.com/jujunwang/Mudis/lib/sync/atomic"
+	"github.com/jujunwang/Mudis/lib/sync/lock"
 	"github.com/jujunwang/Mudis/resp/reply"
 	"strings"
+	"time"
 )
 
 const (
 	dataDictSize = 1 << 16
+	ttlDictSize  = 1 << 10
+	statDictSize = 1 << 12
+	lockerSize   = 1024
 )
 
 // DB 存储数据、执行用户的命令
 type DB struct {
 	index int
 	// key -> DataEntity
-	data   dict.Dict
-	addAof func(CmdLine)
+	data dict.Dict
+	// key -> expireTime (time.Time)
+	ttlMap dict.Dict
+	// 在执行命令前对 key 加锁，保证多 key 命令和读-改-写命令的原子性
+	locker *lock.Locks
+	// key 的版本号，用于 WATCH
+	versions *keyVersions
+	// 阻塞在各个 key 上的客户端
+	blocking *blockingQueues
+	// 每个 key 估算的内存占用和访问信息，用于内存淘汰
+	memory *memoryUsage
+	// 过期和淘汰的 key 数，用于 INFO
+	stats *dbStats
+	// 多条命令会被连续写入 AOF，appendfsync 为 always 时返回的 channel 收到写入的结果
+	addAof func(...CmdLine) <-chan error
+	// notify 发送键空间通知，class 是通知的类型，例如 notifyString
+	notify func(class int, event string, key string)
+}
+
+// dbStats 记录 DB 的统计信息，事务使用的 DB 副本与原 DB 共享同一个 dbStats
+type dbStats struct {
+	expiredKeys atomic.Int64
+	evictedKeys atomic.Int64
 }
 
 // ExecFunc 是命令对应函数的接口
 // args 不包含 cmd 列，例如：set a b ——> a b
 type ExecFunc func(db *DB, args [][]byte) resp.Reply
 
+// PreFunc 在 ExecFunc 之前执行，返回需要加写锁的 key 和需要加读锁的 key
+// args 不包含 cmd 列
+type PreFunc func(args [][]byte) ([]string, []string)
+
+// UndoFunc 在 ExecFunc 之前执行，返回能够撤销该命令的命令，用于事务回滚
+// args 不包含 cmd 列
+type UndoFunc func(db *DB, args [][]byte) []CmdLine
+
 // CmdLine 代表命令行
 type CmdLine = [][]byte
 
@@ -33,8 +68,15 @@ func makeDB() *DB {
 	db := &DB{
 		//data:   dict.MakeSyncDict(),
 		// 换用分段锁实现hashmap
-		data:   dict.MakeConcurrent(dataDictSize),
-		addAof: func(line CmdLine) {},
+		data:     dict.MakeConcurrent(dataDictSize),
+		ttlMap:   dict.MakeConcurrent(ttlDictSize),
+		versions: makeKeyVersions(),
+		locker:   lock.Make(lockerSize),
+		blocking: makeBlockingQueues(),
+		memory:   makeMemoryUsage(),
+		stats:    &dbStats{},
+		addAof:   func(lines ...CmdLine) <-chan error { return nil },
+		notify:   func(class int, event string, key string) {},
 	}
 	return db
 }
@@ -42,6 +84,76 @@ func makeDB() *DB {
 // Exec 在单机数据库中执行命令
 func (db *DB) Exec(c resp.Connection, cmdLine [][]byte) resp.Reply {
 
+	cmdName := strings.ToLower(string(cmdLine[0]))
+	// 事务相关的命令
+	switch cmdName {
+	case "multi":
+		if len(cmdLine) != 1 {
+			return reply.MakeArgNumErrReply(cmdName)
+		}
+		return StartMulti(c)
+	case "discard":
+		if len(cmdLine) != 1 {
+			return reply.MakeArgNumErrReply(cmdName)
+		}
+		return DiscardMulti(c)
+	case "exec":
+		if len(cmdLine) != 1 {
+			return reply.MakeArgNumErrReply(cmdName)
+		}
+		return execMulti(db, c)
+	case "watch":
+		if len(cmdLine) < 2 {
+			return reply.MakeArgNumErrReply(cmdName)
+		}
+		return Watch(db, c, cmdLine[1:])
+	case "unwatch":
+		if len(cmdLine) != 1 {
+			return reply.MakeArgNumErrReply(cmdName)
+		}
+		return UnWatch(c)
+	}
+	if c != nil && c.InMultiState() {
+		return EnqueueCmd(c, cmdLine)
+	}
+	if bcmd, ok := blockingCmdTable[cmdName]; ok && c != nil {
+		return db.execBlockingCommand(c, bcmd, cmdLine)
+	}
+	return db.execNormalCommand(cmdLine)
+}
+
+func (db *DB) execNormalCommand(cmdLine [][]byte) resp.Reply {
+	cmdName := strings.ToLower(string(cmdLine[0]))
+	cmd, ok := cmdTable[cmdName]
+	if !ok {
+		return reply.MakeErrReply("ERR unknown command '" + cmdName + "'")
+	}
+	if !validateArity(cmd.arity, cmdLine) {
+		return reply.MakeArgNumErrReply(cmdName)
+	}
+	prepare := cmd.prepare
+	write, read := prepare(cmdLine[1:])
+	buf := &cmdBuffer{}
+	result, done := func() (resp.Reply, <-chan error) {
+		if _, ok := lockAllCommands[cmdName]; ok {
+			db.locker.LockAll()
+			defer db.locker.UnLockAll()
+		} else {
+			db.RWLocks(write, read)
+			defer db.RWUnLocks(write, read)
+		}
+		fun := cmd.executor
+		result := fun(db.withBuffer(buf), cmdLine[1:])
+		db.addVersion(write...)
+		db.updateMemory(write...)
+		return result, db.flushAof(buf)
+	}()
+	db.flushEvents(buf)
+	return waitAof(done, result)
+}
+
+// execWithLock 在调用方已经持有锁的情况下执行命令
+func (db *DB) execWithLock(cmdLine [][]byte) resp.Reply {
 	cmdName := strings.ToLower(string(cmdLine[0]))
 	cmd, ok := cmdTable[cmdName]
 	if !ok {
@@ -73,35 +185,48 @@ func (db *DB) GetEntity(key string) (*database.DataEntity, bool) {
 	if !ok {
 		return nil, false
 	}
+	if db.IsExpired(key) {
+		return nil, false
+	}
 	entity, _ := raw.(*database.DataEntity)
+	db.touch(key)
 	return entity, true
 }
 
 // PutEntity 向 DB 中写入DataEntity
+// 写入前先清理已过期的同名 key，避免新值继承旧的过期时间
 func (db *DB) PutEntity(key string, entity *database.DataEntity) int {
+	db.IsExpired(key)
 	return db.data.Put(key, entity)
 }
 
 // PutIfExists 编辑已存在的数据库实体
 func (db *DB) PutIfExists(key string, entity *database.DataEntity) int {
+	db.IsExpired(key)
 	return db.data.PutIfExists(key, entity)
 }
 
 // PutIfAbsent 当且仅当key不存在时插入一个 DataEntity
 func (db *DB) PutIfAbsent(key string, entity *database.DataEntity) int {
+	db.IsExpired(key)
 	return db.data.PutIfAbsent(key, entity)
 }
 
-// Remove 从数据库中删除指定key
+// Remove 从数据库中删除指定key，同时清除它的过期时间
 func (db *DB) Remove(key string) {
-	db.data.Remove(key)
+	removed := db.data.Remove(key)
+	db.ttlMap.Remove(key)
+	db.removeKeyStat(key)
+	if removed > 0 {
+		db.versions.remove(key)
+	}
 }
 
 // 一次性删除多个 key
 func (db *DB) Removes(keys ...string) (deleted int) {
 	deleted = 0
 	for _, key := range keys {
-		_, exists := db.data.Get(key)
+		_, exists := db.GetEntity(key)
 		if exists {
 			db.Remove(key)
 			deleted++
@@ -113,4 +238,138 @@ func (db *DB) Removes(keys ...string) (deleted int) {
 // Flush 清空 database
 func (db *DB) Flush() {
 	db.data.Clear()
+	db.ttlMap.Clear()
+	db.clearMemory()
+	db.versions.clear()
+}
+
+/* ---- 锁 ----- */
+
+// RWLocks 对 writeKeys 加写锁，对 readKeys 加读锁
+func (db *DB) RWLocks(writeKeys []string, readKeys []string) {
+	db.locker.RWLocks(writeKeys, readKeys)
+}
+
+// RWUnLocks 释放 writeKeys 的写锁和 readKeys 的读锁
+func (db *DB) RWUnLocks(writeKeys []string, readKeys []string) {
+	db.locker.RWUnLocks(writeKeys, readKeys)
+}
+
+/* ---- 版本号 ----- */
+
+// keyVersions 记录 key 的版本号，事务使用的 DB 副本与原 DB 共享同一个 keyVersions
+// 所有版本号都从 seq 递增分配，写入 key 时保存新的版本号，删除 key 时保存一个新的墓碑版本号，
+// 因此删除或过期只会改变被删除的 key 的版本号；没有记录的 key(从未写入过或从快照、AOF 加载的 key)
+// 的版本号是最后一次清空 DB 时分配的 flushed，这样只有 FLUSHDB 会改变所有 key 的版本号
+type keyVersions struct {
+	// key -> version (uint32)
+	m dict.Dict
+	// seq 是最后分配的版本号
+	seq atomic.Int64
+	// flushed 是最后一次清空 DB 时分配的版本号
+	flushed atomic.Int64
+}
+
+func makeKeyVersions() *keyVersions {
+	return &keyVersions{
+		m: dict.MakeConcurrent(dataDictSize),
+	}
+}
+
+// remove 为被删除的 key 保存墓碑版本号，调用方需要持有 key 的锁
+func (v *keyVersions) remove(key string) {
+	v.m.Put(key, uint32(v.seq.Add(1)))
+}
+
+// clear 删除所有 key 的版本号，调用方需要持有所有 key 的写锁
+func (v *keyVersions) clear() {
+	v.m.Clear()
+	v.flushed.Set(v.seq.Add(1))
+}
+
+// addVersion 在写命令执行后为 key 分配新的版本号，调用方需要持有 key 的写锁
+// 命令执行后不存在的 key 若在执行中被删除，Remove 已经为它保存了墓碑版本号
+func (db *DB) addVersion(keys ...string) {
+	for _, key := range keys {
+		if _, exists := db.data.Get(key); exists {
+			db.versions.m.Put(key, uint32(db.versions.seq.Add(1)))
+		}
+	}
+}
+
+// GetVersion 返回 key 的版本号
+func (db *DB) GetVersion(key string) uint32 {
+	raw, ok := db.versions.m.Get(key)
+	if !ok {
+		return uint32(db.versions.flushed.Get())
+	}
+	return raw.(uint32)
+}
+
+/* ---- 过期时间 ----- */
+
+const (
+	// 每轮主动过期最多抽样的 key 数
+	expireSampleSize = 20
+	// 每轮主动过期最多执行的时间
+	expireCycleTimeLimit = 25 * time.Millisecond
+)
+
+// Expire 设置 key 的过期时间
+func (db *DB) Expire(key string, expireTime time.Time) {
+	db.ttlMap.Put(key, expireTime)
+}
+
+// Persist 取消 key 的过期时间
+func (db *DB) Persist(key string) {
+	db.ttlMap.Remove(key)
+}
+
+// ExpireTime 返回 key 的过期时间，若 key 没有设置过期时间则 ok 为 false
+func (db *DB) ExpireTime(key string) (expireTime time.Time, ok bool) {
+	raw, exists := db.ttlMap.Get(key)
+	if !exists {
+		return time.Time{}, false
+	}
+	expireTime, _ = raw.(time.Time)
+	return expireTime, true
+}
+
+// IsExpired 检查 key 是否已经过期，已过期的 key 会被立即删除(惰性删除)
+func (db *DB) IsExpired(key string) bool {
+	expireTime, ok := db.ExpireTime(key)
+	if !ok {
+		return false
+	}
+	expired := time.Now().After(expireTime)
+	if expired {
+		db.Remove(key)
+		db.stats.expiredKeys.Add(1)
+		db.notify(notifyExpired, "expired", key)
+	}
+	return expired
+}
+
+// activeExpireCycle 从设置了过期时间的 key 中随机抽样并删除已过期的 key
+// 若抽样中过期 key 的比例超过 1/4，说明过期 key 较多，继续下一轮抽样直到超时
+func (db *DB) activeExpireCycle() {
+	start := time.Now()
+	for db.ttlMap.Len() > 0 {
+		keys := db.ttlMap.RandomDistinctKeys(expireSampleSize)
+		expired := 0
+		// expired 通知在释放锁之后发送
+		buf := &cmdBuffer{}
+		cycleDB := db.withBuffer(buf)
+		for _, key := range keys {
+			db.locker.Lock(key)
+			if cycleDB.IsExpired(key) {
+				expired++
+			}
+			db.locker.UnLock(key)
+		}
+		db.flushEvents(buf)
+		if expired*4 <= len(keys) || time.Since(start) > expireCycleTimeLimit {
+			return
+		}
+	}
 }
@@ -26,3 +26,14 @@ func (e EchoDatabase) Close() {
 	logger.Info("EchoDatabase Close")
 
 }
+
+func (e EchoDatabase) Info(section string) []string {
+	return nil
+}
+
+func (e EchoDatabase) ResetStats() {
+}
+
+func (e EchoDatabase) SetAppendOnly(enabled bool) error {
+	return nil
+}
@@ -0,0 +1,234 @@
+package database
+
+import (
+	"github.com/jujunwang/Mudis/config"
+	"github.com/jujunwang/Mudis/interface/resp"
+	"github.com/jujunwang/Mudis/lib/utils"
+	"github.com/jujunwang/Mudis/resp/reply"
+	"math"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+// 内存淘汰策略
+const (
+	policyNoEviction     = "noeviction"
+	policyAllKeysLRU     = "allkeys-lru"
+	policyAllKeysLFU     = "allkeys-lfu"
+	policyAllKeysRandom  = "allkeys-random"
+	policyVolatileLRU    = "volatile-lru"
+	policyVolatileLFU    = "volatile-lfu"
+	policyVolatileRandom = "volatile-random"
+	policyVolatileTTL    = "volatile-ttl"
+)
+
+// evictionPolicies 是会淘汰 key 的策略，noeviction 和未知的策略都不会淘汰 key
+var evictionPolicies = map[string]struct{}{
+	policyAllKeysLRU:     {},
+	policyAllKeysLFU:     {},
+	policyAllKeysRandom:  {},
+	policyVolatileLRU:    {},
+	policyVolatileLFU:    {},
+	policyVolatileRandom: {},
+	policyVolatileTTL:    {},
+}
+
+// IsEvictionPolicy 判断是否是支持的内存淘汰策略
+func IsEvictionPolicy(policy string) bool {
+	_, ok := evictionPolicies[policy]
+	return ok || policy == policyNoEviction
+}
+
+// defaultEvictionSamples 是没有配置 maxmemory-samples 时每次抽样的 key 数
+const defaultEvictionSamples = 5
+
+var oomErrReply = reply.MakeErrReply("OOM command not allowed when used memory > 'maxmemory'.")
+
+// freeMemoryCommands 中的写命令只会减少内存占用，内存超过上限时仍然允许执行
+var freeMemoryCommands = map[string]struct{}{
+	"del":              {},
+	"flushdb":          {},
+	"expire":           {},
+	"pexpire":          {},
+	"expireat":         {},
+	"pexpireat":        {},
+	"persist":          {},
+	"rename":           {},
+	"renamenx":         {},
+	"lpop":             {},
+	"rpop":             {},
+	"lrem":             {},
+	"blpop":            {},
+	"brpop":            {},
+	"blmpop":           {},
+	"srem":             {},
+	"spop":             {},
+	"hdel":             {},
+	"zrem":             {},
+	"zremrangebyrank":  {},
+	"zremrangebyscore": {},
+	"zpopmin":          {},
+	"zpopmax":          {},
+	"xdel":             {},
+	"xtrim":            {},
+	"xack":             {},
+}
+
+// UsedMemory 返回所有 DB 中数据估算的内存占用
+func (mdb *StandaloneDatabase) UsedMemory() int64 {
+	used := int64(0)
+	for _, db := range mdb.dbSet {
+		used += db.UsedMemory()
+	}
+	return used
+}
+
+// isDenyOOM 判断命令在内存超过上限时是否应该被拒绝
+// 会写入 key 的命令都可能占用更多内存，除非它只会删除数据
+func isDenyOOM(cmdLine [][]byte) bool {
+	cmdName := strings.ToLower(string(cmdLine[0]))
+	if _, ok := freeMemoryCommands[cmdName]; ok {
+		return false
+	}
+	return IsWriteCommand(cmdLine)
+}
+
+// checkMemory 在执行命令前检查内存占用，超过 maxmemory 时按照淘汰策略删除 key
+// 淘汰后仍然超过上限时，拒绝可能占用更多内存的命令
+func (mdb *StandaloneDatabase) checkMemory(c resp.Connection, cmdLine [][]byte) resp.Reply {
+	if config.Properties().MaxMemory <= 0 || mdb.loading {
+		return nil
+	}
+	if mdb.freeMemoryIfNeeded() {
+		return nil
+	}
+	cmdName := strings.ToLower(string(cmdLine[0]))
+	denyOOM := false
+	if cmdName == "exec" {
+		// 事务中的命令在入队时已经检查过，EXEC 时内存可能又超过了上限
+		if c.InMultiState() && len(c.GetTxErrors()) == 0 {
+			for _, queued := range c.GetQueuedCmdLine() {
+				if isDenyOOM(queued) {
+					denyOOM = true
+					break
+				}
+			}
+		}
+	} else {
+		denyOOM = isDenyOOM(cmdLine)
+	}
+	if !denyOOM {
+		return nil
+	}
+	if c.InMultiState() {
+		if cmdName == "exec" {
+			return abortExec(c, oomErrReply)
+		}
+		c.AddTxError(oomErrReply)
+	}
+	return oomErrReply
+}
+
+// freeMemoryIfNeeded 淘汰 key 直到内存占用不超过 maxmemory，返回内存占用是否已经不超过上限
+func (mdb *StandaloneDatabase) freeMemoryIfNeeded() bool {
+	maxMemory := config.Properties().MaxMemory
+	policy := strings.ToLower(config.Properties().MaxMemoryPolicy)
+	_, evictable := evictionPolicies[policy]
+	for mdb.UsedMemory() > maxMemory {
+		if !evictable {
+			return false
+		}
+		db, key := mdb.findEvictionCandidate(policy)
+		if db == nil {
+			return false
+		}
+		db.evict(key)
+	}
+	return true
+}
+
+// findEvictionCandidate 在每个 DB 中抽样若干个 key，按照淘汰策略选出最适合淘汰的 key
+func (mdb *StandaloneDatabase) findEvictionCandidate(policy string) (*DB, string) {
+	samples := config.Properties().MaxMemorySamples
+	if samples <= 0 {
+		samples = defaultEvictionSamples
+	}
+	volatile := strings.HasPrefix(policy, "volatile-")
+	random := strings.HasSuffix(policy, "-random")
+	now := time.Now()
+
+	var bestDB *DB
+	var bestKey string
+	bestScore := int64(math.MinInt64)
+	// 随机策略从随机的 DB 开始寻找第一个非空的 DB
+	offset := rand.Intn(len(mdb.dbSet))
+	for i := range mdb.dbSet {
+		db := mdb.dbSet[(i+offset)%len(mdb.dbSet)]
+		keySpace := db.data
+		if volatile {
+			keySpace = db.ttlMap
+		}
+		if keySpace.Len() == 0 {
+			continue
+		}
+		if random {
+			keys := keySpace.RandomKeys(1)
+			if len(keys) > 0 {
+				return db, keys[0]
+			}
+			continue
+		}
+		for _, key := range keySpace.RandomKeys(samples) {
+			score := db.evictionScore(key, policy, now)
+			if score > bestScore {
+				bestDB, bestKey, bestScore = db, key, score
+			}
+		}
+	}
+	return bestDB, bestKey
+}
+
+// evictionScore 返回 key 的淘汰优先级，分数越高越应该被淘汰
+func (db *DB) evictionScore(key string, policy string, now time.Time) int64 {
+	if policy == policyVolatileTTL {
+		expireTime, ok := db.ExpireTime(key)
+		if !ok {
+			return math.MinInt64
+		}
+		// 越早过期的 key 分数越高
+		return math.MaxInt64 - expireTime.UnixMilli()
+	}
+	stat := db.getKeyStat(key)
+	if stat == nil {
+		return 0
+	}
+	switch policy {
+	case policyAllKeysLFU, policyVolatileLFU:
+		return 255 - int64(stat.decayedCounter(now))
+	default:
+		return int64(stat.idleTime(now))
+	}
+}
+
+// evict 删除被淘汰的 key 并以 DEL 命令写入 AOF，evicted 通知在释放锁之后发送
+func (db *DB) evict(key string) {
+	if db.evictLocked(key) {
+		db.notify(notifyEvicted, "evicted", key)
+	}
+}
+
+func (db *DB) evictLocked(key string) bool {
+	db.locker.Lock(key)
+	defer db.locker.UnLock(key)
+	_, exists := db.data.Get(key)
+	// key 可能已经被其它命令删除，仍然清理它残留的过期时间和内存统计
+	db.Remove(key)
+	if !exists {
+		return false
+	}
+	db.addVersion(key)
+	db.stats.evictedKeys.Add(1)
+	db.addAof(utils.ToCmdLine("del", key))
+	return true
+}
@@ -0,0 +1,555 @@
+package database
+
+import (
+	"fmt"
+	"github.com/jujunwang/Mudis/datastruct/sortedset"
+	"github.com/jujunwang/Mudis/interface/resp"
+	"github.com/jujunwang/Mudis/lib/geohash"
+	"github.com/jujunwang/Mudis/resp/reply"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 与 redis 一样，地理位置保存在有序集合中，分值为 52 位的 geohash
+
+var geoUnits = map[string]float64{
+	"m":  1,
+	"km": 1000,
+	"ft": 0.3048,
+	"mi": 1609.34,
+}
+
+func parseGeoUnit(arg []byte) (float64, reply.ErrorReply) {
+	unit, ok := geoUnits[strings.ToLower(string(arg))]
+	if !ok {
+		return 0, reply.MakeErrReply("ERR unsupported unit provided. please use M, KM, FT, MI")
+	}
+	return unit, nil
+}
+
+func parseLonLat(lonArg []byte, latArg []byte) (float64, float64, reply.ErrorReply) {
+	longitude, errReply := parseScore(lonArg)
+	if errReply != nil {
+		return 0, 0, errReply
+	}
+	latitude, errReply := parseScore(latArg)
+	if errReply != nil {
+		return 0, 0, errReply
+	}
+	if longitude < geohash.MinLongitude || longitude > geohash.MaxLongitude ||
+		latitude < geohash.MinLatitude || latitude > geohash.MaxLatitude {
+		return 0, 0, reply.MakeErrReply(fmt.Sprintf("ERR invalid longitude,latitude pair %f,%f", longitude, latitude))
+	}
+	return longitude, latitude, nil
+}
+
+func formatCoord(v float64) []byte {
+	return []byte(strconv.FormatFloat(v, 'g', 17, 64))
+}
+
+func formatDist(dist float64) []byte {
+	return []byte(strconv.FormatFloat(dist, 'f', 4, 64))
+}
+
+// execGeoAdd GEOADD key [NX|XX] [CH] longitude latitude member [longitude latitude member ...]
+// 转换为 ZADD 执行，aof 中记录的也是 ZADD
+func execGeoAdd(db *DB, args [][]byte) resp.Reply {
+	zaddArgs := [][]byte{args[0]}
+	i := 1
+	for ; i < len(args); i++ {
+		opt := strings.ToUpper(string(args[i]))
+		if opt != "NX" && opt != "XX" && opt != "CH" {
+			break
+		}
+		zaddArgs = append(zaddArgs, args[i])
+	}
+	triples := args[i:]
+	if len(triples) == 0 || len(triples)%3 != 0 {
+		return reply.MakeSyntaxErrReply()
+	}
+	for j := 0; j < len(triples); j += 3 {
+		longitude, latitude, errReply := parseLonLat(triples[j], triples[j+1])
+		if errReply != nil {
+			return errReply
+		}
+		score := geohash.Encode(longitude, latitude)
+		zaddArgs = append(zaddArgs, []byte(strconv.FormatUint(score, 10)), triples[j+2])
+	}
+	return execZAdd(db, zaddArgs)
+}
+
+// execGeoPos GEOPOS key [member ...]
+func execGeoPos(db *DB, args [][]byte) resp.Reply {
+	sortedSet, errReply := db.getAsSortedSet(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	members := args[1:]
+	result := make([]resp.Reply, len(members))
+	for i, member := range members {
+		result[i] = reply.MakeNullMultiBulkReply()
+		if sortedSet == nil {
+			continue
+		}
+		element, exists := sortedSet.Get(string(member))
+		if !exists {
+			continue
+		}
+		longitude, latitude := geohash.Decode(uint64(element.Score))
+		result[i] = reply.MakeMultiBulkReply([][]byte{formatCoord(longitude), formatCoord(latitude)})
+	}
+	return reply.MakeMultiRawReply(result)
+}
+
+// execGeoDist GEODIST key member1 member2 [M|KM|FT|MI]
+func execGeoDist(db *DB, args [][]byte) resp.Reply {
+	if len(args) > 4 {
+		return reply.MakeSyntaxErrReply()
+	}
+	unit := 1.0
+	if len(args) == 4 {
+		var errReply reply.ErrorReply
+		unit, errReply = parseGeoUnit(args[3])
+		if errReply != nil {
+			return errReply
+		}
+	}
+	sortedSet, errReply := db.getAsSortedSet(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if sortedSet == nil {
+		return reply.MakeNullBulkReply()
+	}
+	e1, ok1 := sortedSet.Get(string(args[1]))
+	e2, ok2 := sortedSet.Get(string(args[2]))
+	if !ok1 || !ok2 {
+		return reply.MakeNullBulkReply()
+	}
+	lon1, lat1 := geohash.Decode(uint64(e1.Score))
+	lon2, lat2 := geohash.Decode(uint64(e2.Score))
+	return reply.MakeBulkReply(formatDist(geohash.Distance(lon1, lat1, lon2, lat2) / unit))
+}
+
+// execGeoHash GEOHASH key [member ...]
+func execGeoHash(db *DB, args [][]byte) resp.Reply {
+	sortedSet, errReply := db.getAsSortedSet(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	members := args[1:]
+	result := make([][]byte, len(members))
+	if sortedSet == nil {
+		return reply.MakeMultiBulkReply(result)
+	}
+	for i, member := range members {
+		element, exists := sortedSet.Get(string(member))
+		if exists {
+			result[i] = []byte(geohash.ToString(geohash.Decode(uint64(element.Score))))
+		}
+	}
+	return reply.MakeMultiBulkReply(result)
+}
+
+const (
+	geoSortNone = iota
+	geoSortAsc
+	geoSortDesc
+)
+
+// geoSearchOptions 是 GEOSEARCH 和 GEORADIUS 系列命令共用的参数
+type geoSearchOptions struct {
+	hasMember  bool
+	member     string
+	hasLonLat  bool
+	longitude  float64
+	latitude   float64
+	byRadius   bool
+	radius     float64
+	byBox      bool
+	width      float64
+	height     float64
+	unit       float64
+	sort       int
+	count      int
+	any        bool
+	withCoord  bool
+	withDist   bool
+	withHash   bool
+	storeKey   string
+	storeDist  bool
+	allowWith  bool
+	allowStore bool
+}
+
+type geoPoint struct {
+	member    string
+	hash      uint64
+	dist      float64
+	longitude float64
+	latitude  float64
+}
+
+func parseRadius(radiusArg []byte, unitArg []byte) (float64, float64, reply.ErrorReply) {
+	radius, errReply := parseScore(radiusArg)
+	if errReply != nil {
+		return 0, 0, errReply
+	}
+	if radius < 0 {
+		return 0, 0, reply.MakeErrReply("ERR radius cannot be negative")
+	}
+	unit, errReply := parseGeoUnit(unitArg)
+	if errReply != nil {
+		return 0, 0, errReply
+	}
+	return radius, unit, nil
+}
+
+// parseGeoOptions 解析可选参数，FROMMEMBER、BYRADIUS 等只对 GEOSEARCH 系列开放
+func parseGeoOptions(cmdName string, args [][]byte, opts *geoSearchOptions, isSearch bool) reply.ErrorReply {
+	for i := 0; i < len(args); i++ {
+		opt := strings.ToUpper(string(args[i]))
+		remain := len(args) - i - 1
+		switch {
+		case opt == "ASC":
+			opts.sort = geoSortAsc
+		case opt == "DESC":
+			opts.sort = geoSortDesc
+		case opt == "ANY":
+			opts.any = true
+		case opt == "COUNT" && remain >= 1:
+			count, err := strconv.Atoi(string(args[i+1]))
+			if err != nil {
+				return reply.MakeErrReply("ERR value is not an integer or out of range")
+			}
+			if count <= 0 {
+				return reply.MakeErrReply("ERR COUNT must be > 0")
+			}
+			opts.count = count
+			i++
+		case opt == "WITHCOORD" && opts.allowWith:
+			opts.withCoord = true
+		case opt == "WITHDIST" && opts.allowWith:
+			opts.withDist = true
+		case opt == "WITHHASH" && opts.allowWith:
+			opts.withHash = true
+		case isSearch && opt == "STOREDIST" && opts.allowStore:
+			opts.storeDist = true
+		case !isSearch && (opt == "STORE" || opt == "STOREDIST") && remain >= 1:
+			opts.storeKey = string(args[i+1])
+			opts.storeDist = opt == "STOREDIST"
+			i++
+		case isSearch && opt == "FROMMEMBER" && remain >= 1:
+			if opts.hasMember || opts.hasLonLat {
+				return reply.MakeErrReply("ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for " + cmdName)
+			}
+			opts.hasMember = true
+			opts.member = string(args[i+1])
+			i++
+		case isSearch && opt == "FROMLONLAT" && remain >= 2:
+			if opts.hasMember || opts.hasLonLat {
+				return reply.MakeErrReply("ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for " + cmdName)
+			}
+			longitude, latitude, errReply := parseLonLat(args[i+1], args[i+2])
+			if errReply != nil {
+				return errReply
+			}
+			opts.hasLonLat = true
+			opts.longitude, opts.latitude = longitude, latitude
+			i += 2
+		case isSearch && opt == "BYRADIUS" && remain >= 2:
+			if opts.byRadius || opts.byBox {
+				return reply.MakeErrReply("ERR exactly one of BYRADIUS and BYBOX can be specified for " + cmdName)
+			}
+			radius, unit, errReply := parseRadius(args[i+1], args[i+2])
+			if errReply != nil {
+				return errReply
+			}
+			opts.byRadius = true
+			opts.radius, opts.unit = radius, unit
+			i += 2
+		case isSearch && opt == "BYBOX" && remain >= 3:
+			if opts.byRadius || opts.byBox {
+				return reply.MakeErrReply("ERR exactly one of BYRADIUS and BYBOX can be specified for " + cmdName)
+			}
+			width, errReply := parseScore(args[i+1])
+			if errReply != nil {
+				return errReply
+			}
+			height, errReply := parseScore(args[i+2])
+			if errReply != nil {
+				return errReply
+			}
+			if width < 0 || height < 0 {
+				return reply.MakeErrReply("ERR height or width cannot be negative")
+			}
+			unit, errReply := parseGeoUnit(args[i+3])
+			if errReply != nil {
+				return errReply
+			}
+			opts.byBox = true
+			opts.width, opts.height, opts.unit = width, height, unit
+			i += 3
+		default:
+			return reply.MakeSyntaxErrReply()
+		}
+	}
+	if isSearch {
+		if !opts.hasMember && !opts.hasLonLat {
+			return reply.MakeErrReply("ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for " + cmdName)
+		}
+		if !opts.byRadius && !opts.byBox {
+			return reply.MakeErrReply("ERR exactly one of BYRADIUS and BYBOX can be specified for " + cmdName)
+		}
+	}
+	if opts.any && opts.count == 0 {
+		return reply.MakeErrReply("ERR the ANY argument requires COUNT argument")
+	}
+	if opts.storeKey != "" && (opts.withCoord || opts.withDist || opts.withHash) {
+		return reply.MakeErrReply("ERR STORE option in " + cmdName + " is not compatible with WITHDIST, WITHHASH and WITHCOORD options")
+	}
+	return nil
+}
+
+// contains 判断点是否在搜索范围内，并返回点到中心的距离，单位为米
+func (opts *geoSearchOptions) contains(longitude, latitude float64) (float64, bool) {
+	if opts.byRadius {
+		dist := geohash.Distance(opts.longitude, opts.latitude, longitude, latitude)
+		return dist, dist <= opts.radius*opts.unit
+	}
+	// 纬度方向的距离计算更快，先进行判断
+	if geohash.LatDistance(latitude, opts.latitude) > opts.height*opts.unit/2 {
+		return 0, false
+	}
+	if geohash.Distance(longitude, latitude, opts.longitude, latitude) > opts.width*opts.unit/2 {
+		return 0, false
+	}
+	return geohash.Distance(opts.longitude, opts.latitude, longitude, latitude), true
+}
+
+// geoSearch 在 3x3 的 geohash 格子中查找候选点，再按照实际距离过滤
+func geoSearch(sortedSet *sortedset.SortedSet, opts *geoSearchOptions) ([]*geoPoint, reply.ErrorReply) {
+	if opts.hasMember {
+		element, exists := sortedSet.Get(opts.member)
+		if !exists {
+			return nil, reply.MakeErrReply("ERR could not decode requested zset member")
+		}
+		opts.longitude, opts.latitude = geohash.Decode(uint64(element.Score))
+	}
+	width, height := opts.width*opts.unit, opts.height*opts.unit
+	if opts.byRadius {
+		width = opts.radius * opts.unit * 2
+		height = width
+	}
+
+	points := make([]*geoPoint, 0)
+	enough := false
+	for _, r := range geohash.SearchRanges(opts.longitude, opts.latitude, width, height) {
+		min := &sortedset.ScoreBorder{Value: float64(r.Min)}
+		max := &sortedset.ScoreBorder{Value: float64(r.Max), Exclude: true}
+		sortedSet.ForEach(min, max, 0, -1, false, func(element *sortedset.Element) bool {
+			hash := uint64(element.Score)
+			longitude, latitude := geohash.Decode(hash)
+			dist, ok := opts.contains(longitude, latitude)
+			if !ok {
+				return true
+			}
+			points = append(points, &geoPoint{
+				member:    element.Member,
+				hash:      hash,
+				dist:      dist,
+				longitude: longitude,
+				latitude:  latitude,
+			})
+			// ANY 模式下找到足够数量的点就立即返回
+			enough = opts.any && len(points) >= opts.count
+			return !enough
+		})
+		if enough {
+			break
+		}
+	}
+
+	// 指定 COUNT 但没有 ANY 时默认按距离升序返回最近的点
+	if opts.sort == geoSortNone && opts.count > 0 && !opts.any {
+		opts.sort = geoSortAsc
+	}
+	if opts.sort == geoSortAsc {
+		sort.SliceStable(points, func(i, j int) bool {
+			return points[i].dist < points[j].dist
+		})
+	} else if opts.sort == geoSortDesc {
+		sort.SliceStable(points, func(i, j int) bool {
+			return points[i].dist > points[j].dist
+		})
+	}
+	if opts.count > 0 && len(points) > opts.count {
+		points = points[:opts.count]
+	}
+	return points, nil
+}
+
+func makeGeoSearchReply(points []*geoPoint, opts *geoSearchOptions) resp.Reply {
+	if !opts.withCoord && !opts.withDist && !opts.withHash {
+		members := make([][]byte, len(points))
+		for i, p := range points {
+			members[i] = []byte(p.member)
+		}
+		return reply.MakeMultiBulkReply(members)
+	}
+	result := make([]resp.Reply, len(points))
+	for i, p := range points {
+		item := []resp.Reply{reply.MakeBulkReply([]byte(p.member))}
+		if opts.withDist {
+			item = append(item, reply.MakeBulkReply(formatDist(p.dist/opts.unit)))
+		}
+		if opts.withHash {
+			item = append(item, reply.MakeIntReply(int64(p.hash)))
+		}
+		if opts.withCoord {
+			item = append(item, reply.MakeMultiBulkReply([][]byte{formatCoord(p.longitude), formatCoord(p.latitude)}))
+		}
+		result[i] = reply.MakeMultiRawReply(item)
+	}
+	return reply.MakeMultiRawReply(result)
+}
+
+// execGeoSearchGeneric 执行搜索，设置了 storeKey 时将结果保存为有序集合
+func execGeoSearchGeneric(db *DB, cmdName string, key string, opts *geoSearchOptions, args [][]byte) resp.Reply {
+	sortedSet, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	var points []*geoPoint
+	if sortedSet != nil {
+		points, errReply = geoSearch(sortedSet, opts)
+		if errReply != nil {
+			return errReply
+		}
+	}
+	if opts.storeKey == "" {
+		return makeGeoSearchReply(points, opts)
+	}
+	result := sortedset.Make()
+	for _, p := range points {
+		score := float64(p.hash)
+		if opts.storeDist {
+			score = p.dist / opts.unit
+		}
+		result.Add(p.member, score)
+	}
+	return storeSortedSet(db, cmdName, opts.storeKey, result, args)
+}
+
+// execGeoSearch GEOSEARCH key FROMMEMBER member|FROMLONLAT longitude latitude BYRADIUS radius unit|BYBOX width height unit
+// [ASC|DESC] [COUNT count [ANY]] [WITHCOORD] [WITHDIST] [WITHHASH]
+func execGeoSearch(db *DB, args [][]byte) resp.Reply {
+	opts := &geoSearchOptions{allowWith: true}
+	if errReply := parseGeoOptions("GEOSEARCH", args[1:], opts, true); errReply != nil {
+		return errReply
+	}
+	return execGeoSearchGeneric(db, "geosearch", string(args[0]), opts, args)
+}
+
+// execGeoSearchStore GEOSEARCHSTORE destination source FROMMEMBER member|FROMLONLAT longitude latitude
+// BYRADIUS radius unit|BYBOX width height unit [ASC|DESC] [COUNT count [ANY]] [STOREDIST]
+func execGeoSearchStore(db *DB, args [][]byte) resp.Reply {
+	opts := &geoSearchOptions{allowStore: true, storeKey: string(args[0])}
+	if errReply := parseGeoOptions("GEOSEARCHSTORE", args[2:], opts, true); errReply != nil {
+		return errReply
+	}
+	return execGeoSearchGeneric(db, "geosearchstore", string(args[1]), opts, args)
+}
+
+// execGeoRadius GEORADIUS key longitude latitude radius unit [WITHCOORD] [WITHDIST] [WITHHASH]
+// [COUNT count [ANY]] [ASC|DESC] [STORE key] [STOREDIST key]
+func execGeoRadius(db *DB, args [][]byte) resp.Reply {
+	longitude, latitude, errReply := parseLonLat(args[1], args[2])
+	if errReply != nil {
+		return errReply
+	}
+	radius, unit, errReply := parseRadius(args[3], args[4])
+	if errReply != nil {
+		return errReply
+	}
+	opts := &geoSearchOptions{
+		hasLonLat: true,
+		longitude: longitude,
+		latitude:  latitude,
+		byRadius:  true,
+		radius:    radius,
+		unit:      unit,
+		allowWith: true,
+	}
+	if errReply := parseGeoOptions("GEORADIUS", args[5:], opts, false); errReply != nil {
+		return errReply
+	}
+	return execGeoSearchGeneric(db, "georadius", string(args[0]), opts, args)
+}
+
+// execGeoRadiusByMember GEORADIUSBYMEMBER key member radius unit [WITHCOORD] [WITHDIST] [WITHHASH]
+// [COUNT count [ANY]] [ASC|DESC] [STORE key] [STOREDIST key]
+func execGeoRadiusByMember(db *DB, args [][]byte) resp.Reply {
+	radius, unit, errReply := parseRadius(args[2], args[3])
+	if errReply != nil {
+		return errReply
+	}
+	opts := &geoSearchOptions{
+		hasMember: true,
+		member:    string(args[1]),
+		byRadius:  true,
+		radius:    radius,
+		unit:      unit,
+		allowWith: true,
+	}
+	if errReply := parseGeoOptions("GEORADIUSBYMEMBER", args[4:], opts, false); errReply != nil {
+		return errReply
+	}
+	return execGeoSearchGeneric(db, "georadiusbymember", string(args[0]), opts, args)
+}
+
+func prepareGeoSearchStore(args [][]byte) ([]string, []string) {
+	return []string{string(args[0])}, []string{string(args[1])}
+}
+
+// geoRadiusStoreKey 返回 GEORADIUS 命令中 STORE 或 STOREDIST 指定的 key
+func geoRadiusStoreKey(args [][]byte) string {
+	storeKey := ""
+	for i := 1; i < len(args)-1; i++ {
+		opt := strings.ToUpper(string(args[i]))
+		if opt == "STORE" || opt == "STOREDIST" {
+			storeKey = string(args[i+1])
+			i++
+		}
+	}
+	return storeKey
+}
+
+func prepareGeoRadius(args [][]byte) ([]string, []string) {
+	key := string(args[0])
+	storeKey := geoRadiusStoreKey(args)
+	if storeKey == "" {
+		return nil, []string{key}
+	}
+	return []string{storeKey}, []string{key}
+}
+
+func undoGeoRadius(db *DB, args [][]byte) []CmdLine {
+	storeKey := geoRadiusStoreKey(args)
+	if storeKey == "" {
+		return nil
+	}
+	return rollbackGivenKeys(db, storeKey)
+}
+
+func init() {
+	RegisterCommand("GeoAdd", execGeoAdd, writeFirstKey, rollbackFirstKey, -5)
+	RegisterCommand("GeoPos", execGeoPos, readFirstKey, nil, -2)
+	RegisterCommand("GeoDist", execGeoDist, readFirstKey, nil, -4)
+	RegisterCommand("GeoHash", execGeoHash, readFirstKey, nil, -2)
+	RegisterCommand("GeoSearch", execGeoSearch, readFirstKey, nil, -7)
+	RegisterCommand("GeoSearchStore", execGeoSearchStore, prepareGeoSearchStore, rollbackFirstKey, -8)
+	RegisterCommand("GeoRadius", execGeoRadius, prepareGeoRadius, undoGeoRadius, -6)
+	RegisterCommand("GeoRadiusByMember", execGeoRadiusByMember, prepareGeoRadius, undoGeoRadius, -5)
+}
@@ -0,0 +1,471 @@
+package database
+
+import (
+	Dict "github.com/jujunwang/Mudis/datastruct/dict"
+	"github.com/jujunwang/Mudis/interface/database"
+	"github.com/jujunwang/Mudis/interface/resp"
+	"github.com/jujunwang/Mudis/lib/utils"
+	"github.com/jujunwang/Mudis/resp/reply"
+	"math"
+	"strconv"
+	"strings"
+)
+
+func (db *DB) getAsDict(key string) (Dict.Dict, reply.ErrorReply) {
+	entity, exists := db.GetEntity(key)
+	if !exists {
+		return nil, nil
+	}
+	dict, ok := entity.Data.(Dict.Dict)
+	if !ok {
+		return nil, &reply.WrongTypeErrReply{}
+	}
+	return dict, nil
+}
+
+func (db *DB) getOrInitDict(key string) (dict Dict.Dict, inited bool, errReply reply.ErrorReply) {
+	dict, errReply = db.getAsDict(key)
+	if errReply != nil {
+		return nil, false, errReply
+	}
+	inited = false
+	if dict == nil {
+		dict = Dict.MakeSimple()
+		db.PutEntity(key, &database.DataEntity{
+			Data: dict,
+		})
+		inited = true
+	}
+	return dict, inited, nil
+}
+
+// execHSet 设置哈希表中一个或多个字段的值
+func execHSet(db *DB, args [][]byte) resp.Reply {
+	if len(args)%2 != 1 {
+		return reply.MakeArgNumErrReply("hset")
+	}
+	key := string(args[0])
+	size := (len(args) - 1) / 2
+	fields := make([]string, size)
+	values := make([][]byte, size)
+	for i := 0; i < size; i++ {
+		fields[i] = string(args[2*i+1])
+		values[i] = args[2*i+2]
+	}
+
+	dict, _, errReply := db.getOrInitDict(key)
+	if errReply != nil {
+		return errReply
+	}
+
+	result := 0
+	for i, field := range fields {
+		result += dict.Put(field, values[i])
+	}
+	db.addAof(utils.ToCmdLine3("hset", args...))
+	db.notify(notifyHash, "hset", key)
+	return reply.MakeIntReply(int64(result))
+}
+
+// execHMSet 与 HSET 相同，为兼容旧版本客户端保留，成功时返回 OK
+func execHMSet(db *DB, args [][]byte) resp.Reply {
+	result := execHSet(db, args)
+	if reply.IsErrorReply(result) {
+		return result
+	}
+	return &reply.OkReply{}
+}
+
+// execHSetNX 当且仅当字段不存在时设置字段的值
+func execHSetNX(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	field := string(args[1])
+	value := args[2]
+
+	dict, _, errReply := db.getOrInitDict(key)
+	if errReply != nil {
+		return errReply
+	}
+
+	result := dict.PutIfAbsent(field, value)
+	if result > 0 {
+		db.addAof(utils.ToCmdLine3("hsetnx", args...))
+		db.notify(notifyHash, "hset", key)
+	}
+	return reply.MakeIntReply(int64(result))
+}
+
+// execHGet 返回哈希表中给定字段的值
+func execHGet(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	field := string(args[1])
+
+	dict, errReply := db.getAsDict(key)
+	if errReply != nil {
+		return errReply
+	}
+	if dict == nil {
+		return &reply.NullBulkReply{}
+	}
+
+	raw, exists := dict.Get(field)
+	if !exists {
+		return &reply.NullBulkReply{}
+	}
+	value, _ := raw.([]byte)
+	return reply.MakeBulkReply(value)
+}
+
+// execHMGet 返回哈希表中一个或多个给定字段的值
+func execHMGet(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	size := len(args) - 1
+	fields := make([]string, size)
+	for i := 0; i < size; i++ {
+		fields[i] = string(args[i+1])
+	}
+
+	dict, errReply := db.getAsDict(key)
+	if errReply != nil {
+		return errReply
+	}
+	result := make([][]byte, size)
+	if dict == nil {
+		return reply.MakeMultiBulkReply(result)
+	}
+
+	for i, field := range fields {
+		value, ok := dict.Get(field)
+		if !ok {
+			result[i] = nil
+		} else {
+			bytes, _ := value.([]byte)
+			result[i] = bytes
+		}
+	}
+	return reply.MakeMultiBulkReply(result)
+}
+
+// execHDel 删除哈希表中一个或多个字段
+func execHDel(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	size := len(args) - 1
+	fields := make([]string, size)
+	for i := 0; i < size; i++ {
+		fields[i] = string(args[i+1])
+	}
+
+	dict, errReply := db.getAsDict(key)
+	if errReply != nil {
+		return errReply
+	}
+	if dict == nil {
+		return reply.MakeIntReply(0)
+	}
+
+	deleted := 0
+	for _, field := range fields {
+		deleted += dict.Remove(field)
+	}
+	if deleted > 0 {
+		db.addAof(utils.ToCmdLine3("hdel", args...))
+		db.notify(notifyHash, "hdel", key)
+	}
+	if dict.Len() == 0 {
+		db.Remove(key)
+		db.notify(notifyGeneric, "del", key)
+	}
+	return reply.MakeIntReply(int64(deleted))
+}
+
+// execHExists 检查哈希表中给定字段是否存在
+func execHExists(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	field := string(args[1])
+
+	dict, errReply := db.getAsDict(key)
+	if errReply != nil {
+		return errReply
+	}
+	if dict == nil {
+		return reply.MakeIntReply(0)
+	}
+
+	_, exists := dict.Get(field)
+	if exists {
+		return reply.MakeIntReply(1)
+	}
+	return reply.MakeIntReply(0)
+}
+
+// execHLen 返回哈希表中字段的数量
+func execHLen(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+
+	dict, errReply := db.getAsDict(key)
+	if errReply != nil {
+		return errReply
+	}
+	if dict == nil {
+		return reply.MakeIntReply(0)
+	}
+	return reply.MakeIntReply(int64(dict.Len()))
+}
+
+// execHStrLen 返回哈希表中给定字段的值的长度
+func execHStrLen(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	field := string(args[1])
+
+	dict, errReply := db.getAsDict(key)
+	if errReply != nil {
+		return errReply
+	}
+	if dict == nil {
+		return reply.MakeIntReply(0)
+	}
+
+	raw, exists := dict.Get(field)
+	if !exists {
+		return reply.MakeIntReply(0)
+	}
+	value, _ := raw.([]byte)
+	return reply.MakeIntReply(int64(len(value)))
+}
+
+// execHKeys 返回哈希表中的所有字段
+func execHKeys(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+
+	dict, errReply := db.getAsDict(key)
+	if errReply != nil {
+		return errReply
+	}
+	if dict == nil {
+		return &reply.EmptyMultiBulkReply{}
+	}
+
+	fields := make([][]byte, dict.Len())
+	i := 0
+	dict.ForEach(func(key string, val interface{}) bool {
+		fields[i] = []byte(key)
+		i++
+		return true
+	})
+	return reply.MakeMultiBulkReply(fields[:i])
+}
+
+// execHVals 返回哈希表中所有字段的值
+func execHVals(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+
+	dict, errReply := db.getAsDict(key)
+	if errReply != nil {
+		return errReply
+	}
+	if dict == nil {
+		return &reply.EmptyMultiBulkReply{}
+	}
+
+	values := make([][]byte, dict.Len())
+	i := 0
+	dict.ForEach(func(key string, val interface{}) bool {
+		values[i], _ = val.([]byte)
+		i++
+		return true
+	})
+	return reply.MakeMultiBulkReply(values[:i])
+}
+
+// execHGetAll 返回哈希表中所有的字段和值
+func execHGetAll(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+
+	dict, errReply := db.getAsDict(key)
+	if errReply != nil {
+		return errReply
+	}
+	if dict == nil {
+		return &reply.EmptyMultiBulkReply{}
+	}
+
+	size := dict.Len()
+	result := make([][]byte, size*2)
+	i := 0
+	dict.ForEach(func(key string, val interface{}) bool {
+		result[i] = []byte(key)
+		i++
+		result[i], _ = val.([]byte)
+		i++
+		return true
+	})
+	return reply.MakeMultiBulkReply(result[:i])
+}
+
+// execHIncrBy 为哈希表中的字段值加上指定增量
+func execHIncrBy(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	field := string(args[1])
+	rawDelta := string(args[2])
+	delta, err := strconv.ParseInt(rawDelta, 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+
+	dict, _, errReply := db.getOrInitDict(key)
+	if errReply != nil {
+		return errReply
+	}
+
+	value, exists := dict.Get(field)
+	if !exists {
+		dict.Put(field, []byte(strconv.FormatInt(delta, 10)))
+		db.addAof(utils.ToCmdLine3("hincrby", args...))
+		db.notify(notifyHash, "hincrby", key)
+		return reply.MakeIntReply(delta)
+	}
+	val, err := strconv.ParseInt(string(value.([]byte)), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR hash value is not an integer")
+	}
+	if (delta > 0 && val > math.MaxInt64-delta) || (delta < 0 && val < math.MinInt64-delta) {
+		return reply.MakeErrReply("ERR increment or decrement would overflow")
+	}
+	val += delta
+	bytes := []byte(strconv.FormatInt(val, 10))
+	dict.Put(field, bytes)
+	db.addAof(utils.ToCmdLine3("hincrby", args...))
+	db.notify(notifyHash, "hincrby", key)
+	return reply.MakeIntReply(val)
+}
+
+var nanOrInfErrReply = reply.MakeErrReply("ERR increment would produce NaN or Infinity")
+
+// execHIncrByFloat 为哈希表中的字段值加上指定浮点数增量
+func execHIncrByFloat(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	field := string(args[1])
+	rawDelta := string(args[2])
+	delta, err := strconv.ParseFloat(rawDelta, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not a valid float")
+	}
+	if math.IsNaN(delta) || math.IsInf(delta, 0) {
+		return nanOrInfErrReply
+	}
+
+	dict, _, errReply := db.getOrInitDict(key)
+	if errReply != nil {
+		return errReply
+	}
+
+	value, exists := dict.Get(field)
+	if !exists {
+		bytes := []byte(strconv.FormatFloat(delta, 'f', -1, 64))
+		dict.Put(field, bytes)
+		db.addAof(utils.ToCmdLine3("hset", args[0], args[1], bytes))
+		db.notify(notifyHash, "hincrbyfloat", key)
+		return reply.MakeBulkReply(bytes)
+	}
+	val, err := strconv.ParseFloat(string(value.([]byte)), 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR hash value is not a float")
+	}
+	result := val + delta
+	if math.IsNaN(result) || math.IsInf(result, 0) {
+		return nanOrInfErrReply
+	}
+	bytes := []byte(strconv.FormatFloat(result, 'f', -1, 64))
+	dict.Put(field, bytes)
+	// 浮点运算的结果可能受精度影响，AOF 中直接记录计算结果
+	db.addAof(utils.ToCmdLine3("hset", args[0], args[1], bytes))
+	db.notify(notifyHash, "hincrbyfloat", key)
+	return reply.MakeBulkReply(bytes)
+}
+
+// maxRandFieldRepeats 是 count 为负数时 HRANDFIELD 最多返回的字段数，
+// 返回的字段可能重复，数量与哈希表的大小无关，需要限制结果占用的内存
+const maxRandFieldRepeats = 1 << 20
+
+// execHRandField 随机返回哈希表中的字段
+// count 为正数时返回不重复的字段，为负数时返回的字段可能重复
+func execHRandField(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	count := 1
+	withValues := false
+	if len(args) > 3 {
+		return reply.MakeArgNumErrReply("hrandfield")
+	}
+	if len(args) == 3 {
+		if strings.ToLower(string(args[2])) != "withvalues" {
+			return reply.MakeSyntaxErrReply()
+		}
+		withValues = true
+	}
+	if len(args) >= 2 {
+		count64, err := strconv.ParseInt(string(args[1]), 10, 64)
+		if err != nil {
+			return reply.MakeErrReply("ERR value is not an integer or out of range")
+		}
+		// count 为 math.MinInt64 时取反会溢出
+		if count64 < -maxRandFieldRepeats {
+			return reply.MakeErrReply("ERR value is out of range")
+		}
+		count = int(count64)
+	}
+
+	dict, errReply := db.getAsDict(key)
+	if errReply != nil {
+		return errReply
+	}
+	if dict == nil {
+		if len(args) == 1 {
+			return &reply.NullBulkReply{}
+		}
+		return &reply.EmptyMultiBulkReply{}
+	}
+
+	var fields []string
+	if count > 0 {
+		fields = dict.RandomDistinctKeys(count)
+	} else if count < 0 {
+		fields = dict.RandomKeys(-count)
+	} else {
+		return &reply.EmptyMultiBulkReply{}
+	}
+	if len(args) == 1 {
+		return reply.MakeBulkReply([]byte(fields[0]))
+	}
+	if !withValues {
+		result := make([][]byte, len(fields))
+		for i, field := range fields {
+			result[i] = []byte(field)
+		}
+		return reply.MakeMultiBulkReply(result)
+	}
+	result := make([][]byte, 0, 2*len(fields))
+	for _, field := range fields {
+		raw, _ := dict.Get(field)
+		value, _ := raw.([]byte)
+		result = append(result, []byte(field), value)
+	}
+	return reply.MakeMultiBulkReply(result)
+}
+
+func init() {
+	RegisterCommand("HSet", execHSet, writeFirstKey, rollbackFirstKey, -4)
+	RegisterCommand("HMSet", execHMSet, writeFirstKey, rollbackFirstKey, -4)
+	RegisterCommand("HSetNX", execHSetNX, writeFirstKey, rollbackFirstKey, 4)
+	RegisterCommand("HGet", execHGet, readFirstKey, nil, 3)
+	RegisterCommand("HMGet", execHMGet, readFirstKey, nil, -3)
+	RegisterCommand("HDel", execHDel, writeFirstKey, rollbackFirstKey, -3)
+	RegisterCommand("HExists", execHExists, readFirstKey, nil, 3)
+	RegisterCommand("HLen", execHLen, readFirstKey, nil, 2)
+	RegisterCommand("HStrLen", execHStrLen, readFirstKey, nil, 3)
+	RegisterCommand("HKeys", execHKeys, readFirstKey, nil, 2)
+	RegisterCommand("HVals", execHVals, readFirstKey, nil, 2)
+	RegisterCommand("HGetAll", execHGetAll, readFirstKey, nil, 2)
+	RegisterCommand("HIncrBy", execHIncrBy, writeFirstKey, rollbackFirstKey, 4)
+	RegisterCommand("HIncrByFloat", execHIncrByFloat, writeFirstKey, rollbackFirstKey, 4)
+	RegisterCommand("HRandField", execHRandField, readFirstKey, nil, -2)
+}
@@ -0,0 +1,71 @@
+package database
+
+import (
+	"github.com/jujunwang/Mudis/lib/utils"
+	"math"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestHRandFieldCount(t *testing.T) {
+	tests := []struct {
+		name  string
+		count string
+		want  string
+	}{
+		{"positive", "5", "*2\r\n"},
+		{"negative", "-3", "*3\r\n"},
+		{"largest repeat count", strconv.Itoa(-maxRandFieldRepeats), "*" + strconv.Itoa(maxRandFieldRepeats) + "\r\n"},
+		{"repeat count over limit", strconv.Itoa(-maxRandFieldRepeats - 1), "-ERR value is out of range\r\n"},
+		{"huge negative", "-1000000000000", "-ERR value is out of range\r\n"},
+		{"min int64", strconv.FormatInt(-1<<63, 10), "-ERR value is out of range\r\n"},
+		{"max int64", strconv.FormatInt(1<<63-1, 10), "*2\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := makeDB()
+			db.Exec(nil, utils.ToCmdLine("hset", "h", "a", "1", "b", "2"))
+			got := string(db.Exec(nil, utils.ToCmdLine("hrandfield", "h", tt.count)).ToBytes())
+			if len(got) < len(tt.want) || got[:len(tt.want)] != tt.want {
+				t.Fatalf("reply = %.40q, want prefix %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHIncrByRange(t *testing.T) {
+	tests := []struct {
+		name    string
+		initial string
+		cmd     string
+		delta   string
+		want    string
+	}{
+		{"max int64", strconv.FormatInt(math.MaxInt64-1, 10), "hincrby", "1", ":9223372036854775807\r\n"},
+		{"overflow", strconv.FormatInt(math.MaxInt64, 10), "hincrby", "1", "-ERR increment or decrement would overflow\r\n"},
+		{"min int64", strconv.FormatInt(math.MinInt64+1, 10), "hincrby", "-1", ":-9223372036854775808\r\n"},
+		{"underflow", strconv.FormatInt(math.MinInt64, 10), "hincrby", "-1", "-ERR increment or decrement would overflow\r\n"},
+		{"float", "1.5", "hincrbyfloat", "1", "$3\r\n2.5\r\n"},
+		{"infinite delta", "1", "hincrbyfloat", "inf", "-ERR increment would produce NaN or Infinity\r\n"},
+		{"nan delta", "1", "hincrbyfloat", "nan", "-ERR increment would produce NaN or Infinity\r\n"},
+		{"infinite result", "1.7e308", "hincrbyfloat", "1.7e308", "-ERR increment would produce NaN or Infinity\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := makeDB()
+			db.Exec(nil, utils.ToCmdLine("hset", "h", "f", tt.initial))
+			if got := string(db.Exec(nil, utils.ToCmdLine(tt.cmd, "h", "f", tt.delta)).ToBytes()); got != tt.want {
+				t.Fatalf("reply = %q, want %q", got, tt.want)
+			}
+			if !strings.HasPrefix(tt.want, "-") {
+				return
+			}
+			// 出错时字段的值不变
+			want := "$" + strconv.Itoa(len(tt.initial)) + "\r\n" + tt.initial + "\r\n"
+			if got := string(db.Exec(nil, utils.ToCmdLine("hget", "h", "f")).ToBytes()); got != want {
+				t.Fatalf("hget reply = %q, want %q", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package database
+
+import (
+	"github.com/jujunwang/Mudis/datastruct/hyperloglog"
+	"github.com/jujunwang/Mudis/interface/database"
+	"github.com/jujunwang/Mudis/interface/resp"
+	"github.com/jujunwang/Mudis/lib/utils"
+	"github.com/jujunwang/Mudis/resp/reply"
+)
+
+// HyperLogLog 与 redis 一样以 string 的形式保存，可以直接用 GET/SET 在两者之间迁移
+
+var invalidHLLErrReply = reply.MakeErrReply("WRONGTYPE Key is not a valid HyperLogLog string value.")
+
+// getAsHyperLogLog 读取并解码 HyperLogLog，key 不存在时返回 nil
+func (db *DB) getAsHyperLogLog(key string) (*hyperloglog.HyperLogLog, reply.ErrorReply) {
+	bytes, errReply := db.getAsString(key)
+	if errReply != nil {
+		return nil, errReply
+	}
+	if bytes == nil {
+		return nil, nil
+	}
+	hll, err := hyperloglog.Parse(bytes)
+	if err != nil {
+		return nil, invalidHLLErrReply
+	}
+	return hll, nil
+}
+
+// putHyperLogLog 将 HyperLogLog 编码后写回 DB，保留原有的过期时间
+func (db *DB) putHyperLogLog(key string, hll *hyperloglog.HyperLogLog) {
+	db.PutEntity(key, &database.DataEntity{
+		Data: hll.Bytes(),
+	})
+}
+
+// execPFAdd PFADD key [element ...]
+func execPFAdd(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	hll, errReply := db.getAsHyperLogLog(key)
+	if errReply != nil {
+		return errReply
+	}
+	updated := false
+	if hll == nil {
+		hll = hyperloglog.New()
+		updated = true
+	}
+	for _, element := range args[1:] {
+		if hll.Add(element) {
+			updated = true
+		}
+	}
+	if !updated {
+		return reply.MakeIntReply(0)
+	}
+	db.putHyperLogLog(key, hll)
+	db.addAof(utils.ToCmdLine3("pfadd", args...))
+	return reply.MakeIntReply(1)
+}
+
+// execPFCount PFCOUNT key [key ...]
+// 多个 key 时返回并集的基数，不会写回基数缓存
+func execPFCount(db *DB, args [][]byte) resp.Reply {
+	var union *hyperloglog.HyperLogLog
+	for _, arg := range args {
+		hll, errReply := db.getAsHyperLogLog(string(arg))
+		if errReply != nil {
+			return errReply
+		}
+		if hll == nil {
+			continue
+		}
+		if union == nil {
+			union = hll
+		} else {
+			union.Merge(hll)
+		}
+	}
+	if union == nil {
+		return reply.MakeIntReply(0)
+	}
+	return reply.MakeIntReply(int64(union.Count()))
+}
+
+// execPFMerge PFMERGE destkey [sourcekey ...]
+func execPFMerge(db *DB, args [][]byte) resp.Reply {
+	dest := string(args[0])
+	merged, errReply := db.getAsHyperLogLog(dest)
+	if errReply != nil {
+		return errReply
+	}
+	if merged == nil {
+		merged = hyperloglog.New()
+	}
+	for _, arg := range args[1:] {
+		hll, errReply := db.getAsHyperLogLog(string(arg))
+		if errReply != nil {
+			return errReply
+		}
+		if hll != nil {
+			merged.Merge(hll)
+		}
+	}
+	db.putHyperLogLog(dest, merged)
+	db.addAof(utils.ToCmdLine3("pfmerge", args...))
+	return reply.MakeOkReply()
+}
+
+func init() {
+	RegisterCommand("PFAdd", execPFAdd, writeFirstKey, rollbackFirstKey, -2)
+	RegisterCommand("PFCount", execPFCount, readAllKeys, nil, -2)
+	RegisterCommand("PFMerge", execPFMerge, prepareSetCalculateStore, rollbackFirstKey, -2)
+}
@@ -0,0 +1,152 @@
+package database
+
+import (
+	"fmt"
+	"github.com/jujunwang/Mudis/config"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// Info 返回 INFO 命令中由数据库统计的字段，每个字段的格式为 name:value
+// 不认识的 section 返回 nil
+func (mdb *StandaloneDatabase) Info(section string) []string {
+	switch section {
+	case "clients":
+		blocked := 0
+		for _, db := range mdb.dbSet {
+			blocked += db.blocking.count()
+		}
+		return []string{"blocked_clients:" + strconv.Itoa(blocked)}
+	case "memory":
+		return mdb.memoryInfo()
+	case "persistence":
+		return mdb.persistenceInfo()
+	case "stats":
+		expired, evicted := int64(0), int64(0)
+		for _, db := range mdb.dbSet {
+			expired += db.stats.expiredKeys.Get()
+			evicted += db.stats.evictedKeys.Get()
+		}
+		return []string{
+			"expired_keys:" + strconv.FormatInt(expired, 10),
+			"evicted_keys:" + strconv.FormatInt(evicted, 10),
+			"pubsub_channels:" + strconv.Itoa(mdb.hub.ChannelCount()),
+			"pubsub_patterns:" + strconv.Itoa(mdb.hub.PatternCount()),
+		}
+	case "commandstats":
+		return mdb.cmdStats.info()
+	case "keyspace":
+		lines := make([]string, 0)
+		for _, db := range mdb.dbSet {
+			keys := db.data.Len()
+			if keys == 0 {
+				continue
+			}
+			lines = append(lines, fmt.Sprintf("db%d:keys=%d,expires=%d", db.index, keys, db.ttlMap.Len()))
+		}
+		return lines
+	}
+	return nil
+}
+
+func (mdb *StandaloneDatabase) memoryInfo() []string {
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+	used := mdb.UsedMemory()
+	peak := mdb.updatePeakMemory()
+	maxMemory := config.Properties().MaxMemory
+	policy := config.Properties().MaxMemoryPolicy
+	if policy == "" {
+		policy = policyNoEviction
+	}
+	return []string{
+		// used_memory 是数据估算的内存占用，与 maxmemory 比较的也是这个值
+		"used_memory:" + strconv.FormatInt(used, 10),
+		"used_memory_human:" + bytesToHuman(used),
+		"used_memory_rss:" + strconv.FormatUint(memStats.Sys, 10),
+		"used_memory_rss_human:" + bytesToHuman(int64(memStats.Sys)),
+		"used_memory_peak:" + strconv.FormatInt(peak, 10),
+		"used_memory_peak_human:" + bytesToHuman(peak),
+		"maxmemory:" + strconv.FormatInt(maxMemory, 10),
+		"maxmemory_human:" + bytesToHuman(maxMemory),
+		"maxmemory_policy:" + policy,
+	}
+}
+
+// updatePeakMemory 更新并返回内存占用的峰值
+func (mdb *StandaloneDatabase) updatePeakMemory() int64 {
+	used := mdb.UsedMemory()
+	for {
+		peak := mdb.peakMemory.Get()
+		if used <= peak {
+			return peak
+		}
+		if mdb.peakMemory.CompareAndSwap(peak, used) {
+			return used
+		}
+	}
+}
+
+func (mdb *StandaloneDatabase) persistenceInfo() []string {
+	loading := 0
+	if mdb.loading {
+		loading = 1
+	}
+	lines := []string{"loading:" + strconv.Itoa(loading)}
+	lines = append(lines, mdb.snapshotInfo()...)
+	aofHandler := mdb.aof()
+	if aofHandler == nil {
+		return append(lines, "aof_enabled:0")
+	}
+	lines = append(lines, "aof_enabled:1")
+	if err := aofHandler.LastWriteErr(); err != nil {
+		lines = append(lines,
+			"aof_last_write_status:err",
+			"aof_last_write_error:"+strings.ReplaceAll(err.Error(), "\n", " "))
+	} else {
+		lines = append(lines, "aof_last_write_status:ok")
+	}
+	inProgress, rewriteErr, baseSize := aofHandler.RewriteStatus()
+	rewriting := 0
+	if inProgress {
+		rewriting = 1
+	}
+	rewriteStatus := "ok"
+	if rewriteErr != nil {
+		rewriteStatus = "err"
+	}
+	return append(lines,
+		"aof_rewrite_in_progress:"+strconv.Itoa(rewriting),
+		"aof_last_bgrewrite_status:"+rewriteStatus,
+		"aof_current_size:"+strconv.FormatInt(aofHandler.FileSize(), 10),
+		"aof_base_size:"+strconv.FormatInt(baseSize, 10),
+		// 队列中等待写入文件的命令批数
+		"aof_buffer_length:"+strconv.Itoa(aofHandler.QueueLen()),
+	)
+}
+
+// ResetStats 清空 INFO 中由数据库统计的计数器，用于 CONFIG RESETSTAT
+func (mdb *StandaloneDatabase) ResetStats() {
+	for _, db := range mdb.dbSet {
+		db.stats.expiredKeys.Set(0)
+		db.stats.evictedKeys.Set(0)
+	}
+	mdb.peakMemory.Set(mdb.UsedMemory())
+	mdb.cmdStats.reset()
+}
+
+// bytesToHuman 将字节数转换为便于阅读的格式，例如 1.50M
+func bytesToHuman(n int64) string {
+	units := []string{"B", "K", "M", "G", "T"}
+	value := float64(n)
+	i := 0
+	for value >= 1024 && i < len(units)-1 {
+		value /= 1024
+		i++
+	}
+	if i == 0 {
+		return strconv.FormatInt(n, 10) + "B"
+	}
+	return strconv.FormatFloat(value, 'f', 2, 64) + units[i]
+}
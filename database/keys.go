@@ -1,10 +1,20 @@
 package database
 
 import (
+	"github.com/jujunwang/Mudis/datastruct/dict"
+	"github.com/jujunwang/Mudis/datastruct/list"
+	"github.com/jujunwang/Mudis/datastruct/set"
+	"github.com/jujunwang/Mudis/datastruct/sortedset"
+	"github.com/jujunwang/Mudis/datastruct/stream"
+	"github.com/jujunwang/Mudis/interface/database"
 	"github.com/jujunwang/Mudis/interface/resp"
 	"github.com/jujunwang/Mudis/lib/utils"
 	"github.com/jujunwang/Mudis/lib/wildcard"
 	"github.com/jujunwang/Mudis/resp/reply"
+	"math"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // execDel 从数据库中删除给出的key
@@ -13,7 +23,13 @@ func execDel(db *DB, args [][]byte) resp.Reply {
 	for i, v := range args {
 		keys[i] = string(v)
 	}
-	deleted := db.Removes(keys...)
+	deleted := 0
+	for _, key := range keys {
+		if db.Removes(key) > 0 {
+			deleted++
+			db.notify(notifyGeneric, "del", key)
+		}
+	}
 	if deleted > 0 {
 		db.addAof(utils.ToCmdLine2("del", args...))
 	}
@@ -33,7 +49,7 @@ func execExists(db *DB, args [][]byte) resp.Reply {
 	return reply.MakeIntReply(result)
 }
 
-// execFlushDB 清空数据库
+// execFlushDB 清空数据库，执行时持有所有 key 的写锁，见 lockAllCommands
 func execFlushDB(db *DB, args [][]byte) resp.Reply {
 	db.Flush()
 	db.addAof(utils.ToCmdLine2("flushdb", args...))
@@ -47,19 +63,42 @@ func execType(db *DB, args [][]byte) resp.Reply {
 	if !exists {
 		return reply.MakeStatusReply("none")
 	}
+	typeName := typeOf(entity)
+	if typeName == "" {
+		return &reply.UnknownErrReply{}
+	}
+	return reply.MakeStatusReply(typeName)
+}
+
+// typeOf 返回 entity 的类型名，未知类型返回空字符串
+func typeOf(entity *database.DataEntity) string {
 	switch entity.Data.(type) {
 	case []byte:
-		return reply.MakeStatusReply("string")
-		//case *list.LinkedList:
-		//    return reply.MakeStatusReply("list")
-		//case dict.Dict:
-		//    return reply.MakeStatusReply("hash")
-		//case *set.Set:
-		//    return reply.MakeStatusReply("set")
-		//case *sortedset.SortedSet:
-		//    return reply.MakeStatusReply("zset")
+		return "string"
+	case *list.LinkedList:
+		return "list"
+	case dict.Dict:
+		return "hash"
+	case *set.Set:
+		return "set"
+	case *sortedset.SortedSet:
+		return "zset"
+	case *stream.Stream:
+		return "stream"
 	}
-	return &reply.UnknownErrReply{}
+	return ""
+}
+
+func prepareRename(args [][]byte) ([]string, []string) {
+	src := string(args[0])
+	dest := string(args[1])
+	return []string{src, dest}, nil
+}
+
+func undoRename(db *DB, args [][]byte) []CmdLine {
+	src := string(args[0])
+	dest := string(args[1])
+	return rollbackGivenKeys(db, src, dest)
 }
 
 // execRename 重命名
@@ -74,9 +113,15 @@ func execRename(db *DB, args [][]byte) resp.Reply {
 	if !ok {
 		return reply.MakeErrReply("no such key")
 	}
+	expireTime, hasTTL := db.ExpireTime(src)
+	db.Removes(src, dest) // clean src and dest with their ttl
 	db.PutEntity(dest, entity)
-	db.Remove(src)
+	if hasTTL {
+		db.Expire(dest, expireTime)
+	}
 	db.addAof(utils.ToCmdLine2("rename", args...))
+	db.notify(notifyGeneric, "rename_from", src)
+	db.notify(notifyGeneric, "rename_to", dest)
 	return &reply.OkReply{}
 }
 
@@ -94,9 +139,15 @@ func execRenameNx(db *DB, args [][]byte) resp.Reply {
 	if !ok {
 		return reply.MakeErrReply("no such key")
 	}
+	expireTime, hasTTL := db.ExpireTime(src)
 	db.Removes(src, dest) // clean src and dest with their ttl
 	db.PutEntity(dest, entity)
+	if hasTTL {
+		db.Expire(dest, expireTime)
+	}
 	db.addAof(utils.ToCmdLine2("renamenx", args...))
+	db.notify(notifyGeneric, "rename_from", src)
+	db.notify(notifyGeneric, "rename_to", dest)
 	return reply.MakeIntReply(1)
 }
 
@@ -105,7 +156,7 @@ func execKeys(db *DB, args [][]byte) resp.Reply {
 	pattern := wildcard.CompilePattern(string(args[0]))
 	result := make([][]byte, 0)
 	db.data.ForEach(func(key string, val interface{}) bool {
-		if pattern.IsMatch(key) {
+		if pattern.IsMatch(key) && !db.isExpiredNoDel(key) {
 			result = append(result, []byte(key))
 		}
 		return true
@@ -113,12 +164,299 @@ func execKeys(db *DB, args [][]byte) resp.Reply {
 	return reply.MakeMultiBulkReply(result)
 }
 
+const defaultScanCount = 10
+
+// scanOptions 是 SCAN 系列命令的可选参数
+type scanOptions struct {
+	pattern  *wildcard.Pattern
+	count    int
+	typeName string
+}
+
+// parseScanArgs 解析 cursor [MATCH pattern] [COUNT count] [TYPE type]，allowType 表示是否支持 TYPE 选项
+func parseScanArgs(args [][]byte, allowType bool) (cursor uint64, opts *scanOptions, errReply resp.Reply) {
+	cursor, err := strconv.ParseUint(string(args[0]), 10, 64)
+	if err != nil {
+		return 0, nil, reply.MakeErrReply("ERR invalid cursor")
+	}
+	opts = &scanOptions{
+		count: defaultScanCount,
+	}
+	for i := 1; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return 0, nil, reply.MakeSyntaxErrReply()
+		}
+		value := string(args[i+1])
+		switch strings.ToUpper(string(args[i])) {
+		case "MATCH":
+			opts.pattern = wildcard.CompilePattern(value)
+		case "COUNT":
+			count, err := strconv.Atoi(value)
+			if err != nil {
+				return 0, nil, reply.MakeErrReply("ERR value is not an integer or out of range")
+			}
+			if count < 1 {
+				return 0, nil, reply.MakeSyntaxErrReply()
+			}
+			opts.count = count
+		case "TYPE":
+			if !allowType {
+				return 0, nil, reply.MakeSyntaxErrReply()
+			}
+			opts.typeName = strings.ToLower(value)
+		default:
+			return 0, nil, reply.MakeSyntaxErrReply()
+		}
+	}
+	return cursor, opts, nil
+}
+
+func makeScanReply(nextCursor uint64, keys []string) resp.Reply {
+	result := make([][]byte, len(keys))
+	for i, key := range keys {
+		result[i] = []byte(key)
+	}
+	return reply.MakeMultiRawReply([]resp.Reply{
+		reply.MakeBulkReply([]byte(strconv.FormatUint(nextCursor, 10))),
+		reply.MakeMultiBulkReply(result),
+	})
+}
+
+// execScan SCAN cursor [MATCH pattern] [COUNT count] [TYPE type]
+// 每次只遍历 dict 的一部分分段，不会长时间持有分段锁
+func execScan(db *DB, args [][]byte) resp.Reply {
+	cursor, opts, errReply := parseScanArgs(args, true)
+	if errReply != nil {
+		return errReply
+	}
+	keys, nextCursor := db.data.Scan(cursor, opts.count)
+	result := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if opts.pattern != nil && !opts.pattern.IsMatch(key) {
+			continue
+		}
+		raw, ok := db.data.Get(key)
+		if !ok || db.isExpiredNoDel(key) {
+			continue
+		}
+		if opts.typeName != "" {
+			entity, _ := raw.(*database.DataEntity)
+			if typeOf(entity) != opts.typeName {
+				continue
+			}
+		}
+		result = append(result, key)
+	}
+	return makeScanReply(nextCursor, result)
+}
+
+// isExpiredNoDel 检查 key 是否过期但不删除，用于遍历 dict 时避免在持有分段锁的情况下写 dict
+func (db *DB) isExpiredNoDel(key string) bool {
+	expireTime, ok := db.ExpireTime(key)
+	return ok && time.Now().After(expireTime)
+}
+
+// makeExpireCmd 生成 PEXPIREAT 命令，AOF 中统一记录绝对时间，避免重放时延长 ttl
+func makeExpireCmd(key string, expireTime time.Time) CmdLine {
+	return utils.ToCmdLine("pexpireat", key, strconv.FormatInt(expireTime.UnixMilli(), 10))
+}
+
+// expireKey 为已存在的 key 设置过期时间，返回 key 是否存在
+func expireKey(db *DB, key string, expireTime time.Time) resp.Reply {
+	_, exists := db.GetEntity(key)
+	if !exists {
+		return reply.MakeIntReply(0)
+	}
+	if !expireTime.After(time.Now()) {
+		// 过期时间已经过去，直接删除
+		db.Remove(key)
+		db.addAof(utils.ToCmdLine("del", key))
+		db.notify(notifyGeneric, "del", key)
+		return reply.MakeIntReply(1)
+	}
+	db.Expire(key, expireTime)
+	db.addAof(makeExpireCmd(key, expireTime))
+	db.notify(notifyGeneric, "expire", key)
+	return reply.MakeIntReply(1)
+}
+
+// parseInt64Arg 解析整数参数
+func parseInt64Arg(arg []byte) (int64, reply.ErrorReply) {
+	val, err := strconv.ParseInt(string(arg), 10, 64)
+	if err != nil {
+		return 0, reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	return val, nil
+}
+
+// expireTimeAfter 返回从现在起经过 ttl 个 unit 之后的过期时间
+// ttl 乘以 unit 或者加上当前时间会溢出时 ok 为 false
+func expireTimeAfter(ttl int64, unit time.Duration) (expireTime time.Time, ok bool) {
+	if ttl > math.MaxInt64/int64(unit) || ttl < math.MinInt64/int64(unit) {
+		return time.Time{}, false
+	}
+	d := time.Duration(ttl) * unit
+	now := time.Now()
+	nowNano := now.UnixNano()
+	if (d > 0 && nowNano > math.MaxInt64-int64(d)) || (d < 0 && nowNano < math.MinInt64-int64(d)) {
+		return time.Time{}, false
+	}
+	return now.Add(d), true
+}
+
+// expireTimeAt 返回 unix 时间戳 raw 个 unit 对应的过期时间
+// AOF 中以毫秒记录过期时间，换算成毫秒会溢出时 ok 为 false
+func expireTimeAt(raw int64, unit time.Duration) (expireTime time.Time, ok bool) {
+	scale := int64(unit / time.Millisecond)
+	if raw > math.MaxInt64/scale || raw < math.MinInt64/scale {
+		return time.Time{}, false
+	}
+	return time.UnixMilli(raw * scale), true
+}
+
+// invalidExpireTimeErr 返回过期时间超出范围的错误
+func invalidExpireTimeErr(cmdName string) reply.ErrorReply {
+	return reply.MakeErrReply("ERR invalid expire time in '" + cmdName + "' command")
+}
+
+// execExpire 设置 key 的存活时间，单位为秒
+func execExpire(db *DB, args [][]byte) resp.Reply {
+	ttl, errReply := parseInt64Arg(args[1])
+	if errReply != nil {
+		return errReply
+	}
+	expireTime, ok := expireTimeAfter(ttl, time.Second)
+	if !ok {
+		return invalidExpireTimeErr("expire")
+	}
+	return expireKey(db, string(args[0]), expireTime)
+}
+
+// execPExpire 设置 key 的存活时间，单位为毫秒
+func execPExpire(db *DB, args [][]byte) resp.Reply {
+	ttl, errReply := parseInt64Arg(args[1])
+	if errReply != nil {
+		return errReply
+	}
+	expireTime, ok := expireTimeAfter(ttl, time.Millisecond)
+	if !ok {
+		return invalidExpireTimeErr("pexpire")
+	}
+	return expireKey(db, string(args[0]), expireTime)
+}
+
+// execExpireAt 设置 key 的过期时间点，参数为 unix 时间戳(秒)
+func execExpireAt(db *DB, args [][]byte) resp.Reply {
+	raw, errReply := parseInt64Arg(args[1])
+	if errReply != nil {
+		return errReply
+	}
+	expireTime, ok := expireTimeAt(raw, time.Second)
+	if !ok {
+		return invalidExpireTimeErr("expireat")
+	}
+	return expireKey(db, string(args[0]), expireTime)
+}
+
+// execPExpireAt 设置 key 的过期时间点，参数为 unix 时间戳(毫秒)
+func execPExpireAt(db *DB, args [][]byte) resp.Reply {
+	raw, errReply := parseInt64Arg(args[1])
+	if errReply != nil {
+		return errReply
+	}
+	expireTime, ok := expireTimeAt(raw, time.Millisecond)
+	if !ok {
+		return invalidExpireTimeErr("pexpireat")
+	}
+	return expireKey(db, string(args[0]), expireTime)
+}
+
+// ttlOf 返回 key 的剩余存活时间
+// key 不存在时返回 -2，没有设置过期时间时返回 -1
+func ttlOf(db *DB, key string, unit time.Duration) resp.Reply {
+	_, exists := db.GetEntity(key)
+	if !exists {
+		return reply.MakeIntReply(-2)
+	}
+	expireTime, ok := db.ExpireTime(key)
+	if !ok {
+		return reply.MakeIntReply(-1)
+	}
+	ttl := time.Until(expireTime)
+	// 四舍五入到给定的单位
+	return reply.MakeIntReply(int64((ttl + unit/2) / unit))
+}
+
+// execTTL 返回 key 的剩余存活时间，单位为秒
+func execTTL(db *DB, args [][]byte) resp.Reply {
+	return ttlOf(db, string(args[0]), time.Second)
+}
+
+// execPTTL 返回 key 的剩余存活时间，单位为毫秒
+func execPTTL(db *DB, args [][]byte) resp.Reply {
+	return ttlOf(db, string(args[0]), time.Millisecond)
+}
+
+// expireTimeOf 返回 key 的过期时间点
+// key 不存在时返回 -2，没有设置过期时间时返回 -1
+func expireTimeOf(db *DB, key string, milli bool) resp.Reply {
+	_, exists := db.GetEntity(key)
+	if !exists {
+		return reply.MakeIntReply(-2)
+	}
+	expireTime, ok := db.ExpireTime(key)
+	if !ok {
+		return reply.MakeIntReply(-1)
+	}
+	if milli {
+		return reply.MakeIntReply(expireTime.UnixMilli())
+	}
+	return reply.MakeIntReply(expireTime.Unix())
+}
+
+// execExpireTime 返回 key 过期的 unix 时间戳(秒)
+func execExpireTime(db *DB, args [][]byte) resp.Reply {
+	return expireTimeOf(db, string(args[0]), false)
+}
+
+// execPExpireTime 返回 key 过期的 unix 时间戳(毫秒)
+func execPExpireTime(db *DB, args [][]byte) resp.Reply {
+	return expireTimeOf(db, string(args[0]), true)
+}
+
+// execPersist 移除 key 的过期时间
+func execPersist(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	_, exists := db.GetEntity(key)
+	if !exists {
+		return reply.MakeIntReply(0)
+	}
+	_, hasTTL := db.ExpireTime(key)
+	if !hasTTL {
+		return reply.MakeIntReply(0)
+	}
+	db.Persist(key)
+	db.addAof(utils.ToCmdLine2("persist", args...))
+	db.notify(notifyGeneric, "persist", key)
+	return reply.MakeIntReply(1)
+}
+
 func init() {
-	RegisterCommand("Del", execDel, -2)
-	RegisterCommand("Exists", execExists, -2)
-	RegisterCommand("Keys", execKeys, 2)
-	RegisterCommand("FlushDB", execFlushDB, -1)
-	RegisterCommand("Type", execType, 2)
-	RegisterCommand("Rename", execRename, 3)
-	RegisterCommand("RenameNx", execRenameNx, 3)
+	RegisterCommand("Del", execDel, writeAllKeys, rollbackAllKeys, -2)
+	RegisterCommand("Exists", execExists, readAllKeys, nil, -2)
+	RegisterCommand("Keys", execKeys, noPrepare, nil, 2)
+	RegisterCommand("Scan", execScan, noPrepare, nil, -2)
+	RegisterCommand("FlushDB", execFlushDB, noPrepare, nil, -1)
+	RegisterCommand("Type", execType, readFirstKey, nil, 2)
+	RegisterCommand("Rename", execRename, prepareRename, undoRename, 3)
+	RegisterCommand("RenameNx", execRenameNx, prepareRename, undoRename, 3)
+	RegisterCommand("Expire", execExpire, writeFirstKey, rollbackFirstKey, 3)
+	RegisterCommand("PExpire", execPExpire, writeFirstKey, rollbackFirstKey, 3)
+	RegisterCommand("ExpireAt", execExpireAt, writeFirstKey, rollbackFirstKey, 3)
+	RegisterCommand("PExpireAt", execPExpireAt, writeFirstKey, rollbackFirstKey, 3)
+	RegisterCommand("TTL", execTTL, readFirstKey, nil, 2)
+	RegisterCommand("PTTL", execPTTL, readFirstKey, nil, 2)
+	RegisterCommand("ExpireTime", execExpireTime, readFirstKey, nil, 2)
+	RegisterCommand("PExpireTime", execPExpireTime, readFirstKey, nil, 2)
+	RegisterCommand("Persist", execPersist, writeFirstKey, rollbackFirstKey, 2)
 }
@@ -0,0 +1,58 @@
+package database
+
+import (
+	"github.com/jujunwang/Mudis/lib/utils"
+	"strconv"
+	"testing"
+)
+
+func TestExpireTimeBoundaries(t *testing.T) {
+	maxInt64 := strconv.FormatInt(1<<63-1, 10)
+	minInt64 := strconv.FormatInt(-1<<63, 10)
+	tests := []struct {
+		name string
+		// cmdLine 在 key 已经存在时执行
+		cmdLine []string
+		want    string
+		// exists 表示执行后 key 应当仍然存在
+		exists bool
+	}{
+		{"expire one second", []string{"expire", "k", "1"}, ":1\r\n", true},
+		{"expire a century", []string{"expire", "k", "3153600000"}, ":1\r\n", true},
+		{"expire negative", []string{"expire", "k", "-1"}, ":1\r\n", false},
+		{"expire past year 2262", []string{"expire", "k", "9223372036"}, "-ERR invalid expire time in 'expire' command\r\n", true},
+		{"expire multiply overflow", []string{"expire", "k", "9223372037"}, "-ERR invalid expire time in 'expire' command\r\n", true},
+		{"expire max int64", []string{"expire", "k", maxInt64}, "-ERR invalid expire time in 'expire' command\r\n", true},
+		{"expire min int64", []string{"expire", "k", minInt64}, "-ERR invalid expire time in 'expire' command\r\n", true},
+		{"pexpire a century", []string{"pexpire", "k", "3153600000000"}, ":1\r\n", true},
+		{"pexpire multiply overflow", []string{"pexpire", "k", "9223372036855"}, "-ERR invalid expire time in 'pexpire' command\r\n", true},
+		{"pexpire max int64", []string{"pexpire", "k", maxInt64}, "-ERR invalid expire time in 'pexpire' command\r\n", true},
+		{"expireat a century", []string{"expireat", "k", "4102444800"}, ":1\r\n", true},
+		{"expireat largest", []string{"expireat", "k", "9223372036854775"}, ":1\r\n", true},
+		{"expireat milliseconds overflow", []string{"expireat", "k", "9223372036854776"}, "-ERR invalid expire time in 'expireat' command\r\n", true},
+		{"expireat max int64", []string{"expireat", "k", maxInt64}, "-ERR invalid expire time in 'expireat' command\r\n", true},
+		{"expireat min int64", []string{"expireat", "k", minInt64}, "-ERR invalid expire time in 'expireat' command\r\n", true},
+		{"pexpireat max int64", []string{"pexpireat", "k", maxInt64}, ":1\r\n", true},
+		{"set exat max int64", []string{"set", "k", "v", "exat", maxInt64}, "-ERR invalid expire time in 'set' command\r\n", true},
+		{"set pxat max int64", []string{"set", "k", "v", "pxat", maxInt64}, "+OK\r\n", true},
+		{"set ex a century", []string{"set", "k", "v", "ex", "3153600000"}, "+OK\r\n", true},
+		{"set ex multiply overflow", []string{"set", "k", "v", "ex", "9223372037"}, "-ERR invalid expire time in 'set' command\r\n", true},
+		{"set ex max int64", []string{"set", "k", "v", "ex", maxInt64}, "-ERR invalid expire time in 'set' command\r\n", true},
+		{"set px max int64", []string{"set", "k", "v", "px", maxInt64}, "-ERR invalid expire time in 'set' command\r\n", true},
+		{"setex multiply overflow", []string{"setex", "k", "9223372037", "v"}, "-ERR invalid expire time in 'setex' command\r\n", true},
+		{"psetex max int64", []string{"psetex", "k", maxInt64, "v"}, "-ERR invalid expire time in 'psetex' command\r\n", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := makeDB()
+			db.Exec(nil, utils.ToCmdLine("set", "k", "v"))
+			if got := string(db.Exec(nil, utils.ToCmdLine(tt.cmdLine...)).ToBytes()); got != tt.want {
+				t.Fatalf("reply = %q, want %q", got, tt.want)
+			}
+			_, exists := db.GetEntity("k")
+			if exists != tt.exists {
+				t.Fatalf("key exists = %v, want %v", exists, tt.exists)
+			}
+		})
+	}
+}
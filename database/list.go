@@ -7,6 +7,7 @@ import (
 	"github.com/jujunwang/Mudis/lib/utils"
 	"github.com/jujunwang/Mudis/resp/reply"
 	"strconv"
+	"strings"
 )
 
 func (db *DB) getAsList(key string) (*List.LinkedList, reply.ErrorReply) {
@@ -95,8 +96,10 @@ func execLPop(db *DB, args [][]byte) resp.Reply {
 	}
 
 	val, _ := list.Remove(0).([]byte)
+	db.notify(notifyList, "lpop", key)
 	if list.Len() == 0 {
 		db.Remove(key)
+		db.notify(notifyGeneric, "del", key)
 	}
 	db.addAof(utils.ToCmdLine3("lpop", args...))
 	return reply.MakeBulkReply(val)
@@ -137,6 +140,8 @@ func execLPush(db *DB, args [][]byte) resp.Reply {
 	}
 
 	db.addAof(utils.ToCmdLine3("lpush", args...))
+	db.notify(notifyList, "lpush", key)
+	db.signalKey(key)
 	return reply.MakeIntReply(int64(list.Len()))
 }
 
@@ -166,6 +171,8 @@ func execLPushX(db *DB, args [][]byte) resp.Reply {
 		list.Insert(0, value)
 	}
 	db.addAof(utils.ToCmdLine3("lpushx", args...))
+	db.notify(notifyList, "lpush", key)
+	db.signalKey(key)
 	return reply.MakeIntReply(int64(list.Len()))
 }
 
@@ -246,11 +253,13 @@ func execLRem(db *DB, args [][]byte) resp.Reply {
 		removed = list.ReverseRemoveByVal(value, -count)
 	}
 
-	if list.Len() == 0 {
-		db.Remove(key)
-	}
 	if removed > 0 {
 		db.addAof(utils.ToCmdLine3("lrem", args...))
+		db.notify(notifyList, "lrem", key)
+	}
+	if list.Len() == 0 {
+		db.Remove(key)
+		db.notify(notifyGeneric, "del", key)
 	}
 
 	return reply.MakeIntReply(int64(removed))
@@ -284,6 +293,7 @@ func execLSet(db *DB, args [][]byte) resp.Reply {
 
 	list.Set(index, value)
 	db.addAof(utils.ToCmdLine3("lset", args...))
+	db.notify(notifyList, "lset", key)
 	return &reply.OkReply{}
 }
 
@@ -332,8 +342,10 @@ func execRPop(db *DB, args [][]byte) resp.Reply {
 	}
 
 	val, _ := list.RemoveLast().([]byte)
+	db.notify(notifyList, "rpop", key)
 	if list.Len() == 0 {
 		db.Remove(key)
+		db.notify(notifyGeneric, "del", key)
 	}
 	db.addAof(utils.ToCmdLine3("rpop", args...))
 	return reply.MakeBulkReply(val)
@@ -386,12 +398,16 @@ func execRPopLPush(db *DB, args [][]byte) resp.Reply {
 
 	val, _ := sourceList.RemoveLast().([]byte)
 	destList.Insert(0, val)
+	db.notify(notifyList, "rpop", sourceKey)
+	db.notify(notifyList, "lpush", destKey)
 
 	if sourceList.Len() == 0 {
 		db.Remove(sourceKey)
+		db.notify(notifyGeneric, "del", sourceKey)
 	}
 
 	db.addAof(utils.ToCmdLine3("rpoplpush", args...))
+	db.signalKey(destKey)
 	return reply.MakeBulkReply(val)
 }
 
@@ -432,6 +448,8 @@ func execRPush(db *DB, args [][]byte) resp.Reply {
 		list.Add(value)
 	}
 	db.addAof(utils.ToCmdLine3("rpush", args...))
+	db.notify(notifyList, "rpush", key)
+	db.signalKey(key)
 	return reply.MakeIntReply(int64(list.Len()))
 }
 
@@ -464,21 +482,232 @@ func execRPushX(db *DB, args [][]byte) resp.Reply {
 		list.Add(value)
 	}
 	db.addAof(utils.ToCmdLine3("rpushx", args...))
+	db.notify(notifyList, "rpush", key)
+	db.signalKey(key)
 
 	return reply.MakeIntReply(int64(list.Len()))
 }
 
+/* ---- 阻塞命令 ---- */
+
+func firstKeyOnly(args [][]byte) []string {
+	return []string{string(args[0])}
+}
+
+// prepareBPop 对除了最后一个超时参数以外的所有 key 加写锁
+func prepareBPop(args [][]byte) ([]string, []string) {
+	return writeAllKeys(args[:len(args)-1])
+}
+
+func bPopKeys(args [][]byte) []string {
+	keys, _ := prepareBPop(args)
+	return keys
+}
+
+// popFromList 从列表头部或尾部弹出一个元素，AOF 中记录实际执行的 LPOP/RPOP
+func (db *DB) popFromList(key string, list *List.LinkedList, left bool) []byte {
+	var val []byte
+	var cmdName string
+	if left {
+		val, _ = list.Remove(0).([]byte)
+		cmdName = "lpop"
+	} else {
+		val, _ = list.RemoveLast().([]byte)
+		cmdName = "rpop"
+	}
+	db.notify(notifyList, cmdName, key)
+	if list.Len() == 0 {
+		db.Remove(key)
+		db.notify(notifyGeneric, "del", key)
+	}
+	db.addAof(utils.ToCmdLine(cmdName, key))
+	return val
+}
+
+// tryBPop 从第一个非空的列表中弹出元素
+func tryBPop(db *DB, args [][]byte, ready func(key string) bool, left bool) (resp.Reply, bool) {
+	for _, arg := range args[:len(args)-1] {
+		key := string(arg)
+		list, errReply := db.getAsList(key)
+		if errReply != nil {
+			return errReply, true
+		}
+		if list == nil || !ready(key) {
+			continue
+		}
+		val := db.popFromList(key, list, left)
+		return reply.MakeMultiBulkReply([][]byte{arg, val}), true
+	}
+	return reply.MakeNullMultiBulkReply(), false
+}
+
+// tryBLPop BLPOP key [key ...] timeout
+func tryBLPop(db *DB, args [][]byte, ready func(key string) bool) (resp.Reply, bool) {
+	return tryBPop(db, args, ready, true)
+}
+
+// tryBRPop BRPOP key [key ...] timeout
+func tryBRPop(db *DB, args [][]byte, ready func(key string) bool) (resp.Reply, bool) {
+	return tryBPop(db, args, ready, false)
+}
+
+// tryMove 从 src 中弹出一个元素并插入 dest，AOF 中记录实际执行的弹出和插入
+func tryMove(db *DB, src string, dest string, fromLeft bool, toLeft bool, ready func(key string) bool) (resp.Reply, bool) {
+	srcList, errReply := db.getAsList(src)
+	if errReply != nil {
+		return errReply, true
+	}
+	if srcList == nil || !ready(src) {
+		return &reply.NullBulkReply{}, false
+	}
+	destList, _, errReply := db.getOrInitList(dest)
+	if errReply != nil {
+		return errReply, true
+	}
+
+	var val []byte
+	popCmd, pushCmd := "rpop", "rpush"
+	if fromLeft {
+		val, _ = srcList.Remove(0).([]byte)
+		popCmd = "lpop"
+	} else {
+		val, _ = srcList.RemoveLast().([]byte)
+	}
+	if toLeft {
+		destList.Insert(0, val)
+		pushCmd = "lpush"
+	} else {
+		destList.Add(val)
+	}
+	db.notify(notifyList, popCmd, src)
+	db.notify(notifyList, pushCmd, dest)
+	// src 和 dest 可能是同一个列表，插入之后再判断是否为空
+	if srcList.Len() == 0 {
+		db.Remove(src)
+		db.notify(notifyGeneric, "del", src)
+	}
+	db.addAof(
+		utils.ToCmdLine(popCmd, src),
+		utils.ToCmdLine3(pushCmd, []byte(dest), val),
+	)
+	db.signalKey(dest)
+	return reply.MakeBulkReply(val), true
+}
+
+// tryBRPopLPush BRPOPLPUSH source destination timeout
+func tryBRPopLPush(db *DB, args [][]byte, ready func(key string) bool) (resp.Reply, bool) {
+	return tryMove(db, string(args[0]), string(args[1]), false, true, ready)
+}
+
+func parseDirection(arg []byte) (left bool, ok bool) {
+	switch strings.ToUpper(string(arg)) {
+	case "LEFT":
+		return true, true
+	case "RIGHT":
+		return false, true
+	}
+	return false, false
+}
+
+// tryBLMove BLMOVE source destination LEFT|RIGHT LEFT|RIGHT timeout
+func tryBLMove(db *DB, args [][]byte, ready func(key string) bool) (resp.Reply, bool) {
+	fromLeft, ok1 := parseDirection(args[2])
+	toLeft, ok2 := parseDirection(args[3])
+	if !ok1 || !ok2 {
+		return reply.MakeSyntaxErrReply(), true
+	}
+	return tryMove(db, string(args[0]), string(args[1]), fromLeft, toLeft, ready)
+}
+
+// parseBLMPop 解析 BLMPOP timeout numkeys key [key ...] LEFT|RIGHT [COUNT count]
+func parseBLMPop(args [][]byte) (keys []string, left bool, count int, errReply resp.Reply) {
+	numKeys, err := strconv.Atoi(string(args[1]))
+	if err != nil {
+		return nil, false, 0, reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	if numKeys <= 0 {
+		return nil, false, 0, reply.MakeErrReply("ERR numkeys should be greater than 0")
+	}
+	if len(args) < numKeys+3 {
+		return nil, false, 0, reply.MakeSyntaxErrReply()
+	}
+	keys = make([]string, numKeys)
+	for i := 0; i < numKeys; i++ {
+		keys[i] = string(args[i+2])
+	}
+	left, ok := parseDirection(args[numKeys+2])
+	if !ok {
+		return nil, false, 0, reply.MakeSyntaxErrReply()
+	}
+	count = 1
+	rest := args[numKeys+3:]
+	if len(rest) > 0 {
+		if len(rest) != 2 || strings.ToUpper(string(rest[0])) != "COUNT" {
+			return nil, false, 0, reply.MakeSyntaxErrReply()
+		}
+		count, err = strconv.Atoi(string(rest[1]))
+		if err != nil || count <= 0 {
+			return nil, false, 0, reply.MakeErrReply("ERR count should be greater than 0")
+		}
+	}
+	return keys, left, count, nil
+}
+
+func prepareBLMPop(args [][]byte) ([]string, []string) {
+	keys, _, _, errReply := parseBLMPop(args)
+	if errReply != nil {
+		return nil, nil
+	}
+	return keys, nil
+}
+
+func blmPopKeys(args [][]byte) []string {
+	keys, _ := prepareBLMPop(args)
+	return keys
+}
+
+// tryBLMPop 从第一个非空的列表中弹出最多 count 个元素
+func tryBLMPop(db *DB, args [][]byte, ready func(key string) bool) (resp.Reply, bool) {
+	keys, left, count, errReply := parseBLMPop(args)
+	if errReply != nil {
+		return errReply, true
+	}
+	for _, key := range keys {
+		list, errReply := db.getAsList(key)
+		if errReply != nil {
+			return errReply, true
+		}
+		if list == nil || !ready(key) {
+			continue
+		}
+		vals := make([][]byte, 0, count)
+		for len(vals) < count && list.Len() > 0 {
+			vals = append(vals, db.popFromList(key, list, left))
+		}
+		return reply.MakeMultiRawReply([]resp.Reply{
+			reply.MakeBulkReply([]byte(key)),
+			reply.MakeMultiBulkReply(vals),
+		}), true
+	}
+	return reply.MakeNullMultiBulkReply(), false
+}
+
 func init() {
-	RegisterCommand("lpush", execLPush, -3)
-	RegisterCommand("lpushx", execLPushX, -3)
-	RegisterCommand("rpush", execRPush, -3)
-	RegisterCommand("rpushX", execRPushX, -3)
-	RegisterCommand("lpop", execLPop, 2)
-	RegisterCommand("rpop", execRPop, 2)
-	RegisterCommand("rpoplpush", execRPopLPush, 3)
-	RegisterCommand("lrem", execLRem, 4)
-	RegisterCommand("llen", execLLen, 2)
-	RegisterCommand("lindex", execLIndex, 3)
-	RegisterCommand("lset", execLSet, 4)
-	RegisterCommand("lrange", execLRange, 4)
+	RegisterCommand("lpush", execLPush, writeFirstKey, undoLPush, -3)
+	RegisterCommand("lpushx", execLPushX, writeFirstKey, undoLPush, -3)
+	RegisterCommand("rpush", execRPush, writeFirstKey, undoRPush, -3)
+	RegisterCommand("rpushX", execRPushX, writeFirstKey, undoRPush, -3)
+	RegisterCommand("lpop", execLPop, writeFirstKey, undoLPop, 2)
+	RegisterCommand("rpop", execRPop, writeFirstKey, undoRPop, 2)
+	RegisterCommand("rpoplpush", execRPopLPush, prepareRPopLPush, undoRPopLPush, 3)
+	RegisterCommand("lrem", execLRem, writeFirstKey, rollbackFirstKey, 4)
+	RegisterCommand("llen", execLLen, readFirstKey, nil, 2)
+	RegisterCommand("lindex", execLIndex, readFirstKey, nil, 3)
+	RegisterCommand("lset", execLSet, writeFirstKey, undoLSet, 4)
+	RegisterCommand("lrange", execLRange, readFirstKey, nil, 4)
+	registerBlockingCommand("blpop", tryBLPop, bPopKeys, timeoutInLastArg, prepareBPop, -3)
+	registerBlockingCommand("brpop", tryBRPop, bPopKeys, timeoutInLastArg, prepareBPop, -3)
+	registerBlockingCommand("brpoplpush", tryBRPopLPush, firstKeyOnly, timeoutInLastArg, prepareRPopLPush, 4)
+	registerBlockingCommand("blmove", tryBLMove, firstKeyOnly, timeoutInLastArg, prepareRPopLPush, 6)
+	registerBlockingCommand("blmpop", tryBLMPop, blmPopKeys, timeoutInFirstArg, prepareBLMPop, -5)
 }
@@ -0,0 +1,229 @@
+package database
+
+import (
+	"github.com/jujunwang/Mudis/datastruct/dict"
+	List "github.com/jujunwang/Mudis/datastruct/list"
+	"github.com/jujunwang/Mudis/datastruct/set"
+	"github.com/jujunwang/Mudis/datastruct/sortedset"
+	"github.com/jujunwang/Mudis/datastruct/stream"
+	"github.com/jujunwang/Mudis/interface/database"
+	"math/rand"
+	"sync/atomic"
+	"time"
+)
+
+// 估算内存占用时使用的常量，只需要和真实占用处于同一量级
+const (
+	// keyOverhead 是每个 key 在 data、ttlMap 等字典中的固定开销
+	keyOverhead = 96
+	// elementOverhead 是集合类型中每个元素的固定开销(链表节点、map 槽位、跳表节点等)
+	elementOverhead = 48
+	// sizeSamples 是估算集合类型大小时抽样的元素数量
+	sizeSamples = 5
+)
+
+// LFU 计数器的参数，与 redis 的默认配置一致
+const (
+	lfuInitVal   = 5
+	lfuLogFactor = 10
+	// lfuDecayTime 是计数器减一的时间间隔(分钟)
+	lfuDecayTime = 1
+)
+
+// keyStat 记录 key 估算的内存占用和访问信息
+// 读命令只持有 key 的读锁，因此所有字段都使用原子操作访问
+type keyStat struct {
+	size int64
+	// lastAccess 是最近一次访问的时间，unix 毫秒
+	lastAccess int64
+	// lfuCounter 是对数访问计数器，最大为 255
+	lfuCounter uint32
+	// lfuDecrTime 是计数器最近一次衰减的时间，unix 分钟
+	lfuDecrTime int64
+}
+
+// memoryUsage 记录一个 DB 中所有 key 的内存占用
+// 事务使用的 DB 副本与原 DB 共享同一个 memoryUsage
+type memoryUsage struct {
+	// used 是所有 key 估算的内存占用之和
+	used int64
+	// key -> *keyStat
+	stats dict.Dict
+}
+
+func makeMemoryUsage() *memoryUsage {
+	return &memoryUsage{
+		stats: dict.MakeConcurrent(statDictSize),
+	}
+}
+
+// UsedMemory 返回 DB 中数据估算的内存占用
+func (db *DB) UsedMemory() int64 {
+	return atomic.LoadInt64(&db.memory.used)
+}
+
+func (db *DB) getKeyStat(key string) *keyStat {
+	raw, ok := db.memory.stats.Get(key)
+	if !ok {
+		return nil
+	}
+	return raw.(*keyStat)
+}
+
+// updateMemory 在写命令执行后重新估算 key 的内存占用，调用方需要持有 key 的写锁
+func (db *DB) updateMemory(keys ...string) {
+	for _, key := range keys {
+		raw, exists := db.data.Get(key)
+		if !exists {
+			db.removeKeyStat(key)
+			continue
+		}
+		size := int64(len(key)) + entitySize(raw.(*database.DataEntity))
+		stat := db.getKeyStat(key)
+		if stat == nil {
+			now := time.Now()
+			stat = &keyStat{
+				lastAccess:  now.UnixMilli(),
+				lfuCounter:  lfuInitVal,
+				lfuDecrTime: now.Unix() / 60,
+			}
+			db.memory.stats.Put(key, stat)
+		}
+		old := atomic.SwapInt64(&stat.size, size)
+		atomic.AddInt64(&db.memory.used, size-old)
+	}
+}
+
+// removeKeyStat 在 key 被删除后扣除它的内存占用
+func (db *DB) removeKeyStat(key string) {
+	stat := db.getKeyStat(key)
+	if stat == nil {
+		return
+	}
+	// 惰性删除可能在多个读命令中并发发生，只有真正删除了 stat 的调用扣除内存
+	if db.memory.stats.Remove(key) > 0 {
+		atomic.AddInt64(&db.memory.used, -atomic.LoadInt64(&stat.size))
+	}
+}
+
+// clearMemory 在清空 DB 后重置内存统计
+func (db *DB) clearMemory() {
+	db.memory.stats.Clear()
+	atomic.StoreInt64(&db.memory.used, 0)
+}
+
+// touch 更新 key 的 LRU 时间和 LFU 计数器
+func (db *DB) touch(key string) {
+	stat := db.getKeyStat(key)
+	if stat == nil {
+		return
+	}
+	now := time.Now()
+	atomic.StoreInt64(&stat.lastAccess, now.UnixMilli())
+	counter := lfuLogIncr(stat.decayedCounter(now))
+	atomic.StoreUint32(&stat.lfuCounter, counter)
+	atomic.StoreInt64(&stat.lfuDecrTime, now.Unix()/60)
+}
+
+// idleTime 返回 key 距离上次访问经过的时间
+func (stat *keyStat) idleTime(now time.Time) time.Duration {
+	return time.Duration(now.UnixMilli()-atomic.LoadInt64(&stat.lastAccess)) * time.Millisecond
+}
+
+// decayedCounter 返回按照距离上次衰减经过的时间衰减后的 LFU 计数器
+func (stat *keyStat) decayedCounter(now time.Time) uint32 {
+	counter := atomic.LoadUint32(&stat.lfuCounter)
+	periods := (now.Unix()/60 - atomic.LoadInt64(&stat.lfuDecrTime)) / lfuDecayTime
+	if periods <= 0 {
+		return counter
+	}
+	if periods >= int64(counter) {
+		return 0
+	}
+	return counter - uint32(periods)
+}
+
+// lfuLogIncr 以对数概率递增计数器，计数器越大递增的概率越小
+func lfuLogIncr(counter uint32) uint32 {
+	if counter >= 255 {
+		return 255
+	}
+	baseVal := float64(counter) - lfuInitVal
+	if baseVal < 0 {
+		baseVal = 0
+	}
+	p := 1.0 / (baseVal*lfuLogFactor + 1)
+	if rand.Float64() < p {
+		counter++
+	}
+	return counter
+}
+
+// entitySize 估算 DataEntity 占用的内存
+// 集合类型只抽样前几个元素，用它们的平均大小乘以元素数量，避免每次写入都遍历整个集合
+func entitySize(entity *database.DataEntity) int64 {
+	size := int64(keyOverhead)
+	switch val := entity.Data.(type) {
+	case []byte:
+		size += int64(len(val))
+	case *List.LinkedList:
+		sampled, total := 0, int64(0)
+		val.ForEach(func(i int, v interface{}) bool {
+			bytes, _ := v.([]byte)
+			total += int64(len(bytes))
+			sampled++
+			return sampled < sizeSamples
+		})
+		size += sampledSize(val.Len(), sampled, total)
+	case *set.Set:
+		members := val.RandomMembers(sizeSamples)
+		total := int64(0)
+		for _, member := range members {
+			total += int64(len(member))
+		}
+		size += sampledSize(val.Len(), len(members), total)
+	case dict.Dict:
+		fields := val.RandomKeys(sizeSamples)
+		total := int64(0)
+		for _, field := range fields {
+			raw, _ := val.Get(field)
+			value, _ := raw.([]byte)
+			total += int64(len(field) + len(value))
+		}
+		size += sampledSize(val.Len(), len(fields), total)
+	case *sortedset.SortedSet:
+		sampled, total := 0, int64(0)
+		if val.Len() > 0 {
+			val.ForEachByRank(0, val.Len(), false, func(element *sortedset.Element) bool {
+				// 成员同时保存在字典和跳表中
+				total += int64(len(element.Member)) + elementOverhead
+				sampled++
+				return sampled < sizeSamples
+			})
+		}
+		size += sampledSize(int(val.Len()), sampled, total)
+	case *stream.Stream:
+		sampled, total := 0, int64(0)
+		val.ForEach(func(entry *stream.Entry) bool {
+			for _, field := range entry.Fields {
+				total += int64(len(field)) + 24
+			}
+			sampled++
+			return sampled < sizeSamples
+		})
+		size += sampledSize(val.Len(), sampled, total)
+		for _, group := range val.Groups() {
+			size += int64(len(group.Name)) + elementOverhead
+			size += int64(group.PendingCount()) * elementOverhead
+		}
+	}
+	return size
+}
+
+// sampledSize 根据抽样元素的总大小估算 n 个元素的大小
+func sampledSize(n int, sampled int, total int64) int64 {
+	if sampled == 0 {
+		return 0
+	}
+	return int64(n)*elementOverhead + total*int64(n)/int64(sampled)
+}
@@ -0,0 +1,110 @@
+package database
+
+import (
+	"github.com/jujunwang/Mudis/config"
+	"github.com/jujunwang/Mudis/pubsub"
+	"strconv"
+	"strings"
+)
+
+// 键空间通知的类型，对应 notify-keyspace-events 中的字符
+const (
+	notifyKeyspace = 1 << iota // K，发送到 __keyspace@<db>__:<key>
+	notifyKeyevent             // E，发送到 __keyevent@<db>__:<event>
+	notifyGeneric              // g，DEL、EXPIRE、RENAME 等与类型无关的命令
+	notifyString               // $
+	notifyList                 // l
+	notifySet                  // s
+	notifyHash                 // h
+	notifyZSet                 // z
+	notifyExpired              // x，key 过期被删除
+	notifyEvicted              // e，key 因为 maxmemory 被淘汰
+
+	// notifyAll 对应 A，是 g$lshzxe 的别名
+	notifyAll = notifyGeneric | notifyString | notifyList | notifySet | notifyHash | notifyZSet |
+		notifyExpired | notifyEvicted
+)
+
+// notifyFlagChars 是每种通知类型对应的字符，按照 CONFIG GET 输出的顺序排列
+var notifyFlagChars = []struct {
+	flag int
+	char byte
+}{
+	{notifyGeneric, 'g'},
+	{notifyString, '$'},
+	{notifyList, 'l'},
+	{notifySet, 's'},
+	{notifyHash, 'h'},
+	{notifyZSet, 'z'},
+	{notifyExpired, 'x'},
+	{notifyEvicted, 'e'},
+	{notifyKeyspace, 'K'},
+	{notifyKeyevent, 'E'},
+}
+
+// ParseKeyspaceEvents 解析 notify-keyspace-events，包含不支持的字符时 ok 为 false
+func ParseKeyspaceEvents(value string) (flags int, ok bool) {
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if c == 'A' {
+			flags |= notifyAll
+			continue
+		}
+		found := false
+		for _, fc := range notifyFlagChars {
+			if fc.char == c {
+				flags |= fc.flag
+				found = true
+				break
+			}
+		}
+		if !found {
+			return 0, false
+		}
+	}
+	return flags, true
+}
+
+// FormatKeyspaceEvents 将通知类型转换为规范的 notify-keyspace-events，例如 AKE
+func FormatKeyspaceEvents(flags int) string {
+	var builder strings.Builder
+	if flags&notifyAll == notifyAll {
+		builder.WriteByte('A')
+	}
+	for _, fc := range notifyFlagChars {
+		if fc.flag&notifyAll != 0 && flags&notifyAll == notifyAll {
+			continue
+		}
+		if flags&fc.flag != 0 {
+			builder.WriteByte(fc.char)
+		}
+	}
+	return builder.String()
+}
+
+// removeAndNotify 删除 key，key 存在时发送 del 通知
+func (db *DB) removeAndNotify(key string) {
+	if db.Removes(key) > 0 {
+		db.notify(notifyGeneric, "del", key)
+	}
+}
+
+// notifyKeyspaceEvent 按照 notify-keyspace-events 的配置通过发布订阅发送键空间通知
+func (mdb *StandaloneDatabase) notifyKeyspaceEvent(dbIndex int, class int, event string, key string) {
+	raw := config.Properties().NotifyKeyspaceEvents
+	// 加载 AOF 时不发送通知
+	if raw == "" || mdb.loading {
+		return
+	}
+	flags, _ := ParseKeyspaceEvents(raw)
+	if flags&class == 0 {
+		return
+	}
+	prefix := "@" + strconv.Itoa(dbIndex) + "__:"
+	if flags&notifyKeyspace != 0 {
+		pubsub.Publish(mdb.hub, [][]byte{[]byte("__keyspace" + prefix + key), []byte(event)})
+	}
+	if flags&notifyKeyevent != 0 {
+		pubsub.Publish(mdb.hub, [][]byte{[]byte("__keyevent" + prefix + event), []byte(key)})
+	}
+}
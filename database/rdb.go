@@ -0,0 +1,367 @@
+package database
+
+import (
+	"errors"
+	"github.com/jujunwang/Mudis/aof"
+	"github.com/jujunwang/Mudis/config"
+	"github.com/jujunwang/Mudis/datastruct/dict"
+	List "github.com/jujunwang/Mudis/datastruct/list"
+	"github.com/jujunwang/Mudis/datastruct/set"
+	"github.com/jujunwang/Mudis/datastruct/sortedset"
+	"github.com/jujunwang/Mudis/interface/database"
+	"github.com/jujunwang/Mudis/interface/resp"
+	"github.com/jujunwang/Mudis/lib/logger"
+	"github.com/jujunwang/Mudis/rdb"
+	"github.com/jujunwang/Mudis/resp/reply"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// saveRetryDelay 是自动保存失败后再次尝试前等待的时间
+	saveRetryDelay = 5 * time.Second
+)
+
+var errSaveInProgress = errors.New("Background save already in progress")
+
+// SaveRule 是一条自动保存快照的规则，seconds 秒内至少有 changes 次修改时执行 BGSAVE
+type SaveRule struct {
+	Seconds int
+	Changes int64
+}
+
+// ParseSaveRules 解析 save 配置，格式为 "<seconds> <changes> ..."，格式错误时 ok 为 false
+func ParseSaveRules(value string) (rules []SaveRule, ok bool) {
+	fields := strings.Fields(value)
+	if len(fields)%2 != 0 {
+		return nil, false
+	}
+	for i := 0; i < len(fields); i += 2 {
+		seconds, err := strconv.Atoi(fields[i])
+		if err != nil || seconds < 0 {
+			return nil, false
+		}
+		changes, err := strconv.ParseInt(fields[i+1], 10, 64)
+		if err != nil || changes < 0 {
+			return nil, false
+		}
+		rules = append(rules, SaveRule{Seconds: seconds, Changes: changes})
+	}
+	return rules, true
+}
+
+// snapshotState 记录快照的保存状态
+type snapshotState struct {
+	mu         sync.Mutex
+	inProgress bool
+	// lastSave 是最近一次成功保存的时间，启动时为启动完成的时间
+	lastSave time.Time
+	// lastTry 和 lastErr 是最近一次保存的时间和错误，用于自动保存失败后延迟重试
+	lastTry time.Time
+	lastErr error
+	// bgSaving 在后台保存结束时完成，关闭数据库时等待它
+	bgSaving sync.WaitGroup
+}
+
+// Save 在当前 goroutine 中保存快照
+func (mdb *StandaloneDatabase) Save() error {
+	if err := mdb.beginSave(); err != nil {
+		return err
+	}
+	return mdb.doSave()
+}
+
+// BGSave 在后台保存快照，只在复制数据时短暂阻塞其他命令
+func (mdb *StandaloneDatabase) BGSave() error {
+	if err := mdb.beginSave(); err != nil {
+		return err
+	}
+	mdb.snapshot.bgSaving.Add(1)
+	go func() {
+		defer mdb.snapshot.bgSaving.Done()
+		if err := mdb.doSave(); err != nil {
+			logger.Error("background saving error: " + err.Error())
+			return
+		}
+		logger.Info("background saving terminated with success")
+	}()
+	return nil
+}
+
+// LastSave 返回最近一次成功保存快照的时间
+func (mdb *StandaloneDatabase) LastSave() time.Time {
+	mdb.snapshot.mu.Lock()
+	defer mdb.snapshot.mu.Unlock()
+	return mdb.snapshot.lastSave
+}
+
+// beginSave 将快照标记为保存中，同一时间只能有一个保存过程
+func (mdb *StandaloneDatabase) beginSave() error {
+	state := &mdb.snapshot
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	if state.inProgress {
+		return errSaveInProgress
+	}
+	state.inProgress = true
+	state.lastTry = time.Now()
+	return nil
+}
+
+// doSave 写入快照并更新保存状态，调用前需要通过 beginSave 标记为保存中
+func (mdb *StandaloneDatabase) doSave() error {
+	// 保存期间产生的修改不一定包含在快照中，只扣除开始保存之前的修改次数
+	dirty := mdb.dirty.Get()
+	err := mdb.writeSnapshot(config.Properties().DbFilename)
+	state := &mdb.snapshot
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	state.inProgress = false
+	state.lastErr = err
+	if err == nil {
+		state.lastSave = time.Now()
+		mdb.dirty.Add(-dirty)
+	}
+	return err
+}
+
+// writeSnapshot 将所有 DB 写入临时文件，写入成功后替换快照文件
+// 先逐个 DB 加锁复制数据，释放锁之后再编码和写入文件
+func (mdb *StandaloneDatabase) writeSnapshot(filename string) error {
+	snapshot := mdb.captureSnapshot()
+	tmpFilename := filename + ".tmp"
+	file, err := os.OpenFile(tmpFilename, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	enc := rdb.NewEncoder(file)
+	for i, entries := range snapshot {
+		if len(entries) == 0 {
+			continue
+		}
+		if err = enc.SelectDB(mdb.dbSet[i].index); err != nil {
+			break
+		}
+		if err = writeSnapshotEntries(enc, entries); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = enc.Close()
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tmpFilename)
+		return err
+	}
+	return os.Rename(tmpFilename, filename)
+}
+
+// snapshotEntry 是快照中的一个 key，entity 是复制出来的值，之后的命令不会再修改它
+// 无法复制的类型(例如流)在复制时序列化为能够重建它的命令 cmdLines
+type snapshotEntry struct {
+	key        string
+	entity     *database.DataEntity
+	cmdLines   []CmdLine
+	expireTime time.Time
+}
+
+// captureSnapshot 依次复制每个 DB 中未过期的 key，复制一个 DB 时只持有这个 DB 中所有 key 的写锁
+// 复制期间访问这个 DB 的命令会等待，停顿时间与这个 DB 的大小成正比，其它 DB 不受影响。
+// 没有同时访问多个 DB 的命令，每个 DB 的数据都是某一时刻的完整状态，但不同 DB 复制的时刻不同
+func (mdb *StandaloneDatabase) captureSnapshot() [][]*snapshotEntry {
+	snapshot := make([][]*snapshotEntry, len(mdb.dbSet))
+	for i, db := range mdb.dbSet {
+		snapshot[i] = db.captureSnapshotLocked()
+	}
+	return snapshot
+}
+
+// captureSnapshotLocked 持有 DB 中所有 key 的写锁，复制 DB 中所有未过期的 key
+func (db *DB) captureSnapshotLocked() []*snapshotEntry {
+	db.locker.LockAll()
+	defer db.locker.UnLockAll()
+	return db.captureSnapshot()
+}
+
+// captureSnapshot 复制 DB 中所有未过期的 key，调用方需要持有所有 key 的锁
+func (db *DB) captureSnapshot() []*snapshotEntry {
+	entries := make([]*snapshotEntry, 0, db.data.Len())
+	db.data.ForEach(func(key string, raw interface{}) bool {
+		if db.isExpiredNoDel(key) {
+			return true
+		}
+		entry := &snapshotEntry{key: key}
+		entry.expireTime, _ = db.ExpireTime(key)
+		entity := raw.(*database.DataEntity)
+		if cloned, ok := cloneEntity(entity); ok {
+			entry.entity = cloned
+		} else {
+			entry.cmdLines = aof.EntityToCmds(key, entity)
+		}
+		entries = append(entries, entry)
+		return true
+	})
+	return entries
+}
+
+// cloneEntity 复制 entity 的值，包括 SETRANGE、SETBIT 等命令原地修改的字符串，无法复制的类型返回 false
+func cloneEntity(entity *database.DataEntity) (*database.DataEntity, bool) {
+	switch val := entity.Data.(type) {
+	case []byte:
+		bytes := make([]byte, len(val))
+		copy(bytes, val)
+		return &database.DataEntity{Data: bytes}, true
+	case *List.LinkedList:
+		list := List.Make()
+		val.ForEach(func(i int, v interface{}) bool {
+			list.Add(v)
+			return true
+		})
+		return &database.DataEntity{Data: list}, true
+	case *set.Set:
+		return &database.DataEntity{Data: set.Make(val.ToSlice()...)}, true
+	case *sortedset.SortedSet:
+		zset := sortedset.Make()
+		val.ForEach(sortedset.NegativeInfBorder, sortedset.PositiveInfBorder, 0, -1, false,
+			func(element *sortedset.Element) bool {
+				zset.Add(element.Member, element.Score)
+				return true
+			})
+		return &database.DataEntity{Data: zset}, true
+	case dict.Dict:
+		hash := dict.MakeSimple()
+		val.ForEach(func(field string, v interface{}) bool {
+			hash.Put(field, v)
+			return true
+		})
+		return &database.DataEntity{Data: hash}, true
+	}
+	return nil, false
+}
+
+func writeSnapshotEntries(enc *rdb.Encoder, entries []*snapshotEntry) error {
+	for _, entry := range entries {
+		var err error
+		if entry.entity != nil {
+			err = enc.WriteEntry(entry.key, entry.entity, entry.expireTime)
+		} else {
+			err = enc.WriteCommands(entry.key, entry.cmdLines, entry.expireTime)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadSnapshot 加载快照文件，文件不存在时忽略
+func (mdb *StandaloneDatabase) loadSnapshot(filename string) error {
+	if _, err := os.Stat(filename); os.IsNotExist(err) {
+		return nil
+	}
+	now := time.Now()
+	keys := 0
+	err := rdb.Load(filename, func(entry *rdb.Entry) error {
+		if entry.DBIndex >= len(mdb.dbSet) {
+			return errors.New("DB index " + strconv.Itoa(entry.DBIndex) + " in snapshot is out of range")
+		}
+		if !entry.ExpireTime.IsZero() && now.After(entry.ExpireTime) {
+			return nil
+		}
+		db := mdb.dbSet[entry.DBIndex]
+		if entry.Entity != nil {
+			db.PutEntity(entry.Key, entry.Entity)
+		}
+		for _, cmdLine := range entry.Cmds {
+			if result := db.execWithLock(cmdLine); reply.IsErrorReply(result) {
+				return errors.New("load key " + entry.Key + " error: " + string(result.ToBytes()))
+			}
+		}
+		if !entry.ExpireTime.IsZero() {
+			db.Expire(entry.Key, entry.ExpireTime)
+		}
+		db.updateMemory(entry.Key)
+		keys++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	logger.Info("loaded " + strconv.Itoa(keys) + " keys from " + filename)
+	return nil
+}
+
+// checkSaveRules 在满足 save 配置中的任意一条规则时执行 BGSAVE，由后台的定时任务调用
+func (mdb *StandaloneDatabase) checkSaveRules() {
+	rules, _ := ParseSaveRules(config.Properties().Save)
+	if len(rules) == 0 {
+		return
+	}
+	dirty := mdb.dirty.Get()
+	state := &mdb.snapshot
+	state.mu.Lock()
+	if state.inProgress || (state.lastErr != nil && time.Since(state.lastTry) < saveRetryDelay) {
+		state.mu.Unlock()
+		return
+	}
+	elapsed := time.Since(state.lastSave)
+	state.mu.Unlock()
+	for _, rule := range rules {
+		if dirty >= rule.Changes && elapsed >= time.Duration(rule.Seconds)*time.Second {
+			logger.Info(strconv.FormatInt(rule.Changes, 10) + " changes in " + strconv.Itoa(rule.Seconds) +
+				" seconds. Saving...")
+			_ = mdb.BGSave()
+			return
+		}
+	}
+}
+
+// execSave SAVE | BGSAVE | LASTSAVE
+func (mdb *StandaloneDatabase) execSave(cmdName string, args [][]byte) resp.Reply {
+	if len(args) != 0 {
+		return reply.MakeArgNumErrReply(cmdName)
+	}
+	switch cmdName {
+	case "save":
+		if err := mdb.Save(); err != nil {
+			return reply.MakeErrReply("ERR " + err.Error())
+		}
+		return reply.MakeOkReply()
+	case "bgsave":
+		if err := mdb.BGSave(); err != nil {
+			return reply.MakeErrReply("ERR " + err.Error())
+		}
+		return reply.MakeStatusReply("Background saving started")
+	}
+	return reply.MakeIntReply(mdb.LastSave().Unix())
+}
+
+// snapshotInfo 返回 INFO persistence 中与快照相关的字段
+func (mdb *StandaloneDatabase) snapshotInfo() []string {
+	state := &mdb.snapshot
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	inProgress := 0
+	if state.inProgress {
+		inProgress = 1
+	}
+	status := "ok"
+	if state.lastErr != nil {
+		status = "err"
+	}
+	return []string{
+		"rdb_changes_since_last_save:" + strconv.FormatInt(mdb.dirty.Get(), 10),
+		"rdb_bgsave_in_progress:" + strconv.Itoa(inProgress),
+		"rdb_last_save_time:" + strconv.FormatInt(state.lastSave.Unix(), 10),
+		"rdb_last_bgsave_status:" + status,
+	}
+}
@@ -50,6 +50,9 @@ func execSAdd(db *DB, args [][]byte) resp.Reply {
 		counter += set.Add(string(member))
 	}
 	db.addAof(utils.ToCmdLine3("sadd", args...))
+	if counter > 0 {
+		db.notify(notifySet, "sadd", key)
+	}
 	return reply.MakeIntReply(int64(counter))
 }
 
@@ -87,11 +90,13 @@ func execSRem(db *DB, args [][]byte) resp.Reply {
 	for _, member := range members {
 		counter += set.Remove(string(member))
 	}
-	if set.Len() == 0 {
-		db.Remove(key)
-	}
 	if counter > 0 {
 		db.addAof(utils.ToCmdLine3("srem", args...))
+		db.notify(notifySet, "srem", key)
+	}
+	if set.Len() == 0 {
+		db.Remove(key)
+		db.notify(notifyGeneric, "del", key)
 	}
 	return reply.MakeIntReply(int64(counter))
 }
@@ -131,6 +136,11 @@ func execSPop(db *DB, args [][]byte) resp.Reply {
 
 	if count > 0 {
 		db.addAof(utils.ToCmdLine3("spop", args...))
+		db.notify(notifySet, "spop", key)
+	}
+	if set.Len() == 0 {
+		db.Remove(key)
+		db.notify(notifyGeneric, "del", key)
 	}
 	return reply.MakeMultiBulkReply(result)
 }
@@ -206,6 +216,17 @@ func execSInter(db *DB, args [][]byte) resp.Reply {
 	return reply.MakeMultiBulkReply(arr)
 }
 
+// prepareSetCalculateStore 对目标 key 加写锁，对参与计算的 key 加读锁
+func prepareSetCalculateStore(args [][]byte) ([]string, []string) {
+	dest := string(args[0])
+	keys := make([]string, len(args)-1)
+	keyArgs := args[1:]
+	for i, arg := range keyArgs {
+		keys[i] = string(arg)
+	}
+	return []string{dest}, keys
+}
+
 func execSInterStore(db *DB, args [][]byte) resp.Reply {
 	dest := string(args[0])
 	keys := make([]string, len(args)-1)
@@ -221,7 +242,7 @@ func execSInterStore(db *DB, args [][]byte) resp.Reply {
 			return errReply
 		}
 		if set == nil {
-			db.Remove(dest)
+			db.removeAndNotify(dest)
 			return reply.MakeIntReply(0)
 		}
 
@@ -231,7 +252,7 @@ func execSInterStore(db *DB, args [][]byte) resp.Reply {
 		} else {
 			result = result.Intersect(set)
 			if result.Len() == 0 {
-				db.Remove(dest)
+				db.removeAndNotify(dest)
 				return reply.MakeIntReply(0)
 			}
 		}
@@ -242,6 +263,7 @@ func execSInterStore(db *DB, args [][]byte) resp.Reply {
 		Data: set,
 	})
 	db.addAof(utils.ToCmdLine3("sinterstore", args...))
+	db.notify(notifySet, "sinterstore", dest)
 	return reply.MakeIntReply(int64(set.Len()))
 }
 
@@ -305,10 +327,11 @@ func execSUnionStore(db *DB, args [][]byte) resp.Reply {
 		}
 	}
 
-	db.Remove(dest)
 	if result == nil {
+		db.removeAndNotify(dest)
 		return &reply.EmptyMultiBulkReply{}
 	}
+	db.Remove(dest)
 
 	set := HashSet.Make(result.ToSlice()...)
 	db.PutEntity(dest, &database.DataEntity{
@@ -316,6 +339,7 @@ func execSUnionStore(db *DB, args [][]byte) resp.Reply {
 	})
 
 	db.addAof(utils.ToCmdLine3("sunionstore", args...))
+	db.notify(notifySet, "sunionstore", dest)
 	return reply.MakeIntReply(int64(set.Len()))
 }
 
@@ -376,7 +400,7 @@ func execSDiffStore(db *DB, args [][]byte) resp.Reply {
 		}
 		if set == nil {
 			if i == 0 {
-				db.Remove(dest)
+				db.removeAndNotify(dest)
 				return reply.MakeIntReply(0)
 			}
 			continue
@@ -386,14 +410,14 @@ func execSDiffStore(db *DB, args [][]byte) resp.Reply {
 		} else {
 			result = result.Diff(set)
 			if result.Len() == 0 {
-				db.Remove(dest)
+				db.removeAndNotify(dest)
 				return reply.MakeIntReply(0)
 			}
 		}
 	}
 
 	if result == nil {
-		db.Remove(dest)
+		db.removeAndNotify(dest)
 		return &reply.EmptyMultiBulkReply{}
 	}
 	set := HashSet.Make(result.ToSlice()...)
@@ -402,9 +426,34 @@ func execSDiffStore(db *DB, args [][]byte) resp.Reply {
 	})
 
 	db.addAof(utils.ToCmdLine3("sdiffstore", args...))
+	db.notify(notifySet, "sdiffstore", dest)
 	return reply.MakeIntReply(int64(set.Len()))
 }
 
+// execSScan SSCAN key cursor [MATCH pattern] [COUNT count]
+func execSScan(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	cursor, opts, errReply := parseScanArgs(args[1:], false)
+	if errReply != nil {
+		return errReply
+	}
+	set, errReply := db.getAsSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if set == nil {
+		return makeScanReply(0, nil)
+	}
+	members, nextCursor := set.Scan(cursor, opts.count)
+	result := make([]string, 0, len(members))
+	for _, member := range members {
+		if opts.pattern == nil || opts.pattern.IsMatch(member) {
+			result = append(result, member)
+		}
+	}
+	return makeScanReply(nextCursor, result)
+}
+
 func execSRandMember(db *DB, args [][]byte) resp.Reply {
 	if len(args) != 1 && len(args) != 2 {
 		return reply.MakeErrReply("ERR wrong number of arguments for 'srandmember' command")
@@ -446,17 +495,18 @@ func execSRandMember(db *DB, args [][]byte) resp.Reply {
 }
 
 func init() {
-	RegisterCommand("SAdd", execSAdd, -3)
-	RegisterCommand("SIsMember", execSIsMember, 3)
-	RegisterCommand("SRem", execSRem, -3)
-	RegisterCommand("SPop", execSPop, -2)
-	RegisterCommand("SCard", execSCard, 2)
-	RegisterCommand("SMembers", execSMembers, 2)
-	RegisterCommand("SInter", execSInter, -2)
-	RegisterCommand("SInterStore", execSInterStore, -3)
-	RegisterCommand("SUnion", execSUnion, -2)
-	RegisterCommand("SUnionStore", execSUnionStore, -3)
-	RegisterCommand("SDiff", execSDiff, -2)
-	RegisterCommand("SDiffStore", execSDiffStore, -3)
-	RegisterCommand("SRandMember", execSRandMember, -2)
+	RegisterCommand("SAdd", execSAdd, writeFirstKey, rollbackFirstKey, -3)
+	RegisterCommand("SIsMember", execSIsMember, readFirstKey, nil, 3)
+	RegisterCommand("SRem", execSRem, writeFirstKey, rollbackFirstKey, -3)
+	RegisterCommand("SPop", execSPop, writeFirstKey, rollbackFirstKey, -2)
+	RegisterCommand("SCard", execSCard, readFirstKey, nil, 2)
+	RegisterCommand("SMembers", execSMembers, readFirstKey, nil, 2)
+	RegisterCommand("SInter", execSInter, readAllKeys, nil, -2)
+	RegisterCommand("SInterStore", execSInterStore, prepareSetCalculateStore, rollbackFirstKey, -3)
+	RegisterCommand("SUnion", execSUnion, readAllKeys, nil, -2)
+	RegisterCommand("SUnionStore", execSUnionStore, prepareSetCalculateStore, rollbackFirstKey, -3)
+	RegisterCommand("SDiff", execSDiff, readAllKeys, nil, -2)
+	RegisterCommand("SDiffStore", execSDiffStore, prepareSetCalculateStore, rollbackFirstKey, -3)
+	RegisterCommand("SScan", execSScan, readFirstKey, nil, -3)
+	RegisterCommand("SRandMember", execSRandMember, readFirstKey, nil, -2)
 }
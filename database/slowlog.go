@@ -0,0 +1,310 @@
+package database
+
+import (
+	"fmt"
+	"github.com/jujunwang/Mudis/config"
+	"github.com/jujunwang/Mudis/interface/resp"
+	"github.com/jujunwang/Mudis/lib/sync/atomic"
+	"github.com/jujunwang/Mudis/resp/reply"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// slowLogMaxArgs 是慢查询日志中每条命令最多记录的参数个数
+	slowLogMaxArgs = 32
+	// slowLogMaxArgLen 是慢查询日志中每个参数最多记录的字节数
+	slowLogMaxArgLen = 128
+	// slowLogDefaultCount 是 SLOWLOG GET 默认返回的条数
+	slowLogDefaultCount = 10
+)
+
+// slowLogEntry 是一条慢查询日志
+type slowLogEntry struct {
+	id       int64
+	time     time.Time
+	duration time.Duration
+	// args 是截断后的命令和参数
+	args [][]byte
+	addr string
+	name string
+}
+
+// commandStat 是一个命令的执行统计
+type commandStat struct {
+	calls atomic.Int64
+	usec  atomic.Int64
+	// rejected 是参数个数错误等执行前就被拒绝的次数，failed 是执行后返回错误的次数
+	rejected atomic.Int64
+	failed   atomic.Int64
+}
+
+// commandStats 记录每个命令的执行统计和慢查询日志
+type commandStats struct {
+	// commands 保存命令名 -> *commandStat，每个命令第一次执行时创建，之后只需要原子地更新计数
+	commands sync.Map
+	// slowLogMu 保护慢查询日志，只有记录慢查询和执行 SLOWLOG 时需要获取
+	slowLogMu  sync.Mutex
+	slowLog    slowLogRing
+	nextSlowID int64
+}
+
+func makeCommandStats() *commandStats {
+	return &commandStats{}
+}
+
+// slowLogRing 是保存慢查询日志的环形缓冲区，容量为 slowlog-max-len，写满后覆盖最旧的日志
+type slowLogRing struct {
+	entries []*slowLogEntry
+	// next 是下一条日志写入的位置
+	next int
+	size int
+}
+
+// resize 修改容量，保留最新的日志
+func (ring *slowLogRing) resize(capacity int) {
+	if capacity < 0 {
+		capacity = 0
+	}
+	if capacity == len(ring.entries) {
+		return
+	}
+	kept := ring.newest(capacity)
+	*ring = slowLogRing{entries: make([]*slowLogEntry, capacity)}
+	for i := len(kept) - 1; i >= 0; i-- {
+		ring.push(kept[i])
+	}
+}
+
+func (ring *slowLogRing) push(entry *slowLogEntry) {
+	if len(ring.entries) == 0 {
+		return
+	}
+	ring.entries[ring.next] = entry
+	ring.next = (ring.next + 1) % len(ring.entries)
+	if ring.size < len(ring.entries) {
+		ring.size++
+	}
+}
+
+// newest 按照从新到旧的顺序返回最多 count 条日志，count 为负数时返回所有日志
+func (ring *slowLogRing) newest(count int) []*slowLogEntry {
+	if count < 0 || count > ring.size {
+		count = ring.size
+	}
+	entries := make([]*slowLogEntry, 0, count)
+	for i := 0; i < count; i++ {
+		index := (ring.next - 1 - i + len(ring.entries)) % len(ring.entries)
+		entries = append(entries, ring.entries[index])
+	}
+	return entries
+}
+
+func (ring *slowLogRing) reset() {
+	*ring = slowLogRing{entries: make([]*slowLogEntry, len(ring.entries))}
+}
+
+// namedConn 是能够提供地址和名称的连接，慢查询日志中记录这些信息
+type namedConn interface {
+	RemoteAddr() net.Addr
+	Name() string
+}
+
+// isKnownCommand 判断是否是单机数据库支持的命令，未知的命令不统计
+func isKnownCommand(cmdName string) bool {
+	if _, ok := cmdTable[cmdName]; ok {
+		return true
+	}
+	if _, ok := pubsubCommands[cmdName]; ok {
+		return true
+	}
+	switch cmdName {
+	case "select", "slowlog", "save", "bgsave", "lastsave", "bgrewriteaof", "multi", "exec", "discard", "watch", "unwatch":
+		return true
+	}
+	return false
+}
+
+// DisableCommandStats 停止在 Exec 中统计命令，集群模式下由 ClusterDatabase 调用 RecordCommand 统计
+func (mdb *StandaloneDatabase) DisableCommandStats() {
+	mdb.recordCommands = false
+}
+
+// RecordCommand 记录命令的执行统计，执行时间超过 slowlog-log-slower-than 时写入慢查询日志
+func (mdb *StandaloneDatabase) RecordCommand(c resp.Connection, cmdLine [][]byte, result resp.Reply, duration time.Duration) {
+	cmdName := strings.ToLower(string(cmdLine[0]))
+	if !isKnownCommand(cmdName) {
+		return
+	}
+	// 事务中的命令在 EXEC 时执行，入队时不统计
+	if _, ok := result.(*reply.QueuedReply); ok {
+		return
+	}
+	stats := mdb.cmdStats
+	stat := stats.get(cmdName)
+	if _, ok := result.(*reply.ArgNumErrReply); ok || result == oomErrReply {
+		stat.rejected.Add(1)
+		return
+	}
+	stat.calls.Add(1)
+	stat.usec.Add(duration.Microseconds())
+	if _, ok := result.(reply.ErrorReply); ok {
+		stat.failed.Add(1)
+	}
+
+	slowerThan := config.Properties().SlowLogSlowerThan
+	// 阻塞命令的执行时间包括等待的时间，不写入慢查询日志
+	if _, blocking := blockingCmdTable[cmdName]; blocking || slowerThan < 0 ||
+		duration.Microseconds() < int64(slowerThan) {
+		return
+	}
+	entry := &slowLogEntry{
+		time:     time.Now(),
+		duration: duration,
+		args:     truncateSlowLogArgs(cmdLine),
+	}
+	if conn, ok := c.(namedConn); ok {
+		if addr := conn.RemoteAddr(); addr != nil {
+			entry.addr = addr.String()
+		}
+		entry.name = conn.Name()
+	}
+	stats.slowLogMu.Lock()
+	defer stats.slowLogMu.Unlock()
+	entry.id = stats.nextSlowID
+	stats.nextSlowID++
+	stats.resizeSlowLog()
+	stats.slowLog.push(entry)
+}
+
+// get 返回命令的执行统计，第一次执行时创建
+func (stats *commandStats) get(cmdName string) *commandStat {
+	if stat, ok := stats.commands.Load(cmdName); ok {
+		return stat.(*commandStat)
+	}
+	stat, _ := stats.commands.LoadOrStore(cmdName, &commandStat{})
+	return stat.(*commandStat)
+}
+
+// resizeSlowLog 按照 slowlog-max-len 调整慢查询日志的容量，调用方需要持有 slowLogMu
+func (stats *commandStats) resizeSlowLog() {
+	stats.slowLog.resize(config.Properties().SlowLogMaxLen)
+}
+
+// truncateSlowLogArgs 截断过多的参数和过长的参数，避免慢查询日志占用过多内存
+func truncateSlowLogArgs(cmdLine [][]byte) [][]byte {
+	n := len(cmdLine)
+	if n > slowLogMaxArgs {
+		n = slowLogMaxArgs
+	}
+	args := make([][]byte, 0, n)
+	for i := 0; i < n; i++ {
+		if i == slowLogMaxArgs-1 && len(cmdLine) > slowLogMaxArgs {
+			more := len(cmdLine) - slowLogMaxArgs + 1
+			args = append(args, []byte(fmt.Sprintf("... (%d more arguments)", more)))
+			break
+		}
+		arg := cmdLine[i]
+		if len(arg) > slowLogMaxArgLen {
+			more := len(arg) - slowLogMaxArgLen
+			arg = []byte(fmt.Sprintf("%s... (%d more bytes)", arg[:slowLogMaxArgLen], more))
+		} else {
+			arg = append([]byte(nil), arg...)
+		}
+		args = append(args, arg)
+	}
+	return args
+}
+
+// execSlowLog SLOWLOG GET [count] | LEN | RESET
+func (mdb *StandaloneDatabase) execSlowLog(args [][]byte) resp.Reply {
+	if len(args) == 0 {
+		return reply.MakeArgNumErrReply("slowlog")
+	}
+	stats := mdb.cmdStats
+	subCmd := strings.ToLower(string(args[0]))
+	args = args[1:]
+	switch subCmd {
+	case "get":
+		if len(args) > 1 {
+			return reply.MakeArgNumErrReply("slowlog|get")
+		}
+		count := slowLogDefaultCount
+		if len(args) == 1 {
+			n, err := strconv.Atoi(string(args[0]))
+			if err != nil || n < -1 {
+				return reply.MakeErrReply("ERR count should be greater than or equal to -1")
+			}
+			count = n
+		}
+		stats.slowLogMu.Lock()
+		stats.resizeSlowLog()
+		entries := stats.slowLog.newest(count)
+		stats.slowLogMu.Unlock()
+		result := make([]resp.Reply, 0, len(entries))
+		for _, entry := range entries {
+			result = append(result, reply.MakeMultiRawReply([]resp.Reply{
+				reply.MakeIntReply(entry.id),
+				reply.MakeIntReply(entry.time.Unix()),
+				reply.MakeIntReply(entry.duration.Microseconds()),
+				reply.MakeMultiBulkReply(entry.args),
+				reply.MakeBulkReply([]byte(entry.addr)),
+				reply.MakeBulkReply([]byte(entry.name)),
+			}))
+		}
+		return reply.MakeMultiRawReply(result)
+	case "len":
+		if len(args) != 0 {
+			return reply.MakeArgNumErrReply("slowlog|len")
+		}
+		stats.slowLogMu.Lock()
+		defer stats.slowLogMu.Unlock()
+		stats.resizeSlowLog()
+		return reply.MakeIntReply(int64(stats.slowLog.size))
+	case "reset":
+		if len(args) != 0 {
+			return reply.MakeArgNumErrReply("slowlog|reset")
+		}
+		stats.slowLogMu.Lock()
+		defer stats.slowLogMu.Unlock()
+		stats.slowLog.reset()
+		return reply.MakeOkReply()
+	}
+	return reply.MakeErrReply("ERR unknown subcommand '" + subCmd + "'. Try SLOWLOG HELP.")
+}
+
+// info 返回 INFO commandstats 的字段，按照命令名排序
+func (stats *commandStats) info() []string {
+	commands := make(map[string]*commandStat)
+	names := make([]string, 0)
+	stats.commands.Range(func(name, stat interface{}) bool {
+		commands[name.(string)] = stat.(*commandStat)
+		names = append(names, name.(string))
+		return true
+	})
+	sort.Strings(names)
+	lines := make([]string, 0, len(names))
+	for _, name := range names {
+		stat := commands[name]
+		calls, usec := stat.calls.Get(), stat.usec.Get()
+		usecPerCall := 0.0
+		if calls > 0 {
+			usecPerCall = float64(usec) / float64(calls)
+		}
+		lines = append(lines, fmt.Sprintf("cmdstat_%s:calls=%d,usec=%d,usec_per_call=%.2f,rejected_calls=%d,failed_calls=%d",
+			name, calls, usec, usecPerCall, stat.rejected.Get(), stat.failed.Get()))
+	}
+	return lines
+}
+
+// reset 清空命令的执行统计，慢查询日志由 SLOWLOG RESET 清空
+func (stats *commandStats) reset() {
+	stats.commands.Range(func(name, _ interface{}) bool {
+		stats.commands.Delete(name)
+		return true
+	})
+}
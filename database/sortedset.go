@@ -0,0 +1,994 @@
+package database
+
+import (
+	HashSet "github.com/jujunwang/Mudis/datastruct/set"
+	SortedSet "github.com/jujunwang/Mudis/datastruct/sortedset"
+	"github.com/jujunwang/Mudis/interface/database"
+	"github.com/jujunwang/Mudis/interface/resp"
+	"github.com/jujunwang/Mudis/lib/utils"
+	"github.com/jujunwang/Mudis/resp/reply"
+	"math"
+	"strconv"
+	"strings"
+)
+
+func (db *DB) getAsSortedSet(key string) (*SortedSet.SortedSet, reply.ErrorReply) {
+	entity, exists := db.GetEntity(key)
+	if !exists {
+		return nil, nil
+	}
+	sortedSet, ok := entity.Data.(*SortedSet.SortedSet)
+	if !ok {
+		return nil, &reply.WrongTypeErrReply{}
+	}
+	return sortedSet, nil
+}
+
+func (db *DB) getOrInitSortedSet(key string) (sortedSet *SortedSet.SortedSet, inited bool, errReply reply.ErrorReply) {
+	sortedSet, errReply = db.getAsSortedSet(key)
+	if errReply != nil {
+		return nil, false, errReply
+	}
+	inited = false
+	if sortedSet == nil {
+		sortedSet = SortedSet.Make()
+		db.PutEntity(key, &database.DataEntity{
+			Data: sortedSet,
+		})
+		inited = true
+	}
+	return sortedSet, inited, nil
+}
+
+// formatScore 按照 redis 的格式输出分值
+func formatScore(score float64) []byte {
+	if math.IsInf(score, 1) {
+		return []byte("inf")
+	} else if math.IsInf(score, -1) {
+		return []byte("-inf")
+	}
+	return []byte(strconv.FormatFloat(score, 'f', -1, 64))
+}
+
+func parseScore(arg []byte) (float64, reply.ErrorReply) {
+	score, err := strconv.ParseFloat(string(arg), 64)
+	if err != nil || math.IsNaN(score) {
+		return 0, reply.MakeErrReply("ERR value is not a valid float")
+	}
+	return score, nil
+}
+
+var nanScoreErr = reply.MakeErrReply("ERR resulting score is not a number (NaN)")
+
+// execZAdd ZADD key [NX|XX] [GT|LT] [CH] [INCR] score member [score member ...]
+func execZAdd(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	var nx, xx, gt, lt, ch, incr bool
+	i := 1
+	for ; i < len(args); i++ {
+		opt := strings.ToUpper(string(args[i]))
+		if opt == "NX" {
+			nx = true
+		} else if opt == "XX" {
+			xx = true
+		} else if opt == "GT" {
+			gt = true
+		} else if opt == "LT" {
+			lt = true
+		} else if opt == "CH" {
+			ch = true
+		} else if opt == "INCR" {
+			incr = true
+		} else {
+			break
+		}
+	}
+	pairs := args[i:]
+	if len(pairs) == 0 || len(pairs)%2 != 0 {
+		return reply.MakeSyntaxErrReply()
+	}
+	if nx && xx {
+		return reply.MakeErrReply("ERR XX and NX options at the same time are not compatible")
+	}
+	if (gt && lt) || (nx && (gt || lt)) {
+		return reply.MakeErrReply("ERR GT, LT, and/or NX options at the same time are not compatible")
+	}
+	if incr && len(pairs) != 2 {
+		return reply.MakeErrReply("ERR INCR option supports a single increment-element pair")
+	}
+
+	size := len(pairs) / 2
+	elements := make([]*SortedSet.Element, size)
+	for j := 0; j < size; j++ {
+		score, errReply := parseScore(pairs[2*j])
+		if errReply != nil {
+			return errReply
+		}
+		elements[j] = &SortedSet.Element{
+			Member: string(pairs[2*j+1]),
+			Score:  score,
+		}
+	}
+
+	sortedSet, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if sortedSet == nil {
+		if xx {
+			if incr {
+				return &reply.NullBulkReply{}
+			}
+			return reply.MakeIntReply(0)
+		}
+		sortedSet, _, _ = db.getOrInitSortedSet(key)
+	}
+
+	added, changed := 0, 0
+	incrApplied := false
+	var incrResult float64
+	aofArgs := make([][]byte, 0, len(pairs)+1)
+	aofArgs = append(aofArgs, args[0])
+	for _, e := range elements {
+		old, exists := sortedSet.Get(e.Member)
+		if (exists && nx) || (!exists && xx) {
+			continue
+		}
+		score := e.Score
+		if incr && exists {
+			score += old.Score
+			if math.IsNaN(score) {
+				if sortedSet.Len() == 0 {
+					db.Remove(key)
+				}
+				return nanScoreErr
+			}
+		}
+		if exists && ((gt && score <= old.Score) || (lt && score >= old.Score)) {
+			continue
+		}
+		if sortedSet.Add(e.Member, score) {
+			added++
+			changed++
+		} else if score != old.Score {
+			changed++
+		}
+		incrApplied = true
+		incrResult = score
+		aofArgs = append(aofArgs, formatScore(score), []byte(e.Member))
+	}
+	if sortedSet.Len() == 0 {
+		db.Remove(key)
+	}
+	if len(aofArgs) > 1 {
+		db.addAof(utils.ToCmdLine3("zadd", aofArgs...))
+		if incr {
+			db.notify(notifyZSet, "zincr", key)
+		} else {
+			db.notify(notifyZSet, "zadd", key)
+		}
+	}
+
+	if incr {
+		if !incrApplied {
+			return &reply.NullBulkReply{}
+		}
+		return reply.MakeBulkReply(formatScore(incrResult))
+	}
+	if ch {
+		return reply.MakeIntReply(int64(changed))
+	}
+	return reply.MakeIntReply(int64(added))
+}
+
+// execZIncrBy 为有序集合中成员的分值加上增量
+func execZIncrBy(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	delta, errReply := parseScore(args[1])
+	if errReply != nil {
+		return errReply
+	}
+	member := string(args[2])
+
+	sortedSet, _, errReply := db.getOrInitSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+
+	score := delta
+	if element, exists := sortedSet.Get(member); exists {
+		score += element.Score
+	}
+	if math.IsNaN(score) {
+		if sortedSet.Len() == 0 {
+			db.Remove(key)
+		}
+		return nanScoreErr
+	}
+	sortedSet.Add(member, score)
+	// 浮点运算的结果可能受精度影响，AOF 中直接记录计算结果
+	bytes := formatScore(score)
+	db.addAof(utils.ToCmdLine3("zadd", args[0], bytes, args[2]))
+	db.notify(notifyZSet, "zincr", key)
+	return reply.MakeBulkReply(bytes)
+}
+
+// execZRem 删除有序集合中的一个或多个成员
+func execZRem(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	members := args[1:]
+
+	sortedSet, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if sortedSet == nil {
+		return reply.MakeIntReply(0)
+	}
+
+	var deleted int64 = 0
+	for _, member := range members {
+		if sortedSet.Remove(string(member)) {
+			deleted++
+		}
+	}
+	if deleted > 0 {
+		db.addAof(utils.ToCmdLine3("zrem", args...))
+		db.notify(notifyZSet, "zrem", key)
+	}
+	if sortedSet.Len() == 0 {
+		db.Remove(key)
+		db.notify(notifyGeneric, "del", key)
+	}
+	return reply.MakeIntReply(deleted)
+}
+
+// execZScore 返回有序集合中成员的分值
+func execZScore(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	member := string(args[1])
+
+	sortedSet, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if sortedSet == nil {
+		return &reply.NullBulkReply{}
+	}
+
+	element, exists := sortedSet.Get(member)
+	if !exists {
+		return &reply.NullBulkReply{}
+	}
+	return reply.MakeBulkReply(formatScore(element.Score))
+}
+
+// execZMScore 返回有序集合中多个成员的分值
+func execZMScore(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	members := args[1:]
+
+	sortedSet, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	result := make([][]byte, len(members))
+	if sortedSet == nil {
+		return reply.MakeMultiBulkReply(result)
+	}
+
+	for i, member := range members {
+		element, exists := sortedSet.Get(string(member))
+		if exists {
+			result[i] = formatScore(element.Score)
+		}
+	}
+	return reply.MakeMultiBulkReply(result)
+}
+
+// execZCard 返回有序集合的成员数量
+func execZCard(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+
+	sortedSet, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if sortedSet == nil {
+		return reply.MakeIntReply(0)
+	}
+	return reply.MakeIntReply(sortedSet.Len())
+}
+
+// execZCount 返回分值在 [min, max] 范围内的成员数量
+func execZCount(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	min, err := SortedSet.ParseScoreBorder(string(args[1]))
+	if err != nil {
+		return reply.MakeErrReply(err.Error())
+	}
+	max, err := SortedSet.ParseScoreBorder(string(args[2]))
+	if err != nil {
+		return reply.MakeErrReply(err.Error())
+	}
+
+	sortedSet, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if sortedSet == nil {
+		return reply.MakeIntReply(0)
+	}
+	return reply.MakeIntReply(sortedSet.RangeCount(min, max))
+}
+
+func rankGeneric(db *DB, args [][]byte, desc bool) resp.Reply {
+	key := string(args[0])
+	member := string(args[1])
+	withScore := false
+	if len(args) > 3 {
+		return reply.MakeSyntaxErrReply()
+	}
+	if len(args) == 3 {
+		if strings.ToUpper(string(args[2])) != "WITHSCORE" {
+			return reply.MakeSyntaxErrReply()
+		}
+		withScore = true
+	}
+
+	sortedSet, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if sortedSet == nil {
+		return &reply.NullBulkReply{}
+	}
+
+	rank := sortedSet.GetRank(member, desc)
+	if rank < 0 {
+		return &reply.NullBulkReply{}
+	}
+	if !withScore {
+		return reply.MakeIntReply(rank)
+	}
+	element, _ := sortedSet.Get(member)
+	return reply.MakeMultiBulkReply([][]byte{
+		[]byte(strconv.FormatInt(rank, 10)),
+		formatScore(element.Score),
+	})
+}
+
+// execZRank 返回成员按分值从小到大的排名
+func execZRank(db *DB, args [][]byte) resp.Reply {
+	return rankGeneric(db, args, false)
+}
+
+// execZRevRank 返回成员按分值从大到小的排名
+func execZRevRank(db *DB, args [][]byte) resp.Reply {
+	return rankGeneric(db, args, true)
+}
+
+// normalizeRank 将 redis 风格的闭区间 [start, stop] 转换为左闭右开区间，区间为空时 ok 为 false
+func normalizeRank(start int64, stop int64, size int64) (int64, int64, bool) {
+	if start < 0 {
+		start = size + start
+	}
+	if stop < 0 {
+		stop = size + stop
+	}
+	if start < 0 {
+		start = 0
+	}
+	if start > stop || start >= size {
+		return 0, 0, false
+	}
+	if stop >= size {
+		stop = size - 1
+	}
+	return start, stop + 1, true
+}
+
+func elementsToReply(elements []*SortedSet.Element, withScores bool) resp.Reply {
+	if len(elements) == 0 {
+		return &reply.EmptyMultiBulkReply{}
+	}
+	size := len(elements)
+	if withScores {
+		size *= 2
+	}
+	result := make([][]byte, 0, size)
+	for _, element := range elements {
+		result = append(result, []byte(element.Member))
+		if withScores {
+			result = append(result, formatScore(element.Score))
+		}
+	}
+	return reply.MakeMultiBulkReply(result)
+}
+
+func rangeByRank(db *DB, key string, start int64, stop int64, withScores bool, desc bool) resp.Reply {
+	sortedSet, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if sortedSet == nil {
+		return &reply.EmptyMultiBulkReply{}
+	}
+
+	start, stop, ok := normalizeRank(start, stop, sortedSet.Len())
+	if !ok {
+		return &reply.EmptyMultiBulkReply{}
+	}
+	elements := sortedSet.RangeByRank(start, stop, desc)
+	return elementsToReply(elements, withScores)
+}
+
+func rangeByBorder(db *DB, key string, min SortedSet.Border, max SortedSet.Border, offset int64, limit int64, withScores bool, desc bool) resp.Reply {
+	sortedSet, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if sortedSet == nil {
+		return &reply.EmptyMultiBulkReply{}
+	}
+
+	elements := sortedSet.Range(min, max, offset, limit, desc)
+	return elementsToReply(elements, withScores)
+}
+
+// parseBorders 解析分值或字典序边界
+func parseBorders(rawMin []byte, rawMax []byte, byLex bool) (SortedSet.Border, SortedSet.Border, reply.ErrorReply) {
+	if byLex {
+		min, err := SortedSet.ParseLexBorder(string(rawMin))
+		if err != nil {
+			return nil, nil, reply.MakeErrReply(err.Error())
+		}
+		max, err := SortedSet.ParseLexBorder(string(rawMax))
+		if err != nil {
+			return nil, nil, reply.MakeErrReply(err.Error())
+		}
+		return min, max, nil
+	}
+	min, err := SortedSet.ParseScoreBorder(string(rawMin))
+	if err != nil {
+		return nil, nil, reply.MakeErrReply(err.Error())
+	}
+	max, err := SortedSet.ParseScoreBorder(string(rawMax))
+	if err != nil {
+		return nil, nil, reply.MakeErrReply(err.Error())
+	}
+	return min, max, nil
+}
+
+// parseLimit 解析 LIMIT offset count
+func parseLimit(rawOffset []byte, rawCount []byte) (offset int64, limit int64, errReply reply.ErrorReply) {
+	offset, err := strconv.ParseInt(string(rawOffset), 10, 64)
+	if err != nil {
+		return 0, 0, reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	limit, err = strconv.ParseInt(string(rawCount), 10, 64)
+	if err != nil {
+		return 0, 0, reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	return offset, limit, nil
+}
+
+// execZRange ZRANGE key start stop [BYSCORE|BYLEX] [REV] [LIMIT offset count] [WITHSCORES]
+func execZRange(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	var byScore, byLex, rev, withScores, hasLimit bool
+	var offset, limit int64 = 0, -1
+	for i := 3; i < len(args); i++ {
+		switch strings.ToUpper(string(args[i])) {
+		case "BYSCORE":
+			byScore = true
+		case "BYLEX":
+			byLex = true
+		case "REV":
+			rev = true
+		case "WITHSCORES":
+			withScores = true
+		case "LIMIT":
+			if i+2 >= len(args) {
+				return reply.MakeSyntaxErrReply()
+			}
+			var errReply reply.ErrorReply
+			offset, limit, errReply = parseLimit(args[i+1], args[i+2])
+			if errReply != nil {
+				return errReply
+			}
+			hasLimit = true
+			i += 2
+		default:
+			return reply.MakeSyntaxErrReply()
+		}
+	}
+	if byScore && byLex {
+		return reply.MakeSyntaxErrReply()
+	}
+	if hasLimit && !byScore && !byLex {
+		return reply.MakeErrReply("ERR syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX")
+	}
+	if withScores && byLex {
+		return reply.MakeErrReply("ERR syntax error, WITHSCORES not supported in combination with BYLEX")
+	}
+
+	if byScore || byLex {
+		rawMin, rawMax := args[1], args[2]
+		if rev {
+			rawMin, rawMax = rawMax, rawMin
+		}
+		min, max, errReply := parseBorders(rawMin, rawMax, byLex)
+		if errReply != nil {
+			return errReply
+		}
+		return rangeByBorder(db, key, min, max, offset, limit, withScores, rev)
+	}
+
+	start, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	stop, err := strconv.ParseInt(string(args[2]), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	return rangeByRank(db, key, start, stop, withScores, rev)
+}
+
+// execZRevRange ZREVRANGE key start stop [WITHSCORES]
+func execZRevRange(db *DB, args [][]byte) resp.Reply {
+	if len(args) > 4 {
+		return reply.MakeSyntaxErrReply()
+	}
+	withScores := false
+	if len(args) == 4 {
+		if strings.ToUpper(string(args[3])) != "WITHSCORES" {
+			return reply.MakeSyntaxErrReply()
+		}
+		withScores = true
+	}
+	start, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	stop, err := strconv.ParseInt(string(args[2]), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	return rangeByRank(db, string(args[0]), start, stop, withScores, true)
+}
+
+// rangeByScoreGeneric 解析 ZRANGEBYSCORE 和 ZREVRANGEBYSCORE 的参数
+// desc 为 true 时参数的顺序为 max min
+func rangeByScoreGeneric(db *DB, args [][]byte, desc bool) resp.Reply {
+	key := string(args[0])
+	withScores := false
+	var offset, limit int64 = 0, -1
+	for i := 3; i < len(args); i++ {
+		switch strings.ToUpper(string(args[i])) {
+		case "WITHSCORES":
+			withScores = true
+		case "LIMIT":
+			if i+2 >= len(args) {
+				return reply.MakeSyntaxErrReply()
+			}
+			var errReply reply.ErrorReply
+			offset, limit, errReply = parseLimit(args[i+1], args[i+2])
+			if errReply != nil {
+				return errReply
+			}
+			i += 2
+		default:
+			return reply.MakeSyntaxErrReply()
+		}
+	}
+	rawMin, rawMax := args[1], args[2]
+	if desc {
+		rawMin, rawMax = rawMax, rawMin
+	}
+	min, max, errReply := parseBorders(rawMin, rawMax, false)
+	if errReply != nil {
+		return errReply
+	}
+	return rangeByBorder(db, key, min, max, offset, limit, withScores, desc)
+}
+
+// execZRangeByScore ZRANGEBYSCORE key min max [WITHSCORES] [LIMIT offset count]
+func execZRangeByScore(db *DB, args [][]byte) resp.Reply {
+	return rangeByScoreGeneric(db, args, false)
+}
+
+// execZRevRangeByScore ZREVRANGEBYSCORE key max min [WITHSCORES] [LIMIT offset count]
+func execZRevRangeByScore(db *DB, args [][]byte) resp.Reply {
+	return rangeByScoreGeneric(db, args, true)
+}
+
+// execZRemRangeByRank 删除排名在 [start, stop] 范围内的成员
+func execZRemRangeByRank(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	start, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	stop, err := strconv.ParseInt(string(args[2]), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+
+	sortedSet, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if sortedSet == nil {
+		return reply.MakeIntReply(0)
+	}
+
+	start, stop, ok := normalizeRank(start, stop, sortedSet.Len())
+	if !ok {
+		return reply.MakeIntReply(0)
+	}
+	removed := sortedSet.RemoveByRank(start, stop)
+	if removed > 0 {
+		db.addAof(utils.ToCmdLine3("zremrangebyrank", args...))
+		db.notify(notifyZSet, "zremrangebyrank", key)
+	}
+	if sortedSet.Len() == 0 {
+		db.Remove(key)
+		db.notify(notifyGeneric, "del", key)
+	}
+	return reply.MakeIntReply(removed)
+}
+
+// execZRemRangeByScore 删除分值在 [min, max] 范围内的成员
+func execZRemRangeByScore(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	min, max, errReply := parseBorders(args[1], args[2], false)
+	if errReply != nil {
+		return errReply
+	}
+
+	sortedSet, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if sortedSet == nil {
+		return reply.MakeIntReply(0)
+	}
+
+	removed := sortedSet.RemoveRange(min, max)
+	if removed > 0 {
+		db.addAof(utils.ToCmdLine3("zremrangebyscore", args...))
+		db.notify(notifyZSet, "zremrangebyscore", key)
+	}
+	if sortedSet.Len() == 0 {
+		db.Remove(key)
+		db.notify(notifyGeneric, "del", key)
+	}
+	return reply.MakeIntReply(removed)
+}
+
+func popGeneric(db *DB, args [][]byte, max bool) resp.Reply {
+	key := string(args[0])
+	count := 1
+	if len(args) > 2 {
+		return reply.MakeSyntaxErrReply()
+	}
+	if len(args) == 2 {
+		count64, err := strconv.ParseInt(string(args[1]), 10, 64)
+		if err != nil || count64 < 0 {
+			return reply.MakeErrReply("ERR value is out of range, must be positive")
+		}
+		count = int(count64)
+	}
+
+	sortedSet, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if sortedSet == nil {
+		return &reply.EmptyMultiBulkReply{}
+	}
+
+	var removed []*SortedSet.Element
+	if max {
+		removed = sortedSet.PopMax(count)
+	} else {
+		removed = sortedSet.PopMin(count)
+	}
+	if len(removed) > 0 {
+		// 记录实际弹出的成员，而不是弹出命令
+		aofArgs := make([][]byte, 0, len(removed)+1)
+		aofArgs = append(aofArgs, args[0])
+		for _, element := range removed {
+			aofArgs = append(aofArgs, []byte(element.Member))
+		}
+		db.addAof(utils.ToCmdLine3("zrem", aofArgs...))
+		if max {
+			db.notify(notifyZSet, "zpopmax", key)
+		} else {
+			db.notify(notifyZSet, "zpopmin", key)
+		}
+	}
+	if sortedSet.Len() == 0 {
+		db.Remove(key)
+		db.notify(notifyGeneric, "del", key)
+	}
+	return elementsToReply(removed, true)
+}
+
+// execZPopMin 弹出分值最小的成员
+func execZPopMin(db *DB, args [][]byte) resp.Reply {
+	return popGeneric(db, args, false)
+}
+
+// execZPopMax 弹出分值最大的成员
+func execZPopMax(db *DB, args [][]byte) resp.Reply {
+	return popGeneric(db, args, true)
+}
+
+/* ---- ZUNIONSTORE / ZINTERSTORE / ZDIFFSTORE ---- */
+
+const (
+	aggregateSum = "SUM"
+	aggregateMin = "MIN"
+	aggregateMax = "MAX"
+)
+
+// getAsSortedSetOrSet 读取有序集合，普通集合的成员会被当作分值为 1 的有序集合成员
+func (db *DB) getAsSortedSetOrSet(key string) (*SortedSet.SortedSet, reply.ErrorReply) {
+	entity, exists := db.GetEntity(key)
+	if !exists {
+		return nil, nil
+	}
+	switch data := entity.Data.(type) {
+	case *SortedSet.SortedSet:
+		return data, nil
+	case *HashSet.Set:
+		sortedSet := SortedSet.Make()
+		data.ForEach(func(member string) bool {
+			sortedSet.Add(member, 1)
+			return true
+		})
+		return sortedSet, nil
+	}
+	return nil, &reply.WrongTypeErrReply{}
+}
+
+func aggregate(method string, a float64, b float64) float64 {
+	switch method {
+	case aggregateMin:
+		return math.Min(a, b)
+	case aggregateMax:
+		return math.Max(a, b)
+	}
+	sum := a + b
+	if math.IsNaN(sum) {
+		// inf + -inf
+		return 0
+	}
+	return sum
+}
+
+func weightScore(score float64, weight float64) float64 {
+	result := score * weight
+	if math.IsNaN(result) {
+		// inf * 0
+		return 0
+	}
+	return result
+}
+
+// parseStoreArgs 解析 numkeys key [key ...] [WEIGHTS weight [weight ...]] [AGGREGATE SUM|MIN|MAX]
+func parseStoreArgs(cmdName string, args [][]byte, allowOptions bool) (keys []string, weights []float64, method string, errReply reply.ErrorReply) {
+	numKeys, err := strconv.ParseInt(string(args[0]), 10, 64)
+	if err != nil {
+		return nil, nil, "", reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	if numKeys <= 0 {
+		return nil, nil, "", reply.MakeErrReply("ERR at least 1 input key is needed for '" + cmdName + "' command")
+	}
+	if int(numKeys) > len(args)-1 {
+		return nil, nil, "", reply.MakeSyntaxErrReply()
+	}
+	keys = make([]string, numKeys)
+	weights = make([]float64, numKeys)
+	for i := 0; i < int(numKeys); i++ {
+		keys[i] = string(args[i+1])
+		weights[i] = 1
+	}
+	method = aggregateSum
+
+	for i := int(numKeys) + 1; i < len(args); i++ {
+		if !allowOptions {
+			return nil, nil, "", reply.MakeSyntaxErrReply()
+		}
+		switch strings.ToUpper(string(args[i])) {
+		case "WEIGHTS":
+			if i+int(numKeys) >= len(args) {
+				return nil, nil, "", reply.MakeSyntaxErrReply()
+			}
+			for j := 0; j < int(numKeys); j++ {
+				weight, err := strconv.ParseFloat(string(args[i+1+j]), 64)
+				if err != nil || math.IsNaN(weight) {
+					return nil, nil, "", reply.MakeErrReply("ERR weight value is not a float")
+				}
+				weights[j] = weight
+			}
+			i += int(numKeys)
+		case "AGGREGATE":
+			if i+1 >= len(args) {
+				return nil, nil, "", reply.MakeSyntaxErrReply()
+			}
+			method = strings.ToUpper(string(args[i+1]))
+			if method != aggregateSum && method != aggregateMin && method != aggregateMax {
+				return nil, nil, "", reply.MakeSyntaxErrReply()
+			}
+			i++
+		default:
+			return nil, nil, "", reply.MakeSyntaxErrReply()
+		}
+	}
+	return keys, weights, method, nil
+}
+
+// prepareZStore 对目标 key 加写锁，对 numkeys 指定的 key 加读锁
+func prepareZStore(args [][]byte) ([]string, []string) {
+	dest := string(args[0])
+	numKeys, err := strconv.Atoi(string(args[1]))
+	if err != nil || numKeys <= 0 {
+		return []string{dest}, nil
+	}
+	if numKeys > len(args)-2 {
+		numKeys = len(args) - 2
+	}
+	keys := make([]string, numKeys)
+	for i := 0; i < numKeys; i++ {
+		keys[i] = string(args[i+2])
+	}
+	return []string{dest}, keys
+}
+
+// storeSortedSet 将计算结果写入 dest，结果为空时删除 dest
+func storeSortedSet(db *DB, cmdName string, dest string, result *SortedSet.SortedSet, args [][]byte) resp.Reply {
+	if result.Len() == 0 {
+		db.removeAndNotify(dest)
+	} else {
+		db.PutEntity(dest, &database.DataEntity{
+			Data: result,
+		})
+		db.Persist(dest)
+		db.notify(notifyZSet, cmdName, dest)
+	}
+	db.addAof(utils.ToCmdLine3(cmdName, args...))
+	return reply.MakeIntReply(result.Len())
+}
+
+// execZUnionStore ZUNIONSTORE destination numkeys key [key ...] [WEIGHTS weight ...] [AGGREGATE SUM|MIN|MAX]
+func execZUnionStore(db *DB, args [][]byte) resp.Reply {
+	dest := string(args[0])
+	keys, weights, method, errReply := parseStoreArgs("zunionstore", args[1:], true)
+	if errReply != nil {
+		return errReply
+	}
+
+	result := SortedSet.Make()
+	for i, key := range keys {
+		sortedSet, errReply := db.getAsSortedSetOrSet(key)
+		if errReply != nil {
+			return errReply
+		}
+		if sortedSet == nil {
+			continue
+		}
+		sortedSet.ForEach(SortedSet.NegativeInfBorder, SortedSet.PositiveInfBorder, 0, -1, false,
+			func(element *SortedSet.Element) bool {
+				score := weightScore(element.Score, weights[i])
+				if old, exists := result.Get(element.Member); exists {
+					score = aggregate(method, old.Score, score)
+				}
+				result.Add(element.Member, score)
+				return true
+			})
+	}
+	return storeSortedSet(db, "zunionstore", dest, result, args)
+}
+
+// execZInterStore ZINTERSTORE destination numkeys key [key ...] [WEIGHTS weight ...] [AGGREGATE SUM|MIN|MAX]
+func execZInterStore(db *DB, args [][]byte) resp.Reply {
+	dest := string(args[0])
+	keys, weights, method, errReply := parseStoreArgs("zinterstore", args[1:], true)
+	if errReply != nil {
+		return errReply
+	}
+
+	sortedSets := make([]*SortedSet.SortedSet, len(keys))
+	for i, key := range keys {
+		sortedSet, errReply := db.getAsSortedSetOrSet(key)
+		if errReply != nil {
+			return errReply
+		}
+		sortedSets[i] = sortedSet
+	}
+
+	result := SortedSet.Make()
+	for _, sortedSet := range sortedSets {
+		if sortedSet == nil {
+			// 任何一个集合为空，交集为空
+			return storeSortedSet(db, "zinterstore", dest, result, args)
+		}
+	}
+	sortedSets[0].ForEach(SortedSet.NegativeInfBorder, SortedSet.PositiveInfBorder, 0, -1, false,
+		func(element *SortedSet.Element) bool {
+			score := weightScore(element.Score, weights[0])
+			for i := 1; i < len(sortedSets); i++ {
+				other, exists := sortedSets[i].Get(element.Member)
+				if !exists {
+					return true
+				}
+				score = aggregate(method, score, weightScore(other.Score, weights[i]))
+			}
+			result.Add(element.Member, score)
+			return true
+		})
+	return storeSortedSet(db, "zinterstore", dest, result, args)
+}
+
+// execZDiffStore ZDIFFSTORE destination numkeys key [key ...]
+func execZDiffStore(db *DB, args [][]byte) resp.Reply {
+	dest := string(args[0])
+	keys, _, _, errReply := parseStoreArgs("zdiffstore", args[1:], false)
+	if errReply != nil {
+		return errReply
+	}
+
+	sortedSets := make([]*SortedSet.SortedSet, len(keys))
+	for i, key := range keys {
+		sortedSet, errReply := db.getAsSortedSetOrSet(key)
+		if errReply != nil {
+			return errReply
+		}
+		sortedSets[i] = sortedSet
+	}
+
+	result := SortedSet.Make()
+	if sortedSets[0] != nil {
+		sortedSets[0].ForEach(SortedSet.NegativeInfBorder, SortedSet.PositiveInfBorder, 0, -1, false,
+			func(element *SortedSet.Element) bool {
+				for i := 1; i < len(sortedSets); i++ {
+					if sortedSets[i] == nil {
+						continue
+					}
+					if _, exists := sortedSets[i].Get(element.Member); exists {
+						return true
+					}
+				}
+				result.Add(element.Member, element.Score)
+				return true
+			})
+	}
+	return storeSortedSet(db, "zdiffstore", dest, result, args)
+}
+
+func init() {
+	RegisterCommand("ZAdd", execZAdd, writeFirstKey, rollbackFirstKey, -4)
+	RegisterCommand("ZIncrBy", execZIncrBy, writeFirstKey, rollbackFirstKey, 4)
+	RegisterCommand("ZRem", execZRem, writeFirstKey, rollbackFirstKey, -3)
+	RegisterCommand("ZScore", execZScore, readFirstKey, nil, 3)
+	RegisterCommand("ZMScore", execZMScore, readFirstKey, nil, -3)
+	RegisterCommand("ZCard", execZCard, readFirstKey, nil, 2)
+	RegisterCommand("ZCount", execZCount, readFirstKey, nil, 4)
+	RegisterCommand("ZRank", execZRank, readFirstKey, nil, -3)
+	RegisterCommand("ZRevRank", execZRevRank, readFirstKey, nil, -3)
+	RegisterCommand("ZRange", execZRange, readFirstKey, nil, -4)
+	RegisterCommand("ZRevRange", execZRevRange, readFirstKey, nil, -4)
+	RegisterCommand("ZRangeByScore", execZRangeByScore, readFirstKey, nil, -4)
+	RegisterCommand("ZRevRangeByScore", execZRevRangeByScore, readFirstKey, nil, -4)
+	RegisterCommand("ZRemRangeByRank", execZRemRangeByRank, writeFirstKey, rollbackFirstKey, 4)
+	RegisterCommand("ZRemRangeByScore", execZRemRangeByScore, writeFirstKey, rollbackFirstKey, 4)
+	RegisterCommand("ZPopMin", execZPopMin, writeFirstKey, rollbackFirstKey, -2)
+	RegisterCommand("ZPopMax", execZPopMax, writeFirstKey, rollbackFirstKey, -2)
+	RegisterCommand("ZUnionStore", execZUnionStore, prepareZStore, rollbackFirstKey, -4)
+	RegisterCommand("ZInterStore", execZInterStore, prepareZStore, rollbackFirstKey, -4)
+	RegisterCommand("ZDiffStore", execZDiffStore, prepareZStore, rollbackFirstKey, -4)
+}
@@ -4,53 +4,153 @@ import (
 	"fmt"
 	"github.com/jujunwang/Mudis/aof"
 	"github.com/jujunwang/Mudis/config"
+	"github.com/jujunwang/Mudis/interface/database"
 	"github.com/jujunwang/Mudis/interface/resp"
 	"github.com/jujunwang/Mudis/lib/logger"
+	"github.com/jujunwang/Mudis/lib/sync/atomic"
+	"github.com/jujunwang/Mudis/pubsub"
 	"github.com/jujunwang/Mudis/resp/reply"
 	"runtime/debug"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
+// activeExpireInterval 主动过期的执行间隔
+const activeExpireInterval = 100 * time.Millisecond
+
 // StandaloneDatabase 是多个单机数据库
 type StandaloneDatabase struct {
 	dbSet []*DB
-	// aof 持久化处理器
+	// aof 持久化处理器，没有开启 AOF 时为 nil
+	// aofMu 保护 aofHandler，写入 AOF 时持有读锁，运行时开启或关闭 AOF 时持有写锁
+	aofMu      sync.RWMutex
 	aofHandler *aof.AofHandler
+	// 关闭时通知后台的主动过期 goroutine 退出
+	closeChan chan struct{}
+	// 服务器关闭时 Close 可能被调用多次
+	closeOnce sync.Once
+	// 发布订阅
+	hub *pubsub.Hub
+	// 加载 AOF 期间不淘汰 key
+	loading bool
+	// 数据内存占用的峰值，用于 INFO
+	peakMemory atomic.Int64
+	// 命令的执行统计和慢查询日志，recordCommands 为 false 时 Exec 不统计
+	cmdStats       *commandStats
+	recordCommands bool
+	// dirty 是上次保存快照之后的修改次数，用于自动保存
+	dirty    atomic.Int64
+	snapshot snapshotState
 }
 
 // NewStandaloneDatabase 新建一个 redis 实例,
 func NewStandaloneDatabase() *StandaloneDatabase {
-	mdb := &StandaloneDatabase{}
-	if config.Properties.Databases == 0 {
-		config.Properties.Databases = 16
+	mdb := makeStandaloneDatabase()
+	if config.Properties().AppendOnly && aof.Exists() {
+		mdb.loading = true
+		aofHandler, err := aof.NewAOFHandler(mdb, newAuxiliaryDatabase)
+		mdb.loading = false
+		if err != nil {
+			panic(err)
+		}
+		mdb.aofHandler = aofHandler
+	} else {
+		// 没有 AOF 文件时从快照加载数据
+		mdb.loading = true
+		err := mdb.loadSnapshot(config.Properties().DbFilename)
+		mdb.loading = false
+		if err != nil {
+			panic(err)
+		}
+		if config.Properties().AppendOnly {
+			// 新建 AOF 文件并写入从快照加载的数据
+			if err := mdb.SetAppendOnly(true); err != nil {
+				panic(err)
+			}
+		}
+	}
+	mdb.dirty.Set(0)
+	mdb.snapshot.lastSave = time.Now()
+	go mdb.activeExpire()
+	return mdb
+}
+
+// newAuxiliaryDatabase 新建一个不加载数据、不持久化、没有后台任务的数据库，用于重写 AOF 时重放 AOF
+func newAuxiliaryDatabase() database.DBEngine {
+	mdb := makeStandaloneDatabase()
+	// 重放时不淘汰 key，也不发送键空间通知
+	mdb.loading = true
+	mdb.recordCommands = false
+	return mdb
+}
+
+func makeStandaloneDatabase() *StandaloneDatabase {
+	mdb := &StandaloneDatabase{
+		closeChan:      make(chan struct{}),
+		hub:            pubsub.MakeHub(),
+		cmdStats:       makeCommandStats(),
+		recordCommands: true,
+	}
+	databases := config.Properties().Databases
+	if databases <= 0 {
+		databases = 16
+		_ = config.Set("databases", strconv.Itoa(databases))
 	}
-	mdb.dbSet = make([]*DB, config.Properties.Databases)
+	mdb.dbSet = make([]*DB, databases)
 	for i := range mdb.dbSet {
 		singleDB := makeDB()
 		singleDB.index = i
 		mdb.dbSet[i] = singleDB
 	}
-	if config.Properties.AppendOnly {
-		aofHandler, err := aof.NewAOFHandler(mdb)
-		if err != nil {
-			panic(err)
+	for _, db := range mdb.dbSet {
+		// avoid closure
+		singleDB := db
+		singleDB.addAof = func(lines ...CmdLine) <-chan error {
+			return mdb.addAof(singleDB.index, lines...)
 		}
-		mdb.aofHandler = aofHandler
-		for _, db := range mdb.dbSet {
-			// avoid closure
-			singleDB := db
-			singleDB.addAof = func(line CmdLine) {
-				mdb.aofHandler.AddAof(singleDB.index, line)
-			}
+		singleDB.notify = func(class int, event string, key string) {
+			mdb.notifyKeyspaceEvent(singleDB.index, class, event, key)
 		}
 	}
 	return mdb
 }
 
-// Exec 执行命令
+// activeExpire 定期清理各个 DB 中已过期的 key
+func (mdb *StandaloneDatabase) activeExpire() {
+	ticker := time.NewTicker(activeExpireInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			for _, db := range mdb.dbSet {
+				db.activeExpireCycle()
+			}
+			mdb.updatePeakMemory()
+			mdb.checkSaveRules()
+			if aofHandler := mdb.aof(); aofHandler != nil {
+				aofHandler.RewriteIfNeeded()
+			}
+		case <-mdb.closeChan:
+			return
+		}
+	}
+}
+
+// Exec 执行命令，并记录命令的执行统计和慢查询日志
 // 参数'cmdLine'包含命令及其参数，例如:"set key value"
-func (mdb *StandaloneDatabase) Exec(c resp.Connection, cmdLine [][]byte) (result resp.Reply) {
+func (mdb *StandaloneDatabase) Exec(c resp.Connection, cmdLine [][]byte) resp.Reply {
+	start := time.Now()
+	result := mdb.exec(c, cmdLine)
+	// 加载 AOF 时执行的命令不统计
+	if mdb.recordCommands && !mdb.loading {
+		mdb.RecordCommand(c, cmdLine, result, time.Since(start))
+	}
+	return result
+}
+
+func (mdb *StandaloneDatabase) exec(c resp.Connection, cmdLine [][]byte) (result resp.Reply) {
 
 	defer func() {
 		if err := recover(); err != nil {
@@ -60,12 +160,55 @@ func (mdb *StandaloneDatabase) Exec(c resp.Connection, cmdLine [][]byte) (result
 	}()
 
 	cmdName := strings.ToLower(string(cmdLine[0]))
+	// 订阅模式下只允许执行订阅相关的命令，回复与消息一样经过订阅者的队列
+	if pubsub.IsSubscribed(c) {
+		if _, ok := subscribedModeCommands[cmdName]; !ok {
+			return pubsub.Reply(mdb.hub, c, reply.MakeErrReply("ERR Can't execute '"+cmdName+
+				"': only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING are allowed in this context"))
+		}
+		if cmdName == "ping" {
+			return pubsub.Reply(mdb.hub, c, subscribedPing(cmdLine[1:]))
+		}
+	}
+	if _, ok := pubsubCommands[cmdName]; ok {
+		if c.InMultiState() {
+			return rejectInMulti(c)
+		}
+		return mdb.execPubSub(c, cmdName, cmdLine)
+	}
 	if cmdName == "select" {
+		if c.InMultiState() {
+			return rejectInMulti(c)
+		}
 		if len(cmdLine) != 2 {
 			return reply.MakeArgNumErrReply("select")
 		}
 		return execSelect(c, mdb, cmdLine[1:])
 	}
+	if cmdName == "slowlog" {
+		if c.InMultiState() {
+			return rejectInMulti(c)
+		}
+		return mdb.execSlowLog(cmdLine[1:])
+	}
+	if cmdName == "save" || cmdName == "bgsave" || cmdName == "lastsave" {
+		if c.InMultiState() {
+			return rejectInMulti(c)
+		}
+		return mdb.execSave(cmdName, cmdLine[1:])
+	}
+	if cmdName == "bgrewriteaof" {
+		if c.InMultiState() {
+			return rejectInMulti(c)
+		}
+		return mdb.execBGRewriteAof(cmdLine[1:])
+	}
+	if errReply := mdb.checkMemory(c, cmdLine); errReply != nil {
+		return errReply
+	}
+	if errReply := mdb.checkAofWriteErr(c, cmdLine); errReply != nil {
+		return errReply
+	}
 	// 普通命令
 	dbIndex := c.GetDBIndex()
 	if dbIndex >= len(mdb.dbSet) {
@@ -75,13 +218,26 @@ func (mdb *StandaloneDatabase) Exec(c resp.Connection, cmdLine [][]byte) (result
 	return selectedDB.Exec(c, cmdLine)
 }
 
-// Close 优雅关闭数据库
+// Close 优雅关闭数据库，配置了 save 规则时在关闭前保存快照，最后将 AOF 中剩余的数据写入文件并 fsync
+// 并发调用时会等待第一次调用中的保存完成
 func (mdb *StandaloneDatabase) Close() {
-
+	mdb.closeOnce.Do(func() {
+		close(mdb.closeChan)
+		mdb.snapshot.bgSaving.Wait()
+		if rules, _ := ParseSaveRules(config.Properties().Save); len(rules) > 0 {
+			if err := mdb.Save(); err != nil {
+				logger.Error("save snapshot on shutdown error: " + err.Error())
+			}
+		}
+		// 关闭 AofHandler，写入队列中剩余的命令并 fsync
+		_ = mdb.SetAppendOnly(false)
+	})
 }
 
+// AfterClientClose 在连接断开且最后一条命令执行结束后清理连接的状态，例如取消订阅
+// 阻塞在 BLPOP 等命令上的客户端在连接关闭时已经被唤醒
 func (mdb *StandaloneDatabase) AfterClientClose(c resp.Connection) {
-
+	pubsub.UnsubscribeAll(mdb.hub, c)
 }
 
 func execSelect(c resp.Connection, mdb *StandaloneDatabase, args [][]byte) resp.Reply {
@@ -95,3 +251,62 @@ func execSelect(c resp.Connection, mdb *StandaloneDatabase, args [][]byte) resp.
 	c.SelectDB(dbIndex)
 	return reply.MakeOkReply()
 }
+
+// pubsubCommands 是发布订阅相关的命令，它们作用于整个服务器而不是某个 DB
+var pubsubCommands = map[string]struct{}{
+	"subscribe":    {},
+	"unsubscribe":  {},
+	"psubscribe":   {},
+	"punsubscribe": {},
+	"publish":      {},
+	"pubsub":       {},
+}
+
+// subscribedModeCommands 是订阅模式下允许执行的命令
+var subscribedModeCommands = map[string]struct{}{
+	"subscribe":    {},
+	"unsubscribe":  {},
+	"psubscribe":   {},
+	"punsubscribe": {},
+	"ping":         {},
+}
+
+func (mdb *StandaloneDatabase) execPubSub(c resp.Connection, cmdName string, cmdLine [][]byte) resp.Reply {
+	args := cmdLine[1:]
+	switch cmdName {
+	case "subscribe":
+		if len(args) < 1 {
+			return reply.MakeArgNumErrReply(cmdName)
+		}
+		return pubsub.Subscribe(mdb.hub, c, args)
+	case "unsubscribe":
+		return pubsub.UnSubscribe(mdb.hub, c, args)
+	case "psubscribe":
+		if len(args) < 1 {
+			return reply.MakeArgNumErrReply(cmdName)
+		}
+		return pubsub.PSubscribe(mdb.hub, c, args)
+	case "punsubscribe":
+		return pubsub.PUnSubscribe(mdb.hub, c, args)
+	case "publish":
+		return pubsub.Publish(mdb.hub, args)
+	case "pubsub":
+		if len(args) < 1 {
+			return reply.MakeArgNumErrReply(cmdName)
+		}
+		return pubsub.PubSub(mdb.hub, args)
+	}
+	return reply.MakeErrReply("ERR unknown command '" + cmdName + "'")
+}
+
+// subscribedPing 订阅模式下的 PING 返回 pong 和参数组成的数组
+func subscribedPing(args [][]byte) resp.Reply {
+	if len(args) > 1 {
+		return reply.MakeArgNumErrReply("ping")
+	}
+	message := []byte("")
+	if len(args) == 1 {
+		message = args[0]
+	}
+	return reply.MakeMultiBulkReply([][]byte{[]byte("pong"), message})
+}
@@ -0,0 +1,1454 @@
+package database
+
+import (
+	"github.com/jujunwang/Mudis/datastruct/stream"
+	"github.com/jujunwang/Mudis/interface/database"
+	"github.com/jujunwang/Mudis/interface/resp"
+	"github.com/jujunwang/Mudis/lib/utils"
+	"github.com/jujunwang/Mudis/resp/reply"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func (db *DB) getAsStream(key string) (*stream.Stream, reply.ErrorReply) {
+	entity, ok := db.GetEntity(key)
+	if !ok {
+		return nil, nil
+	}
+	s, ok := entity.Data.(*stream.Stream)
+	if !ok {
+		return nil, &reply.WrongTypeErrReply{}
+	}
+	return s, nil
+}
+
+func (db *DB) getOrInitStream(key string) (s *stream.Stream, inited bool, errReply reply.ErrorReply) {
+	s, errReply = db.getAsStream(key)
+	if errReply != nil {
+		return nil, false, errReply
+	}
+	inited = false
+	if s == nil {
+		s = stream.Make()
+		db.PutEntity(key, &database.DataEntity{
+			Data: s,
+		})
+		inited = true
+	}
+	return s, inited, nil
+}
+
+var invalidStreamIDErrReply = reply.MakeErrReply("ERR Invalid stream ID specified as stream command argument")
+
+// parseStreamID 解析 ms-seq 格式的 ID，省略序号时序号为 0
+func parseStreamID(arg []byte) (stream.ID, reply.ErrorReply) {
+	id, _, err := stream.ParseID(string(arg), 0, false)
+	if err != nil {
+		return stream.ID{}, invalidStreamIDErrReply
+	}
+	return id, nil
+}
+
+// parseIntervalID 解析范围查询的边界，支持 - + 和表示开区间的 (
+// 省略序号时起点的序号为 0，终点的序号为最大值
+func parseIntervalID(arg []byte, isStart bool) (stream.ID, reply.ErrorReply) {
+	s := string(arg)
+	exclude := len(s) > 1 && s[0] == '('
+	if exclude {
+		s = s[1:]
+	}
+	invalidInterval := reply.MakeErrReply("ERR invalid end ID for the interval")
+	if isStart {
+		invalidInterval = reply.MakeErrReply("ERR invalid start ID for the interval")
+	}
+	if s == "-" || s == "+" {
+		if exclude {
+			return stream.ID{}, invalidInterval
+		}
+		if s == "-" {
+			return stream.MinID, nil
+		}
+		return stream.MaxID, nil
+	}
+	var missingSeq uint64
+	if !isStart {
+		missingSeq = math.MaxUint64
+	}
+	id, _, err := stream.ParseID(s, missingSeq, false)
+	if err != nil {
+		return stream.ID{}, invalidStreamIDErrReply
+	}
+	if exclude {
+		var ok bool
+		if isStart {
+			id, ok = id.Incr()
+		} else {
+			id, ok = id.Decr()
+		}
+		if !ok {
+			return stream.ID{}, invalidInterval
+		}
+	}
+	return id, nil
+}
+
+func streamIDReply(id stream.ID) *reply.BulkReply {
+	return reply.MakeBulkReply([]byte(id.String()))
+}
+
+func streamEntryToReply(entry *stream.Entry) resp.Reply {
+	return reply.MakeMultiRawReply([]resp.Reply{
+		streamIDReply(entry.ID),
+		reply.MakeMultiBulkReply(entry.Fields),
+	})
+}
+
+func streamEntriesToReply(entries []*stream.Entry) resp.Reply {
+	result := make([]resp.Reply, len(entries))
+	for i, entry := range entries {
+		result[i] = streamEntryToReply(entry)
+	}
+	return reply.MakeMultiRawReply(result)
+}
+
+/* ---- 裁剪 ---- */
+
+// streamTrimOptions 是 XADD 和 XTRIM 共用的 MAXLEN|MINID [=|~] threshold [LIMIT count] 参数
+// 裁剪总是精确执行的，~ 只是允许使用 LIMIT
+type streamTrimOptions struct {
+	strategy string
+	maxLen   int
+	minID    stream.ID
+	limit    int
+}
+
+// parseStreamTrim 从 args[i] 开始解析裁剪参数，返回下一个未解析参数的位置
+func parseStreamTrim(args [][]byte, i int, opts *streamTrimOptions) (int, reply.ErrorReply) {
+	opts.strategy = strings.ToUpper(string(args[i]))
+	i++
+	approx := false
+	if i < len(args) && (string(args[i]) == "~" || string(args[i]) == "=") {
+		approx = string(args[i]) == "~"
+		i++
+	}
+	if i >= len(args) {
+		return 0, reply.MakeSyntaxErrReply()
+	}
+	if opts.strategy == "MAXLEN" {
+		maxLen, err := strconv.ParseInt(string(args[i]), 10, 64)
+		if err != nil {
+			return 0, reply.MakeErrReply("ERR value is not an integer or out of range")
+		}
+		if maxLen < 0 {
+			return 0, reply.MakeErrReply("ERR The MAXLEN argument must be >= 0.")
+		}
+		opts.maxLen = int(maxLen)
+	} else {
+		minID, errReply := parseStreamID(args[i])
+		if errReply != nil {
+			return 0, errReply
+		}
+		opts.minID = minID
+	}
+	i++
+	if i+1 < len(args) && strings.ToUpper(string(args[i])) == "LIMIT" {
+		if !approx {
+			return 0, reply.MakeErrReply("ERR syntax error, LIMIT cannot be used without the special ~ option")
+		}
+		limit, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+		if err != nil {
+			return 0, reply.MakeErrReply("ERR value is not an integer or out of range")
+		}
+		if limit < 0 {
+			return 0, reply.MakeErrReply("ERR The LIMIT argument must be >= 0.")
+		}
+		opts.limit = int(limit)
+		i += 2
+	}
+	return i, nil
+}
+
+func (opts *streamTrimOptions) trim(s *stream.Stream) int {
+	switch opts.strategy {
+	case "MAXLEN":
+		return s.TrimByLen(opts.maxLen, opts.limit)
+	case "MINID":
+		return s.TrimByMinID(opts.minID, opts.limit)
+	}
+	return 0
+}
+
+/* ---- 基本命令 ---- */
+
+// nextStreamID 根据 XADD 的 ID 参数生成新元素的 ID，支持 * 和 ms-*
+func nextStreamID(lastID stream.ID, arg []byte) (stream.ID, reply.ErrorReply) {
+	tooSmall := reply.MakeErrReply("ERR The ID specified in XADD is equal or smaller than the target stream top item")
+	if string(arg) == "*" {
+		now := uint64(time.Now().UnixMilli())
+		if now > lastID.Ms {
+			return stream.ID{Ms: now}, nil
+		}
+		id, ok := lastID.Incr()
+		if !ok {
+			return stream.ID{}, reply.MakeErrReply("ERR The stream has exhausted the last possible ID, unable to add more items")
+		}
+		return id, nil
+	}
+	id, seqGiven, err := stream.ParseID(string(arg), 0, true)
+	if err != nil {
+		return stream.ID{}, invalidStreamIDErrReply
+	}
+	if !seqGiven {
+		if id.Ms < lastID.Ms {
+			return stream.ID{}, tooSmall
+		}
+		if id.Ms == lastID.Ms {
+			if lastID.Seq == math.MaxUint64 {
+				return stream.ID{}, tooSmall
+			}
+			id.Seq = lastID.Seq + 1
+		}
+	}
+	if id.IsZero() {
+		return stream.ID{}, reply.MakeErrReply("ERR The ID specified in XADD must be greater than 0-0")
+	}
+	if !lastID.Less(id) {
+		return stream.ID{}, tooSmall
+	}
+	return id, nil
+}
+
+// execXAdd XADD key [NOMKSTREAM] [MAXLEN|MINID [=|~] threshold [LIMIT count]] *|id field value [field value ...]
+// aof 中记录实际生成的 ID
+func execXAdd(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	noMkStream := false
+	trimOpts := &streamTrimOptions{}
+	i := 1
+	for i < len(args) {
+		opt := strings.ToUpper(string(args[i]))
+		if opt == "NOMKSTREAM" {
+			noMkStream = true
+			i++
+		} else if opt == "MAXLEN" || opt == "MINID" {
+			var errReply reply.ErrorReply
+			i, errReply = parseStreamTrim(args, i, trimOpts)
+			if errReply != nil {
+				return errReply
+			}
+		} else {
+			break
+		}
+	}
+	if i >= len(args) {
+		return reply.MakeArgNumErrReply("xadd")
+	}
+	fields := args[i+1:]
+	if len(fields) == 0 || len(fields)%2 != 0 {
+		return reply.MakeArgNumErrReply("xadd")
+	}
+
+	s, errReply := db.getAsStream(key)
+	if errReply != nil {
+		return errReply
+	}
+	if s == nil && noMkStream {
+		return reply.MakeNullBulkReply()
+	}
+	lastID := stream.MinID
+	if s != nil {
+		lastID = s.LastID()
+	}
+	id, errReply := nextStreamID(lastID, args[i])
+	if errReply != nil {
+		return errReply
+	}
+	if s == nil {
+		s, _, _ = db.getOrInitStream(key)
+	}
+	s.Add(id, fields)
+	trimOpts.trim(s)
+
+	aofArgs := make([][]byte, len(args))
+	copy(aofArgs, args)
+	aofArgs[i] = []byte(id.String())
+	db.addAof(utils.ToCmdLine3("xadd", aofArgs...))
+	db.signalKey(key)
+	return streamIDReply(id)
+}
+
+// execXTrim XTRIM key MAXLEN|MINID [=|~] threshold [LIMIT count]
+func execXTrim(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	opt := strings.ToUpper(string(args[1]))
+	if opt != "MAXLEN" && opt != "MINID" {
+		return reply.MakeSyntaxErrReply()
+	}
+	trimOpts := &streamTrimOptions{}
+	next, errReply := parseStreamTrim(args, 1, trimOpts)
+	if errReply != nil {
+		return errReply
+	}
+	if next != len(args) {
+		return reply.MakeSyntaxErrReply()
+	}
+	s, errReply := db.getAsStream(key)
+	if errReply != nil {
+		return errReply
+	}
+	if s == nil {
+		return reply.MakeIntReply(0)
+	}
+	removed := trimOpts.trim(s)
+	if removed > 0 {
+		db.addAof(utils.ToCmdLine3("xtrim", args...))
+	}
+	return reply.MakeIntReply(int64(removed))
+}
+
+// execXLen XLEN key
+func execXLen(db *DB, args [][]byte) resp.Reply {
+	s, errReply := db.getAsStream(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if s == nil {
+		return reply.MakeIntReply(0)
+	}
+	return reply.MakeIntReply(int64(s.Len()))
+}
+
+// execXDel XDEL key id [id ...]
+func execXDel(db *DB, args [][]byte) resp.Reply {
+	ids := make([]stream.ID, 0, len(args)-1)
+	for _, arg := range args[1:] {
+		id, errReply := parseStreamID(arg)
+		if errReply != nil {
+			return errReply
+		}
+		ids = append(ids, id)
+	}
+	s, errReply := db.getAsStream(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if s == nil {
+		return reply.MakeIntReply(0)
+	}
+	deleted := 0
+	for _, id := range ids {
+		if s.Delete(id) {
+			deleted++
+		}
+	}
+	if deleted > 0 {
+		db.addAof(utils.ToCmdLine3("xdel", args...))
+	}
+	return reply.MakeIntReply(int64(deleted))
+}
+
+func xRangeGeneric(db *DB, args [][]byte, rev bool) resp.Reply {
+	startArg, endArg := args[1], args[2]
+	if rev {
+		startArg, endArg = endArg, startArg
+	}
+	start, errReply := parseIntervalID(startArg, true)
+	if errReply != nil {
+		return errReply
+	}
+	end, errReply := parseIntervalID(endArg, false)
+	if errReply != nil {
+		return errReply
+	}
+	count := 0
+	if len(args) > 3 {
+		if len(args) != 5 || strings.ToUpper(string(args[3])) != "COUNT" {
+			return reply.MakeSyntaxErrReply()
+		}
+		n, err := strconv.ParseInt(string(args[4]), 10, 64)
+		if err != nil {
+			return reply.MakeErrReply("ERR value is not an integer or out of range")
+		}
+		if n <= 0 {
+			return &reply.EmptyMultiBulkReply{}
+		}
+		count = int(n)
+	}
+	s, errReply := db.getAsStream(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if s == nil {
+		return &reply.EmptyMultiBulkReply{}
+	}
+	return streamEntriesToReply(s.Range(start, end, count, rev))
+}
+
+// execXRange XRANGE key start end [COUNT count]
+func execXRange(db *DB, args [][]byte) resp.Reply {
+	return xRangeGeneric(db, args, false)
+}
+
+// execXRevRange XREVRANGE key end start [COUNT count]
+func execXRevRange(db *DB, args [][]byte) resp.Reply {
+	return xRangeGeneric(db, args, true)
+}
+
+// execXSetID XSETID key last-id [ENTRIESADDED entries-added] [MAXDELETEDID max-deleted-id]
+func execXSetID(db *DB, args [][]byte) resp.Reply {
+	id, errReply := parseStreamID(args[1])
+	if errReply != nil {
+		return errReply
+	}
+	entriesAdded := int64(-1)
+	maxDeletedID := stream.MinID
+	hasMaxDeleted := false
+	for i := 2; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return reply.MakeSyntaxErrReply()
+		}
+		opt := strings.ToUpper(string(args[i]))
+		if opt == "ENTRIESADDED" {
+			n, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+			if err != nil {
+				return reply.MakeErrReply("ERR value is not an integer or out of range")
+			}
+			if n < 0 {
+				return reply.MakeErrReply("ERR entries_added must be positive")
+			}
+			entriesAdded = n
+		} else if opt == "MAXDELETEDID" {
+			maxDeletedID, errReply = parseStreamID(args[i+1])
+			if errReply != nil {
+				return errReply
+			}
+			if id.Less(maxDeletedID) {
+				return reply.MakeErrReply("ERR The ID specified in XSETID is smaller than the provided max_deleted_entry_id")
+			}
+			hasMaxDeleted = true
+		} else {
+			return reply.MakeSyntaxErrReply()
+		}
+	}
+	s, errReply := db.getAsStream(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if s == nil {
+		return reply.MakeErrReply("ERR no such key")
+	}
+	if entriesAdded >= 0 && entriesAdded < int64(s.Len()) {
+		return reply.MakeErrReply("ERR The entries_added specified in XSETID is smaller than the target stream length")
+	}
+	if last := s.Last(); last != nil && id.Less(last.ID) {
+		return reply.MakeErrReply("ERR The ID specified in XSETID is smaller than the target stream top item")
+	}
+	s.SetLastID(id)
+	if entriesAdded >= 0 {
+		s.SetEntriesAdded(uint64(entriesAdded))
+	}
+	if hasMaxDeleted {
+		s.SetMaxDeletedID(maxDeletedID)
+	}
+	db.addAof(utils.ToCmdLine3("xsetid", args...))
+	return reply.MakeOkReply()
+}
+
+/* ---- XREAD ---- */
+
+// streamReadOptions 是 XREAD 和 XREADGROUP 的参数
+type streamReadOptions struct {
+	count    int
+	block    bool
+	timeout  time.Duration
+	noAck    bool
+	group    string
+	consumer string
+	keys     []string
+	// idIndex 是第一个 ID 参数在 args 中的位置
+	idIndex int
+}
+
+// parseStreamRead 解析 [GROUP group consumer] [COUNT count] [BLOCK milliseconds] [NOACK] STREAMS key [key ...] id [id ...]
+func parseStreamRead(args [][]byte, isGroup bool) (*streamReadOptions, reply.ErrorReply) {
+	cmdName := "xread"
+	if isGroup {
+		cmdName = "xreadgroup"
+	}
+	opts := &streamReadOptions{}
+	hasGroup, hasStreams := false, false
+	i := 0
+	for ; i < len(args); i++ {
+		opt := strings.ToUpper(string(args[i]))
+		remain := len(args) - i - 1
+		if opt == "STREAMS" {
+			hasStreams = true
+			i++
+			break
+		} else if opt == "COUNT" && remain >= 1 {
+			count, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+			if err != nil {
+				return nil, reply.MakeErrReply("ERR value is not an integer or out of range")
+			}
+			if count > 0 {
+				opts.count = int(count)
+			}
+			i++
+		} else if opt == "BLOCK" && remain >= 1 {
+			ms, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+			if err != nil {
+				return nil, reply.MakeErrReply("ERR timeout is not an integer or out of range")
+			}
+			if ms < 0 {
+				return nil, reply.MakeErrReply("ERR timeout is negative")
+			}
+			opts.block = true
+			opts.timeout = time.Duration(ms) * time.Millisecond
+			i++
+		} else if opt == "GROUP" && remain >= 2 {
+			if !isGroup {
+				return nil, reply.MakeErrReply("ERR The GROUP option is only supported by XREADGROUP. You called XREAD instead.")
+			}
+			hasGroup = true
+			opts.group = string(args[i+1])
+			opts.consumer = string(args[i+2])
+			i += 2
+		} else if opt == "NOACK" && isGroup {
+			opts.noAck = true
+		} else {
+			return nil, reply.MakeSyntaxErrReply()
+		}
+	}
+	if !hasStreams {
+		return nil, reply.MakeSyntaxErrReply()
+	}
+	if isGroup && !hasGroup {
+		return nil, reply.MakeErrReply("ERR Missing GROUP option for XREADGROUP")
+	}
+	remain := len(args) - i
+	if remain == 0 || remain%2 != 0 {
+		symbol := "$"
+		if isGroup {
+			symbol = ">"
+		}
+		return nil, reply.MakeErrReply("ERR Unbalanced '" + cmdName +
+			"' list of streams: for each stream key an ID or '" + symbol + "' must be specified.")
+	}
+	n := remain / 2
+	opts.keys = make([]string, n)
+	for j := 0; j < n; j++ {
+		opts.keys[j] = string(args[i+j])
+	}
+	opts.idIndex = i + n
+	return opts, nil
+}
+
+func streamReadKeys(isGroup bool) func(args [][]byte) []string {
+	return func(args [][]byte) []string {
+		opts, errReply := parseStreamRead(args, isGroup)
+		if errReply != nil {
+			return nil
+		}
+		return opts.keys
+	}
+}
+
+func prepareXRead(args [][]byte) ([]string, []string) {
+	return nil, streamReadKeys(false)(args)
+}
+
+func prepareXReadGroup(args [][]byte) ([]string, []string) {
+	return streamReadKeys(true)(args), nil
+}
+
+// streamReadTimeout 只有指定了 BLOCK 时才会阻塞，XREADGROUP 读取历史消息时不阻塞
+func streamReadTimeout(isGroup bool) func(args [][]byte) (time.Duration, bool, resp.Reply) {
+	return func(args [][]byte) (time.Duration, bool, resp.Reply) {
+		opts, errReply := parseStreamRead(args, isGroup)
+		if errReply != nil || !opts.block {
+			// 参数错误由普通命令的执行流程返回
+			return 0, false, nil
+		}
+		if isGroup {
+			for _, arg := range args[opts.idIndex:] {
+				if string(arg) != ">" {
+					return 0, false, nil
+				}
+			}
+		}
+		return opts.timeout, true, nil
+	}
+}
+
+func makeStreamReadReply(key string, entries resp.Reply) resp.Reply {
+	return reply.MakeMultiRawReply([]resp.Reply{
+		reply.MakeBulkReply([]byte(key)),
+		entries,
+	})
+}
+
+// tryXRead XREAD [COUNT count] [BLOCK milliseconds] STREAMS key [key ...] id [id ...]
+// $ 在第一次执行时被替换为当前的最后一个 ID，保证阻塞后重试时从同一位置读取
+func tryXRead(db *DB, args [][]byte, ready func(key string) bool) (resp.Reply, bool) {
+	opts, errReply := parseStreamRead(args, false)
+	if errReply != nil {
+		return errReply, true
+	}
+	streams := make([]*stream.Stream, len(opts.keys))
+	starts := make([]stream.ID, len(opts.keys))
+	for i, key := range opts.keys {
+		s, errReply := db.getAsStream(key)
+		if errReply != nil {
+			return errReply, true
+		}
+		streams[i] = s
+		idArg := args[opts.idIndex+i]
+		switch string(idArg) {
+		case "$":
+			if s != nil {
+				starts[i] = s.LastID()
+			}
+			args[opts.idIndex+i] = []byte(starts[i].String())
+		case ">":
+			return reply.MakeErrReply("ERR The > ID can be specified only when calling XREADGROUP using the GROUP <group> <consumer> option."), true
+		default:
+			starts[i], errReply = parseStreamID(idArg)
+			if errReply != nil {
+				return errReply, true
+			}
+		}
+	}
+
+	result := make([]resp.Reply, 0)
+	for i, s := range streams {
+		if s == nil {
+			continue
+		}
+		start, ok := starts[i].Incr()
+		if !ok {
+			continue
+		}
+		entries := s.Range(start, stream.MaxID, opts.count, false)
+		if len(entries) == 0 {
+			continue
+		}
+		result = append(result, makeStreamReadReply(opts.keys[i], streamEntriesToReply(entries)))
+	}
+	if len(result) == 0 {
+		return reply.MakeNullMultiBulkReply(), false
+	}
+	return reply.MakeMultiRawReply(result), true
+}
+
+/* ---- 消费者组 ---- */
+
+func noGroupErr(key string, group string) reply.ErrorReply {
+	return reply.MakeErrReply("NOGROUP No such key '" + key + "' or consumer group '" + group + "'")
+}
+
+// getStreamGroup 查找 key 上的消费者组，key 或组不存在时返回 NOGROUP 错误
+func (db *DB) getStreamGroup(key string, groupName string) (*stream.Stream, *stream.Group, reply.ErrorReply) {
+	s, errReply := db.getAsStream(key)
+	if errReply != nil {
+		return nil, nil, errReply
+	}
+	if s == nil {
+		return nil, nil, noGroupErr(key, groupName)
+	}
+	group := s.GetGroup(groupName)
+	if group == nil {
+		return nil, nil, noGroupErr(key, groupName)
+	}
+	return s, group, nil
+}
+
+// getOrCreateConsumer 查找消费者，不存在时创建并在 aof 中记录 XGROUP CREATECONSUMER
+func (db *DB) getOrCreateConsumer(key string, group *stream.Group, name string, now int64) *stream.Consumer {
+	consumer := group.GetConsumer(name)
+	if consumer == nil {
+		consumer = group.CreateConsumer(name, now)
+		db.addAof(utils.ToCmdLine("xgroup", "createconsumer", key, group.Name, name))
+	}
+	consumer.SeenTime = now
+	return consumer
+}
+
+// makeXClaimCmd 生成能够重建待确认元素的 XCLAIM 命令
+func makeXClaimCmd(key string, group *stream.Group, pe *stream.PendingEntry) CmdLine {
+	return utils.ToCmdLine("xclaim", key, group.Name, pe.Consumer.Name, "0", pe.ID.String(),
+		"TIME", strconv.FormatInt(pe.DeliveryTime, 10),
+		"RETRYCOUNT", strconv.FormatInt(pe.DeliveryCount, 10),
+		"FORCE", "JUSTID")
+}
+
+func makeXGroupSetIDCmd(key string, group *stream.Group) CmdLine {
+	return utils.ToCmdLine("xgroup", "setid", key, group.Name, group.LastID.String(),
+		"ENTRIESREAD", strconv.FormatInt(group.EntriesRead, 10))
+}
+
+// tryXReadGroup XREADGROUP GROUP group consumer [COUNT count] [BLOCK milliseconds] [NOACK] STREAMS key [key ...] id [id ...]
+// ID 为 > 时读取新消息并加入待确认列表，否则读取该消费者待确认列表中 ID 更大的消息
+// aof 中记录每条新消息对应的 XCLAIM 和最终的 XGROUP SETID
+func tryXReadGroup(db *DB, args [][]byte, ready func(key string) bool) (resp.Reply, bool) {
+	opts, errReply := parseStreamRead(args, true)
+	if errReply != nil {
+		return errReply, true
+	}
+	streams := make([]*stream.Stream, len(opts.keys))
+	groups := make([]*stream.Group, len(opts.keys))
+	starts := make([]*stream.ID, len(opts.keys))
+	for i, key := range opts.keys {
+		streams[i], groups[i], errReply = db.getStreamGroup(key, opts.group)
+		if errReply != nil {
+			return errReply, true
+		}
+		idArg := args[opts.idIndex+i]
+		if string(idArg) == ">" {
+			continue
+		}
+		start, errReply := parseStreamID(idArg)
+		if errReply != nil {
+			return errReply, true
+		}
+		starts[i] = &start
+	}
+
+	now := time.Now().UnixMilli()
+	result := make([]resp.Reply, 0)
+	for i, key := range opts.keys {
+		s, group := streams[i], groups[i]
+		consumer := db.getOrCreateConsumer(key, group, opts.consumer, now)
+		if starts[i] != nil {
+			// 读取历史消息，已经被删除的消息返回空值
+			var pending []*stream.PendingEntry
+			if start, ok := starts[i].Incr(); ok {
+				pending = consumer.Pending(start, stream.MaxID, opts.count)
+			}
+			items := make([]resp.Reply, len(pending))
+			for j, pe := range pending {
+				if entry, ok := s.Get(pe.ID); ok {
+					items[j] = streamEntryToReply(entry)
+				} else {
+					items[j] = reply.MakeMultiRawReply([]resp.Reply{streamIDReply(pe.ID), reply.MakeNullMultiBulkReply()})
+				}
+			}
+			result = append(result, makeStreamReadReply(key, reply.MakeMultiRawReply(items)))
+			continue
+		}
+		start, ok := group.LastID.Incr()
+		if !ok {
+			continue
+		}
+		entries := s.Range(start, stream.MaxID, opts.count, false)
+		if len(entries) == 0 {
+			continue
+		}
+		aofLines := make([]CmdLine, 0, len(entries)+1)
+		for _, entry := range entries {
+			s.Deliver(group, entry)
+			if !opts.noAck {
+				pe := group.Assign(entry.ID, consumer, now, 1)
+				aofLines = append(aofLines, makeXClaimCmd(key, group, pe))
+			}
+		}
+		consumer.ActiveTime = now
+		aofLines = append(aofLines, makeXGroupSetIDCmd(key, group))
+		db.addAof(aofLines...)
+		result = append(result, makeStreamReadReply(key, streamEntriesToReply(entries)))
+	}
+	if len(result) == 0 {
+		return reply.MakeNullMultiBulkReply(), false
+	}
+	return reply.MakeMultiRawReply(result), true
+}
+
+// execXAck XACK key group id [id ...]
+func execXAck(db *DB, args [][]byte) resp.Reply {
+	ids := make([]stream.ID, 0, len(args)-2)
+	for _, arg := range args[2:] {
+		id, errReply := parseStreamID(arg)
+		if errReply != nil {
+			return errReply
+		}
+		ids = append(ids, id)
+	}
+	s, errReply := db.getAsStream(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if s == nil {
+		return reply.MakeIntReply(0)
+	}
+	group := s.GetGroup(string(args[1]))
+	if group == nil {
+		return reply.MakeIntReply(0)
+	}
+	acked := 0
+	for _, id := range ids {
+		if group.Ack(id) {
+			acked++
+		}
+	}
+	if acked > 0 {
+		db.addAof(utils.ToCmdLine3("xack", args...))
+	}
+	return reply.MakeIntReply(int64(acked))
+}
+
+// execXPending XPENDING key group [[IDLE min-idle-time] start end count [consumer]]
+func execXPending(db *DB, args [][]byte) resp.Reply {
+	key, groupName := string(args[0]), string(args[1])
+	var minIdle int64
+	rest := args[2:]
+	if len(rest) > 0 && strings.ToUpper(string(rest[0])) == "IDLE" {
+		if len(rest) < 2 {
+			return reply.MakeSyntaxErrReply()
+		}
+		n, err := strconv.ParseInt(string(rest[1]), 10, 64)
+		if err != nil {
+			return reply.MakeErrReply("ERR value is not an integer or out of range")
+		}
+		minIdle = n
+		rest = rest[2:]
+		if len(rest) == 0 {
+			return reply.MakeSyntaxErrReply()
+		}
+	}
+	if len(rest) != 0 && len(rest) != 3 && len(rest) != 4 {
+		return reply.MakeSyntaxErrReply()
+	}
+	var start, end stream.ID
+	count := 0
+	if len(rest) > 0 {
+		var errReply reply.ErrorReply
+		start, errReply = parseIntervalID(rest[0], true)
+		if errReply != nil {
+			return errReply
+		}
+		end, errReply = parseIntervalID(rest[1], false)
+		if errReply != nil {
+			return errReply
+		}
+		n, err := strconv.ParseInt(string(rest[2]), 10, 64)
+		if err != nil {
+			return reply.MakeErrReply("ERR value is not an integer or out of range")
+		}
+		count = int(n)
+	}
+
+	_, group, errReply := db.getStreamGroup(key, groupName)
+	if errReply != nil {
+		return errReply
+	}
+
+	if len(rest) == 0 {
+		// 概要格式: 数量，最小 ID，最大 ID，每个消费者的待确认数量
+		pending := group.Pending(stream.MinID, stream.MaxID, 0)
+		if len(pending) == 0 {
+			return reply.MakeMultiRawReply([]resp.Reply{
+				reply.MakeIntReply(0),
+				reply.MakeNullBulkReply(),
+				reply.MakeNullBulkReply(),
+				reply.MakeNullMultiBulkReply(),
+			})
+		}
+		consumers := make([]resp.Reply, 0)
+		for _, consumer := range group.Consumers() {
+			if consumer.PendingCount() == 0 {
+				continue
+			}
+			consumers = append(consumers, reply.MakeMultiBulkReply([][]byte{
+				[]byte(consumer.Name),
+				[]byte(strconv.Itoa(consumer.PendingCount())),
+			}))
+		}
+		return reply.MakeMultiRawReply([]resp.Reply{
+			reply.MakeIntReply(int64(len(pending))),
+			streamIDReply(pending[0].ID),
+			streamIDReply(pending[len(pending)-1].ID),
+			reply.MakeMultiRawReply(consumers),
+		})
+	}
+
+	if count <= 0 {
+		return &reply.EmptyMultiBulkReply{}
+	}
+	var pending []*stream.PendingEntry
+	if len(rest) == 4 {
+		consumer := group.GetConsumer(string(rest[3]))
+		if consumer == nil {
+			return &reply.EmptyMultiBulkReply{}
+		}
+		pending = consumer.Pending(start, end, 0)
+	} else {
+		pending = group.Pending(start, end, 0)
+	}
+	now := time.Now().UnixMilli()
+	result := make([]resp.Reply, 0)
+	for _, pe := range pending {
+		if len(result) >= count {
+			break
+		}
+		idle := now - pe.DeliveryTime
+		if idle < minIdle {
+			continue
+		}
+		result = append(result, reply.MakeMultiRawReply([]resp.Reply{
+			streamIDReply(pe.ID),
+			reply.MakeBulkReply([]byte(pe.Consumer.Name)),
+			reply.MakeIntReply(idle),
+			reply.MakeIntReply(pe.DeliveryCount),
+		}))
+	}
+	return reply.MakeMultiRawReply(result)
+}
+
+// claimPending 将待确认元素转移给消费者，返回新的待确认元素
+// retryCount < 0 时投递次数加一(justID 为 true 时不变)
+func claimPending(group *stream.Group, id stream.ID, consumer *stream.Consumer, deliveryTime int64,
+	retryCount int64, justID bool) *stream.PendingEntry {
+	deliveryCount := int64(1)
+	if pe, ok := group.GetPending(id); ok {
+		deliveryCount = pe.DeliveryCount
+	}
+	if retryCount >= 0 {
+		deliveryCount = retryCount
+	} else if !justID {
+		deliveryCount++
+	}
+	return group.Assign(id, consumer, deliveryTime, deliveryCount)
+}
+
+func parseMinIdle(arg []byte, cmdName string) (int64, reply.ErrorReply) {
+	minIdle, err := strconv.ParseInt(string(arg), 10, 64)
+	if err != nil {
+		return 0, reply.MakeErrReply("ERR Invalid min-idle-time argument for " + cmdName)
+	}
+	if minIdle < 0 {
+		minIdle = 0
+	}
+	return minIdle, nil
+}
+
+// execXClaim XCLAIM key group consumer min-idle-time id [id ...] [IDLE ms] [TIME unix-time-milliseconds]
+// [RETRYCOUNT count] [FORCE] [JUSTID] [LASTID lastid]
+func execXClaim(db *DB, args [][]byte) resp.Reply {
+	key, groupName, consumerName := string(args[0]), string(args[1]), string(args[2])
+	minIdle, errReply := parseMinIdle(args[3], "XCLAIM")
+	if errReply != nil {
+		return errReply
+	}
+	// 连续的合法 ID 之后是可选参数
+	ids := make([]stream.ID, 0)
+	i := 4
+	for ; i < len(args); i++ {
+		id, errReply := parseStreamID(args[i])
+		if errReply != nil {
+			break
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		return invalidStreamIDErrReply
+	}
+	now := time.Now().UnixMilli()
+	deliveryTime := now
+	retryCount := int64(-1)
+	force, justID := false, false
+	var lastID *stream.ID
+	for ; i < len(args); i++ {
+		opt := strings.ToUpper(string(args[i]))
+		remain := len(args) - i - 1
+		switch {
+		case opt == "FORCE":
+			force = true
+		case opt == "JUSTID":
+			justID = true
+		case (opt == "IDLE" || opt == "TIME" || opt == "RETRYCOUNT") && remain >= 1:
+			n, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+			if err != nil {
+				return reply.MakeErrReply("ERR Invalid " + opt + " option argument for XCLAIM")
+			}
+			if opt == "IDLE" {
+				deliveryTime = now - n
+			} else if opt == "TIME" {
+				deliveryTime = n
+			} else {
+				retryCount = n
+			}
+			i++
+		case opt == "LASTID" && remain >= 1:
+			id, errReply := parseStreamID(args[i+1])
+			if errReply != nil {
+				return errReply
+			}
+			lastID = &id
+			i++
+		default:
+			return reply.MakeErrReply("ERR Unrecognized XCLAIM option '" + string(args[i]) + "'")
+		}
+	}
+	if deliveryTime < 0 || deliveryTime > now {
+		deliveryTime = now
+	}
+
+	s, group, errReply := db.getStreamGroup(key, groupName)
+	if errReply != nil {
+		return errReply
+	}
+	if lastID != nil && group.LastID.Less(*lastID) {
+		group.LastID = *lastID
+		db.addAof(makeXGroupSetIDCmd(key, group))
+	}
+	var consumer *stream.Consumer
+	result := make([]resp.Reply, 0)
+	for _, id := range ids {
+		pe, pending := group.GetPending(id)
+		entry, exists := s.Get(id)
+		if !pending && !(force && exists) {
+			continue
+		}
+		if pending && !exists {
+			// 元素已经被删除，从待确认列表中移除
+			group.Ack(id)
+			db.addAof(utils.ToCmdLine("xack", key, groupName, id.String()))
+			continue
+		}
+		if pending && minIdle > 0 && now-pe.DeliveryTime < minIdle {
+			continue
+		}
+		if consumer == nil {
+			consumer = db.getOrCreateConsumer(key, group, consumerName, now)
+		}
+		pe = claimPending(group, id, consumer, deliveryTime, retryCount, justID)
+		consumer.ActiveTime = now
+		db.addAof(makeXClaimCmd(key, group, pe))
+		if justID {
+			result = append(result, streamIDReply(id))
+		} else {
+			result = append(result, streamEntryToReply(entry))
+		}
+	}
+	return reply.MakeMultiRawReply(result)
+}
+
+// execXAutoClaim XAUTOCLAIM key group consumer min-idle-time start [COUNT count] [JUSTID]
+// 返回下一次扫描的起点、认领的元素和已经被删除的元素 ID
+func execXAutoClaim(db *DB, args [][]byte) resp.Reply {
+	key, groupName, consumerName := string(args[0]), string(args[1]), string(args[2])
+	minIdle, errReply := parseMinIdle(args[3], "XAUTOCLAIM")
+	if errReply != nil {
+		return errReply
+	}
+	start, errReply := parseIntervalID(args[4], true)
+	if errReply != nil {
+		return errReply
+	}
+	count := 100
+	justID := false
+	for i := 5; i < len(args); i++ {
+		opt := strings.ToUpper(string(args[i]))
+		if opt == "JUSTID" {
+			justID = true
+		} else if opt == "COUNT" && i+1 < len(args) {
+			n, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+			if err != nil {
+				return reply.MakeErrReply("ERR value is not an integer or out of range")
+			}
+			if n <= 0 || n > math.MaxInt32 {
+				return reply.MakeErrReply("ERR COUNT must be > 0")
+			}
+			count = int(n)
+			i++
+		} else {
+			return reply.MakeSyntaxErrReply()
+		}
+	}
+
+	s, group, errReply := db.getStreamGroup(key, groupName)
+	if errReply != nil {
+		return errReply
+	}
+	now := time.Now().UnixMilli()
+	// 最多检查 count * 10 个待确认元素
+	attempts := count * 10
+	next := stream.MinID
+	claimed := make([]resp.Reply, 0)
+	deleted := make([][]byte, 0)
+	var consumer *stream.Consumer
+	for _, pe := range group.Pending(start, stream.MaxID, 0) {
+		if attempts == 0 || len(claimed) >= count {
+			next = pe.ID
+			break
+		}
+		attempts--
+		entry, exists := s.Get(pe.ID)
+		if !exists {
+			group.Ack(pe.ID)
+			db.addAof(utils.ToCmdLine("xack", key, groupName, pe.ID.String()))
+			deleted = append(deleted, []byte(pe.ID.String()))
+			continue
+		}
+		if minIdle > 0 && now-pe.DeliveryTime < minIdle {
+			continue
+		}
+		if consumer == nil {
+			consumer = db.getOrCreateConsumer(key, group, consumerName, now)
+		}
+		claimedPE := claimPending(group, pe.ID, consumer, now, -1, justID)
+		consumer.ActiveTime = now
+		db.addAof(makeXClaimCmd(key, group, claimedPE))
+		if justID {
+			claimed = append(claimed, streamIDReply(pe.ID))
+		} else {
+			claimed = append(claimed, streamEntryToReply(entry))
+		}
+	}
+	return reply.MakeMultiRawReply([]resp.Reply{
+		streamIDReply(next),
+		reply.MakeMultiRawReply(claimed),
+		reply.MakeMultiBulkReply(deleted),
+	})
+}
+
+// parseEntriesRead 解析 ENTRIESREAD 参数
+func parseEntriesRead(args [][]byte) (int64, reply.ErrorReply) {
+	if len(args) != 2 || strings.ToUpper(string(args[0])) != "ENTRIESREAD" {
+		return 0, reply.MakeSyntaxErrReply()
+	}
+	n, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return 0, reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	if n < -1 {
+		return 0, reply.MakeErrReply("ERR value for ENTRIESREAD must be positive or -1")
+	}
+	return n, nil
+}
+
+// parseGroupID 解析 XGROUP 的 ID 参数，$ 代表流的最后一个 ID
+func parseGroupID(s *stream.Stream, arg []byte) (stream.ID, reply.ErrorReply) {
+	if string(arg) == "$" {
+		if s == nil {
+			return stream.MinID, nil
+		}
+		return s.LastID(), nil
+	}
+	return parseStreamID(arg)
+}
+
+// xGroupArity 是 XGROUP 各个子命令的参数数量(包括子命令本身)，负数代表最少数量
+var xGroupArity = map[string]int{
+	"CREATE":         -4,
+	"SETID":          -4,
+	"DESTROY":        3,
+	"CREATECONSUMER": 4,
+	"DELCONSUMER":    4,
+}
+
+// execXGroup XGROUP CREATE|SETID|DESTROY|CREATECONSUMER|DELCONSUMER key group ...
+func execXGroup(db *DB, args [][]byte) resp.Reply {
+	subCmd := strings.ToUpper(string(args[0]))
+	expected, ok := xGroupArity[subCmd]
+	if !ok {
+		return reply.MakeErrReply("ERR unknown subcommand '" + string(args[0]) + "'. Try XGROUP HELP.")
+	}
+	if (expected > 0 && len(args) != expected) || (expected < 0 && len(args) < -expected) {
+		return reply.MakeErrReply("ERR wrong number of arguments for 'xgroup|" + strings.ToLower(subCmd) + "' command")
+	}
+	key, groupName := string(args[1]), string(args[2])
+	s, errReply := db.getAsStream(key)
+	if errReply != nil {
+		return errReply
+	}
+	mkStream := false
+	entriesRead := int64(-1)
+	if subCmd == "CREATE" || subCmd == "SETID" {
+		opts := args[4:]
+		if subCmd == "CREATE" && len(opts) > 0 && strings.ToUpper(string(opts[0])) == "MKSTREAM" {
+			mkStream = true
+			opts = opts[1:]
+		}
+		if len(opts) > 0 {
+			entriesRead, errReply = parseEntriesRead(opts)
+			if errReply != nil {
+				return errReply
+			}
+		}
+	}
+	if s == nil && !mkStream {
+		return reply.MakeErrReply("ERR The XGROUP subcommand requires the key to exist. " +
+			"Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically.")
+	}
+	var group *stream.Group
+	if s != nil && subCmd != "CREATE" {
+		group = s.GetGroup(groupName)
+		if group == nil && subCmd != "DESTROY" {
+			return reply.MakeErrReply("NOGROUP No such consumer group '" + groupName + "' for key name '" + key + "'")
+		}
+	}
+
+	switch subCmd {
+	case "CREATE":
+		id, errReply := parseGroupID(s, args[3])
+		if errReply != nil {
+			return errReply
+		}
+		if s != nil && s.GetGroup(groupName) != nil {
+			return reply.MakeErrReply("BUSYGROUP Consumer Group name already exists")
+		}
+		if s == nil {
+			s, _, _ = db.getOrInitStream(key)
+		}
+		s.CreateGroup(groupName, id, entriesRead)
+		db.addAof(utils.ToCmdLine3("xgroup", args...))
+		return reply.MakeOkReply()
+	case "SETID":
+		id, errReply := parseGroupID(s, args[3])
+		if errReply != nil {
+			return errReply
+		}
+		group.LastID = id
+		group.EntriesRead = entriesRead
+		db.addAof(utils.ToCmdLine3("xgroup", args...))
+		return reply.MakeOkReply()
+	case "DESTROY":
+		if !s.DestroyGroup(groupName) {
+			return reply.MakeIntReply(0)
+		}
+		db.addAof(utils.ToCmdLine3("xgroup", args...))
+		// 唤醒阻塞在该组上的客户端，让它们返回 NOGROUP 错误
+		db.signalKey(key)
+		return reply.MakeIntReply(1)
+	case "CREATECONSUMER":
+		if group.CreateConsumer(string(args[3]), time.Now().UnixMilli()) == nil {
+			return reply.MakeIntReply(0)
+		}
+		db.addAof(utils.ToCmdLine3("xgroup", args...))
+		return reply.MakeIntReply(1)
+	default:
+		pending := group.DeleteConsumer(string(args[3]))
+		if pending < 0 {
+			return reply.MakeIntReply(0)
+		}
+		db.addAof(utils.ToCmdLine3("xgroup", args...))
+		return reply.MakeIntReply(int64(pending))
+	}
+}
+
+func prepareXGroup(args [][]byte) ([]string, []string) {
+	if len(args) < 2 {
+		return nil, nil
+	}
+	return []string{string(args[1])}, nil
+}
+
+func undoXGroup(db *DB, args [][]byte) []CmdLine {
+	if len(args) < 2 {
+		return nil
+	}
+	return rollbackGivenKeys(db, string(args[1]))
+}
+
+/* ---- XINFO ---- */
+
+// makeMapReply 将 key value 交替排列的列表转换为回复
+func makeMapReply(pairs ...interface{}) resp.Reply {
+	result := make([]resp.Reply, len(pairs))
+	for i, v := range pairs {
+		switch val := v.(type) {
+		case string:
+			result[i] = reply.MakeBulkReply([]byte(val))
+		case int:
+			result[i] = reply.MakeIntReply(int64(val))
+		case int64:
+			result[i] = reply.MakeIntReply(val)
+		case uint64:
+			result[i] = reply.MakeIntReply(int64(val))
+		case stream.ID:
+			result[i] = streamIDReply(val)
+		case resp.Reply:
+			result[i] = val
+		}
+	}
+	return reply.MakeMultiRawReply(result)
+}
+
+// optionalInt 值为 -1 时返回空值
+func optionalInt(n int64) resp.Reply {
+	if n < 0 {
+		return reply.MakeNullBulkReply()
+	}
+	return reply.MakeIntReply(n)
+}
+
+func optionalEntry(entry *stream.Entry) resp.Reply {
+	if entry == nil {
+		return reply.MakeNullBulkReply()
+	}
+	return streamEntryToReply(entry)
+}
+
+func xInfoStream(s *stream.Stream, full bool, count int) resp.Reply {
+	head := []interface{}{
+		"length", s.Len(),
+		"last-generated-id", s.LastID(),
+		"max-deleted-entry-id", s.MaxDeletedID(),
+		"entries-added", s.EntriesAdded(),
+		"recorded-first-entry-id", s.FirstID(),
+	}
+	if !full {
+		return makeMapReply(append(head,
+			"groups", len(s.Groups()),
+			"first-entry", optionalEntry(s.First()),
+			"last-entry", optionalEntry(s.Last()),
+		)...)
+	}
+
+	entries := s.Range(stream.MinID, stream.MaxID, count, false)
+	groups := make([]resp.Reply, 0)
+	for _, group := range s.Groups() {
+		groupPending := group.Pending(stream.MinID, stream.MaxID, count)
+		pendingReplies := make([]resp.Reply, len(groupPending))
+		for i, pe := range groupPending {
+			pendingReplies[i] = makeMapReply(pe.ID, pe.Consumer.Name, pe.DeliveryTime, pe.DeliveryCount)
+		}
+		consumers := make([]resp.Reply, 0)
+		for _, consumer := range group.Consumers() {
+			consumerPending := consumer.Pending(stream.MinID, stream.MaxID, count)
+			consumerPendingReplies := make([]resp.Reply, len(consumerPending))
+			for i, pe := range consumerPending {
+				consumerPendingReplies[i] = makeMapReply(pe.ID, pe.DeliveryTime, pe.DeliveryCount)
+			}
+			consumers = append(consumers, makeMapReply(
+				"name", consumer.Name,
+				"seen-time", consumer.SeenTime,
+				"active-time", consumer.ActiveTime,
+				"pel-count", consumer.PendingCount(),
+				"pending", reply.MakeMultiRawReply(consumerPendingReplies),
+			))
+		}
+		groups = append(groups, makeMapReply(
+			"name", group.Name,
+			"last-delivered-id", group.LastID,
+			"entries-read", optionalInt(group.EntriesRead),
+			"lag", optionalInt(s.Lag(group)),
+			"pel-count", group.PendingCount(),
+			"pending", reply.MakeMultiRawReply(pendingReplies),
+			"consumers", reply.MakeMultiRawReply(consumers),
+		))
+	}
+	return makeMapReply(append(head,
+		"entries", streamEntriesToReply(entries),
+		"groups", reply.MakeMultiRawReply(groups),
+	)...)
+}
+
+// execXInfo XINFO STREAM key [FULL [COUNT count]] | GROUPS key | CONSUMERS key group
+func execXInfo(db *DB, args [][]byte) resp.Reply {
+	subCmd := strings.ToUpper(string(args[0]))
+	if subCmd != "STREAM" && subCmd != "GROUPS" && subCmd != "CONSUMERS" {
+		return reply.MakeErrReply("ERR unknown subcommand '" + string(args[0]) + "'. Try XINFO HELP.")
+	}
+	if len(args) < 2 || (subCmd == "GROUPS" && len(args) != 2) || (subCmd == "CONSUMERS" && len(args) != 3) {
+		return reply.MakeErrReply("ERR wrong number of arguments for 'xinfo|" + strings.ToLower(subCmd) + "' command")
+	}
+	key := string(args[1])
+	s, errReply := db.getAsStream(key)
+	if errReply != nil {
+		return errReply
+	}
+	if s == nil {
+		return reply.MakeErrReply("ERR no such key")
+	}
+
+	switch subCmd {
+	case "STREAM":
+		full := false
+		count := 10
+		rest := args[2:]
+		if len(rest) > 0 {
+			if strings.ToUpper(string(rest[0])) != "FULL" {
+				return reply.MakeSyntaxErrReply()
+			}
+			full = true
+			rest = rest[1:]
+		}
+		if len(rest) > 0 {
+			if len(rest) != 2 || strings.ToUpper(string(rest[0])) != "COUNT" {
+				return reply.MakeSyntaxErrReply()
+			}
+			n, err := strconv.ParseInt(string(rest[1]), 10, 64)
+			if err != nil {
+				return reply.MakeErrReply("ERR value is not an integer or out of range")
+			}
+			// COUNT 0 代表返回全部
+			count = int(n)
+			if count < 0 {
+				count = 0
+			}
+		}
+		return xInfoStream(s, full, count)
+	case "GROUPS":
+		groups := make([]resp.Reply, 0)
+		for _, group := range s.Groups() {
+			groups = append(groups, makeMapReply(
+				"name", group.Name,
+				"consumers", len(group.Consumers()),
+				"pending", group.PendingCount(),
+				"last-delivered-id", group.LastID,
+				"entries-read", optionalInt(group.EntriesRead),
+				"lag", optionalInt(s.Lag(group)),
+			))
+		}
+		return reply.MakeMultiRawReply(groups)
+	default:
+		groupName := string(args[2])
+		group := s.GetGroup(groupName)
+		if group == nil {
+			return reply.MakeErrReply("NOGROUP No such consumer group '" + groupName + "' for key name '" + key + "'")
+		}
+		now := time.Now().UnixMilli()
+		consumers := make([]resp.Reply, 0)
+		for _, consumer := range group.Consumers() {
+			inactive := int64(-1)
+			if consumer.ActiveTime >= 0 {
+				inactive = now - consumer.ActiveTime
+			}
+			consumers = append(consumers, makeMapReply(
+				"name", consumer.Name,
+				"pending", consumer.PendingCount(),
+				"idle", now-consumer.SeenTime,
+				"inactive", inactive,
+			))
+		}
+		return reply.MakeMultiRawReply(consumers)
+	}
+}
+
+func prepareXInfo(args [][]byte) ([]string, []string) {
+	if len(args) < 2 {
+		return nil, nil
+	}
+	return nil, []string{string(args[1])}
+}
+
+func init() {
+	RegisterCommand("XAdd", execXAdd, writeFirstKey, rollbackFirstKey, -5)
+	RegisterCommand("XTrim", execXTrim, writeFirstKey, rollbackFirstKey, -4)
+	RegisterCommand("XLen", execXLen, readFirstKey, nil, 2)
+	RegisterCommand("XDel", execXDel, writeFirstKey, rollbackFirstKey, -3)
+	RegisterCommand("XRange", execXRange, readFirstKey, nil, -4)
+	RegisterCommand("XRevRange", execXRevRange, readFirstKey, nil, -4)
+	RegisterCommand("XSetID", execXSetID, writeFirstKey, rollbackFirstKey, -3)
+	registerBlockingCommand("XRead", tryXRead, streamReadKeys(false), streamReadTimeout(false), prepareXRead, -4)
+	registerBlockingCommand("XReadGroup", tryXReadGroup, streamReadKeys(true), streamReadTimeout(true), prepareXReadGroup, -7)
+	RegisterCommand("XAck", execXAck, writeFirstKey, rollbackFirstKey, -4)
+	RegisterCommand("XPending", execXPending, readFirstKey, nil, -3)
+	RegisterCommand("XClaim", execXClaim, writeFirstKey, rollbackFirstKey, -6)
+	RegisterCommand("XAutoClaim", execXAutoClaim, writeFirstKey, rollbackFirstKey, -6)
+	RegisterCommand("XGroup", execXGroup, prepareXGroup, undoXGroup, -2)
+	RegisterCommand("XInfo", execXInfo, prepareXInfo, nil, -2)
+}
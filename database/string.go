@@ -7,6 +7,7 @@ import (
 	"github.com/jujunwang/Mudis/resp/reply"
 	"strconv"
 	"strings"
+	"time"
 )
 
 func (db *DB) getAsString(key string) ([]byte, reply.ErrorReply) {
@@ -45,23 +46,54 @@ func execSet(db *DB, args [][]byte) resp.Reply {
 	key := string(args[0])
 	value := args[1]
 	policy := upsertPolicy
+	var expireTime time.Time // 零值代表不设置过期时间
+	keepTTL := false
 	// 解析
-	if len(args) > 2 {
-		for i := 2; i < len(args); i++ {
-			arg := strings.ToUpper(string(args[i]))
-			if arg == "NX" { // insert
-				if policy == updatePolicy {
-					return &reply.SyntaxErrReply{}
-				}
-				policy = insertPolicy
-			} else if arg == "XX" { // update policy
-				if policy == insertPolicy {
-					return &reply.SyntaxErrReply{}
-				}
-				policy = updatePolicy
+	for i := 2; i < len(args); i++ {
+		arg := strings.ToUpper(string(args[i]))
+		switch arg {
+		case "NX": // insert
+			if policy == updatePolicy {
+				return &reply.SyntaxErrReply{}
+			}
+			policy = insertPolicy
+		case "XX": // update policy
+			if policy == insertPolicy {
+				return &reply.SyntaxErrReply{}
+			}
+			policy = updatePolicy
+		case "EX", "PX", "EXAT", "PXAT":
+			if !expireTime.IsZero() || keepTTL || i+1 >= len(args) {
+				return &reply.SyntaxErrReply{}
+			}
+			raw, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+			if err != nil {
+				return reply.MakeErrReply("ERR value is not an integer or out of range")
+			}
+			if raw <= 0 {
+				return invalidExpireTimeErr("set")
+			}
+			unit := time.Second
+			if arg == "PX" || arg == "PXAT" {
+				unit = time.Millisecond
+			}
+			var ok bool
+			if arg == "EX" || arg == "PX" {
+				expireTime, ok = expireTimeAfter(raw, unit)
 			} else {
+				expireTime, ok = expireTimeAt(raw, unit)
+			}
+			if !ok {
+				return invalidExpireTimeErr("set")
+			}
+			i++
+		case "KEEPTTL":
+			if !expireTime.IsZero() {
 				return &reply.SyntaxErrReply{}
 			}
+			keepTTL = true
+		default:
+			return &reply.SyntaxErrReply{}
 		}
 	}
 
@@ -79,11 +111,57 @@ func execSet(db *DB, args [][]byte) resp.Reply {
 	case updatePolicy:
 		result = db.PutIfExists(key, entity)
 	}
-	db.addAof(utils.ToCmdLine2("set", args...))
-	if result > 0 {
+	if result == 0 {
+		return &reply.NullBulkReply{}
+	}
+	db.notify(notifyString, "set", key)
+	if keepTTL {
+		db.addAof(utils.ToCmdLine3("set", args[0], value, []byte("keepttl")))
 		return &reply.OkReply{}
 	}
-	return &reply.NullBulkReply{}
+	db.addAof(utils.ToCmdLine3("set", args[0], value))
+	if expireTime.IsZero() {
+		db.Persist(key)
+	} else {
+		db.Expire(key, expireTime)
+		db.addAof(makeExpireCmd(key, expireTime))
+		db.notify(notifyGeneric, "expire", key)
+	}
+	return &reply.OkReply{}
+}
+
+// setWithTTL 设置 k v 键值对并设置存活时间，供 SETEX 与 PSETEX 使用
+func setWithTTL(db *DB, cmdName string, key string, rawTTL []byte, value []byte, unit time.Duration) resp.Reply {
+	ttl, err := strconv.ParseInt(string(rawTTL), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	if ttl <= 0 {
+		return invalidExpireTimeErr(cmdName)
+	}
+	expireTime, ok := expireTimeAfter(ttl, unit)
+	if !ok {
+		return invalidExpireTimeErr(cmdName)
+	}
+	db.PutEntity(key, &database.DataEntity{
+		Data: value,
+	})
+	db.Expire(key, expireTime)
+	db.addAof(utils.ToCmdLine3("set", []byte(key), value))
+	db.addAof(makeExpireCmd(key, expireTime))
+	db.notify(notifyString, "set", key)
+	db.notify(notifyGeneric, "expire", key)
+	return &reply.OkReply{}
+}
+
+// execSetEX 设置 k v 键值对以及以秒为单位的存活时间
+func execSetEX(db *DB, args [][]byte) resp.Reply {
+	return setWithTTL(db, "setex", string(args[0]), args[1], args[2], time.Second)
+}
+
+// execPSetEX 设置 k v 键值对以及以毫秒为单位的存活时间
+func execPSetEX(db *DB, args [][]byte) resp.Reply {
+	return setWithTTL(db, "psetex", string(args[0]), args[1], args[2], time.Millisecond)
 }
 
 // execSetNX 当给定 key 不存在时，才 set
@@ -95,9 +173,26 @@ func execSetNX(db *DB, args [][]byte) resp.Reply {
 	}
 	result := db.PutIfAbsent(key, entity)
 	db.addAof(utils.ToCmdLine2("setnx", args...))
+	if result > 0 {
+		db.notify(notifyString, "set", key)
+	}
 	return reply.MakeIntReply(int64(result))
 }
 
+func prepareMSet(args [][]byte) ([]string, []string) {
+	size := len(args) / 2
+	keys := make([]string, size)
+	for i := 0; i < size; i++ {
+		keys[i] = string(args[2*i])
+	}
+	return keys, nil
+}
+
+func undoMSet(db *DB, args [][]byte) []CmdLine {
+	writeKeys, _ := prepareMSet(args)
+	return rollbackGivenKeys(db, writeKeys...)
+}
+
 // execMSet 同时设置一个或多个 key-value 对
 func execMSet(db *DB, args [][]byte) resp.Reply {
 	if len(args)%2 != 0 {
@@ -115,6 +210,8 @@ func execMSet(db *DB, args [][]byte) resp.Reply {
 	for i, key := range keys {
 		value := values[i]
 		db.PutEntity(key, &database.DataEntity{Data: value})
+		db.Persist(key)
+		db.notify(notifyString, "set", key)
 	}
 	db.addAof(utils.ToCmdLine2("mset", args...))
 	return &reply.OkReply{}
@@ -169,6 +266,7 @@ func execMSetNX(db *DB, args [][]byte) resp.Reply {
 	for i, key := range keys {
 		value := values[i]
 		db.PutEntity(key, &database.DataEntity{Data: value})
+		db.notify(notifyString, "set", key)
 	}
 	db.addAof(utils.ToCmdLine2("msetnx", args...))
 	return reply.MakeIntReply(1)
@@ -184,6 +282,8 @@ func execGetSet(db *DB, args [][]byte) resp.Reply {
 		return err
 	}
 	db.PutEntity(key, &database.DataEntity{Data: value})
+	db.Persist(key)
+	db.notify(notifyString, "set", key)
 	if old == nil {
 		return new(reply.NullBulkReply)
 	}
@@ -208,12 +308,14 @@ func execIncr(db *DB, args [][]byte) resp.Reply {
 			Data: []byte(strconv.FormatInt(val+1, 10)),
 		})
 		db.addAof(utils.ToCmdLine2("incr", args...))
+		db.notify(notifyString, "incrby", key)
 		return reply.MakeIntReply(val + 1)
 	}
 	db.PutEntity(key, &database.DataEntity{
 		Data: []byte("1"),
 	})
 	db.addAof(utils.ToCmdLine2("incr", args...))
+	db.notify(notifyString, "incrby", key)
 	return reply.MakeIntReply(1)
 }
 
@@ -240,12 +342,14 @@ func execIncrBy(db *DB, args [][]byte) resp.Reply {
 			Data: []byte(strconv.FormatInt(val+delta, 10)),
 		})
 		db.addAof(utils.ToCmdLine2("incrby", args...))
+		db.notify(notifyString, "incrby", key)
 		return reply.MakeIntReply(val + delta)
 	}
 	db.PutEntity(key, &database.DataEntity{
 		Data: args[1],
 	})
 	db.addAof(utils.ToCmdLine2("incrby", args...))
+	db.notify(notifyString, "incrby", key)
 	return reply.MakeIntReply(delta)
 }
 
@@ -266,6 +370,7 @@ func execDecr(db *DB, args [][]byte) resp.Reply {
 			Data: []byte(strconv.FormatInt(val-1, 10)),
 		})
 		db.addAof(utils.ToCmdLine2("decr", args...))
+		db.notify(notifyString, "incrby", key)
 		return reply.MakeIntReply(val - 1)
 	}
 	entity := &database.DataEntity{
@@ -273,6 +378,7 @@ func execDecr(db *DB, args [][]byte) resp.Reply {
 	}
 	db.PutEntity(key, entity)
 	db.addAof(utils.ToCmdLine2("decr", args...))
+	db.notify(notifyString, "incrby", key)
 	return reply.MakeIntReply(-1)
 }
 
@@ -298,6 +404,7 @@ func execDecrBy(db *DB, args [][]byte) resp.Reply {
 			Data: []byte(strconv.FormatInt(val-delta, 10)),
 		})
 		db.addAof(utils.ToCmdLine2("decrby", args...))
+		db.notify(notifyString, "incrby", key)
 		return reply.MakeIntReply(val - delta)
 	}
 	valueStr := strconv.FormatInt(-delta, 10)
@@ -305,6 +412,7 @@ func execDecrBy(db *DB, args [][]byte) resp.Reply {
 		Data: []byte(valueStr),
 	})
 	db.addAof(utils.ToCmdLine2("decrby", args...))
+	db.notify(notifyString, "incrby", key)
 	return reply.MakeIntReply(-delta)
 }
 
@@ -333,6 +441,7 @@ func execAppend(db *DB, args [][]byte) resp.Reply {
 		Data: bytes,
 	})
 	db.addAof(utils.ToCmdLine2("append", args...))
+	db.notify(notifyString, "append", key)
 	return reply.MakeIntReply(int64(len(bytes)))
 }
 
@@ -349,6 +458,8 @@ func execSetRange(db *DB, args [][]byte) resp.Reply {
 	if err != nil {
 		return err
 	}
+	// 修改副本，已经返回给其它客户端的回复可能仍在使用原数组
+	bytes = append([]byte(nil), bytes...)
 	bytesLen := int64(len(bytes))
 	if bytesLen < offset {
 		diff := offset - bytesLen
@@ -368,6 +479,7 @@ func execSetRange(db *DB, args [][]byte) resp.Reply {
 		Data: bytes,
 	})
 	db.addAof(utils.ToCmdLine2("setRange", args...))
+	db.notify(notifyString, "setrange", key)
 	return reply.MakeIntReply(int64(len(bytes)))
 }
 
@@ -416,19 +528,21 @@ func execGetRange(db *DB, args [][]byte) resp.Reply {
 }
 
 func init() {
-	RegisterCommand("Set", execSet, -3)
-	RegisterCommand("SetNx", execSetNX, 3)
-	RegisterCommand("MSet", execMSet, -3)
-	RegisterCommand("MGet", execMGet, -2)
-	RegisterCommand("MSetNX", execMSetNX, -3)
-	RegisterCommand("Get", execGet, 2)
-	RegisterCommand("GetSet", execGetSet, 3)
-	RegisterCommand("Incr", execIncr, 2)
-	RegisterCommand("IncrBy", execIncrBy, 3)
-	RegisterCommand("Decr", execDecr, 2)
-	RegisterCommand("DecrBy", execDecrBy, 3)
-	RegisterCommand("StrLen", execStrLen, 2)
-	RegisterCommand("Append", execAppend, 3)
-	RegisterCommand("SetRange", execSetRange, 4)
-	RegisterCommand("GetRange", execGetRange, 4)
+	RegisterCommand("Set", execSet, writeFirstKey, rollbackFirstKey, -3)
+	RegisterCommand("SetNx", execSetNX, writeFirstKey, rollbackFirstKey, 3)
+	RegisterCommand("SetEX", execSetEX, writeFirstKey, rollbackFirstKey, 4)
+	RegisterCommand("PSetEX", execPSetEX, writeFirstKey, rollbackFirstKey, 4)
+	RegisterCommand("MSet", execMSet, prepareMSet, undoMSet, -3)
+	RegisterCommand("MGet", execMGet, readAllKeys, nil, -2)
+	RegisterCommand("MSetNX", execMSetNX, prepareMSet, undoMSet, -3)
+	RegisterCommand("Get", execGet, readFirstKey, nil, 2)
+	RegisterCommand("GetSet", execGetSet, writeFirstKey, rollbackFirstKey, 3)
+	RegisterCommand("Incr", execIncr, writeFirstKey, rollbackFirstKey, 2)
+	RegisterCommand("IncrBy", execIncrBy, writeFirstKey, rollbackFirstKey, 3)
+	RegisterCommand("Decr", execDecr, writeFirstKey, rollbackFirstKey, 2)
+	RegisterCommand("DecrBy", execDecrBy, writeFirstKey, rollbackFirstKey, 3)
+	RegisterCommand("StrLen", execStrLen, readFirstKey, nil, 2)
+	RegisterCommand("Append", execAppend, writeFirstKey, rollbackFirstKey, 3)
+	RegisterCommand("SetRange", execSetRange, writeFirstKey, rollbackFirstKey, 4)
+	RegisterCommand("GetRange", execGetRange, readFirstKey, nil, 4)
 }
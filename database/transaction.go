@@ -0,0 +1,209 @@
+package database
+
+import (
+	"fmt"
+	"github.com/jujunwang/Mudis/interface/resp"
+	"github.com/jujunwang/Mudis/lib/logger"
+	"github.com/jujunwang/Mudis/lib/utils"
+	"github.com/jujunwang/Mudis/resp/reply"
+	"runtime/debug"
+	"strings"
+)
+
+// forbiddenInMulti 中的命令无法回滚，不允许在事务中使用
+var forbiddenInMulti = map[string]struct{}{
+	"flushdb": {},
+}
+
+// notAllowedInMultiErrReply 是在事务中执行不能入队的命令时的回复
+var notAllowedInMultiErrReply = reply.MakeErrReply("ERR Command not allowed inside a transaction")
+
+// rejectInMulti 拒绝不能在事务中执行的命令，与其它入队失败的命令一样让 EXEC 放弃整个事务
+func rejectInMulti(conn resp.Connection) resp.Reply {
+	conn.AddTxError(notAllowedInMultiErrReply)
+	return notAllowedInMultiErrReply
+}
+
+// Watch 记录 key 的当前版本号，EXEC 时若版本号发生变化则放弃执行事务
+func Watch(db *DB, conn resp.Connection, args [][]byte) resp.Reply {
+	if conn.InMultiState() {
+		return reply.MakeErrReply("ERR WATCH inside MULTI is not allowed")
+	}
+	watching := conn.GetWatching()
+	for _, bkey := range args {
+		key := string(bkey)
+		watching[key] = db.GetVersion(key)
+	}
+	return reply.MakeOkReply()
+}
+
+// UnWatch 取消所有 WATCH 的 key
+func UnWatch(conn resp.Connection) resp.Reply {
+	watching := conn.GetWatching()
+	for key := range watching {
+		delete(watching, key)
+	}
+	return reply.MakeOkReply()
+}
+
+func isWatchingChanged(db *DB, watching map[string]uint32) bool {
+	for key, ver := range watching {
+		currentVersion := db.GetVersion(key)
+		if ver != currentVersion {
+			return true
+		}
+	}
+	return false
+}
+
+// StartMulti 开启事务
+func StartMulti(conn resp.Connection) resp.Reply {
+	if conn.InMultiState() {
+		return reply.MakeErrReply("ERR MULTI calls can not be nested")
+	}
+	conn.SetMultiState(true)
+	return reply.MakeOkReply()
+}
+
+// DiscardMulti 放弃事务
+func DiscardMulti(conn resp.Connection) resp.Reply {
+	if !conn.InMultiState() {
+		return reply.MakeErrReply("ERR DISCARD without MULTI")
+	}
+	conn.SetMultiState(false)
+	return reply.MakeOkReply()
+}
+
+// EnqueueCmd 检查命令后将其加入事务队列，出错的命令会导致 EXEC 放弃整个事务
+func EnqueueCmd(conn resp.Connection, cmdLine [][]byte) resp.Reply {
+	cmdName := strings.ToLower(string(cmdLine[0]))
+	cmd, ok := cmdTable[cmdName]
+	if !ok {
+		err := reply.MakeErrReply("ERR unknown command '" + cmdName + "'")
+		conn.AddTxError(err)
+		return err
+	}
+	if _, forbidden := forbiddenInMulti[cmdName]; forbidden {
+		err := reply.MakeErrReply("ERR command '" + cmdName + "' cannot be used in MULTI")
+		conn.AddTxError(err)
+		return err
+	}
+	if !validateArity(cmd.arity, cmdLine) {
+		err := reply.MakeArgNumErrReply(cmdName)
+		conn.AddTxError(err)
+		return err
+	}
+	conn.EnqueueCmd(cmdLine)
+	return reply.MakeQueuedReply()
+}
+
+func execMulti(db *DB, conn resp.Connection) resp.Reply {
+	if !conn.InMultiState() {
+		return reply.MakeErrReply("ERR EXEC without MULTI")
+	}
+	defer conn.SetMultiState(false)
+	if len(conn.GetTxErrors()) > 0 {
+		return reply.MakeErrReply("EXECABORT Transaction discarded because of previous errors.")
+	}
+	cmdLines := conn.GetQueuedCmdLine()
+	return db.ExecMulti(conn.GetWatching(), cmdLines)
+}
+
+// abortExec 在 EXEC 被拒绝时放弃事务，与 DISCARD 一样清空事务状态
+func abortExec(conn resp.Connection, errReply reply.ErrorReply) resp.Reply {
+	conn.SetMultiState(false)
+	return reply.MakeErrReply("EXECABORT Transaction discarded because of: " + errReply.Error())
+}
+
+// ExecMulti 原子地执行事务中的命令
+// 执行前对所有命令涉及的 key 加锁，任意命令出错或 panic 时按照 undo log 回滚已经执行的命令
+// 事务成功后所有写命令作为一个 MULTI ... EXEC 块写入 AOF
+func (db *DB) ExecMulti(watching map[string]uint32, cmdLines []CmdLine) resp.Reply {
+	writeKeys := make([]string, 0)
+	readKeys := make([]string, 0, len(watching))
+	for _, cmdLine := range cmdLines {
+		cmdName := strings.ToLower(string(cmdLine[0]))
+		cmd := cmdTable[cmdName]
+		write, read := cmd.prepare(cmdLine[1:])
+		writeKeys = append(writeKeys, write...)
+		readKeys = append(readKeys, read...)
+	}
+	for key := range watching {
+		readKeys = append(readKeys, key)
+	}
+	// 事务中产生的 AOF 命令和键空间通知先写入缓冲区，回滚时直接丢弃
+	buf := &cmdBuffer{}
+	result, done := db.execMultiLocked(watching, cmdLines, writeKeys, readKeys, buf)
+	db.flushEvents(buf)
+	return waitAof(done, result)
+}
+
+// execMultiLocked 加锁后执行事务，成功时在释放锁之前将事务写入 AOF
+func (db *DB) execMultiLocked(watching map[string]uint32, cmdLines []CmdLine,
+	writeKeys []string, readKeys []string, buf *cmdBuffer) (resp.Reply, <-chan error) {
+	db.RWLocks(writeKeys, readKeys)
+	defer db.RWUnLocks(writeKeys, readKeys)
+	// 无论提交还是回滚，都在释放锁之前重新估算写入的 key 的内存占用
+	defer db.updateMemory(writeKeys...)
+
+	if isWatchingChanged(db, watching) {
+		return reply.MakeNullMultiBulkReply(), nil
+	}
+
+	txDB := db.withBuffer(buf)
+
+	results := make([]resp.Reply, 0, len(cmdLines))
+	undoCmdLines := make([][]CmdLine, 0, len(cmdLines))
+	for _, cmdLine := range cmdLines {
+		undoCmdLines = append(undoCmdLines, db.GetUndoLogs(cmdLine))
+		result := txDB.execInTx(cmdLine)
+		if reply.IsErrorReply(result) {
+			txDB.rollback(undoCmdLines)
+			buf.discard()
+			return reply.MakeErrReply("EXECABORT Transaction rolled back because of error: " + errorMessage(result)), nil
+		}
+		results = append(results, result)
+	}
+	db.addVersion(writeKeys...)
+	if len(buf.aof) > 0 {
+		buf.aof = append(append([]CmdLine{utils.ToCmdLine("multi")}, buf.aof...), utils.ToCmdLine("exec"))
+	}
+	return reply.MakeMultiRawReply(results), db.flushAof(buf)
+}
+
+// execInTx 执行事务中的一条命令，将 panic 转换为错误回复
+func (db *DB) execInTx(cmdLine CmdLine) (result resp.Reply) {
+	defer func() {
+		if err := recover(); err != nil {
+			logger.Warn(fmt.Sprintf("error occurs: %v\n%s", err, string(debug.Stack())))
+			result = &reply.UnknownErrReply{}
+		}
+	}()
+	return db.execWithLock(cmdLine)
+}
+
+// rollback 逆序执行 undo log
+func (db *DB) rollback(undoCmdLines [][]CmdLine) {
+	for i := len(undoCmdLines) - 1; i >= 0; i-- {
+		for _, cmdLine := range undoCmdLines[i] {
+			db.execWithLock(cmdLine)
+		}
+	}
+}
+
+// GetUndoLogs 返回撤销给定命令所需的命令
+func (db *DB) GetUndoLogs(cmdLine [][]byte) []CmdLine {
+	cmdName := strings.ToLower(string(cmdLine[0]))
+	cmd, ok := cmdTable[cmdName]
+	if !ok || cmd.undo == nil {
+		return nil
+	}
+	return cmd.undo(db, cmdLine[1:])
+}
+
+func errorMessage(r resp.Reply) string {
+	if errReply, ok := r.(reply.ErrorReply); ok {
+		return errReply.Error()
+	}
+	return strings.TrimSpace(strings.TrimPrefix(string(r.ToBytes()), "-"))
+}
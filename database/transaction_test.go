@@ -0,0 +1,226 @@
+package database
+
+import (
+	"github.com/jujunwang/Mudis/config"
+	"github.com/jujunwang/Mudis/interface/resp"
+	"github.com/jujunwang/Mudis/lib/utils"
+	"github.com/jujunwang/Mudis/resp/connection"
+	"github.com/jujunwang/Mudis/resp/reply"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// execTx 在 conn 上执行 MULTI、cmdLines 和 EXEC，返回 EXEC 的回复
+func execTx(db *DB, conn resp.Connection, cmdLines ...CmdLine) resp.Reply {
+	db.Exec(conn, utils.ToCmdLine("multi"))
+	for _, cmdLine := range cmdLines {
+		db.Exec(conn, cmdLine)
+	}
+	return db.Exec(conn, utils.ToCmdLine("exec"))
+}
+
+func TestWatch(t *testing.T) {
+	tests := []struct {
+		name string
+		// prepare 在 WATCH 之前执行
+		prepare []CmdLine
+		// watch 是 WATCH 的 key
+		watch string
+		// change 在 WATCH 之后、EXEC 之前执行
+		change func(db *DB)
+		// aborted 表示 EXEC 应当放弃执行事务
+		aborted bool
+	}{
+		{
+			name:  "missing key survives expiry of another key",
+			watch: "a",
+			prepare: []CmdLine{
+				utils.ToCmdLine("set", "b", "1", "px", "1"),
+			},
+			change: func(db *DB) {
+				time.Sleep(5 * time.Millisecond)
+				db.Exec(nil, utils.ToCmdLine("get", "b"))
+			},
+		},
+		{
+			name:  "existing key survives active expiry of another key",
+			watch: "a",
+			prepare: []CmdLine{
+				utils.ToCmdLine("set", "a", "1"),
+				utils.ToCmdLine("set", "b", "1", "px", "1"),
+			},
+			change: func(db *DB) {
+				time.Sleep(5 * time.Millisecond)
+				db.activeExpireCycle()
+			},
+		},
+		{
+			name:  "missing key survives delete of another key",
+			watch: "a",
+			prepare: []CmdLine{
+				utils.ToCmdLine("set", "b", "1"),
+			},
+			change: func(db *DB) {
+				db.Exec(nil, utils.ToCmdLine("del", "b"))
+			},
+		},
+		{
+			name:  "expiry of watched key",
+			watch: "a",
+			prepare: []CmdLine{
+				utils.ToCmdLine("set", "a", "1", "px", "1"),
+			},
+			change: func(db *DB) {
+				time.Sleep(5 * time.Millisecond)
+				db.Exec(nil, utils.ToCmdLine("get", "a"))
+			},
+			aborted: true,
+		},
+		{
+			name:  "delete of watched key",
+			watch: "a",
+			prepare: []CmdLine{
+				utils.ToCmdLine("set", "a", "1"),
+			},
+			change: func(db *DB) {
+				db.Exec(nil, utils.ToCmdLine("del", "a"))
+			},
+			aborted: true,
+		},
+		{
+			name:  "write of watched missing key",
+			watch: "a",
+			change: func(db *DB) {
+				db.Exec(nil, utils.ToCmdLine("set", "a", "1"))
+			},
+			aborted: true,
+		},
+		{
+			name:  "flushdb",
+			watch: "a",
+			change: func(db *DB) {
+				db.Exec(nil, utils.ToCmdLine("flushdb"))
+			},
+			aborted: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := makeDB()
+			for _, cmdLine := range tt.prepare {
+				db.Exec(nil, cmdLine)
+			}
+			conn := &connection.FakeConn{}
+			db.Exec(conn, utils.ToCmdLine("watch", tt.watch))
+			tt.change(db)
+			result := execTx(db, conn, utils.ToCmdLine("set", "c", "1"))
+			_, aborted := result.(*reply.NullMultiBulkReply)
+			if aborted != tt.aborted {
+				t.Fatalf("aborted = %v, want %v, reply %q", aborted, tt.aborted, result.ToBytes())
+			}
+		})
+	}
+}
+
+// TestExecRejectedByOOM EXEC 因为内存超过上限被拒绝时，事务被放弃，之后的命令不会再入队
+func TestExecRejectedByOOM(t *testing.T) {
+	mdb := makeStandaloneDatabase()
+	defer func() { _ = config.Set("maxmemory", "0") }()
+	conn := &connection.FakeConn{}
+	mdb.Exec(conn, utils.ToCmdLine("set", "k", "v"))
+	mdb.Exec(conn, utils.ToCmdLine("multi"))
+	mdb.Exec(conn, utils.ToCmdLine("set", "a", "1"))
+	if err := config.Set("maxmemory", "1"); err != nil {
+		t.Fatal(err)
+	}
+	result := mdb.Exec(conn, utils.ToCmdLine("exec"))
+	if want := "-EXECABORT Transaction discarded because of: OOM"; !strings.HasPrefix(string(result.ToBytes()), want) {
+		t.Fatalf("exec reply %q, want prefix %q", result.ToBytes(), want)
+	}
+	if conn.InMultiState() {
+		t.Fatal("connection still in MULTI after rejected EXEC")
+	}
+	result = mdb.Exec(conn, utils.ToCmdLine("get", "a"))
+	if _, ok := result.(*reply.NullBulkReply); !ok {
+		t.Fatalf("get reply %q, want nil", result.ToBytes())
+	}
+}
+
+// TestExecRejectedByAofWriteErr EXEC 因为写入 AOF 失败被拒绝时，事务被放弃，之后的命令不会再入队
+func TestExecRejectedByAofWriteErr(t *testing.T) {
+	if _, err := os.Stat("/dev/full"); err != nil {
+		t.Skip("/dev/full is not available")
+	}
+	dir := t.TempDir()
+	defer func(old string) { _ = config.Set("appenddirname", old) }(config.Properties().AppendDirName)
+	defer func(old string) { _ = config.Set("appendfilename", old) }(config.Properties().AppendFilename)
+	if err := config.Set("appenddirname", dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.Set("appendfilename", "appendonly.aof"); err != nil {
+		t.Fatal(err)
+	}
+	// 写入 /dev/full 总是返回 ENOSPC
+	if err := os.Symlink("/dev/full", filepath.Join(dir, "appendonly.aof.1.incr.aof")); err != nil {
+		t.Fatal(err)
+	}
+	mdb := makeStandaloneDatabase()
+	if err := mdb.SetAppendOnly(true); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = mdb.SetAppendOnly(false) }()
+	conn := &connection.FakeConn{}
+	mdb.Exec(conn, utils.ToCmdLine("multi"))
+	mdb.Exec(conn, utils.ToCmdLine("set", "a", "1"))
+	// 入队之后写入 AOF 失败
+	mdb.Exec(&connection.FakeConn{}, utils.ToCmdLine("set", "k", "v"))
+	deadline := time.Now().Add(time.Second)
+	for mdb.aof().LastWriteErr() == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("AOF write did not fail")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	result := mdb.Exec(conn, utils.ToCmdLine("exec"))
+	if want := "-EXECABORT Transaction discarded because of: MISCONF"; !strings.HasPrefix(string(result.ToBytes()), want) {
+		t.Fatalf("exec reply %q, want prefix %q", result.ToBytes(), want)
+	}
+	result = mdb.Exec(conn, utils.ToCmdLine("get", "a"))
+	if _, ok := result.(*reply.NullBulkReply); !ok {
+		t.Fatalf("get reply %q, want nil", result.ToBytes())
+	}
+}
+
+func TestServerCommandsInMulti(t *testing.T) {
+	mdb := makeStandaloneDatabase()
+	conn := &connection.FakeConn{}
+	cmdLines := []CmdLine{
+		utils.ToCmdLine("select", "1"),
+		utils.ToCmdLine("slowlog", "len"),
+		utils.ToCmdLine("save"),
+		utils.ToCmdLine("bgsave"),
+		utils.ToCmdLine("lastsave"),
+		utils.ToCmdLine("bgrewriteaof"),
+		utils.ToCmdLine("publish", "ch", "msg"),
+		utils.ToCmdLine("subscribe", "ch"),
+	}
+	for _, cmdLine := range cmdLines {
+		mdb.Exec(conn, utils.ToCmdLine("multi"))
+		mdb.Exec(conn, utils.ToCmdLine("set", "a", "1"))
+		result := mdb.Exec(conn, cmdLine)
+		if want := "-ERR Command not allowed inside a transaction\r\n"; string(result.ToBytes()) != want {
+			t.Fatalf("%s in MULTI = %q, want %q", cmdLine[0], result.ToBytes(), want)
+		}
+		result = mdb.Exec(conn, utils.ToCmdLine("exec"))
+		if want := "-EXECABORT"; !strings.HasPrefix(string(result.ToBytes()), want) {
+			t.Fatalf("EXEC after %s = %q, want prefix %q", cmdLine[0], result.ToBytes(), want)
+		}
+	}
+	result := mdb.Exec(conn, utils.ToCmdLine("get", "a"))
+	if _, ok := result.(*reply.NullBulkReply); !ok {
+		t.Fatalf("get reply %q, want nil", result.ToBytes())
+	}
+}
@@ -0,0 +1,49 @@
+package database
+
+import (
+	"github.com/jujunwang/Mudis/aof"
+	"github.com/jujunwang/Mudis/lib/utils"
+)
+
+/* ---- 常用的 UndoFunc ---- */
+
+// rollbackGivenKeys 返回将给定 key 恢复为当前状态(包括过期时间)的命令
+func rollbackGivenKeys(db *DB, keys ...string) []CmdLine {
+	var undoCmdLines []CmdLine
+	for _, key := range keys {
+		entity, ok := db.GetEntity(key)
+		if !ok {
+			undoCmdLines = append(undoCmdLines, utils.ToCmdLine("DEL", key))
+			continue
+		}
+		undoCmdLines = append(undoCmdLines, utils.ToCmdLine("DEL", key))
+		undoCmdLines = append(undoCmdLines, aof.EntityToCmds(key, entity)...)
+		if expireTime, ok := db.ExpireTime(key); ok {
+			undoCmdLines = append(undoCmdLines, makeExpireCmd(key, expireTime))
+		}
+	}
+	return undoCmdLines
+}
+
+// rollbackPreparedKeys 恢复 prepare 返回的所有写 key
+func rollbackPreparedKeys(prepare PreFunc) UndoFunc {
+	return func(db *DB, args [][]byte) []CmdLine {
+		writeKeys, _ := prepare(args)
+		return rollbackGivenKeys(db, writeKeys...)
+	}
+}
+
+// rollbackFirstKey 恢复第一个 key
+func rollbackFirstKey(db *DB, args [][]byte) []CmdLine {
+	key := string(args[0])
+	return rollbackGivenKeys(db, key)
+}
+
+// rollbackAllKeys 恢复所有参数对应的 key
+func rollbackAllKeys(db *DB, args [][]byte) []CmdLine {
+	keys := make([]string, len(args))
+	for i, v := range args {
+		keys[i] = string(v)
+	}
+	return rollbackGivenKeys(db, keys...)
+}
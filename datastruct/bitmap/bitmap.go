@@ -0,0 +1,77 @@
+package bitmap
+
+// BitMap 是按位访问的字节数组，与 redis 一致，偏移量 0 是第一个字节的最高位
+type BitMap []byte
+
+// New 新建一个空的 BitMap
+func New() *BitMap {
+	b := BitMap(make([]byte, 0))
+	return &b
+}
+
+// FromBytes 直接使用给定的字节数组，修改 BitMap 会修改原数组
+func FromBytes(bytes []byte) *BitMap {
+	bm := BitMap(bytes)
+	return &bm
+}
+
+// ToBytes 返回底层的字节数组
+func (b *BitMap) ToBytes() []byte {
+	return *b
+}
+
+// BitSize 返回位数
+func (b *BitMap) BitSize() int64 {
+	return int64(len(*b)) * 8
+}
+
+// grow 扩展字节数组使其至少包含 bitSize 位，新增的位为 0
+func (b *BitMap) grow(bitSize int64) {
+	byteSize := (bitSize + 7) / 8
+	gap := byteSize - int64(len(*b))
+	if gap <= 0 {
+		return
+	}
+	*b = append(*b, make([]byte, gap)...)
+}
+
+// GetBit 返回 offset 处的位，超出范围时返回 0
+func (b *BitMap) GetBit(offset int64) byte {
+	byteIndex := offset / 8
+	if byteIndex >= int64(len(*b)) {
+		return 0
+	}
+	bitOffset := 7 - offset%8
+	return ((*b)[byteIndex] >> bitOffset) & 0x01
+}
+
+// SetBit 设置 offset 处的位，必要时扩展字节数组
+func (b *BitMap) SetBit(offset int64, val byte) {
+	b.grow(offset + 1)
+	byteIndex := offset / 8
+	bitOffset := 7 - offset%8
+	mask := byte(1 << bitOffset)
+	if val > 0 {
+		(*b)[byteIndex] |= mask
+	} else {
+		(*b)[byteIndex] &^= mask
+	}
+}
+
+// GetBits 从 offset 开始按照高位在前读取 width 位无符号整数，width 不超过 64
+func (b *BitMap) GetBits(offset int64, width int) uint64 {
+	var result uint64
+	for i := 0; i < width; i++ {
+		result = result<<1 | uint64(b.GetBit(offset+int64(i)))
+	}
+	return result
+}
+
+// SetBits 从 offset 开始按照高位在前写入 value 的低 width 位，width 不超过 64
+func (b *BitMap) SetBits(offset int64, width int, value uint64) {
+	b.grow(offset + int64(width))
+	for i := 0; i < width; i++ {
+		bit := byte(value>>uint(width-1-i)) & 0x01
+		b.SetBit(offset+int64(i), bit)
+	}
+}
@@ -15,5 +15,9 @@ type Dict interface {
 	Keys() []string
 	RandomKeys(limit int) []string
 	RandomDistinctKeys(limit int) []string
+	// Scan 从 cursor 开始遍历大约 count 个 key，返回遍历到的 key 和下一次遍历的 cursor
+	// cursor 为 0 表示从头开始，返回的 cursor 为 0 表示遍历结束
+	// 在整个遍历过程中一直存在的 key 一定会被返回，并且只返回一次
+	Scan(cursor uint64, count int) (keys []string, nextCursor uint64)
 	Clear()
 }
@@ -0,0 +1,111 @@
+package dict
+
+import (
+	"math"
+	"math/bits"
+)
+
+const (
+	// minScanBuckets 是 scanIndex 的初始桶数
+	minScanBuckets = 4
+	// scanEmptyVisits 是每个 count 最多访问的空桶数，避免在稀疏的表上一次遍历过多的空桶
+	scanEmptyVisits = 10
+	// maxScanCapacity 是预先分配的结果容量的上限，COUNT 可能远大于实际的 key 数
+	maxScanCapacity = 1024
+)
+
+// scanLimits 返回一次遍历预先分配的结果容量和最多访问的桶数
+func scanLimits(count int) (capacity int, maxVisits int) {
+	capacity = count
+	if capacity > maxScanCapacity {
+		capacity = maxScanCapacity
+	}
+	maxVisits = math.MaxInt
+	if count <= math.MaxInt/scanEmptyVisits {
+		maxVisits = count * scanEmptyVisits
+	}
+	return capacity, maxVisits
+}
+
+// scanIndex 按照 key 的哈希值把 key 分到 2 的幂个桶中，用于增量地遍历字典
+// 桶数只增不减(字典清空时重置)，配合反向二进制的 cursor，扩容前后遍历都不会遗漏或重复返回 key
+type scanIndex struct {
+	buckets [][]string
+	size    int
+}
+
+func makeScanIndex() *scanIndex {
+	return &scanIndex{
+		buckets: make([][]string, minScanBuckets),
+	}
+}
+
+func (idx *scanIndex) mask() uint64 {
+	return uint64(len(idx.buckets) - 1)
+}
+
+// add 记录新插入的 key，调用方需要保证 key 之前不存在
+func (idx *scanIndex) add(key string) {
+	idx.size++
+	if idx.size > len(idx.buckets) {
+		idx.grow()
+	}
+	i := uint64(fnv32(key)) & idx.mask()
+	idx.buckets[i] = append(idx.buckets[i], key)
+}
+
+// remove 删除已经记录的 key
+func (idx *scanIndex) remove(key string) {
+	i := uint64(fnv32(key)) & idx.mask()
+	bucket := idx.buckets[i]
+	for j, k := range bucket {
+		if k == key {
+			last := len(bucket) - 1
+			bucket[j] = bucket[last]
+			bucket[last] = ""
+			idx.buckets[i] = bucket[:last]
+			idx.size--
+			break
+		}
+	}
+	if idx.size == 0 && len(idx.buckets) > minScanBuckets {
+		// 字典为空时不存在遍历期间一直存在的 key，可以放心地缩小
+		idx.buckets = make([][]string, minScanBuckets)
+	}
+}
+
+// grow 把桶数翻倍
+func (idx *scanIndex) grow() {
+	buckets := make([][]string, 2*len(idx.buckets))
+	mask := uint64(len(buckets) - 1)
+	for _, bucket := range idx.buckets {
+		for _, key := range bucket {
+			i := uint64(fnv32(key)) & mask
+			buckets[i] = append(buckets[i], key)
+		}
+	}
+	idx.buckets = buckets
+}
+
+// scan 从 cursor 指向的桶开始遍历，直到返回了至少 count 个 key 或者访问了过多的空桶
+func (idx *scanIndex) scan(cursor uint64, count int) ([]string, uint64) {
+	mask := idx.mask()
+	capacity, maxVisits := scanLimits(count)
+	keys := make([]string, 0, capacity)
+	for visits := 0; ; visits++ {
+		keys = append(keys, idx.buckets[cursor&mask]...)
+		cursor = nextScanCursor(cursor, mask)
+		if cursor == 0 || len(keys) >= count || visits >= maxVisits {
+			return keys, cursor
+		}
+	}
+}
+
+// nextScanCursor 以反向二进制的方式递增 cursor 的低位，即从高位开始加一，
+// 这样桶数翻倍后，已经访问过的桶拆分出的桶都排在 cursor 之前，不会被再次访问
+func nextScanCursor(cursor uint64, mask uint64) uint64 {
+	cursor |= ^mask
+	cursor = bits.Reverse64(cursor)
+	cursor++
+	return bits.Reverse64(cursor)
+}
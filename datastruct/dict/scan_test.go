@@ -0,0 +1,121 @@
+package dict
+
+import (
+	"strconv"
+	"testing"
+)
+
+// scanAll 用 count 遍历 d，每次遍历之后调用 between，返回每个 key 被返回的次数
+func scanAll(t *testing.T, d Dict, count int, between func(round int)) map[string]int {
+	seen := make(map[string]int)
+	cursor := uint64(0)
+	for round := 0; ; round++ {
+		if round > 100000 {
+			t.Fatal("scan did not finish")
+		}
+		keys, next := d.Scan(cursor, count)
+		if len(keys) > 4*count {
+			t.Fatalf("round %d returned %d keys with count %d", round, len(keys), count)
+		}
+		for _, key := range keys {
+			seen[key]++
+		}
+		if next == 0 {
+			return seen
+		}
+		cursor = next
+		between(round)
+	}
+}
+
+func TestScan(t *testing.T) {
+	dicts := []struct {
+		name string
+		make func() Dict
+	}{
+		{"simple", func() Dict { return MakeSimple() }},
+		{"concurrent", func() Dict { return MakeConcurrent(1024) }},
+		// 分段很大时 COUNT 仍然限制每次返回的 key 数
+		{"concurrent large shards", func() Dict { return MakeConcurrent(4) }},
+	}
+	for _, tt := range dicts {
+		t.Run(tt.name, func(t *testing.T) {
+			d := tt.make()
+			for i := 0; i < 1000; i++ {
+				d.Put("k"+strconv.Itoa(i), nil)
+				d.Put("tmp"+strconv.Itoa(i), nil)
+			}
+			// 遍历期间插入新的 key 使 SimpleDict 扩容，并删除一部分 key
+			added := 0
+			seen := scanAll(t, d, 10, func(round int) {
+				for i := 0; i < 5; i++ {
+					d.Put("new"+strconv.Itoa(added), nil)
+					added++
+				}
+				d.Remove("tmp" + strconv.Itoa(round))
+			})
+			for i := 0; i < 1000; i++ {
+				key := "k" + strconv.Itoa(i)
+				if seen[key] != 1 {
+					t.Fatalf("%s returned %d times", key, seen[key])
+				}
+			}
+			for key, n := range seen {
+				if n != 1 {
+					t.Fatalf("%s returned %d times", key, n)
+				}
+			}
+		})
+	}
+}
+
+func TestScanEmptyShrink(t *testing.T) {
+	d := MakeSimple()
+	for i := 0; i < 100; i++ {
+		d.Put(strconv.Itoa(i), nil)
+	}
+	_, cursor := d.Scan(0, 10)
+	for i := 0; i < 100; i++ {
+		d.Remove(strconv.Itoa(i))
+	}
+	d.Put("a", nil)
+	// 缩小之后旧的 cursor 仍然可以继续遍历直到结束
+	seen := make(map[string]int)
+	for rounds := 0; cursor != 0; rounds++ {
+		if rounds > 100 {
+			t.Fatal("scan did not finish")
+		}
+		var keys []string
+		keys, cursor = d.Scan(cursor, 10)
+		for _, key := range keys {
+			seen[key]++
+		}
+	}
+	if seen["a"] > 1 {
+		t.Fatalf("a returned %d times", seen["a"])
+	}
+}
+
+func TestConcurrentScanAndClear(t *testing.T) {
+	d := MakeConcurrent(16)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			d.Clear()
+			d.Put("k"+strconv.Itoa(i), nil)
+		}
+	}()
+	cursor := uint64(0)
+	for {
+		select {
+		case <-done:
+			if d.Len() != 1 {
+				t.Fatalf("Len() = %d after clear, want 1", d.Len())
+			}
+			return
+		default:
+		}
+		_, cursor = d.Scan(cursor, 10)
+	}
+}
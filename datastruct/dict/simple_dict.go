@@ -3,6 +3,8 @@ package dict
 // SimpleDict 封装一个map，它不是线程安全的
 type SimpleDict struct {
 	m map[string]interface{}
+	// index 在第一次 Scan 时创建，之后随着插入和删除更新，不遍历的字典没有额外的开销
+	index *scanIndex
 }
 
 // MakeSimple 新建一个map
@@ -33,6 +35,9 @@ func (dict *SimpleDict) Put(key string, val interface{}) (result int) {
 	if existed {
 		return 0
 	}
+	if dict.index != nil {
+		dict.index.add(key)
+	}
 	return 1
 }
 
@@ -43,6 +48,9 @@ func (dict *SimpleDict) PutIfAbsent(key string, val interface{}) (result int) {
 		return 0
 	}
 	dict.m[key] = val
+	if dict.index != nil {
+		dict.index.add(key)
+	}
 	return 1
 }
 
@@ -61,6 +69,9 @@ func (dict *SimpleDict) Remove(key string) (result int) {
 	_, existed := dict.m[key]
 	delete(dict.m, key)
 	if existed {
+		if dict.index != nil {
+			dict.index.remove(key)
+		}
 		return 1
 	}
 	return 0
@@ -115,6 +126,17 @@ func (dict *SimpleDict) RandomDistinctKeys(limit int) []string {
 	return result
 }
 
+// Scan 遍历大约 count 个 key，cursor 是反向二进制递增的桶下标
+func (dict *SimpleDict) Scan(cursor uint64, count int) ([]string, uint64) {
+	if dict.index == nil {
+		dict.index = makeScanIndex()
+		for key := range dict.m {
+			dict.index.add(key)
+		}
+	}
+	return dict.index.scan(cursor, count)
+}
+
 // Clear 清空dict中的key
 func (dict *SimpleDict) Clear() {
 	*dict = *MakeSimple()
@@ -15,7 +15,9 @@ type ConcurrentDict struct {
 }
 
 type shard struct {
-	m     map[string]interface{}
+	m map[string]interface{}
+	// index 在第一次 Scan 该分段时创建，之后随着插入和删除更新
+	index *scanIndex
 	mutex sync.RWMutex
 }
 
@@ -116,6 +118,9 @@ func (dict *ConcurrentDict) Put(key string, val interface{}) (result int) {
 		return 0
 	}
 	shard.m[key] = val
+	if shard.index != nil {
+		shard.index.add(key)
+	}
 	dict.addCount()
 	return 1
 }
@@ -135,6 +140,9 @@ func (dict *ConcurrentDict) PutIfAbsent(key string, val interface{}) (result int
 		return 0
 	}
 	shard.m[key] = val
+	if shard.index != nil {
+		shard.index.add(key)
+	}
 	dict.addCount()
 	return 1
 }
@@ -170,6 +178,9 @@ func (dict *ConcurrentDict) Remove(key string) (result int) {
 
 	if _, ok := shard.m[key]; ok {
 		delete(shard.m, key)
+		if shard.index != nil {
+			shard.index.remove(key)
+		}
 		dict.decreaseCount()
 		return 1
 	}
@@ -245,6 +256,10 @@ func (dict *ConcurrentDict) RandomKeys(limit int) []string {
 
 	result := make([]string, limit)
 	for i := 0; i < limit; {
+		// 其它 goroutine 可能在抽样期间删除 key，字典为空时提前返回
+		if dict.Len() == 0 {
+			return result[:i]
+		}
 		shard := dict.getShard(uint32(rand.Intn(shardCount)))
 		if shard == nil {
 			continue
@@ -267,7 +282,7 @@ func (dict *ConcurrentDict) RandomDistinctKeys(limit int) []string {
 
 	shardCount := len(dict.table)
 	result := make(map[string]bool)
-	for len(result) < limit {
+	for len(result) < limit && len(result) < dict.Len() {
 		shardIndex := uint32(rand.Intn(shardCount))
 		shard := dict.getShard(shardIndex)
 		if shard == nil {
@@ -278,7 +293,7 @@ func (dict *ConcurrentDict) RandomDistinctKeys(limit int) []string {
 			result[key] = true
 		}
 	}
-	arr := make([]string, limit)
+	arr := make([]string, len(result))
 	i := 0
 	for k := range result {
 		arr[i] = k
@@ -287,7 +302,56 @@ func (dict *ConcurrentDict) RandomDistinctKeys(limit int) []string {
 	return arr
 }
 
-// Clear 清空 dict
+// Scan 按照分段遍历字典，cursor 的高 32 位是分段下标，低 32 位是分段内反向二进制递增的桶下标
+// 分段数固定不变，遍历期间一直存在的 key 只会出现在同一个分段中；每次只对一个分段加锁，不会长时间阻塞写入
+func (dict *ConcurrentDict) Scan(cursor uint64, count int) ([]string, uint64) {
+	if dict == nil {
+		panic("dict is nil")
+	}
+	shardIndex := cursor >> 32
+	position := cursor & math.MaxUint32
+	capacity, maxVisits := scanLimits(count)
+	keys := make([]string, 0, capacity)
+	for visits := 0; shardIndex < uint64(len(dict.table)); visits++ {
+		if len(keys) >= count || visits >= maxVisits {
+			return keys, shardIndex<<32 | position
+		}
+		shardKeys, next := dict.table[shardIndex].scan(position, count-len(keys))
+		keys = append(keys, shardKeys...)
+		if next != 0 {
+			return keys, shardIndex<<32 | next
+		}
+		shardIndex++
+		position = 0
+	}
+	return keys, 0
+}
+
+// scan 从 position 开始遍历分段中大约 count 个 key，返回的 next 为 0 表示分段遍历完毕
+func (shard *shard) scan(position uint64, count int) (keys []string, next uint64) {
+	// 创建 index 需要写锁，空的分段不创建 index
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+	if len(shard.m) == 0 {
+		return nil, 0
+	}
+	if shard.index == nil {
+		shard.index = makeScanIndex()
+		for key := range shard.m {
+			shard.index.add(key)
+		}
+	}
+	return shard.index.scan(position, count)
+}
+
+// Clear 在各个分段的锁内清空 dict，不替换 table，不加锁的遍历不会与清空发生数据竞争
 func (dict *ConcurrentDict) Clear() {
-	*dict = *MakeConcurrent(dict.shardCount)
+	for _, shard := range dict.table {
+		shard.mutex.Lock()
+		n := len(shard.m)
+		shard.m = make(map[string]interface{})
+		shard.index = nil
+		atomic.AddInt32(&dict.count, -int32(n))
+		shard.mutex.Unlock()
+	}
 }
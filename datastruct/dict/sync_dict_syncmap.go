@@ -116,6 +116,11 @@ func (dict *SyncDict) RandomDistinctKeys(limit int) []string {
 	return result
 }
 
+// Scan 一次返回所有 key，sync.Map 无法按位置遍历，因此不支持增量遍历
+func (dict *SyncDict) Scan(cursor uint64, count int) ([]string, uint64) {
+	return dict.Keys(), 0
+}
+
 // Clear 清空 dict
 func (dict *SyncDict) Clear() {
 	*dict = *MakeSyncDict()
@@ -0,0 +1,354 @@
+// Package hyperloglog 实现了与 redis 字节布局兼容的 HyperLogLog
+//
+// 字符串格式为 16 字节的头部加上寄存器:
+//
+//	+------+---+-----+----------+
+//	| HYLL | E | N/U | Cardin.  |
+//	+------+---+-----+----------+
+//
+// E 为编码方式(0 dense, 1 sparse)，Cardin. 为小端序的基数缓存，最高字节的最高位为 1 表示缓存失效
+package hyperloglog
+
+import (
+	"errors"
+	"math"
+	"math/bits"
+)
+
+const (
+	hllP           = 14
+	hllQ           = 64 - hllP
+	hllRegisters   = 1 << hllP
+	hllPMask       = hllRegisters - 1
+	hllBits        = 6
+	hllRegisterMax = 1<<hllBits - 1
+	hllHdrSize     = 16
+	hllDenseSize   = hllHdrSize + (hllRegisters*hllBits+7)/8
+	hllAlphaInf    = 0.721347520444481703680
+
+	encodingDense  = 0
+	encodingSparse = 1
+
+	// sparse 编码的操作码
+	sparseZeroMaxLen  = 64
+	sparseXZeroMaxLen = 16384
+	sparseValMaxValue = 32
+	sparseValMaxLen   = 4
+
+	// SparseMaxBytes 超过这个长度时 sparse 编码会转换为 dense 编码
+	SparseMaxBytes = 3000
+
+	hashSeed = 0xadc83b19
+)
+
+var magic = []byte("HYLL")
+
+// ErrInvalid 表示字符串不是合法的 HyperLogLog
+var ErrInvalid = errors.New("invalid hyperloglog")
+
+// HyperLogLog 保存解码后的寄存器
+type HyperLogLog struct {
+	registers [hllRegisters]uint8
+	// dense 编码不会再转换回 sparse 编码
+	dense bool
+}
+
+// New 新建一个空的 sparse 编码的 HyperLogLog
+func New() *HyperLogLog {
+	return &HyperLogLog{}
+}
+
+// IsHyperLogLog 检查字符串头部是否为 HyperLogLog
+func IsHyperLogLog(data []byte) bool {
+	return len(data) >= hllHdrSize && string(data[:4]) == string(magic)
+}
+
+// Parse 解码 redis 格式的 HyperLogLog 字符串
+func Parse(data []byte) (*HyperLogLog, error) {
+	if !IsHyperLogLog(data) {
+		return nil, ErrInvalid
+	}
+	h := &HyperLogLog{}
+	switch data[4] {
+	case encodingDense:
+		if len(data) != hllDenseSize {
+			return nil, ErrInvalid
+		}
+		h.dense = true
+		registers := data[hllHdrSize:]
+		for i := 0; i < hllRegisters; i++ {
+			h.registers[i] = getDenseRegister(registers, i)
+		}
+	case encodingSparse:
+		if err := h.decodeSparse(data[hllHdrSize:]); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, ErrInvalid
+	}
+	return h, nil
+}
+
+func getDenseRegister(registers []byte, index int) uint8 {
+	byteIndex := index * hllBits / 8
+	fb := uint(index * hllBits & 7)
+	b0 := uint(registers[byteIndex])
+	var b1 uint
+	if byteIndex+1 < len(registers) {
+		b1 = uint(registers[byteIndex+1])
+	}
+	return uint8((b0>>fb | b1<<(8-fb)) & hllRegisterMax)
+}
+
+func setDenseRegister(registers []byte, index int, val uint8) {
+	byteIndex := index * hllBits / 8
+	fb := uint(index * hllBits & 7)
+	v := uint(val)
+	registers[byteIndex] &^= byte(hllRegisterMax << fb)
+	registers[byteIndex] |= byte(v << fb)
+	if byteIndex+1 < len(registers) {
+		registers[byteIndex+1] &^= byte(hllRegisterMax >> (8 - fb))
+		registers[byteIndex+1] |= byte(v >> (8 - fb))
+	}
+}
+
+// decodeSparse 解码 sparse 编码:
+// ZERO 00xxxxxx 表示 xxxxxx+1 个值为 0 的寄存器
+// XZERO 01xxxxxx yyyyyyyy 表示 xxxxxxyyyyyyyy+1 个值为 0 的寄存器
+// VAL 1vvvvvxx 表示 xx+1 个值为 vvvvv+1 的寄存器
+func (h *HyperLogLog) decodeSparse(data []byte) error {
+	index := 0
+	for i := 0; i < len(data); {
+		op := data[i]
+		switch {
+		case op&0xc0 == 0x00:
+			index += int(op&0x3f) + 1
+			i++
+		case op&0xc0 == 0x40:
+			if i+1 >= len(data) {
+				return ErrInvalid
+			}
+			index += (int(op&0x3f)<<8 | int(data[i+1])) + 1
+			i += 2
+		default:
+			runLen := int(op&0x3) + 1
+			val := (op>>2)&0x1f + 1
+			if index+runLen > hllRegisters {
+				return ErrInvalid
+			}
+			for j := 0; j < runLen; j++ {
+				h.registers[index+j] = val
+			}
+			index += runLen
+			i++
+		}
+		if index > hllRegisters {
+			return ErrInvalid
+		}
+	}
+	if index != hllRegisters {
+		return ErrInvalid
+	}
+	return nil
+}
+
+// encodeSparse 将寄存器编码为 sparse 格式，寄存器的值超过 32 时无法编码
+func (h *HyperLogLog) encodeSparse() ([]byte, bool) {
+	data := make([]byte, 0, 64)
+	for i := 0; i < hllRegisters; {
+		val := h.registers[i]
+		runLen := 1
+		for i+runLen < hllRegisters && h.registers[i+runLen] == val {
+			runLen++
+		}
+		i += runLen
+		if val == 0 {
+			for runLen > 0 {
+				if runLen > sparseZeroMaxLen {
+					n := runLen
+					if n > sparseXZeroMaxLen {
+						n = sparseXZeroMaxLen
+					}
+					data = append(data, byte(0x40|(n-1)>>8), byte((n-1)&0xff))
+					runLen -= n
+				} else {
+					data = append(data, byte(runLen-1))
+					runLen = 0
+				}
+			}
+			continue
+		}
+		if val > sparseValMaxValue {
+			return nil, false
+		}
+		for runLen > 0 {
+			n := runLen
+			if n > sparseValMaxLen {
+				n = sparseValMaxLen
+			}
+			data = append(data, 0x80|(val-1)<<2|byte(n-1))
+			runLen -= n
+		}
+	}
+	return data, true
+}
+
+// Bytes 按照 redis 的格式编码，sparse 编码过长或无法表示时转换为 dense 编码
+// 基数缓存被标记为失效
+func (h *HyperLogLog) Bytes() []byte {
+	if !h.dense {
+		sparse, ok := h.encodeSparse()
+		if ok && len(sparse)+hllHdrSize <= SparseMaxBytes {
+			data := makeHeader(encodingSparse, hllHdrSize+len(sparse))
+			return append(data, sparse...)
+		}
+		h.dense = true
+	}
+	data := makeHeader(encodingDense, hllDenseSize)
+	data = data[:hllDenseSize]
+	registers := data[hllHdrSize:]
+	for i := 0; i < hllRegisters; i++ {
+		setDenseRegister(registers, i, h.registers[i])
+	}
+	return data
+}
+
+func makeHeader(encoding byte, capacity int) []byte {
+	data := make([]byte, hllHdrSize, capacity)
+	copy(data, magic)
+	data[4] = encoding
+	data[15] = 1 << 7
+	return data
+}
+
+// patLen 返回元素对应的寄存器下标和哈希值中 0 的游程长度 + 1
+func patLen(element []byte) (index int, count uint8) {
+	hash := murmurHash64A(element, hashSeed)
+	index = int(hash & hllPMask)
+	hash >>= hllP
+	// 保证循环可以结束，count 最大为 hllQ + 1
+	hash |= 1 << hllQ
+	count = uint8(bits.TrailingZeros64(hash) + 1)
+	return index, count
+}
+
+// Add 添加一个元素，寄存器发生变化时返回 true
+func (h *HyperLogLog) Add(element []byte) bool {
+	index, count := patLen(element)
+	if count > h.registers[index] {
+		h.registers[index] = count
+		return true
+	}
+	return false
+}
+
+// Merge 将另一个 HyperLogLog 合并进来，每个寄存器取较大值
+func (h *HyperLogLog) Merge(other *HyperLogLog) {
+	for i := 0; i < hllRegisters; i++ {
+		if other.registers[i] > h.registers[i] {
+			h.registers[i] = other.registers[i]
+		}
+	}
+	if other.dense {
+		h.dense = true
+	}
+}
+
+// Count 使用 Otmar Ertl 提出的改进算法估算基数，与 redis 保持一致
+func (h *HyperLogLog) Count() uint64 {
+	var histogram [64]int
+	for _, r := range h.registers {
+		histogram[r]++
+	}
+	m := float64(hllRegisters)
+	z := m * tau((m-float64(histogram[hllQ+1]))/m)
+	for j := hllQ; j >= 1; j-- {
+		z += float64(histogram[j])
+		z *= 0.5
+	}
+	z += m * sigma(float64(histogram[0])/m)
+	return uint64(math.Round(hllAlphaInf * m * m / z))
+}
+
+func sigma(x float64) float64 {
+	if x == 1 {
+		return math.Inf(1)
+	}
+	y := 1.0
+	z := x
+	for {
+		x *= x
+		zPrime := z
+		z += x * y
+		y += y
+		if zPrime == z {
+			return z
+		}
+	}
+}
+
+func tau(x float64) float64 {
+	if x == 0 || x == 1 {
+		return 0
+	}
+	y := 1.0
+	z := 1 - x
+	for {
+		x = math.Sqrt(x)
+		zPrime := z
+		y *= 0.5
+		z -= math.Pow(1-x, 2) * y
+		if zPrime == z {
+			return z / 3
+		}
+	}
+}
+
+// murmurHash64A 是 redis 使用的 64 位 MurmurHash2
+func murmurHash64A(key []byte, seed uint64) uint64 {
+	const m uint64 = 0xc6a4a7935bd1e995
+	const r = 47
+	length := len(key)
+	h := seed ^ (uint64(length) * m)
+
+	tail := length - length&7
+	for i := 0; i < tail; i += 8 {
+		k := uint64(key[i]) | uint64(key[i+1])<<8 | uint64(key[i+2])<<16 | uint64(key[i+3])<<24 |
+			uint64(key[i+4])<<32 | uint64(key[i+5])<<40 | uint64(key[i+6])<<48 | uint64(key[i+7])<<56
+		k *= m
+		k ^= k >> r
+		k *= m
+		h ^= k
+		h *= m
+	}
+
+	rest := key[tail:]
+	switch len(rest) {
+	case 7:
+		h ^= uint64(rest[6]) << 48
+		fallthrough
+	case 6:
+		h ^= uint64(rest[5]) << 40
+		fallthrough
+	case 5:
+		h ^= uint64(rest[4]) << 32
+		fallthrough
+	case 4:
+		h ^= uint64(rest[3]) << 24
+		fallthrough
+	case 3:
+		h ^= uint64(rest[2]) << 16
+		fallthrough
+	case 2:
+		h ^= uint64(rest[1]) << 8
+		fallthrough
+	case 1:
+		h ^= uint64(rest[0])
+		h *= m
+	}
+
+	h ^= h >> r
+	h *= m
+	h ^= h >> r
+	return h
+}
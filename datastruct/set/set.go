@@ -54,6 +54,11 @@ func (set *Set) ForEach(consumer func(member string) bool) {
 	})
 }
 
+// Scan 从 cursor 开始遍历大约 count 个成员，返回下一次遍历的 cursor，为 0 时遍历结束
+func (set *Set) Scan(cursor uint64, count int) ([]string, uint64) {
+	return set.dict.Scan(cursor, count)
+}
+
 func (set *Set) Intersect(another *Set) *Set {
 	if set == nil {
 		panic("set is nil")
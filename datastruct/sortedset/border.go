@@ -0,0 +1,149 @@
+package sortedset
+
+import (
+	"errors"
+	"math"
+	"strconv"
+)
+
+/*
+ * ScoreBorder 是 ZRANGEBYSCORE 等命令的分值边界
+ * 例如：`-inf`, `+inf`, `(1.5`, `3`
+ *
+ * LexBorder 是 BYLEX 范围查询的字典序边界
+ * 例如：`-`, `+`, `[a`, `(b`
+ */
+
+const (
+	negativeInf int8 = -1
+	positiveInf int8 = 1
+)
+
+// Border 表示范围查询的上界或下界
+type Border interface {
+	// less 当边界作为下界时，判断元素是否满足边界条件
+	less(element *Element) bool
+	// greater 当边界作为上界时，判断元素是否满足边界条件
+	greater(element *Element) bool
+}
+
+// ScoreBorder 分值边界
+type ScoreBorder struct {
+	Inf     int8
+	Value   float64
+	Exclude bool
+}
+
+func (border *ScoreBorder) less(element *Element) bool {
+	if border.Inf == negativeInf {
+		return true
+	} else if border.Inf == positiveInf {
+		return false
+	}
+	if border.Exclude {
+		return border.Value < element.Score
+	}
+	return border.Value <= element.Score
+}
+
+func (border *ScoreBorder) greater(element *Element) bool {
+	if border.Inf == positiveInf {
+		return true
+	} else if border.Inf == negativeInf {
+		return false
+	}
+	if border.Exclude {
+		return border.Value > element.Score
+	}
+	return border.Value >= element.Score
+}
+
+// PositiveInfBorder 代表 +inf
+var PositiveInfBorder = &ScoreBorder{
+	Inf: positiveInf,
+}
+
+// NegativeInfBorder 代表 -inf
+var NegativeInfBorder = &ScoreBorder{
+	Inf: negativeInf,
+}
+
+// ParseScoreBorder 从命令参数中解析分值边界
+func ParseScoreBorder(s string) (*ScoreBorder, error) {
+	if s == "inf" || s == "+inf" {
+		return PositiveInfBorder, nil
+	}
+	if s == "-inf" {
+		return NegativeInfBorder, nil
+	}
+	if len(s) > 0 && s[0] == '(' {
+		value, err := strconv.ParseFloat(s[1:], 64)
+		if err != nil || math.IsNaN(value) {
+			return nil, errors.New("ERR min or max is not a float")
+		}
+		return &ScoreBorder{
+			Value:   value,
+			Exclude: true,
+		}, nil
+	}
+	value, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(value) {
+		return nil, errors.New("ERR min or max is not a float")
+	}
+	return &ScoreBorder{
+		Value: value,
+	}, nil
+}
+
+// LexBorder 字典序边界
+type LexBorder struct {
+	Inf     int8
+	Value   string
+	Exclude bool
+}
+
+func (border *LexBorder) less(element *Element) bool {
+	if border.Inf == negativeInf {
+		return true
+	} else if border.Inf == positiveInf {
+		return false
+	}
+	if border.Exclude {
+		return border.Value < element.Member
+	}
+	return border.Value <= element.Member
+}
+
+func (border *LexBorder) greater(element *Element) bool {
+	if border.Inf == positiveInf {
+		return true
+	} else if border.Inf == negativeInf {
+		return false
+	}
+	if border.Exclude {
+		return border.Value > element.Member
+	}
+	return border.Value >= element.Member
+}
+
+// ParseLexBorder 从命令参数中解析字典序边界
+func ParseLexBorder(s string) (*LexBorder, error) {
+	if s == "+" {
+		return &LexBorder{Inf: positiveInf}, nil
+	}
+	if s == "-" {
+		return &LexBorder{Inf: negativeInf}, nil
+	}
+	if len(s) > 0 && s[0] == '(' {
+		return &LexBorder{
+			Value:   s[1:],
+			Exclude: true,
+		}, nil
+	}
+	if len(s) > 0 && s[0] == '[' {
+		return &LexBorder{
+			Value: s[1:],
+		}, nil
+	}
+	return nil, errors.New("ERR min or max not valid string range item")
+}
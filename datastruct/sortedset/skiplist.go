@@ -0,0 +1,307 @@
+package sortedset
+
+import "math/rand"
+
+const (
+	maxLevel = 16
+)
+
+// Element 是有序集合中的成员及其分值
+type Element struct {
+	Member string
+	Score  float64
+}
+
+// Level 是节点在某一层的前进指针
+type Level struct {
+	forward *node // 指向同层的下一个节点
+	span    int64 // 到下一个节点跨越的节点数，用于计算排名
+}
+
+type node struct {
+	Element
+	backward *node
+	level    []*Level // level[0] 是最底层
+}
+
+type skiplist struct {
+	header *node
+	tail   *node
+	length int64
+	level  int16
+}
+
+func makeNode(level int16, score float64, member string) *node {
+	n := &node{
+		Element: Element{
+			Score:  score,
+			Member: member,
+		},
+		level: make([]*Level, level),
+	}
+	for i := range n.level {
+		n.level[i] = new(Level)
+	}
+	return n
+}
+
+func makeSkiplist() *skiplist {
+	return &skiplist{
+		level:  1,
+		header: makeNode(maxLevel, 0, ""),
+	}
+}
+
+// randomLevel 以 1/4 的概率逐层晋升，返回新节点的层数
+func randomLevel() int16 {
+	level := int16(1)
+	for float32(rand.Int31()&0xFFFF) < (0.25 * 0xFFFF) {
+		level++
+	}
+	if level < maxLevel {
+		return level
+	}
+	return maxLevel
+}
+
+// lessThan 按照 (score, member) 的顺序比较两个元素
+func lessThan(score float64, member string, n *node) bool {
+	return n.Score < score || (n.Score == score && n.Member < member)
+}
+
+func (skiplist *skiplist) insert(member string, score float64) *node {
+	update := make([]*node, maxLevel) // 每一层中新节点的前驱
+	rank := make([]int64, maxLevel)   // 每一层前驱节点的排名
+
+	// 寻找插入位置
+	n := skiplist.header
+	for i := skiplist.level - 1; i >= 0; i-- {
+		if i == skiplist.level-1 {
+			rank[i] = 0
+		} else {
+			rank[i] = rank[i+1]
+		}
+		for n.level[i].forward != nil && lessThan(score, member, n.level[i].forward) {
+			rank[i] += n.level[i].span
+			n = n.level[i].forward
+		}
+		update[i] = n
+	}
+
+	level := randomLevel()
+	// 扩展跳表的层数
+	if level > skiplist.level {
+		for i := skiplist.level; i < level; i++ {
+			rank[i] = 0
+			update[i] = skiplist.header
+			update[i].level[i].span = skiplist.length
+		}
+		skiplist.level = level
+	}
+
+	// 创建新节点并插入到每一层
+	n = makeNode(level, score, member)
+	for i := int16(0); i < level; i++ {
+		n.level[i].forward = update[i].level[i].forward
+		update[i].level[i].forward = n
+
+		n.level[i].span = update[i].level[i].span - (rank[0] - rank[i])
+		update[i].level[i].span = (rank[0] - rank[i]) + 1
+	}
+	// 新节点没有触及的层，前驱的跨度加一
+	for i := level; i < skiplist.level; i++ {
+		update[i].level[i].span++
+	}
+
+	// 设置后退指针
+	if update[0] == skiplist.header {
+		n.backward = nil
+	} else {
+		n.backward = update[0]
+	}
+	if n.level[0].forward != nil {
+		n.level[0].forward.backward = n
+	} else {
+		skiplist.tail = n
+	}
+	skiplist.length++
+	return n
+}
+
+// removeNode 删除节点，update 保存了每一层中该节点的前驱
+func (skiplist *skiplist) removeNode(n *node, update []*node) {
+	for i := int16(0); i < skiplist.level; i++ {
+		if update[i].level[i].forward == n {
+			update[i].level[i].span += n.level[i].span - 1
+			update[i].level[i].forward = n.level[i].forward
+		} else {
+			update[i].level[i].span--
+		}
+	}
+	if n.level[0].forward != nil {
+		n.level[0].forward.backward = n.backward
+	} else {
+		skiplist.tail = n.backward
+	}
+	for skiplist.level > 1 && skiplist.header.level[skiplist.level-1].forward == nil {
+		skiplist.level--
+	}
+	skiplist.length--
+}
+
+// remove 删除给定的元素，返回元素是否存在
+func (skiplist *skiplist) remove(member string, score float64) bool {
+	update := make([]*node, maxLevel)
+	n := skiplist.header
+	for i := skiplist.level - 1; i >= 0; i-- {
+		for n.level[i].forward != nil && lessThan(score, member, n.level[i].forward) {
+			n = n.level[i].forward
+		}
+		update[i] = n
+	}
+	n = n.level[0].forward
+	if n != nil && score == n.Score && n.Member == member {
+		skiplist.removeNode(n, update)
+		return true
+	}
+	return false
+}
+
+// getRank 返回元素的排名，排名从 1 开始，元素不存在时返回 0
+func (skiplist *skiplist) getRank(member string, score float64) int64 {
+	var rank int64 = 0
+	x := skiplist.header
+	for i := skiplist.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil &&
+			(x.level[i].forward.Score < score ||
+				(x.level[i].forward.Score == score && x.level[i].forward.Member <= member)) {
+			rank += x.level[i].span
+			x = x.level[i].forward
+		}
+		if x != skiplist.header && x.Member == member {
+			return rank
+		}
+	}
+	return 0
+}
+
+// getByRank 返回给定排名的节点，排名从 1 开始
+func (skiplist *skiplist) getByRank(rank int64) *node {
+	var i int64 = 0
+	n := skiplist.header
+	for level := skiplist.level - 1; level >= 0; level-- {
+		for n.level[level].forward != nil && (i+n.level[level].span) <= rank {
+			i += n.level[level].span
+			n = n.level[level].forward
+		}
+		if i == rank {
+			return n
+		}
+	}
+	return nil
+}
+
+// hasInRange 检查跳表中是否可能有元素在给定的范围内
+func (skiplist *skiplist) hasInRange(min Border, max Border) bool {
+	n := skiplist.tail
+	if n == nil || !min.less(&n.Element) {
+		return false
+	}
+	n = skiplist.header.level[0].forward
+	if n == nil || !max.greater(&n.Element) {
+		return false
+	}
+	return true
+}
+
+// getFirstInRange 返回范围内的第一个节点，不存在时返回 nil
+func (skiplist *skiplist) getFirstInRange(min Border, max Border) *node {
+	if !skiplist.hasInRange(min, max) {
+		return nil
+	}
+	n := skiplist.header
+	for level := skiplist.level - 1; level >= 0; level-- {
+		for n.level[level].forward != nil && !min.less(&n.level[level].forward.Element) {
+			n = n.level[level].forward
+		}
+	}
+	n = n.level[0].forward
+	if !max.greater(&n.Element) {
+		return nil
+	}
+	return n
+}
+
+// getLastInRange 返回范围内的最后一个节点，不存在时返回 nil
+func (skiplist *skiplist) getLastInRange(min Border, max Border) *node {
+	if !skiplist.hasInRange(min, max) {
+		return nil
+	}
+	n := skiplist.header
+	for level := skiplist.level - 1; level >= 0; level-- {
+		for n.level[level].forward != nil && max.greater(&n.level[level].forward.Element) {
+			n = n.level[level].forward
+		}
+	}
+	if !min.less(&n.Element) {
+		return nil
+	}
+	return n
+}
+
+// removeRange 删除范围内的元素，limit <= 0 代表不限制数量
+func (skiplist *skiplist) removeRange(min Border, max Border, limit int) (removed []*Element) {
+	update := make([]*node, maxLevel)
+	removed = make([]*Element, 0)
+	n := skiplist.header
+	for i := skiplist.level - 1; i >= 0; i-- {
+		for n.level[i].forward != nil && !min.less(&n.level[i].forward.Element) {
+			n = n.level[i].forward
+		}
+		update[i] = n
+	}
+
+	n = n.level[0].forward
+	for n != nil {
+		if !max.greater(&n.Element) {
+			break
+		}
+		next := n.level[0].forward
+		removedElement := n.Element
+		removed = append(removed, &removedElement)
+		skiplist.removeNode(n, update)
+		if limit > 0 && len(removed) == limit {
+			break
+		}
+		n = next
+	}
+	return removed
+}
+
+// removeRangeByRank 删除排名在 [start, stop) 之间的元素，排名从 1 开始
+func (skiplist *skiplist) removeRangeByRank(start int64, stop int64) (removed []*Element) {
+	var i int64 = 0
+	update := make([]*node, maxLevel)
+	removed = make([]*Element, 0)
+
+	n := skiplist.header
+	for level := skiplist.level - 1; level >= 0; level-- {
+		for n.level[level].forward != nil && (i+n.level[level].span) < start {
+			i += n.level[level].span
+			n = n.level[level].forward
+		}
+		update[level] = n
+	}
+
+	i++
+	n = n.level[0].forward
+	for n != nil && i < stop {
+		next := n.level[0].forward
+		removedElement := n.Element
+		removed = append(removed, &removedElement)
+		skiplist.removeNode(n, update)
+		n = next
+		i++
+	}
+	return removed
+}
@@ -0,0 +1,238 @@
+package sortedset
+
+import (
+	"github.com/jujunwang/Mudis/datastruct/dict"
+	"strconv"
+)
+
+// SortedSet 是基于 dict + skiplist 实现的有序集合
+// dict 保存 member -> *Element 用于 O(1) 查询分值，skiplist 按 (score, member) 排序
+type SortedSet struct {
+	dict     dict.Dict
+	skiplist *skiplist
+}
+
+// Make 新建一个空的 SortedSet
+func Make() *SortedSet {
+	return &SortedSet{
+		dict:     dict.MakeSimple(),
+		skiplist: makeSkiplist(),
+	}
+}
+
+// Add 添加或更新成员的分值，如果是新成员返回 true
+func (sortedSet *SortedSet) Add(member string, score float64) bool {
+	element, ok := sortedSet.Get(member)
+	sortedSet.dict.Put(member, &Element{
+		Member: member,
+		Score:  score,
+	})
+	if ok {
+		if score != element.Score {
+			sortedSet.skiplist.remove(member, element.Score)
+			sortedSet.skiplist.insert(member, score)
+		}
+		return false
+	}
+	sortedSet.skiplist.insert(member, score)
+	return true
+}
+
+// Len 返回成员数量
+func (sortedSet *SortedSet) Len() int64 {
+	return int64(sortedSet.dict.Len())
+}
+
+// Get 返回给定成员对应的元素
+func (sortedSet *SortedSet) Get(member string) (element *Element, ok bool) {
+	raw, exists := sortedSet.dict.Get(member)
+	if !exists {
+		return nil, false
+	}
+	element, _ = raw.(*Element)
+	return element, true
+}
+
+// Remove 删除给定成员，成员存在时返回 true
+func (sortedSet *SortedSet) Remove(member string) bool {
+	element, ok := sortedSet.Get(member)
+	if !ok {
+		return false
+	}
+	sortedSet.skiplist.remove(member, element.Score)
+	sortedSet.dict.Remove(member)
+	return true
+}
+
+// GetRank 返回成员的排名，排名从 0 开始，成员不存在时返回 -1
+func (sortedSet *SortedSet) GetRank(member string, desc bool) (rank int64) {
+	element, ok := sortedSet.Get(member)
+	if !ok {
+		return -1
+	}
+	r := sortedSet.skiplist.getRank(member, element.Score)
+	if desc {
+		r = sortedSet.skiplist.length - r
+	} else {
+		r--
+	}
+	return r
+}
+
+// ForEachByRank 按排名遍历 [start, stop) 范围内的元素，排名从 0 开始
+func (sortedSet *SortedSet) ForEachByRank(start int64, stop int64, desc bool, consumer func(element *Element) bool) {
+	size := sortedSet.Len()
+	if start < 0 || start >= size {
+		panic("illegal start " + strconv.FormatInt(start, 10))
+	}
+	if stop < start || stop > size {
+		panic("illegal end " + strconv.FormatInt(stop, 10))
+	}
+
+	// 找到起始节点
+	var n *node
+	if desc {
+		n = sortedSet.skiplist.tail
+		if start > 0 {
+			n = sortedSet.skiplist.getByRank(size - start)
+		}
+	} else {
+		n = sortedSet.skiplist.header.level[0].forward
+		if start > 0 {
+			n = sortedSet.skiplist.getByRank(start + 1)
+		}
+	}
+
+	sliceSize := int(stop - start)
+	for i := 0; i < sliceSize; i++ {
+		if !consumer(&n.Element) {
+			break
+		}
+		if desc {
+			n = n.backward
+		} else {
+			n = n.level[0].forward
+		}
+	}
+}
+
+// RangeByRank 返回排名在 [start, stop) 范围内的元素，排名从 0 开始
+func (sortedSet *SortedSet) RangeByRank(start int64, stop int64, desc bool) []*Element {
+	sliceSize := int(stop - start)
+	slice := make([]*Element, sliceSize)
+	i := 0
+	sortedSet.ForEachByRank(start, stop, desc, func(element *Element) bool {
+		slice[i] = element
+		i++
+		return true
+	})
+	return slice
+}
+
+// RangeCount 返回在 [min, max] 范围内的元素数量
+func (sortedSet *SortedSet) RangeCount(min Border, max Border) int64 {
+	var i int64 = 0
+	sortedSet.ForEach(min, max, 0, -1, false, func(element *Element) bool {
+		i++
+		return true
+	})
+	return i
+}
+
+// ForEach 遍历 [min, max] 范围内的元素，跳过前 offset 个元素，limit < 0 代表不限制数量
+func (sortedSet *SortedSet) ForEach(min Border, max Border, offset int64, limit int64, desc bool, consumer func(element *Element) bool) {
+	// 找到起始节点
+	var n *node
+	if desc {
+		n = sortedSet.skiplist.getLastInRange(min, max)
+	} else {
+		n = sortedSet.skiplist.getFirstInRange(min, max)
+	}
+
+	for n != nil && offset > 0 {
+		if desc {
+			n = n.backward
+		} else {
+			n = n.level[0].forward
+		}
+		offset--
+	}
+
+	// 遍历直到超出范围或达到数量限制
+	for i := int64(0); (i < limit || limit < 0) && n != nil; i++ {
+		if !min.less(&n.Element) || !max.greater(&n.Element) {
+			break
+		}
+		if !consumer(&n.Element) {
+			break
+		}
+		if desc {
+			n = n.backward
+		} else {
+			n = n.level[0].forward
+		}
+	}
+}
+
+// Range 返回 [min, max] 范围内的元素，跳过前 offset 个元素，limit < 0 代表不限制数量
+func (sortedSet *SortedSet) Range(min Border, max Border, offset int64, limit int64, desc bool) []*Element {
+	if limit == 0 || offset < 0 {
+		return make([]*Element, 0)
+	}
+	slice := make([]*Element, 0)
+	sortedSet.ForEach(min, max, offset, limit, desc, func(element *Element) bool {
+		slice = append(slice, element)
+		return true
+	})
+	return slice
+}
+
+// RemoveRange 删除 [min, max] 范围内的元素，返回删除的数量
+func (sortedSet *SortedSet) RemoveRange(min Border, max Border) int64 {
+	removed := sortedSet.skiplist.removeRange(min, max, 0)
+	for _, element := range removed {
+		sortedSet.dict.Remove(element.Member)
+	}
+	return int64(len(removed))
+}
+
+// RemoveByRank 删除排名在 [start, stop) 范围内的元素，排名从 0 开始，返回删除的数量
+func (sortedSet *SortedSet) RemoveByRank(start int64, stop int64) int64 {
+	removed := sortedSet.skiplist.removeRangeByRank(start+1, stop+1)
+	for _, element := range removed {
+		sortedSet.dict.Remove(element.Member)
+	}
+	return int64(len(removed))
+}
+
+// PopMin 弹出分值最小的 count 个元素
+func (sortedSet *SortedSet) PopMin(count int) []*Element {
+	first := sortedSet.skiplist.header.level[0].forward
+	if first == nil || count <= 0 {
+		return nil
+	}
+	border := &ScoreBorder{
+		Value: first.Score,
+	}
+	removed := sortedSet.skiplist.removeRange(border, PositiveInfBorder, count)
+	for _, element := range removed {
+		sortedSet.dict.Remove(element.Member)
+	}
+	return removed
+}
+
+// PopMax 弹出分值最大的 count 个元素
+func (sortedSet *SortedSet) PopMax(count int) []*Element {
+	size := sortedSet.Len()
+	if int64(count) > size {
+		count = int(size)
+	}
+	if count <= 0 {
+		return nil
+	}
+	removed := sortedSet.RangeByRank(0, int64(count), true)
+	for _, element := range removed {
+		sortedSet.Remove(element.Member)
+	}
+	return removed
+}
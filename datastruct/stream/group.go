@@ -0,0 +1,190 @@
+package stream
+
+import (
+	"sort"
+)
+
+// PendingEntry 是已经投递给消费者但尚未确认的元素
+type PendingEntry struct {
+	ID       ID
+	Consumer *Consumer
+	// DeliveryTime 是最后一次投递的时间，unix 毫秒
+	DeliveryTime int64
+	// DeliveryCount 是投递的次数
+	DeliveryCount int64
+}
+
+// pendingList 是按照 ID 排序的待确认元素列表
+type pendingList []*PendingEntry
+
+func (l pendingList) search(id ID) int {
+	return sort.Search(len(l), func(i int) bool {
+		return !l[i].ID.Less(id)
+	})
+}
+
+func (l pendingList) get(id ID) (*PendingEntry, bool) {
+	i := l.search(id)
+	if i < len(l) && l[i].ID == id {
+		return l[i], true
+	}
+	return nil, false
+}
+
+func (l *pendingList) insert(pe *PendingEntry) {
+	i := l.search(pe.ID)
+	*l = append(*l, nil)
+	copy((*l)[i+1:], (*l)[i:])
+	(*l)[i] = pe
+}
+
+func (l *pendingList) remove(id ID) bool {
+	i := l.search(id)
+	if i >= len(*l) || (*l)[i].ID != id {
+		return false
+	}
+	copy((*l)[i:], (*l)[i+1:])
+	(*l)[len(*l)-1] = nil
+	*l = (*l)[:len(*l)-1]
+	return true
+}
+
+// rangeFrom 返回 [start, end] 范围内最多 count 个元素，count <= 0 代表不限制数量
+func (l pendingList) rangeFrom(start ID, end ID, count int) []*PendingEntry {
+	result := make([]*PendingEntry, 0)
+	for i := l.search(start); i < len(l) && !end.Less(l[i].ID); i++ {
+		if count > 0 && len(result) >= count {
+			break
+		}
+		result = append(result, l[i])
+	}
+	return result
+}
+
+// Consumer 是消费者组中的一个消费者
+type Consumer struct {
+	Name string
+	// SeenTime 是最后一次尝试读取或认领的时间，unix 毫秒
+	SeenTime int64
+	// ActiveTime 是最后一次成功读取或认领的时间，从未成功时为 -1
+	ActiveTime int64
+	pel        pendingList
+}
+
+// PendingCount 返回消费者待确认的元素数量
+func (c *Consumer) PendingCount() int {
+	return len(c.pel)
+}
+
+// Pending 返回消费者 [start, end] 范围内最多 count 个待确认的元素
+func (c *Consumer) Pending(start ID, end ID, count int) []*PendingEntry {
+	return c.pel.rangeFrom(start, end, count)
+}
+
+// Group 是流上的消费者组
+type Group struct {
+	Name string
+	// LastID 是最后投递给组内消费者的 ID
+	LastID ID
+	// EntriesRead 是组已经读取的元素数量，无法确定时为 -1
+	EntriesRead int64
+	pel         pendingList
+	consumers   map[string]*Consumer
+}
+
+func makeGroup(name string, lastID ID, entriesRead int64) *Group {
+	return &Group{
+		Name:        name,
+		LastID:      lastID,
+		EntriesRead: entriesRead,
+		consumers:   make(map[string]*Consumer),
+	}
+}
+
+// GetConsumer 查找消费者
+func (g *Group) GetConsumer(name string) *Consumer {
+	return g.consumers[name]
+}
+
+// CreateConsumer 创建消费者，已存在时返回 nil
+func (g *Group) CreateConsumer(name string, now int64) *Consumer {
+	if _, ok := g.consumers[name]; ok {
+		return nil
+	}
+	consumer := &Consumer{
+		Name:       name,
+		SeenTime:   now,
+		ActiveTime: -1,
+	}
+	g.consumers[name] = consumer
+	return consumer
+}
+
+// DeleteConsumer 删除消费者及其待确认的元素，返回删除的待确认元素数量，消费者不存在时返回 -1
+func (g *Group) DeleteConsumer(name string) int {
+	consumer, ok := g.consumers[name]
+	if !ok {
+		return -1
+	}
+	for _, pe := range consumer.pel {
+		g.pel.remove(pe.ID)
+	}
+	delete(g.consumers, name)
+	return len(consumer.pel)
+}
+
+// Consumers 返回按照名称排序的消费者
+func (g *Group) Consumers() []*Consumer {
+	consumers := make([]*Consumer, 0, len(g.consumers))
+	for _, consumer := range g.consumers {
+		consumers = append(consumers, consumer)
+	}
+	sort.Slice(consumers, func(i, j int) bool {
+		return consumers[i].Name < consumers[j].Name
+	})
+	return consumers
+}
+
+// GetPending 查找待确认的元素
+func (g *Group) GetPending(id ID) (*PendingEntry, bool) {
+	return g.pel.get(id)
+}
+
+// PendingCount 返回组内待确认的元素数量
+func (g *Group) PendingCount() int {
+	return len(g.pel)
+}
+
+// Pending 返回组内 [start, end] 范围内最多 count 个待确认的元素
+func (g *Group) Pending(start ID, end ID, count int) []*PendingEntry {
+	return g.pel.rangeFrom(start, end, count)
+}
+
+// Assign 将元素分配给消费者，元素已经在待确认列表中时转移给该消费者
+func (g *Group) Assign(id ID, consumer *Consumer, deliveryTime int64, deliveryCount int64) *PendingEntry {
+	pe, ok := g.pel.get(id)
+	if !ok {
+		pe = &PendingEntry{ID: id}
+		g.pel.insert(pe)
+	} else if pe.Consumer != consumer {
+		pe.Consumer.pel.remove(id)
+	}
+	if pe.Consumer != consumer {
+		pe.Consumer = consumer
+		consumer.pel.insert(pe)
+	}
+	pe.DeliveryTime = deliveryTime
+	pe.DeliveryCount = deliveryCount
+	return pe
+}
+
+// Ack 确认元素，将其从待确认列表中删除
+func (g *Group) Ack(id ID) bool {
+	pe, ok := g.pel.get(id)
+	if !ok {
+		return false
+	}
+	g.pel.remove(id)
+	pe.Consumer.pel.remove(id)
+	return true
+}
@@ -0,0 +1,97 @@
+package stream
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ID 是流中元素的 ID，由毫秒时间戳和序号组成
+type ID struct {
+	Ms  uint64
+	Seq uint64
+}
+
+// MinID 是最小的 ID 0-0
+var MinID = ID{}
+
+// MaxID 是最大的 ID
+var MaxID = ID{Ms: math.MaxUint64, Seq: math.MaxUint64}
+
+// ErrInvalidID 表示 ID 格式错误
+var ErrInvalidID = errors.New("invalid stream id")
+
+func (id ID) String() string {
+	return strconv.FormatUint(id.Ms, 10) + "-" + strconv.FormatUint(id.Seq, 10)
+}
+
+// Compare 比较两个 ID，返回 -1, 0, 1
+func (id ID) Compare(other ID) int {
+	if id.Ms != other.Ms {
+		if id.Ms < other.Ms {
+			return -1
+		}
+		return 1
+	}
+	if id.Seq != other.Seq {
+		if id.Seq < other.Seq {
+			return -1
+		}
+		return 1
+	}
+	return 0
+}
+
+// Less 判断 id 是否小于 other
+func (id ID) Less(other ID) bool {
+	return id.Compare(other) < 0
+}
+
+// IsZero 判断是否为 0-0
+func (id ID) IsZero() bool {
+	return id.Ms == 0 && id.Seq == 0
+}
+
+// Incr 返回下一个 ID，溢出时返回 false
+func (id ID) Incr() (ID, bool) {
+	if id.Seq < math.MaxUint64 {
+		return ID{Ms: id.Ms, Seq: id.Seq + 1}, true
+	}
+	if id.Ms < math.MaxUint64 {
+		return ID{Ms: id.Ms + 1}, true
+	}
+	return id, false
+}
+
+// Decr 返回上一个 ID，下溢时返回 false
+func (id ID) Decr() (ID, bool) {
+	if id.Seq > 0 {
+		return ID{Ms: id.Ms, Seq: id.Seq - 1}, true
+	}
+	if id.Ms > 0 {
+		return ID{Ms: id.Ms - 1, Seq: math.MaxUint64}, true
+	}
+	return id, false
+}
+
+// ParseID 解析 ms-seq 格式的 ID，省略序号时使用 missingSeq
+// seqGiven 表示是否指定了序号，allowAutoSeq 为 true 时序号可以为 *，此时 seqGiven 为 false
+func ParseID(s string, missingSeq uint64, allowAutoSeq bool) (id ID, seqGiven bool, err error) {
+	msPart, seqPart, hasSeq := strings.Cut(s, "-")
+	ms, err := strconv.ParseUint(msPart, 10, 64)
+	if err != nil {
+		return ID{}, false, ErrInvalidID
+	}
+	if !hasSeq {
+		return ID{Ms: ms, Seq: missingSeq}, true, nil
+	}
+	if allowAutoSeq && seqPart == "*" {
+		return ID{Ms: ms}, false, nil
+	}
+	seq, err := strconv.ParseUint(seqPart, 10, 64)
+	if err != nil {
+		return ID{}, false, ErrInvalidID
+	}
+	return ID{Ms: ms, Seq: seq}, true, nil
+}
@@ -0,0 +1,286 @@
+// Package stream 实现了 redis 的流类型
+// 元素按照 ID 递增的顺序保存在切片中，追加和二分查找的开销都很小
+package stream
+
+import (
+	"sort"
+)
+
+// Entry 是流中的一个元素，Fields 按照 field value 交替排列
+type Entry struct {
+	ID     ID
+	Fields [][]byte
+}
+
+// Stream 是一个只能追加的有序日志
+type Stream struct {
+	entries []*Entry
+	// lastID 是最后生成的 ID，元素被删除后也不会回退
+	lastID ID
+	// maxDeletedID 是被 XDEL 删除的最大 ID
+	maxDeletedID ID
+	// entriesAdded 是流创建以来添加过的元素总数
+	entriesAdded uint64
+	groups       map[string]*Group
+}
+
+// Make 新建一个空的流
+func Make() *Stream {
+	return &Stream{
+		groups: make(map[string]*Group),
+	}
+}
+
+// Len 返回元素数量
+func (s *Stream) Len() int {
+	return len(s.entries)
+}
+
+// LastID 返回最后生成的 ID
+func (s *Stream) LastID() ID {
+	return s.lastID
+}
+
+// SetLastID 设置最后生成的 ID
+func (s *Stream) SetLastID(id ID) {
+	s.lastID = id
+}
+
+// MaxDeletedID 返回被删除的最大 ID
+func (s *Stream) MaxDeletedID() ID {
+	return s.maxDeletedID
+}
+
+// SetMaxDeletedID 设置被删除的最大 ID
+func (s *Stream) SetMaxDeletedID(id ID) {
+	s.maxDeletedID = id
+}
+
+// EntriesAdded 返回添加过的元素总数
+func (s *Stream) EntriesAdded() uint64 {
+	return s.entriesAdded
+}
+
+// SetEntriesAdded 设置添加过的元素总数
+func (s *Stream) SetEntriesAdded(n uint64) {
+	s.entriesAdded = n
+}
+
+// First 返回第一个元素，流为空时返回 nil
+func (s *Stream) First() *Entry {
+	if len(s.entries) == 0 {
+		return nil
+	}
+	return s.entries[0]
+}
+
+// Last 返回最后一个元素，流为空时返回 nil
+func (s *Stream) Last() *Entry {
+	if len(s.entries) == 0 {
+		return nil
+	}
+	return s.entries[len(s.entries)-1]
+}
+
+// FirstID 返回第一个元素的 ID，流为空时返回 0-0
+func (s *Stream) FirstID() ID {
+	if len(s.entries) == 0 {
+		return MinID
+	}
+	return s.entries[0].ID
+}
+
+// Add 追加一个元素，调用方需要保证 id 大于 LastID
+func (s *Stream) Add(id ID, fields [][]byte) {
+	s.entries = append(s.entries, &Entry{
+		ID:     id,
+		Fields: fields,
+	})
+	s.lastID = id
+	s.entriesAdded++
+}
+
+// search 返回第一个不小于 id 的元素的下标
+func (s *Stream) search(id ID) int {
+	return sort.Search(len(s.entries), func(i int) bool {
+		return !s.entries[i].ID.Less(id)
+	})
+}
+
+// Get 根据 ID 查找元素
+func (s *Stream) Get(id ID) (*Entry, bool) {
+	i := s.search(id)
+	if i < len(s.entries) && s.entries[i].ID == id {
+		return s.entries[i], true
+	}
+	return nil, false
+}
+
+// Range 返回 [start, end] 范围内的元素，count <= 0 代表不限制数量，rev 为 true 时按照 ID 递减的顺序返回
+func (s *Stream) Range(start ID, end ID, count int, rev bool) []*Entry {
+	result := make([]*Entry, 0)
+	if end.Less(start) {
+		return result
+	}
+	begin := s.search(start)
+	stop := sort.Search(len(s.entries), func(i int) bool {
+		return end.Less(s.entries[i].ID)
+	})
+	if rev {
+		for i := stop - 1; i >= begin && (count <= 0 || len(result) < count); i-- {
+			result = append(result, s.entries[i])
+		}
+		return result
+	}
+	for i := begin; i < stop && (count <= 0 || len(result) < count); i++ {
+		result = append(result, s.entries[i])
+	}
+	return result
+}
+
+// Delete 删除一个元素
+func (s *Stream) Delete(id ID) bool {
+	i := s.search(id)
+	if i >= len(s.entries) || s.entries[i].ID != id {
+		return false
+	}
+	copy(s.entries[i:], s.entries[i+1:])
+	s.entries[len(s.entries)-1] = nil
+	s.entries = s.entries[:len(s.entries)-1]
+	if s.maxDeletedID.Less(id) {
+		s.maxDeletedID = id
+	}
+	return true
+}
+
+// removeFirst 删除前 n 个元素
+func (s *Stream) removeFirst(n int) int {
+	for i := 0; i < n; i++ {
+		s.entries[i] = nil
+	}
+	s.entries = s.entries[n:]
+	return n
+}
+
+// TrimByLen 删除最早的元素直到长度不超过 maxLen，limit > 0 时最多删除 limit 个，返回删除的数量
+func (s *Stream) TrimByLen(maxLen int, limit int) int {
+	n := len(s.entries) - maxLen
+	if n <= 0 {
+		return 0
+	}
+	if limit > 0 && n > limit {
+		n = limit
+	}
+	return s.removeFirst(n)
+}
+
+// TrimByMinID 删除 ID 小于 minID 的元素，limit > 0 时最多删除 limit 个，返回删除的数量
+func (s *Stream) TrimByMinID(minID ID, limit int) int {
+	n := s.search(minID)
+	if limit > 0 && n > limit {
+		n = limit
+	}
+	return s.removeFirst(n)
+}
+
+// ForEach 按照 ID 递增的顺序遍历元素
+func (s *Stream) ForEach(consumer func(entry *Entry) bool) {
+	for _, entry := range s.entries {
+		if !consumer(entry) {
+			break
+		}
+	}
+}
+
+// hasTombstones 判断 start 之后是否有被 XDEL 删除的元素
+func (s *Stream) hasTombstones(start ID) bool {
+	if len(s.entries) == 0 || s.maxDeletedID.IsZero() {
+		return false
+	}
+	return !s.maxDeletedID.Less(start)
+}
+
+// EstimateEntriesRead 估算读到 id 为止已经读取的元素数量，无法确定时返回 -1
+func (s *Stream) EstimateEntriesRead(id ID) int64 {
+	if s.entriesAdded == 0 {
+		return 0
+	}
+	if len(s.entries) == 0 && id.Less(s.lastID) {
+		return int64(s.entriesAdded)
+	}
+	if cmp := id.Compare(s.lastID); cmp >= 0 {
+		return int64(s.entriesAdded)
+	}
+	firstID := s.FirstID()
+	if s.maxDeletedID.IsZero() || s.maxDeletedID.Less(firstID) {
+		// 流中没有空洞，可以根据与第一个元素的位置关系计算
+		if id.Less(firstID) {
+			return int64(s.entriesAdded) - int64(len(s.entries))
+		}
+		if id == firstID {
+			return int64(s.entriesAdded) - int64(len(s.entries)) + 1
+		}
+	}
+	return -1
+}
+
+// GetGroup 根据名称查找消费者组
+func (s *Stream) GetGroup(name string) *Group {
+	return s.groups[name]
+}
+
+// CreateGroup 创建消费者组，组已存在时返回 nil
+func (s *Stream) CreateGroup(name string, lastID ID, entriesRead int64) *Group {
+	if _, ok := s.groups[name]; ok {
+		return nil
+	}
+	group := makeGroup(name, lastID, entriesRead)
+	s.groups[name] = group
+	return group
+}
+
+// DestroyGroup 删除消费者组
+func (s *Stream) DestroyGroup(name string) bool {
+	if _, ok := s.groups[name]; !ok {
+		return false
+	}
+	delete(s.groups, name)
+	return true
+}
+
+// Groups 返回按照名称排序的消费者组
+func (s *Stream) Groups() []*Group {
+	groups := make([]*Group, 0, len(s.groups))
+	for _, group := range s.groups {
+		groups = append(groups, group)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].Name < groups[j].Name
+	})
+	return groups
+}
+
+// Lag 返回消费者组尚未读取的元素数量，无法确定时返回 -1
+func (s *Stream) Lag(group *Group) int64 {
+	if s.entriesAdded == 0 {
+		return 0
+	}
+	if group.EntriesRead >= 0 && !s.hasTombstones(group.LastID) && !group.LastID.Less(s.FirstID()) {
+		return int64(s.entriesAdded) - group.EntriesRead
+	}
+	entriesRead := s.EstimateEntriesRead(group.LastID)
+	if entriesRead < 0 {
+		return -1
+	}
+	return int64(s.entriesAdded) - entriesRead
+}
+
+// Deliver 将 ID 大于组的 LastID 的元素投递给消费者组，更新 LastID 和 EntriesRead
+func (s *Stream) Deliver(group *Group, entry *Entry) {
+	group.LastID = entry.ID
+	if group.EntriesRead >= 0 && !s.hasTombstones(entry.ID) {
+		group.EntriesRead++
+	} else {
+		group.EntriesRead = s.EstimateEntriesRead(entry.ID)
+	}
+}
@@ -2,6 +2,7 @@ package database
 
 import (
 	"github.com/jujunwang/Mudis/interface/resp"
+	"time"
 )
 
 type CmdLine = [][]byte
@@ -11,6 +12,19 @@ type Database interface {
 	Exec(client resp.Connection, args [][]byte) resp.Reply
 	AfterClientClose(c resp.Connection)
 	Close()
+	// Info 返回 INFO 命令中由数据库统计的字段，每个字段的格式为 name:value
+	Info(section string) []string
+	// ResetStats 清空 INFO 中由数据库统计的计数器
+	ResetStats()
+	// SetAppendOnly 在运行时开启或关闭 AOF
+	SetAppendOnly(enabled bool) error
+}
+
+// DBEngine 是能够遍历所有 key 的数据库，AOF 重写时用于重放 AOF 并导出数据
+type DBEngine interface {
+	Database
+	// ForEach 遍历编号为 dbIndex 的 DB 中所有未过期的 key，expiration 为 nil 表示没有过期时间
+	ForEach(dbIndex int, cb func(key string, entity *DataEntity, expiration *time.Time) bool)
 }
 
 // DataEntity 存储指定 key 对应的数据, 包括 string, list, hash, set
@@ -6,4 +6,25 @@ type Connection interface {
 	// used for multi database
 	GetDBIndex() int
 	SelectDB(int)
+	// Closed 返回在连接关闭时关闭的 channel
+	Closed() <-chan struct{}
+
+	// 发布订阅相关
+	Subscribe(channel string)
+	UnSubscribe(channel string)
+	SubsCount() int
+	GetChannels() []string
+	PSubscribe(pattern string)
+	PUnSubscribe(pattern string)
+	PSubsCount() int
+	GetPatterns() []string
+
+	// 事务相关
+	InMultiState() bool
+	SetMultiState(bool)
+	GetQueuedCmdLine() [][][]byte
+	EnqueueCmd([][]byte)
+	AddTxError(err error)
+	GetTxErrors() []error
+	GetWatching() map[string]uint32
 }
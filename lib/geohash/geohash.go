@@ -0,0 +1,219 @@
+// Package geohash 实现了与 redis 一致的 52 位 geohash 编码
+// 纬度位于偶数位，经度位于奇数位，编码结果可以无损地保存为 float64 分值
+package geohash
+
+import (
+	"math"
+)
+
+const (
+	// Step 每个维度的精度，编码结果共 2 * Step = 52 位
+	Step = 26
+
+	// 墨卡托投影的有效范围
+	MinLongitude = -180.0
+	MaxLongitude = 180.0
+	MinLatitude  = -85.05112878
+	MaxLatitude  = 85.05112878
+
+	earthRadius = 6372797.560856
+	mercatorMax = 20037726.37
+	base32      = "0123456789bcdefghjkmnpqrstuvwxyz"
+)
+
+// ScoreRange 表示分值范围 [Min, Max)
+type ScoreRange struct {
+	Min uint64
+	Max uint64
+}
+
+// interleave 交错合并两个 32 位整数，x 位于偶数位，y 位于奇数位
+func interleave(x uint32, y uint32) uint64 {
+	b := [...]uint64{0x5555555555555555, 0x3333333333333333, 0x0F0F0F0F0F0F0F0F, 0x00FF00FF00FF00FF, 0x0000FFFF0000FFFF}
+	s := [...]uint{1, 2, 4, 8, 16}
+	xx, yy := uint64(x), uint64(y)
+	for i := 4; i >= 0; i-- {
+		xx = (xx | xx<<s[i]) & b[i]
+		yy = (yy | yy<<s[i]) & b[i]
+	}
+	return xx | yy<<1
+}
+
+// deinterleave 是 interleave 的逆运算
+func deinterleave(bits uint64) (x uint32, y uint32) {
+	b := [...]uint64{0x5555555555555555, 0x3333333333333333, 0x0F0F0F0F0F0F0F0F, 0x00FF00FF00FF00FF, 0x0000FFFF0000FFFF, 0x00000000FFFFFFFF}
+	s := [...]uint{0, 1, 2, 4, 8, 16}
+	xx, yy := bits, bits>>1
+	for i := 0; i < len(b); i++ {
+		xx = (xx | xx>>s[i]) & b[i]
+		yy = (yy | yy>>s[i]) & b[i]
+	}
+	return uint32(xx), uint32(yy)
+}
+
+func offset(value float64, min float64, max float64, step uint) uint32 {
+	off := (value - min) / (max - min) * float64(uint64(1)<<step)
+	limit := float64(uint64(1)<<step - 1)
+	if off > limit {
+		off = limit
+	}
+	return uint32(off)
+}
+
+func encode(longitude, latitude float64, minLat, maxLat float64, step uint) uint64 {
+	latOffset := offset(latitude, minLat, maxLat, step)
+	lonOffset := offset(longitude, MinLongitude, MaxLongitude, step)
+	return interleave(latOffset, lonOffset)
+}
+
+// Encode 将经纬度编码为 52 位 geohash，调用方需要保证经纬度在有效范围内
+func Encode(longitude, latitude float64) uint64 {
+	return encode(longitude, latitude, MinLatitude, MaxLatitude, Step)
+}
+
+// cellBounds 返回某一精度下格子的经纬度范围
+func cellBounds(latIndex, lonIndex uint32, step uint) (lonMin, lonMax, latMin, latMax float64) {
+	cells := float64(uint64(1) << step)
+	lonScale := MaxLongitude - MinLongitude
+	latScale := MaxLatitude - MinLatitude
+	lonMin = MinLongitude + float64(lonIndex)/cells*lonScale
+	lonMax = MinLongitude + float64(lonIndex+1)/cells*lonScale
+	latMin = MinLatitude + float64(latIndex)/cells*latScale
+	latMax = MinLatitude + float64(latIndex+1)/cells*latScale
+	return
+}
+
+// Decode 返回 geohash 所在格子的中心点
+func Decode(hash uint64) (longitude, latitude float64) {
+	latIndex, lonIndex := deinterleave(hash)
+	lonMin, lonMax, latMin, latMax := cellBounds(latIndex, lonIndex, Step)
+	longitude = math.Max(MinLongitude, math.Min(MaxLongitude, (lonMin+lonMax)/2))
+	latitude = math.Max(MinLatitude, math.Min(MaxLatitude, (latMin+latMax)/2))
+	return
+}
+
+// ToString 返回标准的 11 位 base32 geohash 字符串，纬度范围为 [-90, 90]
+func ToString(longitude, latitude float64) string {
+	hash := encode(longitude, latitude, -90, 90, Step)
+	buf := make([]byte, 11)
+	for i := range buf {
+		idx := 0
+		if i < 10 {
+			idx = int(hash>>(52-(i+1)*5)) & 0x1f
+		}
+		buf[i] = base32[idx]
+	}
+	return string(buf)
+}
+
+func degToRad(deg float64) float64 {
+	return deg * math.Pi / 180
+}
+
+func radToDeg(rad float64) float64 {
+	return rad * 180 / math.Pi
+}
+
+// LatDistance 返回两个纬度之间的距离，单位为米
+func LatDistance(lat1, lat2 float64) float64 {
+	return earthRadius * math.Abs(degToRad(lat2)-degToRad(lat1))
+}
+
+// Distance 使用 haversine 公式计算两点间的距离，单位为米
+func Distance(lon1, lat1, lon2, lat2 float64) float64 {
+	v := math.Sin((degToRad(lon2) - degToRad(lon1)) / 2)
+	if v == 0 {
+		return LatDistance(lat1, lat2)
+	}
+	lat1r := degToRad(lat1)
+	lat2r := degToRad(lat2)
+	u := math.Sin((lat2r - lat1r) / 2)
+	a := u*u + math.Cos(lat1r)*math.Cos(lat2r)*v*v
+	return 2 * earthRadius * math.Asin(math.Sqrt(a))
+}
+
+// estimateStep 根据搜索半径估算合适的精度
+func estimateStep(radius float64, latitude float64) uint {
+	if radius == 0 {
+		return Step
+	}
+	step := 1
+	for radius < mercatorMax {
+		radius *= 2
+		step++
+	}
+	// 保证大多数情况下搜索范围被包含在 3x3 的格子中
+	step -= 2
+	if latitude > 66 || latitude < -66 {
+		step--
+		if latitude > 80 || latitude < -80 {
+			step--
+		}
+	}
+	if step < 1 {
+		step = 1
+	}
+	if step > Step {
+		step = Step
+	}
+	return uint(step)
+}
+
+// boundingBox 返回以 (longitude, latitude) 为中心，宽高为 width, height 米的矩形的经纬度范围
+func boundingBox(longitude, latitude, width, height float64) (lonMin, lonMax, latMin, latMax float64) {
+	latDelta := radToDeg(height / 2 / earthRadius)
+	latMin = latitude - latDelta
+	latMax = latitude + latDelta
+	lonDelta := MaxLongitude
+	if latMin > -90 && latMax < 90 {
+		maxCos := math.Min(math.Cos(degToRad(latMin)), math.Cos(degToRad(latMax)))
+		lonDelta = math.Min(MaxLongitude, radToDeg(width/2/earthRadius/maxCos))
+	}
+	return longitude - lonDelta, longitude + lonDelta, math.Max(latMin, MinLatitude), math.Min(latMax, MaxLatitude)
+}
+
+// SearchRanges 返回覆盖以 (longitude, latitude) 为中心，宽高为 width, height 米的矩形所需的分值范围
+// 结果是中心格子及其周围 8 个格子，调用方仍需按照实际距离过滤
+func SearchRanges(longitude, latitude, width, height float64) []ScoreRange {
+	lonMin, lonMax, latMin, latMax := boundingBox(longitude, latitude, width, height)
+	step := estimateStep(math.Max(width, height)/2, latitude)
+	var latIndex, lonIndex uint32
+	for ; ; step-- {
+		latIndex, lonIndex = deinterleave(encode(longitude, latitude, MinLatitude, MaxLatitude, step))
+		if step == 1 {
+			break
+		}
+		// 周围 8 个格子不能覆盖整个矩形时降低精度
+		cLonMin, cLonMax, cLatMin, cLatMax := cellBounds(latIndex, lonIndex, step)
+		cellWidth, cellHeight := cLonMax-cLonMin, cLatMax-cLatMin
+		if lonMin >= cLonMin-cellWidth && lonMax <= cLonMax+cellWidth &&
+			latMin >= cLatMin-cellHeight && latMax <= cLatMax+cellHeight {
+			break
+		}
+	}
+
+	cells := int64(1) << step
+	shift := 2 * (Step - step)
+	seen := make(map[uint64]struct{}, 9)
+	ranges := make([]ScoreRange, 0, 9)
+	for dy := int64(-1); dy <= 1; dy++ {
+		lat := int64(latIndex) + dy
+		if lat < 0 || lat >= cells {
+			continue
+		}
+		for dx := int64(-1); dx <= 1; dx++ {
+			// 经度方向首尾相连
+			lon := (int64(lonIndex) + dx + cells) % cells
+			bits := interleave(uint32(lat), uint32(lon))
+			if _, ok := seen[bits]; ok {
+				continue
+			}
+			seen[bits] = struct{}{}
+			ranges = append(ranges, ScoreRange{
+				Min: bits << shift,
+				Max: (bits + 1) << shift,
+			})
+		}
+	}
+	return ranges
+}
@@ -27,6 +27,8 @@ var (
 	mu                 sync.Mutex
 	logPrefix          = ""
 	levelFlags         = []string{"DEBUG", "INFO", "WARN", "ERROR", "FATAL"}
+	// minLevel 是输出的最低日志级别
+	minLevel = DEBUG
 )
 
 type logLevel int
@@ -64,6 +66,13 @@ func Setup(settings *Settings) {
 	logger = log.New(mw, defaultPrefix, flags)
 }
 
+// SetLevel 设置输出的最低日志级别，低于该级别的日志不会输出，FATAL 日志总是输出
+func SetLevel(level logLevel) {
+	mu.Lock()
+	defer mu.Unlock()
+	minLevel = level
+}
+
 func setPrefix(level logLevel) {
 	_, file, line, ok := runtime.Caller(defaultCallerDepth)
 	if ok {
@@ -79,6 +88,9 @@ func setPrefix(level logLevel) {
 func Debug(v ...interface{}) {
 	mu.Lock()
 	defer mu.Unlock()
+	if DEBUG < minLevel {
+		return
+	}
 	setPrefix(DEBUG)
 	logger.Println(v...)
 }
@@ -87,6 +99,9 @@ func Debug(v ...interface{}) {
 func Info(v ...interface{}) {
 	mu.Lock()
 	defer mu.Unlock()
+	if INFO < minLevel {
+		return
+	}
 	setPrefix(INFO)
 	logger.Println(v...)
 }
@@ -95,6 +110,9 @@ func Info(v ...interface{}) {
 func Warn(v ...interface{}) {
 	mu.Lock()
 	defer mu.Unlock()
+	if WARNING < minLevel {
+		return
+	}
 	setPrefix(WARNING)
 	logger.Println(v...)
 }
@@ -103,6 +121,9 @@ func Warn(v ...interface{}) {
 func Error(v ...interface{}) {
 	mu.Lock()
 	defer mu.Unlock()
+	if ERROR < minLevel {
+		return
+	}
 	setPrefix(ERROR)
 	logger.Println(v...)
 }
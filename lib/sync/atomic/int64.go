@@ -0,0 +1,26 @@
+package atomic
+
+import "sync/atomic"
+
+// Int64 is an int64 value, all actions of it is atomic
+type Int64 int64
+
+// Get reads the value atomically
+func (i *Int64) Get() int64 {
+	return atomic.LoadInt64((*int64)(i))
+}
+
+// Set writes the value atomically
+func (i *Int64) Set(v int64) {
+	atomic.StoreInt64((*int64)(i), v)
+}
+
+// Add adds delta to the value atomically and returns the new value
+func (i *Int64) Add(delta int64) int64 {
+	return atomic.AddInt64((*int64)(i), delta)
+}
+
+// CompareAndSwap executes the compare-and-swap operation for the value
+func (i *Int64) CompareAndSwap(old, new int64) bool {
+	return atomic.CompareAndSwapInt64((*int64)(i), old, new)
+}
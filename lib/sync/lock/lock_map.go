@@ -0,0 +1,152 @@
+package lock
+
+import (
+	"sort"
+	"sync"
+)
+
+const (
+	prime32 = uint32(16777619)
+)
+
+// Locks 提供对 key 加读写锁的能力
+// key 通过哈希映射到固定数量的读写锁上，不同的 key 可能共用同一把锁
+type Locks struct {
+	table []*sync.RWMutex
+}
+
+// Make 根据给定的数量创建锁表
+func Make(tableSize int) *Locks {
+	table := make([]*sync.RWMutex, tableSize)
+	for i := 0; i < tableSize; i++ {
+		table[i] = &sync.RWMutex{}
+	}
+	return &Locks{
+		table: table,
+	}
+}
+
+func fnv32(key string) uint32 {
+	hash := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		hash *= prime32
+		hash ^= uint32(key[i])
+	}
+	return hash
+}
+
+func (locks *Locks) spread(hashCode uint32) uint32 {
+	if locks == nil {
+		panic("locks is nil")
+	}
+	tableSize := uint32(len(locks.table))
+	return (tableSize - 1) & hashCode
+}
+
+// Lock 获取 key 的写锁
+func (locks *Locks) Lock(key string) {
+	index := locks.spread(fnv32(key))
+	mu := locks.table[index]
+	mu.Lock()
+}
+
+// RLock 获取 key 的读锁
+func (locks *Locks) RLock(key string) {
+	index := locks.spread(fnv32(key))
+	mu := locks.table[index]
+	mu.RLock()
+}
+
+// UnLock 释放 key 的写锁
+func (locks *Locks) UnLock(key string) {
+	index := locks.spread(fnv32(key))
+	mu := locks.table[index]
+	mu.Unlock()
+}
+
+// RUnLock 释放 key 的读锁
+func (locks *Locks) RUnLock(key string) {
+	index := locks.spread(fnv32(key))
+	mu := locks.table[index]
+	mu.RUnlock()
+}
+
+// LockAll 按照下标顺序获取所有的写锁，此时其它协程无法对任何 key 加锁
+func (locks *Locks) LockAll() {
+	for _, mu := range locks.table {
+		mu.Lock()
+	}
+}
+
+// UnLockAll 释放 LockAll 获取的锁
+func (locks *Locks) UnLockAll() {
+	for i := len(locks.table) - 1; i >= 0; i-- {
+		locks.table[i].Unlock()
+	}
+}
+
+// toLockIndices 返回去重并排好序的锁下标
+// 所有协程都按照相同的顺序加锁，从而避免死锁
+func (locks *Locks) toLockIndices(keys []string, reverse bool) []uint32 {
+	indexMap := make(map[uint32]struct{})
+	for _, key := range keys {
+		index := locks.spread(fnv32(key))
+		indexMap[index] = struct{}{}
+	}
+	indices := make([]uint32, 0, len(indexMap))
+	for index := range indexMap {
+		indices = append(indices, index)
+	}
+	sort.Slice(indices, func(i, j int) bool {
+		if !reverse {
+			return indices[i] < indices[j]
+		}
+		return indices[i] > indices[j]
+	})
+	return indices
+}
+
+// RWLocks 对 writeKeys 加写锁，对 readKeys 加读锁
+// 同时出现在两者中的 key 只加写锁
+func (locks *Locks) RWLocks(writeKeys []string, readKeys []string) {
+	keys := make([]string, 0, len(writeKeys)+len(readKeys))
+	keys = append(keys, writeKeys...)
+	keys = append(keys, readKeys...)
+	indices := locks.toLockIndices(keys, false)
+	writeIndexSet := make(map[uint32]struct{})
+	for _, wKey := range writeKeys {
+		idx := locks.spread(fnv32(wKey))
+		writeIndexSet[idx] = struct{}{}
+	}
+	for _, index := range indices {
+		_, w := writeIndexSet[index]
+		mu := locks.table[index]
+		if w {
+			mu.Lock()
+		} else {
+			mu.RLock()
+		}
+	}
+}
+
+// RWUnLocks 释放 RWLocks 获取的锁
+func (locks *Locks) RWUnLocks(writeKeys []string, readKeys []string) {
+	keys := make([]string, 0, len(writeKeys)+len(readKeys))
+	keys = append(keys, writeKeys...)
+	keys = append(keys, readKeys...)
+	indices := locks.toLockIndices(keys, true)
+	writeIndexSet := make(map[uint32]struct{})
+	for _, wKey := range writeKeys {
+		idx := locks.spread(fnv32(wKey))
+		writeIndexSet[idx] = struct{}{}
+	}
+	for _, index := range indices {
+		_, w := writeIndexSet[index]
+		mu := locks.table[index]
+		if w {
+			mu.Unlock()
+		} else {
+			mu.RUnlock()
+		}
+	}
+}
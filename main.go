@@ -11,11 +11,6 @@ import (
 
 const configFile string = "redis.conf"
 
-var defaultProperties = &config.ServerProperties{
-	Bind: "0.0.0.0",
-	Port: 6379,
-}
-
 func fileExists(filename string) bool {
 	info, err := os.Stat(filename)
 	return err == nil && !info.IsDir()
@@ -29,17 +24,16 @@ func main() {
 		TimeFormat: "2006-01-02",
 	})
 
+	// 没有配置文件时使用默认配置
 	if fileExists(configFile) {
 		config.SetupConfig(configFile)
-	} else {
-		config.Properties = defaultProperties
 	}
 
 	err := tcp.ListenAndServeWithSignal(
 		&tcp.Config{
 			Address: fmt.Sprintf("%s:%d",
-				config.Properties.Bind,
-				config.Properties.Port),
+				config.Properties().Bind,
+				config.Properties().Port),
 		},
 		handler.MakeHandler())
 	if err != nil {
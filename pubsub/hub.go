@@ -0,0 +1,50 @@
+package pubsub
+
+import (
+	"github.com/jujunwang/Mudis/datastruct/list"
+	"github.com/jujunwang/Mudis/interface/resp"
+	"github.com/jujunwang/Mudis/lib/wildcard"
+	"sync"
+)
+
+// patternSubs 是订阅同一个模式的客户端
+type patternSubs struct {
+	pattern     *wildcard.Pattern
+	subscribers *list.LinkedList // resp.Connection
+}
+
+// Hub 保存所有的订阅关系
+type Hub struct {
+	// 订阅和退订时加写锁，发布消息时加读锁
+	// 持有锁时只将消息放入订阅者的 feed，不会等待客户端接收
+	mu sync.RWMutex
+	// channel -> *list.LinkedList(resp.Connection)
+	subs map[string]*list.LinkedList
+	// pattern -> *patternSubs
+	patterns map[string]*patternSubs
+	// clients 保存处于订阅模式的客户端
+	clients map[resp.Connection]*subscriber
+}
+
+// MakeHub 新建一个 Hub
+func MakeHub() *Hub {
+	return &Hub{
+		subs:     make(map[string]*list.LinkedList),
+		patterns: make(map[string]*patternSubs),
+		clients:  make(map[resp.Connection]*subscriber),
+	}
+}
+
+// ChannelCount 返回至少有一个订阅者的频道数
+func (hub *Hub) ChannelCount() int {
+	hub.mu.RLock()
+	defer hub.mu.RUnlock()
+	return len(hub.subs)
+}
+
+// PatternCount 返回至少有一个订阅者的模式数
+func (hub *Hub) PatternCount() int {
+	hub.mu.RLock()
+	defer hub.mu.RUnlock()
+	return len(hub.patterns)
+}
@@ -0,0 +1,269 @@
+package pubsub
+
+import (
+	"github.com/jujunwang/Mudis/datastruct/list"
+	"github.com/jujunwang/Mudis/interface/resp"
+	"github.com/jujunwang/Mudis/lib/wildcard"
+	"github.com/jujunwang/Mudis/resp/reply"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	_subscribe    = "subscribe"
+	_unsubscribe  = "unsubscribe"
+	_psubscribe   = "psubscribe"
+	_punsubscribe = "punsubscribe"
+)
+
+var (
+	messageBytes  = []byte("message")
+	pmessageBytes = []byte("pmessage")
+)
+
+// makeMsg 生成订阅/退订的回复，例如 *3 subscribe channel count
+func makeMsg(t string, channel string, code int64) []byte {
+	return []byte("*3\r\n$" + strconv.FormatInt(int64(len(t)), 10) + reply.CRLF + t + reply.CRLF +
+		"$" + strconv.FormatInt(int64(len(channel)), 10) + reply.CRLF + channel + reply.CRLF +
+		":" + strconv.FormatInt(code, 10) + reply.CRLF)
+}
+
+// makeNullMsg 生成没有订阅任何频道时的退订回复
+func makeNullMsg(t string) []byte {
+	return []byte("*3\r\n$" + strconv.FormatInt(int64(len(t)), 10) + reply.CRLF + t + reply.CRLF +
+		"$-1" + reply.CRLF + ":0" + reply.CRLF)
+}
+
+func totalSubsCount(c resp.Connection) int64 {
+	return int64(c.SubsCount() + c.PSubsCount())
+}
+
+// IsSubscribed 返回连接是否处于订阅模式
+func IsSubscribed(c resp.Connection) bool {
+	return c.SubsCount()+c.PSubsCount() > 0
+}
+
+// subscribe0 将客户端加入订阅列表，已经订阅时返回 false
+func subscribe0(subs map[string]*list.LinkedList, channel string, client resp.Connection) bool {
+	subscribers, ok := subs[channel]
+	if !ok {
+		subscribers = list.Make()
+		subs[channel] = subscribers
+	}
+	if subscribers.Contains(client) {
+		return false
+	}
+	subscribers.Add(client)
+	return true
+}
+
+// unsubscribe0 将客户端移出订阅列表，没有订阅时返回 false
+func unsubscribe0(subs map[string]*list.LinkedList, channel string, client resp.Connection) bool {
+	subscribers, ok := subs[channel]
+	if !ok {
+		return false
+	}
+	removed := subscribers.RemoveAllByVal(client) > 0
+	if subscribers.Len() == 0 {
+		delete(subs, channel)
+	}
+	return removed
+}
+
+// Subscribe SUBSCRIBE channel [channel ...]
+func Subscribe(hub *Hub, c resp.Connection, args [][]byte) resp.Reply {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	s := hub.addSubscriber(c)
+	for _, arg := range args {
+		channel := string(arg)
+		if subscribe0(hub.subs, channel, c) {
+			c.Subscribe(channel)
+		}
+		s.send(makeMsg(_subscribe, channel, totalSubsCount(c)))
+	}
+	return &reply.NoReply{}
+}
+
+// UnSubscribe UNSUBSCRIBE [channel ...]，没有给出频道时退订所有频道
+func UnSubscribe(hub *Hub, c resp.Connection, args [][]byte) resp.Reply {
+	var channels []string
+	if len(args) > 0 {
+		channels = make([]string, len(args))
+		for i, b := range args {
+			channels[i] = string(b)
+		}
+	} else {
+		channels = c.GetChannels()
+	}
+	msgs := make([][]byte, 0, len(channels)+1)
+	if len(channels) == 0 {
+		msgs = append(msgs, makeNullMsg(_unsubscribe))
+	}
+	hub.mu.Lock()
+	for _, channel := range channels {
+		if unsubscribe0(hub.subs, channel, c) {
+			c.UnSubscribe(channel)
+		}
+		msgs = append(msgs, makeMsg(_unsubscribe, channel, totalSubsCount(c)))
+	}
+	hub.sendReplies(c, msgs)
+	return &reply.NoReply{}
+}
+
+// PSubscribe PSUBSCRIBE pattern [pattern ...]
+func PSubscribe(hub *Hub, c resp.Connection, args [][]byte) resp.Reply {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	s := hub.addSubscriber(c)
+	for _, arg := range args {
+		pattern := string(arg)
+		subs, ok := hub.patterns[pattern]
+		if !ok {
+			subs = &patternSubs{
+				pattern:     wildcard.CompilePattern(pattern),
+				subscribers: list.Make(),
+			}
+			hub.patterns[pattern] = subs
+		}
+		if !subs.subscribers.Contains(c) {
+			subs.subscribers.Add(c)
+			c.PSubscribe(pattern)
+		}
+		s.send(makeMsg(_psubscribe, pattern, totalSubsCount(c)))
+	}
+	return &reply.NoReply{}
+}
+
+// PUnSubscribe PUNSUBSCRIBE [pattern ...]，没有给出模式时退订所有模式
+func PUnSubscribe(hub *Hub, c resp.Connection, args [][]byte) resp.Reply {
+	var patterns []string
+	if len(args) > 0 {
+		patterns = make([]string, len(args))
+		for i, b := range args {
+			patterns[i] = string(b)
+		}
+	} else {
+		patterns = c.GetPatterns()
+	}
+	msgs := make([][]byte, 0, len(patterns)+1)
+	if len(patterns) == 0 {
+		msgs = append(msgs, makeNullMsg(_punsubscribe))
+	}
+	hub.mu.Lock()
+	for _, pattern := range patterns {
+		hub.punsubscribe0(pattern, c)
+		msgs = append(msgs, makeMsg(_punsubscribe, pattern, totalSubsCount(c)))
+	}
+	hub.sendReplies(c, msgs)
+	return &reply.NoReply{}
+}
+
+func (hub *Hub) punsubscribe0(pattern string, c resp.Connection) {
+	subs, ok := hub.patterns[pattern]
+	if !ok {
+		return
+	}
+	if subs.subscribers.RemoveAllByVal(c) > 0 {
+		c.PUnSubscribe(pattern)
+	}
+	if subs.subscribers.Len() == 0 {
+		delete(hub.patterns, pattern)
+	}
+}
+
+// UnsubscribeAll 在客户端关闭时退订所有频道和模式
+func UnsubscribeAll(hub *Hub, c resp.Connection) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	for _, channel := range c.GetChannels() {
+		unsubscribe0(hub.subs, channel, c)
+		c.UnSubscribe(channel)
+	}
+	for _, pattern := range c.GetPatterns() {
+		hub.punsubscribe0(pattern, c)
+	}
+	hub.removeSubscriber(c)
+}
+
+// Publish PUBLISH channel message，返回收到消息的客户端数量
+// 消息只放入订阅者的 feed，接收过慢的订阅者不会阻塞发布者
+func Publish(hub *Hub, args [][]byte) resp.Reply {
+	if len(args) != 2 {
+		return reply.MakeArgNumErrReply("publish")
+	}
+	channel := string(args[0])
+	message := args[1]
+
+	hub.mu.RLock()
+	defer hub.mu.RUnlock()
+	var count int64 = 0
+	if subscribers, ok := hub.subs[channel]; ok {
+		payload := reply.MakeMultiBulkReply([][]byte{messageBytes, args[0], message}).ToBytes()
+		subscribers.ForEach(func(i int, c interface{}) bool {
+			client, _ := c.(resp.Connection)
+			hub.clients[client].send(payload)
+			count++
+			return true
+		})
+	}
+	for pattern, subs := range hub.patterns {
+		if !subs.pattern.IsMatch(channel) {
+			continue
+		}
+		payload := reply.MakeMultiBulkReply([][]byte{pmessageBytes, []byte(pattern), args[0], message}).ToBytes()
+		subs.subscribers.ForEach(func(i int, c interface{}) bool {
+			client, _ := c.(resp.Connection)
+			hub.clients[client].send(payload)
+			count++
+			return true
+		})
+	}
+	return reply.MakeIntReply(count)
+}
+
+// PubSub PUBSUB CHANNELS [pattern] | NUMSUB [channel ...] | NUMPAT
+func PubSub(hub *Hub, args [][]byte) resp.Reply {
+	subCmd := strings.ToLower(string(args[0]))
+	hub.mu.RLock()
+	defer hub.mu.RUnlock()
+	switch subCmd {
+	case "channels":
+		if len(args) > 2 {
+			return reply.MakeErrReply("ERR wrong number of arguments for 'pubsub|channels' command")
+		}
+		var pattern *wildcard.Pattern
+		if len(args) == 2 {
+			pattern = wildcard.CompilePattern(string(args[1]))
+		}
+		channels := make([]string, 0, len(hub.subs))
+		for channel := range hub.subs {
+			if pattern == nil || pattern.IsMatch(channel) {
+				channels = append(channels, channel)
+			}
+		}
+		sort.Strings(channels)
+		result := make([][]byte, len(channels))
+		for i, channel := range channels {
+			result[i] = []byte(channel)
+		}
+		return reply.MakeMultiBulkReply(result)
+	case "numsub":
+		result := make([]resp.Reply, 0, 2*(len(args)-1))
+		for _, arg := range args[1:] {
+			var count int64 = 0
+			if subscribers, ok := hub.subs[string(arg)]; ok {
+				count = int64(subscribers.Len())
+			}
+			result = append(result, reply.MakeBulkReply(arg), reply.MakeIntReply(count))
+		}
+		return reply.MakeMultiRawReply(result)
+	case "numpat":
+		if len(args) != 1 {
+			return reply.MakeErrReply("ERR wrong number of arguments for 'pubsub|numpat' command")
+		}
+		return reply.MakeIntReply(int64(len(hub.patterns)))
+	}
+	return reply.MakeErrReply("ERR unknown subcommand '" + string(args[0]) + "'. Try PUBSUB HELP.")
+}
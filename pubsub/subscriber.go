@@ -0,0 +1,117 @@
+package pubsub
+
+import (
+	"github.com/jujunwang/Mudis/interface/resp"
+	"github.com/jujunwang/Mudis/lib/logger"
+	"github.com/jujunwang/Mudis/lib/sync/atomic"
+	"github.com/jujunwang/Mudis/resp/reply"
+)
+
+// subscriberBufferSize 是每个订阅者最多缓冲的消息数
+// 客户端接收过慢导致缓冲区满时断开连接，避免拖慢发布消息的客户端
+const subscriberBufferSize = 1024
+
+// subscriber 是一个处于订阅模式的客户端，由单独的 goroutine 将 feed 中的消息发送给客户端
+// 订阅、退订的回复和订阅模式下其他命令的回复也经过 feed，保证它们与消息之间的顺序
+type subscriber struct {
+	conn     resp.Connection
+	feed     chan []byte
+	overflow atomic.Boolean
+	// done 在 feed 中的消息全部发送后关闭
+	done chan struct{}
+}
+
+// forceCloser 是可以不等待正在发送的回复直接关闭的连接，见 connection.Connection.ForceClose
+type forceCloser interface {
+	ForceClose() error
+}
+
+// run 将消息发送给客户端，直到 feed 被关闭
+func (s *subscriber) run() {
+	defer close(s.done)
+	for b := range s.feed {
+		_ = s.conn.Write(b)
+	}
+}
+
+// send 将消息放入 feed，缓冲区满时断开客户端，调用方需要持有 hub.mu
+func (s *subscriber) send(b []byte) {
+	select {
+	case s.feed <- b:
+	default:
+		if !s.overflow.Get() {
+			s.overflow.Set(true)
+			logger.Warn("subscriber is too slow, closing it")
+			// 发送中的回复可能一直阻塞，不能等待它完成
+			if c, ok := s.conn.(forceCloser); ok {
+				_ = c.ForceClose()
+			}
+		}
+	}
+}
+
+// addSubscriber 返回连接对应的 subscriber，第一次订阅时创建，调用方需要持有 hub.mu 的写锁
+func (hub *Hub) addSubscriber(c resp.Connection) *subscriber {
+	s, ok := hub.clients[c]
+	if !ok {
+		s = &subscriber{
+			conn: c,
+			feed: make(chan []byte, subscriberBufferSize),
+			done: make(chan struct{}),
+		}
+		hub.clients[c] = s
+		go s.run()
+	}
+	return s
+}
+
+// removeSubscriber 停止连接的 subscriber，调用方需要持有 hub.mu 的写锁
+// subscriber 的 done 关闭后，之前的消息已经全部发送
+func (hub *Hub) removeSubscriber(c resp.Connection) {
+	s, ok := hub.clients[c]
+	if !ok {
+		return
+	}
+	delete(hub.clients, c)
+	close(s.feed)
+}
+
+// sendReplies 发送退订的回复，调用方需要持有 hub.mu 的写锁，返回前释放锁
+// 连接退订了所有频道和模式时停止它的 subscriber，并等待之前的消息发送完，之后的回复才能直接写入连接
+func (hub *Hub) sendReplies(c resp.Connection, msgs [][]byte) {
+	s, subscribed := hub.clients[c]
+	if subscribed {
+		for _, msg := range msgs {
+			s.send(msg)
+		}
+		if totalSubsCount(c) == 0 {
+			hub.removeSubscriber(c)
+		} else {
+			s = nil
+		}
+	}
+	hub.mu.Unlock()
+	if s != nil {
+		<-s.done
+	}
+	if !subscribed {
+		// 没有订阅过的连接没有 subscriber，在释放锁之后直接写入
+		for _, msg := range msgs {
+			_ = c.Write(msg)
+		}
+	}
+}
+
+// Reply 发送订阅模式下其他命令(例如 PING)的回复，保证它在之前收到的消息之后
+func Reply(hub *Hub, c resp.Connection, r resp.Reply) resp.Reply {
+	hub.mu.RLock()
+	s, ok := hub.clients[c]
+	if ok {
+		s.send(r.ToBytes())
+	}
+	hub.mu.RUnlock()
+	if !ok {
+		return r
+	}
+	return &reply.NoReply{}
+}
@@ -0,0 +1,241 @@
+package rdb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/jujunwang/Mudis/datastruct/dict"
+	List "github.com/jujunwang/Mudis/datastruct/list"
+	"github.com/jujunwang/Mudis/datastruct/set"
+	"github.com/jujunwang/Mudis/datastruct/sortedset"
+	"github.com/jujunwang/Mudis/interface/database"
+	"hash/crc64"
+	"io"
+	"math"
+	"os"
+	"time"
+)
+
+// ErrChecksum 表示快照的校验和与内容不一致，文件可能被截断或损坏
+var ErrChecksum = errors.New("rdb checksum mismatch")
+
+// Entry 是快照中的一个 key
+type Entry struct {
+	DBIndex int
+	Key     string
+	// Entity 是 key 的值，值以命令保存时为 nil
+	Entity *database.DataEntity
+	// Cmds 是重建 key 需要执行的命令，用于流等没有专门编码的类型
+	Cmds []CmdLine
+	// ExpireTime 为零值表示没有过期时间
+	ExpireTime time.Time
+}
+
+// Load 先校验快照的校验和，校验通过后依次读取快照中的 key 并交给 fn 处理
+// 文件损坏时不会调用 fn，避免加载不完整的数据
+func Load(filename string, fn func(entry *Entry) error) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if err := verifyChecksum(file); err != nil {
+		return err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	dec := &decoder{r: bufio.NewReader(file)}
+	return dec.decode(fn)
+}
+
+// verifyChecksum 计算文件中除最后 8 字节之外的内容的 CRC64，并与最后 8 字节比较
+func verifyChecksum(file *os.File) error {
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	size := info.Size()
+	if size < int64(len(magic))+1+1+8 {
+		return ErrChecksum
+	}
+	crc := crc64.New(crcTable)
+	if _, err := io.Copy(crc, io.LimitReader(file, size-8)); err != nil {
+		return err
+	}
+	var sum [8]byte
+	if _, err := io.ReadFull(file, sum[:]); err != nil {
+		return err
+	}
+	if binary.LittleEndian.Uint64(sum[:]) != crc.Sum64() {
+		return ErrChecksum
+	}
+	return nil
+}
+
+type decoder struct {
+	r *bufio.Reader
+}
+
+func (dec *decoder) decode(fn func(entry *Entry) error) error {
+	header := make([]byte, len(magic)+1)
+	if _, err := io.ReadFull(dec.r, header); err != nil {
+		return err
+	}
+	if string(header[:len(magic)]) != magic {
+		return errors.New("not a rdb file")
+	}
+	if header[len(magic)] != version {
+		return fmt.Errorf("unsupported rdb version %d", header[len(magic)])
+	}
+	dbIndex := 0
+	var expireTime time.Time
+	for {
+		op, err := dec.r.ReadByte()
+		if err != nil {
+			return err
+		}
+		switch op {
+		case opEOF:
+			return nil
+		case opSelectDB:
+			n, err := binary.ReadUvarint(dec.r)
+			if err != nil {
+				return err
+			}
+			dbIndex = int(n)
+			continue
+		case opExpireTime:
+			ms, err := binary.ReadVarint(dec.r)
+			if err != nil {
+				return err
+			}
+			expireTime = time.UnixMilli(ms)
+			continue
+		}
+		entry, err := dec.readEntry(op)
+		if err != nil {
+			return err
+		}
+		entry.DBIndex = dbIndex
+		entry.ExpireTime = expireTime
+		expireTime = time.Time{}
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+}
+
+func (dec *decoder) readString() ([]byte, error) {
+	n, err := binary.ReadUvarint(dec.r)
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(dec.r, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func (dec *decoder) readFloat() (float64, error) {
+	var b [8]byte
+	if _, err := io.ReadFull(dec.r, b[:]); err != nil {
+		return 0, err
+	}
+	return math.Float64frombits(binary.LittleEndian.Uint64(b[:])), nil
+}
+
+// readEntry 读取类型为 valueType 的 key 和 value
+func (dec *decoder) readEntry(valueType byte) (*Entry, error) {
+	key, err := dec.readString()
+	if err != nil {
+		return nil, err
+	}
+	entry := &Entry{Key: string(key)}
+	if valueType == typeString {
+		val, err := dec.readString()
+		if err != nil {
+			return nil, err
+		}
+		entry.Entity = &database.DataEntity{Data: val}
+		return entry, nil
+	}
+
+	size, err := binary.ReadUvarint(dec.r)
+	if err != nil {
+		return nil, err
+	}
+	switch valueType {
+	case typeList:
+		list := List.Make()
+		for i := uint64(0); i < size; i++ {
+			val, err := dec.readString()
+			if err != nil {
+				return nil, err
+			}
+			list.Add(val)
+		}
+		entry.Entity = &database.DataEntity{Data: list}
+	case typeSet:
+		s := set.Make()
+		for i := uint64(0); i < size; i++ {
+			member, err := dec.readString()
+			if err != nil {
+				return nil, err
+			}
+			s.Add(string(member))
+		}
+		entry.Entity = &database.DataEntity{Data: s}
+	case typeZSet:
+		zset := sortedset.Make()
+		for i := uint64(0); i < size; i++ {
+			member, err := dec.readString()
+			if err != nil {
+				return nil, err
+			}
+			score, err := dec.readFloat()
+			if err != nil {
+				return nil, err
+			}
+			zset.Add(string(member), score)
+		}
+		entry.Entity = &database.DataEntity{Data: zset}
+	case typeHash:
+		hash := dict.MakeSimple()
+		for i := uint64(0); i < size; i++ {
+			field, err := dec.readString()
+			if err != nil {
+				return nil, err
+			}
+			val, err := dec.readString()
+			if err != nil {
+				return nil, err
+			}
+			hash.Put(string(field), val)
+		}
+		entry.Entity = &database.DataEntity{Data: hash}
+	case typeCommands:
+		cmds := make([]CmdLine, 0, size)
+		for i := uint64(0); i < size; i++ {
+			argc, err := binary.ReadUvarint(dec.r)
+			if err != nil {
+				return nil, err
+			}
+			cmdLine := make(CmdLine, 0, argc)
+			for j := uint64(0); j < argc; j++ {
+				arg, err := dec.readString()
+				if err != nil {
+					return nil, err
+				}
+				cmdLine = append(cmdLine, arg)
+			}
+			cmds = append(cmds, cmdLine)
+		}
+		entry.Cmds = cmds
+	default:
+		return nil, fmt.Errorf("unknown rdb value type %d", valueType)
+	}
+	return entry, nil
+}
@@ -0,0 +1,211 @@
+// Package rdb 实现了二进制的快照格式，用于 SAVE、BGSAVE 以及启动时加载数据
+//
+// 文件格式:
+//
+//	"MUDISRDB" 版本号(1 字节)
+//	opSelectDB db编号
+//	[opExpireTime 过期时间] 类型 key value
+//	...
+//	opEOF 校验和
+//
+// 整数使用 varint 编码，字符串为长度加内容，校验和是之前所有字节的 CRC64(ECMA)，以小端序写入
+package rdb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"github.com/jujunwang/Mudis/aof"
+	"github.com/jujunwang/Mudis/datastruct/dict"
+	List "github.com/jujunwang/Mudis/datastruct/list"
+	"github.com/jujunwang/Mudis/datastruct/set"
+	"github.com/jujunwang/Mudis/datastruct/sortedset"
+	"github.com/jujunwang/Mudis/interface/database"
+	"hash"
+	"hash/crc64"
+	"io"
+	"math"
+	"time"
+)
+
+// CmdLine 代表命令
+type CmdLine = [][]byte
+
+const (
+	magic   = "MUDISRDB"
+	version = 1
+)
+
+const (
+	opExpireTime = 0xFC
+	opSelectDB   = 0xFE
+	opEOF        = 0xFF
+)
+
+// value 的类型
+const (
+	typeString = iota
+	typeList
+	typeSet
+	typeZSet
+	typeHash
+	// typeCommands 表示 value 是能够重建 key 的命令，用于流等没有专门编码的类型
+	typeCommands
+)
+
+var crcTable = crc64.MakeTable(crc64.ECMA)
+
+// Encoder 将数据编码为快照，写入的所有字节都会计入校验和
+type Encoder struct {
+	w   *bufio.Writer
+	crc hash.Hash64
+	// 写入 varint 时使用的缓冲区
+	buf [binary.MaxVarintLen64]byte
+	err error
+}
+
+// NewEncoder 新建 Encoder 并写入文件头
+func NewEncoder(w io.Writer) *Encoder {
+	crc := crc64.New(crcTable)
+	enc := &Encoder{
+		w:   bufio.NewWriter(io.MultiWriter(w, crc)),
+		crc: crc,
+	}
+	enc.writeBytes([]byte(magic))
+	enc.writeByte(version)
+	return enc
+}
+
+func (enc *Encoder) writeBytes(b []byte) {
+	if enc.err == nil {
+		_, enc.err = enc.w.Write(b)
+	}
+}
+
+func (enc *Encoder) writeByte(b byte) {
+	if enc.err == nil {
+		enc.err = enc.w.WriteByte(b)
+	}
+}
+
+func (enc *Encoder) writeUvarint(n uint64) {
+	size := binary.PutUvarint(enc.buf[:], n)
+	enc.writeBytes(enc.buf[:size])
+}
+
+func (enc *Encoder) writeVarint(n int64) {
+	size := binary.PutVarint(enc.buf[:], n)
+	enc.writeBytes(enc.buf[:size])
+}
+
+func (enc *Encoder) writeString(b []byte) {
+	enc.writeUvarint(uint64(len(b)))
+	enc.writeBytes(b)
+}
+
+func (enc *Encoder) writeFloat(f float64) {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], math.Float64bits(f))
+	enc.writeBytes(b[:])
+}
+
+// SelectDB 之后写入的 key 都属于编号为 dbIndex 的 DB
+func (enc *Encoder) SelectDB(dbIndex int) error {
+	enc.writeByte(opSelectDB)
+	enc.writeUvarint(uint64(dbIndex))
+	return enc.err
+}
+
+// WriteEntry 写入一个 key，expireTime 为零值表示没有过期时间
+// 编码过程中会读取 entity 的内容，调用方需要保证 entity 不会被同时修改
+func (enc *Encoder) WriteEntry(key string, entity *database.DataEntity, expireTime time.Time) error {
+	if !expireTime.IsZero() {
+		enc.writeByte(opExpireTime)
+		enc.writeVarint(expireTime.UnixMilli())
+	}
+	switch val := entity.Data.(type) {
+	case []byte:
+		enc.writeByte(typeString)
+		enc.writeString([]byte(key))
+		enc.writeString(val)
+	case *List.LinkedList:
+		enc.writeByte(typeList)
+		enc.writeString([]byte(key))
+		enc.writeUvarint(uint64(val.Len()))
+		val.ForEach(func(i int, v interface{}) bool {
+			bytes, _ := v.([]byte)
+			enc.writeString(bytes)
+			return true
+		})
+	case *set.Set:
+		enc.writeByte(typeSet)
+		enc.writeString([]byte(key))
+		enc.writeUvarint(uint64(val.Len()))
+		val.ForEach(func(member string) bool {
+			enc.writeString([]byte(member))
+			return true
+		})
+	case *sortedset.SortedSet:
+		enc.writeByte(typeZSet)
+		enc.writeString([]byte(key))
+		enc.writeUvarint(uint64(val.Len()))
+		val.ForEach(sortedset.NegativeInfBorder, sortedset.PositiveInfBorder, 0, -1, false,
+			func(element *sortedset.Element) bool {
+				enc.writeString([]byte(element.Member))
+				enc.writeFloat(element.Score)
+				return true
+			})
+	case dict.Dict:
+		enc.writeByte(typeHash)
+		enc.writeString([]byte(key))
+		enc.writeUvarint(uint64(val.Len()))
+		val.ForEach(func(field string, v interface{}) bool {
+			bytes, _ := v.([]byte)
+			enc.writeString([]byte(field))
+			enc.writeString(bytes)
+			return true
+		})
+	default:
+		enc.writeCommands(key, aof.EntityToCmds(key, entity))
+	}
+	return enc.err
+}
+
+// WriteCommands 写入一个由 cmdLines 重建的 key，expireTime 为零值表示没有过期时间
+func (enc *Encoder) WriteCommands(key string, cmdLines []aof.CmdLine, expireTime time.Time) error {
+	if !expireTime.IsZero() {
+		enc.writeByte(opExpireTime)
+		enc.writeVarint(expireTime.UnixMilli())
+	}
+	enc.writeCommands(key, cmdLines)
+	return enc.err
+}
+
+func (enc *Encoder) writeCommands(key string, cmdLines []aof.CmdLine) {
+	enc.writeByte(typeCommands)
+	enc.writeString([]byte(key))
+	enc.writeUvarint(uint64(len(cmdLines)))
+	for _, cmdLine := range cmdLines {
+		enc.writeUvarint(uint64(len(cmdLine)))
+		for _, arg := range cmdLine {
+			enc.writeString(arg)
+		}
+	}
+}
+
+// Close 写入文件尾和校验和，并将缓冲区中的数据写入底层的 Writer
+func (enc *Encoder) Close() error {
+	enc.writeByte(opEOF)
+	if enc.err == nil {
+		enc.err = enc.w.Flush()
+	}
+	if enc.err != nil {
+		return enc.err
+	}
+	var sum [8]byte
+	binary.LittleEndian.PutUint64(sum[:], enc.crc.Sum64())
+	_, enc.err = enc.w.Write(sum[:])
+	if enc.err == nil {
+		enc.err = enc.w.Flush()
+	}
+	return enc.err
+}
@@ -2,6 +2,7 @@ package connection
 
 import (
 	"bytes"
+	"github.com/jujunwang/Mudis/lib/sync/atomic"
 	"github.com/jujunwang/Mudis/lib/sync/wait"
 	"net"
 	"sync"
@@ -15,28 +16,175 @@ type Connection struct {
 	waitingReply wait.Wait
 	// 用于发送响应时加锁
 	mu sync.Mutex
-	// 切换DB
-	selectedDB int
+	// 切换DB，CLIENT LIST 会在其它连接的 goroutine 中读取
+	selectedDB atomic.Int64
+
+	// 客户端信息，用于 CLIENT 命令
+	id         uint64
+	createTime time.Time
+	// infoMu 保护 name、user 和最近一次执行的命令
+	infoMu sync.Mutex
+	name   string
+	// user 是认证的 ACL 用户名，为空表示还没有认证
+	user        string
+	lastCmd     string
+	lastCmdTime time.Time
+	// closeAfterReply 表示发送完当前的回复后关闭连接，例如客户端 CLIENT KILL 了自己
+	closeAfterReply atomic.Boolean
+	// monitor 表示连接执行了 MONITOR
+	monitor atomic.Boolean
+	// closed 在连接关闭时关闭，用于唤醒阻塞在 BLPOP 等命令上的客户端
+	closed     chan struct{}
+	closedInit sync.Once
+	closedOnce sync.Once
+
+	// 发布订阅相关，subsMu 保护订阅的频道和模式
+	subsMu   sync.Mutex
+	subs     map[string]bool
+	patterns map[string]bool
+
+	// 事务相关
+	multiState bool
+	// MULTI 之后排队的命令
+	queue [][][]byte
+	// WATCH 的 key 及其版本号
+	watching map[string]uint32
+	// 命令入队时遇到的错误
+	txErrors []error
 }
 
+// connIDGen 用于为每个连接分配递增的 ID
+var connIDGen atomic.Int64
+
 func NewConn(conn net.Conn) *Connection {
+	now := time.Now()
 	return &Connection{
-		conn: conn,
+		conn:        conn,
+		id:          uint64(connIDGen.Add(1)),
+		createTime:  now,
+		lastCmdTime: now,
 	}
 }
 
-// RemoteAddr 返回远端地址
+// ID 返回连接的唯一 ID
+func (c *Connection) ID() uint64 {
+	return c.id
+}
+
+// CreateTime 返回连接建立的时间
+func (c *Connection) CreateTime() time.Time {
+	return c.createTime
+}
+
+// LocalAddr 返回本地地址
+func (c *Connection) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+// Name 返回 CLIENT SETNAME 设置的连接名
+func (c *Connection) Name() string {
+	c.infoMu.Lock()
+	defer c.infoMu.Unlock()
+	return c.name
+}
+
+// SetName 设置连接名
+func (c *Connection) SetName(name string) {
+	c.infoMu.Lock()
+	defer c.infoMu.Unlock()
+	c.name = name
+}
+
+// User 返回认证的 ACL 用户名，还没有认证时返回空字符串
+func (c *Connection) User() string {
+	c.infoMu.Lock()
+	defer c.infoMu.Unlock()
+	return c.user
+}
+
+// SetUser 设置认证的 ACL 用户名
+func (c *Connection) SetUser(user string) {
+	c.infoMu.Lock()
+	defer c.infoMu.Unlock()
+	c.user = user
+}
+
+// SetLastCmd 记录最近一次执行的命令及其时间
+func (c *Connection) SetLastCmd(cmd string, t time.Time) {
+	c.infoMu.Lock()
+	defer c.infoMu.Unlock()
+	c.lastCmd = cmd
+	c.lastCmdTime = t
+}
+
+// SetCloseAfterReply 标记连接在发送完当前的回复后关闭
+func (c *Connection) SetCloseAfterReply() {
+	c.closeAfterReply.Set(true)
+}
+
+// SetMonitor 标记连接为 MONITOR 客户端
+func (c *Connection) SetMonitor() {
+	c.monitor.Set(true)
+}
+
+// IsMonitor 返回连接是否执行了 MONITOR
+func (c *Connection) IsMonitor() bool {
+	return c.monitor.Get()
+}
+
+// CloseAfterReply 返回连接是否需要在发送完回复后关闭
+func (c *Connection) CloseAfterReply() bool {
+	return c.closeAfterReply.Get()
+}
+
+// LastCmd 返回最近一次执行的命令及其时间
+func (c *Connection) LastCmd() (string, time.Time) {
+	c.infoMu.Lock()
+	defer c.infoMu.Unlock()
+	return c.lastCmd, c.lastCmdTime
+}
+
+// RemoteAddr 返回远端地址，FakeConn 等没有网络连接的客户端返回 nil
 func (c *Connection) RemoteAddr() net.Addr {
+	if c.conn == nil {
+		return nil
+	}
 	return c.conn.RemoteAddr()
 }
 
 // Close 与客户端断开连接
 func (c *Connection) Close() error {
+	c.markClosed()
 	c.waitingReply.WaitWithTimeout(10 * time.Second)
 	_ = c.conn.Close()
 	return nil
 }
 
+// ForceClose 不等待正在发送的回复，立即与客户端断开连接，用于接收过慢的客户端
+func (c *Connection) ForceClose() error {
+	c.markClosed()
+	return c.conn.Close()
+}
+
+// Closed 返回在连接关闭时关闭的 channel
+func (c *Connection) Closed() <-chan struct{} {
+	return c.closedChan()
+}
+
+// closedChan 在第一次使用时创建 closed，FakeConn 等直接构造的连接没有经过 NewConn
+func (c *Connection) closedChan() chan struct{} {
+	c.closedInit.Do(func() {
+		c.closed = make(chan struct{})
+	})
+	return c.closed
+}
+
+func (c *Connection) markClosed() {
+	c.closedOnce.Do(func() {
+		close(c.closedChan())
+	})
+}
+
 // Write 通过TCP向客户端发送响应
 func (c *Connection) Write(b []byte) error {
 	if len(b) == 0 {
@@ -55,12 +203,125 @@ func (c *Connection) Write(b []byte) error {
 
 // GetDBIndex 返回选中的DB
 func (c *Connection) GetDBIndex() int {
-	return c.selectedDB
+	return int(c.selectedDB.Get())
 }
 
 // SelectDB 切换DB
 func (c *Connection) SelectDB(dbNum int) {
-	c.selectedDB = dbNum
+	c.selectedDB.Set(int64(dbNum))
+}
+
+// Subscribe 将频道加入连接的订阅列表
+func (c *Connection) Subscribe(channel string) {
+	c.subsMu.Lock()
+	defer c.subsMu.Unlock()
+	if c.subs == nil {
+		c.subs = make(map[string]bool)
+	}
+	c.subs[channel] = true
+}
+
+// UnSubscribe 将频道移出连接的订阅列表
+func (c *Connection) UnSubscribe(channel string) {
+	c.subsMu.Lock()
+	defer c.subsMu.Unlock()
+	delete(c.subs, channel)
+}
+
+// SubsCount 返回订阅的频道数量
+func (c *Connection) SubsCount() int {
+	c.subsMu.Lock()
+	defer c.subsMu.Unlock()
+	return len(c.subs)
+}
+
+// GetChannels 返回订阅的所有频道
+func (c *Connection) GetChannels() []string {
+	c.subsMu.Lock()
+	defer c.subsMu.Unlock()
+	channels := make([]string, 0, len(c.subs))
+	for channel := range c.subs {
+		channels = append(channels, channel)
+	}
+	return channels
+}
+
+// PSubscribe 将模式加入连接的订阅列表
+func (c *Connection) PSubscribe(pattern string) {
+	c.subsMu.Lock()
+	defer c.subsMu.Unlock()
+	if c.patterns == nil {
+		c.patterns = make(map[string]bool)
+	}
+	c.patterns[pattern] = true
+}
+
+// PUnSubscribe 将模式移出连接的订阅列表
+func (c *Connection) PUnSubscribe(pattern string) {
+	c.subsMu.Lock()
+	defer c.subsMu.Unlock()
+	delete(c.patterns, pattern)
+}
+
+// PSubsCount 返回订阅的模式数量
+func (c *Connection) PSubsCount() int {
+	c.subsMu.Lock()
+	defer c.subsMu.Unlock()
+	return len(c.patterns)
+}
+
+// GetPatterns 返回订阅的所有模式
+func (c *Connection) GetPatterns() []string {
+	c.subsMu.Lock()
+	defer c.subsMu.Unlock()
+	patterns := make([]string, 0, len(c.patterns))
+	for pattern := range c.patterns {
+		patterns = append(patterns, pattern)
+	}
+	return patterns
+}
+
+// InMultiState 返回连接是否处于事务状态
+func (c *Connection) InMultiState() bool {
+	return c.multiState
+}
+
+// SetMultiState 设置事务状态，退出事务时清空排队的命令和 WATCH 的 key
+func (c *Connection) SetMultiState(state bool) {
+	if !state {
+		c.watching = nil
+		c.queue = nil
+		c.txErrors = nil
+	}
+	c.multiState = state
+}
+
+// GetQueuedCmdLine 返回事务中排队的命令
+func (c *Connection) GetQueuedCmdLine() [][][]byte {
+	return c.queue
+}
+
+// EnqueueCmd 将命令加入事务队列
+func (c *Connection) EnqueueCmd(cmdLine [][]byte) {
+	c.queue = append(c.queue, cmdLine)
+}
+
+// AddTxError 记录命令入队时的错误
+func (c *Connection) AddTxError(err error) {
+	c.txErrors = append(c.txErrors, err)
+}
+
+// GetTxErrors 返回命令入队时的错误
+func (c *Connection) GetTxErrors() []error {
+	return c.txErrors
+}
+
+// GetWatching 返回 WATCH 的 key 及其版本号
+func (c *Connection) GetWatching() map[string]uint32 {
+	if c.watching == nil {
+		c.watching = make(map[string]uint32)
+	}
+	return c.watching
 }
 
 // FakeConn 假的 redis server
@@ -0,0 +1,256 @@
+package handler
+
+import (
+	"github.com/jujunwang/Mudis/acl"
+	"github.com/jujunwang/Mudis/config"
+	"github.com/jujunwang/Mudis/database"
+	"github.com/jujunwang/Mudis/interface/resp"
+	"github.com/jujunwang/Mudis/lib/logger"
+	"github.com/jujunwang/Mudis/resp/connection"
+	"github.com/jujunwang/Mudis/resp/reply"
+	"strings"
+)
+
+var (
+	noAuthErrReply    = reply.MakeErrReply("NOAUTH Authentication required.")
+	wrongPassErrReply = reply.MakeErrReply("WRONGPASS invalid username-password pair or user is disabled.")
+	noPermKeyErrReply = reply.MakeErrReply("NOPERM this user has no permissions to access one of the keys used as arguments")
+)
+
+// currentUser 返回连接使用的 ACL 用户
+// 没有执行过 AUTH 的连接使用 default 用户，default 用户需要密码时返回 nil
+func (h *RespHandler) currentUser(c *connection.Connection) *acl.User {
+	name := c.User()
+	if name != "" {
+		return h.acl.GetUser(name)
+	}
+	u := h.acl.GetUser(acl.DefaultUser)
+	if u == nil || !u.Enabled() || !u.NoPass() {
+		return nil
+	}
+	return u
+}
+
+// checkPermission 检查连接是否已经认证以及用户是否有权限执行命令和访问其中的 key
+// 事务中被拒绝的命令会让 EXEC 失败
+func (h *RespHandler) checkPermission(c *connection.Connection, cmdName string, cmdLine [][]byte) resp.Reply {
+	u := h.currentUser(c)
+	if u == nil {
+		return noAuthErrReply
+	}
+	var errReply reply.ErrorReply
+	if !u.CanRun(cmdName) {
+		errReply = reply.MakeErrReply("NOPERM this user has no permissions to run the '" + cmdName + "' command")
+	} else {
+		for _, key := range database.CommandKeys(cmdLine) {
+			if !u.CanAccessKey(key) {
+				errReply = noPermKeyErrReply
+				break
+			}
+		}
+	}
+	if errReply == nil {
+		return nil
+	}
+	if c.InMultiState() && cmdName != "exec" && cmdName != "discard" {
+		c.AddTxError(errReply)
+	}
+	return errReply
+}
+
+// execAuth AUTH [username] password
+func (h *RespHandler) execAuth(c *connection.Connection, args [][]byte) resp.Reply {
+	var name, password string
+	switch len(args) {
+	case 1:
+		name, password = acl.DefaultUser, string(args[0])
+		if u := h.acl.GetUser(acl.DefaultUser); u != nil && u.NoPass() {
+			return reply.MakeErrReply("ERR AUTH <password> called without any password configured for the default user. " +
+				"Are you sure your configuration is correct?")
+		}
+	case 2:
+		name, password = string(args[0]), string(args[1])
+	default:
+		return reply.MakeArgNumErrReply("auth")
+	}
+	if !h.acl.Authenticate(name, password) {
+		return wrongPassErrReply
+	}
+	c.SetUser(name)
+	return reply.MakeOkReply()
+}
+
+// execAcl ACL SETUSER|DELUSER|LIST|USERS|WHOAMI|GETUSER|CAT|LOAD|SAVE
+func (h *RespHandler) execAcl(c *connection.Connection, args [][]byte) resp.Reply {
+	if len(args) == 0 {
+		return reply.MakeArgNumErrReply("acl")
+	}
+	subCmd := strings.ToLower(string(args[0]))
+	args = args[1:]
+	switch subCmd {
+	case "setuser":
+		if len(args) == 0 {
+			return reply.MakeArgNumErrReply("acl|setuser")
+		}
+		rules := make([]string, 0, len(args)-1)
+		for _, arg := range args[1:] {
+			rules = append(rules, string(arg))
+		}
+		if err := h.acl.SetUser(string(args[0]), rules); err != nil {
+			return reply.MakeErrReply("ERR " + err.Error())
+		}
+		return reply.MakeOkReply()
+	case "deluser":
+		if len(args) == 0 {
+			return reply.MakeArgNumErrReply("acl|deluser")
+		}
+		names := make([]string, 0, len(args))
+		for _, arg := range args {
+			names = append(names, string(arg))
+		}
+		deleted, err := h.acl.DelUser(names)
+		if err != nil {
+			return reply.MakeErrReply("ERR " + err.Error())
+		}
+		h.killUserClients(c, names)
+		return reply.MakeIntReply(int64(deleted))
+	case "list":
+		if len(args) != 0 {
+			return reply.MakeArgNumErrReply("acl|list")
+		}
+		lines := make([][]byte, 0)
+		for _, u := range h.acl.Users() {
+			lines = append(lines, []byte(u.Describe()))
+		}
+		return reply.MakeMultiBulkReply(lines)
+	case "users":
+		if len(args) != 0 {
+			return reply.MakeArgNumErrReply("acl|users")
+		}
+		names := make([][]byte, 0)
+		for _, u := range h.acl.Users() {
+			names = append(names, []byte(u.Name()))
+		}
+		return reply.MakeMultiBulkReply(names)
+	case "whoami":
+		if len(args) != 0 {
+			return reply.MakeArgNumErrReply("acl|whoami")
+		}
+		return reply.MakeBulkReply([]byte(clientUser(c)))
+	case "getuser":
+		if len(args) != 1 {
+			return reply.MakeArgNumErrReply("acl|getuser")
+		}
+		return h.execAclGetUser(string(args[0]))
+	case "cat":
+		return execAclCat(args)
+	case "load":
+		if len(args) != 0 {
+			return reply.MakeArgNumErrReply("acl|load")
+		}
+		return h.execAclLoad(c)
+	case "save":
+		if len(args) != 0 {
+			return reply.MakeArgNumErrReply("acl|save")
+		}
+		if config.Properties().AclFile == "" {
+			return noAclFileErrReply
+		}
+		if err := h.acl.SaveFile(config.Properties().AclFile); err != nil {
+			logger.Error("save aclfile failed: ", err)
+			return reply.MakeErrReply("ERR There was an error trying to save the ACLs. Please check the server logs for more information")
+		}
+		return reply.MakeOkReply()
+	}
+	return reply.MakeErrReply("ERR unknown subcommand '" + subCmd + "'. Try ACL HELP.")
+}
+
+var noAclFileErrReply = reply.MakeErrReply("ERR This Redis instance is not configured to use an ACL file. " +
+	"You may want to specify users via the ACL SETUSER command and then issue a CONFIG REWRITE " +
+	"(assuming you have a Redis configuration file set) in order to store users in the Redis configuration.")
+
+// execAclGetUser ACL GETUSER username
+func (h *RespHandler) execAclGetUser(name string) resp.Reply {
+	u := h.acl.GetUser(name)
+	if u == nil {
+		return &reply.NullBulkReply{}
+	}
+	flags := [][]byte{[]byte("off")}
+	if u.Enabled() {
+		flags[0] = []byte("on")
+	}
+	if u.NoPass() {
+		flags = append(flags, []byte("nopass"))
+	}
+	passwords := make([][]byte, 0)
+	for _, hash := range u.PasswordHashes() {
+		passwords = append(passwords, []byte(hash))
+	}
+	return reply.MakeMultiRawReply([]resp.Reply{
+		reply.MakeBulkReply([]byte("flags")),
+		reply.MakeMultiBulkReply(flags),
+		reply.MakeBulkReply([]byte("passwords")),
+		reply.MakeMultiBulkReply(passwords),
+		reply.MakeBulkReply([]byte("commands")),
+		reply.MakeBulkReply([]byte(u.CommandRules())),
+		reply.MakeBulkReply([]byte("keys")),
+		reply.MakeBulkReply([]byte(u.KeyRules())),
+	})
+}
+
+// execAclCat ACL CAT [category]
+func execAclCat(args [][]byte) resp.Reply {
+	var names []string
+	switch len(args) {
+	case 0:
+		names = acl.CategoryNames()
+	case 1:
+		category := strings.ToLower(string(args[0]))
+		commands, ok := acl.CategoryCommands(category)
+		if !ok {
+			return reply.MakeErrReply("ERR Unknown category '" + category + "'")
+		}
+		names = commands
+	default:
+		return reply.MakeArgNumErrReply("acl|cat")
+	}
+	result := make([][]byte, 0, len(names))
+	for _, name := range names {
+		result = append(result, []byte(name))
+	}
+	return reply.MakeMultiBulkReply(result)
+}
+
+// execAclLoad 从 aclfile 重新加载用户，并关闭使用了已经不存在的用户的连接
+func (h *RespHandler) execAclLoad(c *connection.Connection) resp.Reply {
+	if config.Properties().AclFile == "" {
+		return noAclFileErrReply
+	}
+	oldUsers := h.acl.Users()
+	if err := h.acl.LoadFile(config.Properties().AclFile, config.Properties().RequirePass); err != nil {
+		return reply.MakeErrReply("ERR " + err.Error())
+	}
+	removed := make([]string, 0)
+	for _, u := range oldUsers {
+		if h.acl.GetUser(u.Name()) == nil {
+			removed = append(removed, u.Name())
+		}
+	}
+	h.killUserClients(c, removed)
+	return reply.MakeOkReply()
+}
+
+// killUserClients 关闭以这些用户认证的连接
+func (h *RespHandler) killUserClients(self *connection.Connection, names []string) {
+	for _, name := range names {
+		h.killClients(self, &clientFilter{user: name})
+	}
+}
+
+// clientUser 返回连接使用的用户名，没有执行过 AUTH 的连接使用 default 用户
+func clientUser(c *connection.Connection) string {
+	if name := c.User(); name != "" {
+		return name
+	}
+	return acl.DefaultUser
+}
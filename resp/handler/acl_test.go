@@ -0,0 +1,33 @@
+package handler
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestKeyRestrictedWriteUser(t *testing.T) {
+	h := makeTestHandler(t)
+	admin := connect(t, h)
+	if got := admin.do("acl", "setuser", "team1", "on", ">secret", "~team1:*", "+@write", "+@read", "+@keyspace"); got != "+OK" {
+		t.Fatalf("ACL SETUSER = %q", got)
+	}
+	if got := admin.do("set", "team2:k", "v"); got != "+OK" {
+		t.Fatalf("SET = %q", got)
+	}
+
+	c := connect(t, h)
+	if got := c.do("auth", "team1", "secret"); got != "+OK" {
+		t.Fatalf("AUTH = %q", got)
+	}
+	if got := c.do("set", "team1:k", "v"); got != "+OK" {
+		t.Fatalf("SET own key = %q", got)
+	}
+	for _, cmd := range [][]string{{"flushdb"}, {"keys", "*"}, {"scan", "0"}} {
+		if got := c.do(cmd...); !strings.HasPrefix(got, "-NOPERM") {
+			t.Fatalf("%s = %q, want NOPERM", cmd[0], got)
+		}
+	}
+	if got := admin.do("exists", "team2:k"); got != ":1" {
+		t.Fatalf("other team's key was removed, EXISTS = %q", got)
+	}
+}
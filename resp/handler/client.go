@@ -0,0 +1,383 @@
+package handler
+
+import (
+	"fmt"
+	"github.com/jujunwang/Mudis/database"
+	"github.com/jujunwang/Mudis/interface/resp"
+	"github.com/jujunwang/Mudis/resp/connection"
+	"github.com/jujunwang/Mudis/resp/reply"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// clientPause 记录 CLIENT PAUSE 的状态
+type clientPause struct {
+	mu sync.Mutex
+	// all 为 false 时只暂停写命令
+	all bool
+	end time.Time
+	// 暂停结束或者 CLIENT UNPAUSE 时关闭，为 nil 表示没有暂停
+	done chan struct{}
+}
+
+// clientFilter 是 CLIENT LIST 和 CLIENT KILL 用来筛选连接的条件
+type clientFilter struct {
+	ids    map[uint64]bool
+	addr   string
+	laddr  string
+	typ    string
+	user   string
+	maxAge time.Duration
+	// skipMe 为 true 时不包括执行命令的连接
+	skipMe bool
+}
+
+func (f *clientFilter) match(self *connection.Connection, c *connection.Connection) bool {
+	if f.skipMe && c == self {
+		return false
+	}
+	if f.ids != nil && !f.ids[c.ID()] {
+		return false
+	}
+	if f.addr != "" && c.RemoteAddr().String() != f.addr {
+		return false
+	}
+	if f.laddr != "" && c.LocalAddr().String() != f.laddr {
+		return false
+	}
+	if f.typ != "" && clientType(c) != f.typ {
+		return false
+	}
+	if f.user != "" && clientUser(c) != f.user {
+		return false
+	}
+	if f.maxAge > 0 && time.Since(c.CreateTime()) < f.maxAge {
+		return false
+	}
+	return true
+}
+
+// clientType 返回连接的类型，订阅了频道或模式的连接为 pubsub
+func clientType(c *connection.Connection) string {
+	if c.SubsCount()+c.PSubsCount() > 0 {
+		return "pubsub"
+	}
+	return "normal"
+}
+
+// clientInfo 返回 CLIENT LIST 格式的连接信息
+func clientInfo(c *connection.Connection) string {
+	now := time.Now()
+	cmd, lastCmdTime := c.LastCmd()
+	if cmd == "" {
+		cmd = "NULL"
+	}
+	flags := "N"
+	if c.IsMonitor() {
+		flags = "O"
+	} else if clientType(c) == "pubsub" {
+		flags = "P"
+	}
+	return fmt.Sprintf("id=%d addr=%s laddr=%s name=%s age=%d idle=%d flags=%s db=%d sub=%d psub=%d cmd=%s user=%s",
+		c.ID(), c.RemoteAddr(), c.LocalAddr(), c.Name(),
+		int64(now.Sub(c.CreateTime())/time.Second), int64(now.Sub(lastCmdTime)/time.Second),
+		flags, c.GetDBIndex(), c.SubsCount(), c.PSubsCount(), cmd, clientUser(c))
+}
+
+// clients 返回所有满足条件的连接，按照 ID 排序
+func (h *RespHandler) clients(self *connection.Connection, filter *clientFilter) []*connection.Connection {
+	result := make([]*connection.Connection, 0)
+	h.activeConn.Range(func(key interface{}, val interface{}) bool {
+		c := key.(*connection.Connection)
+		if filter.match(self, c) {
+			result = append(result, c)
+		}
+		return true
+	})
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].ID() < result[j].ID()
+	})
+	return result
+}
+
+// lastCmdName 返回记录在连接上的命令名，CLIENT、ACL 和 CONFIG 等容器命令带上子命令，例如 client|list
+func lastCmdName(cmdName string, cmdLine [][]byte) string {
+	switch cmdName {
+	case "client", "acl", "config":
+		if len(cmdLine) > 1 {
+			return cmdName + "|" + strings.ToLower(string(cmdLine[1]))
+		}
+	}
+	return cmdName
+}
+
+// execClient CLIENT LIST|INFO|ID|SETNAME|GETNAME|KILL|PAUSE|UNPAUSE
+func (h *RespHandler) execClient(c *connection.Connection, args [][]byte) resp.Reply {
+	if len(args) == 0 {
+		return reply.MakeArgNumErrReply("client")
+	}
+	subCmd := strings.ToLower(string(args[0]))
+	args = args[1:]
+	switch subCmd {
+	case "id":
+		if len(args) != 0 {
+			return reply.MakeArgNumErrReply("client|id")
+		}
+		return reply.MakeIntReply(int64(c.ID()))
+	case "info":
+		if len(args) != 0 {
+			return reply.MakeArgNumErrReply("client|info")
+		}
+		return reply.MakeBulkReply([]byte(clientInfo(c) + "\n"))
+	case "list":
+		return h.execClientList(c, args)
+	case "setname":
+		if len(args) != 1 {
+			return reply.MakeArgNumErrReply("client|setname")
+		}
+		for _, b := range args[0] {
+			if b < '!' || b > '~' {
+				return reply.MakeErrReply("ERR Client names cannot contain spaces, newlines or special characters.")
+			}
+		}
+		c.SetName(string(args[0]))
+		return reply.MakeOkReply()
+	case "getname":
+		if len(args) != 0 {
+			return reply.MakeArgNumErrReply("client|getname")
+		}
+		name := c.Name()
+		if name == "" {
+			return &reply.NullBulkReply{}
+		}
+		return reply.MakeBulkReply([]byte(name))
+	case "kill":
+		return h.execClientKill(c, args)
+	case "pause":
+		return h.execClientPause(args)
+	case "unpause":
+		if len(args) != 0 {
+			return reply.MakeArgNumErrReply("client|unpause")
+		}
+		h.unpauseClients()
+		return reply.MakeOkReply()
+	}
+	return reply.MakeErrReply("ERR unknown subcommand '" + subCmd + "'. Try CLIENT HELP.")
+}
+
+// execClientList CLIENT LIST [TYPE normal|pubsub] [ID client-id ...]
+func (h *RespHandler) execClientList(c *connection.Connection, args [][]byte) resp.Reply {
+	filter := &clientFilter{}
+	for i := 0; i < len(args); i++ {
+		switch strings.ToLower(string(args[i])) {
+		case "type":
+			if i+1 >= len(args) {
+				return reply.MakeSyntaxErrReply()
+			}
+			filter.typ = strings.ToLower(string(args[i+1]))
+			if filter.typ != "normal" && filter.typ != "pubsub" {
+				return reply.MakeErrReply("ERR Unknown client type '" + string(args[i+1]) + "'")
+			}
+			i++
+		case "id":
+			if i+1 >= len(args) {
+				return reply.MakeSyntaxErrReply()
+			}
+			filter.ids = make(map[uint64]bool)
+			for i++; i < len(args); i++ {
+				id, err := strconv.ParseUint(string(args[i]), 10, 64)
+				if err != nil || id == 0 {
+					return reply.MakeErrReply("ERR Invalid client ID")
+				}
+				filter.ids[id] = true
+			}
+		default:
+			return reply.MakeSyntaxErrReply()
+		}
+	}
+	var builder strings.Builder
+	for _, client := range h.clients(c, filter) {
+		builder.WriteString(clientInfo(client))
+		builder.WriteString("\n")
+	}
+	return reply.MakeBulkReply([]byte(builder.String()))
+}
+
+// execClientKill CLIENT KILL addr 或 CLIENT KILL [ID id] [ADDR addr] [LADDR addr] [TYPE type] [USER username] [SKIPME yes|no] [MAXAGE seconds]
+func (h *RespHandler) execClientKill(c *connection.Connection, args [][]byte) resp.Reply {
+	if len(args) == 0 {
+		return reply.MakeArgNumErrReply("client|kill")
+	}
+	// 旧的格式只按照地址关闭一个连接，可以关闭自己
+	if len(args) == 1 {
+		filter := &clientFilter{addr: string(args[0])}
+		killed := h.killClients(c, filter)
+		if killed == 0 {
+			return reply.MakeErrReply("ERR No such client")
+		}
+		return reply.MakeOkReply()
+	}
+	if len(args)%2 != 0 {
+		return reply.MakeSyntaxErrReply()
+	}
+	filter := &clientFilter{skipMe: true}
+	for i := 0; i < len(args); i += 2 {
+		value := string(args[i+1])
+		switch strings.ToLower(string(args[i])) {
+		case "id":
+			id, err := strconv.ParseUint(value, 10, 64)
+			if err != nil || id == 0 {
+				return reply.MakeErrReply("ERR client-id should be greater than 0")
+			}
+			filter.ids = map[uint64]bool{id: true}
+		case "addr":
+			filter.addr = value
+		case "laddr":
+			filter.laddr = value
+		case "type":
+			filter.typ = strings.ToLower(value)
+			if filter.typ != "normal" && filter.typ != "pubsub" {
+				return reply.MakeErrReply("ERR Unknown client type '" + value + "'")
+			}
+		case "user":
+			if h.acl.GetUser(value) == nil {
+				return reply.MakeErrReply("ERR No such user '" + value + "'")
+			}
+			filter.user = value
+		case "skipme":
+			switch strings.ToLower(value) {
+			case "yes":
+				filter.skipMe = true
+			case "no":
+				filter.skipMe = false
+			default:
+				return reply.MakeSyntaxErrReply()
+			}
+		case "maxage":
+			seconds, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || seconds <= 0 {
+				return reply.MakeErrReply("ERR value is not an integer or out of range")
+			}
+			filter.maxAge = time.Duration(seconds) * time.Second
+		default:
+			return reply.MakeSyntaxErrReply()
+		}
+	}
+	return reply.MakeIntReply(int64(h.killClients(c, filter)))
+}
+
+// killClients 关闭满足条件的连接，返回关闭的连接数
+// 执行命令的连接在发送完回复后才关闭
+func (h *RespHandler) killClients(self *connection.Connection, filter *clientFilter) int {
+	clients := h.clients(self, filter)
+	for _, client := range clients {
+		if client == self {
+			client.SetCloseAfterReply()
+			continue
+		}
+		// Close 会等待正在发送的回复，不阻塞执行 CLIENT KILL 的连接
+		go func(client *connection.Connection) {
+			_ = client.Close()
+		}(client)
+	}
+	return len(clients)
+}
+
+// execClientPause CLIENT PAUSE timeout [WRITE|ALL]
+func (h *RespHandler) execClientPause(args [][]byte) resp.Reply {
+	if len(args) != 1 && len(args) != 2 {
+		return reply.MakeArgNumErrReply("client|pause")
+	}
+	ms, err := strconv.ParseInt(string(args[0]), 10, 64)
+	if err != nil || ms < 0 {
+		return reply.MakeErrReply("ERR timeout is not an integer or out of range")
+	}
+	all := true
+	if len(args) == 2 {
+		switch strings.ToLower(string(args[1])) {
+		case "write":
+			all = false
+		case "all":
+		default:
+			return reply.MakeSyntaxErrReply()
+		}
+	}
+	h.pauseClients(time.Duration(ms)*time.Millisecond, all)
+	return reply.MakeOkReply()
+}
+
+// pauseClients 暂停客户端，已经处于暂停状态时取更晚的结束时间和更严格的暂停类型
+func (h *RespHandler) pauseClients(timeout time.Duration, all bool) {
+	h.pause.mu.Lock()
+	defer h.pause.mu.Unlock()
+	end := time.Now().Add(timeout)
+	if h.pause.done == nil || !time.Now().Before(h.pause.end) {
+		h.pause.done = make(chan struct{})
+		h.pause.all = all
+		h.pause.end = end
+		return
+	}
+	h.pause.all = h.pause.all || all
+	if end.After(h.pause.end) {
+		h.pause.end = end
+	}
+}
+
+// unpauseClients 结束暂停，唤醒所有等待的客户端
+func (h *RespHandler) unpauseClients() {
+	h.pause.mu.Lock()
+	defer h.pause.mu.Unlock()
+	if h.pause.done != nil {
+		close(h.pause.done)
+		h.pause.done = nil
+	}
+}
+
+// waitIfPaused 在客户端被暂停时等待暂停结束
+// CLIENT 命令不会被暂停，以便执行 CLIENT UNPAUSE
+func (h *RespHandler) waitIfPaused(c *connection.Connection, cmdName string, cmdLine [][]byte) {
+	if cmdName == "client" {
+		return
+	}
+	for {
+		h.pause.mu.Lock()
+		done, end, all := h.pause.done, h.pause.end, h.pause.all
+		h.pause.mu.Unlock()
+		wait := time.Until(end)
+		if done == nil || wait <= 0 {
+			return
+		}
+		if !all && !isWriteCommand(c, cmdName, cmdLine) {
+			return
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-done:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// isWriteCommand 判断命令在 CLIENT PAUSE WRITE 期间是否需要暂停
+// PUBLISH 和包含写命令的 EXEC 也会被暂停
+func isWriteCommand(c *connection.Connection, cmdName string, cmdLine [][]byte) bool {
+	switch cmdName {
+	case "publish":
+		return true
+	case "exec":
+		if !c.InMultiState() {
+			return false
+		}
+		for _, queued := range c.GetQueuedCmdLine() {
+			if database.IsWriteCommand(queued) {
+				return true
+			}
+		}
+		return false
+	}
+	return database.IsWriteCommand(cmdLine)
+}
@@ -0,0 +1,292 @@
+package handler
+
+import (
+	"errors"
+	"github.com/jujunwang/Mudis/aof"
+	"github.com/jujunwang/Mudis/config"
+	"github.com/jujunwang/Mudis/database"
+	"github.com/jujunwang/Mudis/interface/resp"
+	"github.com/jujunwang/Mudis/lib/logger"
+	"github.com/jujunwang/Mudis/resp/reply"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// configParam 是可以通过 CONFIG SET 修改的配置项
+type configParam struct {
+	// validate 检查新的值，值的类型由 config.Set 检查
+	validate func(value string) error
+	// apply 在配置修改后使其生效，返回错误时恢复原来的值
+	apply func(h *RespHandler) error
+}
+
+// logLevels 是 loglevel 支持的级别
+var logLevels = map[string]func(){
+	"debug":   func() { logger.SetLevel(logger.DEBUG) },
+	"verbose": func() { logger.SetLevel(logger.INFO) },
+	"notice":  func() { logger.SetLevel(logger.INFO) },
+	"warning": func() { logger.SetLevel(logger.WARNING) },
+	"nothing": func() { logger.SetLevel(logger.FATAL) },
+}
+
+// mutableConfigs 是运行时可以修改的配置项，其余配置项只能在启动时设置
+var mutableConfigs = map[string]*configParam{
+	"appendonly": {
+		apply: func(h *RespHandler) error {
+			return h.db.SetAppendOnly(config.Properties().AppendOnly)
+		},
+	},
+	"requirepass": {
+		apply: func(h *RespHandler) error {
+			h.acl.SetRequirePass(config.Properties().RequirePass)
+			return nil
+		},
+	},
+	// Handle 接受新连接时读取当前的 maxclients，不需要 apply
+	"maxclients": {
+		validate: func(value string) error {
+			if n, err := strconv.Atoi(value); err == nil && n < 0 {
+				return errors.New("argument must be between 0 and 2147483647 inclusive")
+			}
+			return nil
+		},
+	},
+	"loglevel": {
+		validate: func(value string) error {
+			if _, ok := logLevels[strings.ToLower(value)]; !ok {
+				return errors.New("argument(s) must be one of the following: debug, verbose, notice, warning, nothing")
+			}
+			return nil
+		},
+		apply: func(h *RespHandler) error {
+			applyLogLevel()
+			return nil
+		},
+	},
+	"maxmemory": {},
+	"maxmemory-policy": {
+		validate: func(value string) error {
+			if !database.IsEvictionPolicy(strings.ToLower(value)) {
+				return errors.New("argument(s) must be one of the following: volatile-lru, allkeys-lru, " +
+					"volatile-lfu, allkeys-lfu, volatile-random, allkeys-random, volatile-ttl, noeviction")
+			}
+			return nil
+		},
+	},
+	"slowlog-log-slower-than": {},
+	"slowlog-max-len": {
+		validate: func(value string) error {
+			if n, err := strconv.Atoi(value); err == nil && n < 0 {
+				return errors.New("argument must be between 0 and 2147483647 inclusive")
+			}
+			return nil
+		},
+	},
+	"notify-keyspace-events": {
+		validate: func(value string) error {
+			if _, ok := database.ParseKeyspaceEvents(value); !ok {
+				return errors.New("Invalid event class character. Use 'Ag$lshzxeKE'.")
+			}
+			return nil
+		},
+		apply: func(h *RespHandler) error {
+			// 保存规范的格式，CONFIG GET 返回例如 AKE
+			flags, _ := database.ParseKeyspaceEvents(config.Properties().NotifyKeyspaceEvents)
+			return config.Set("notify-keyspace-events", database.FormatKeyspaceEvents(flags))
+		},
+	},
+	"save": {
+		validate: func(value string) error {
+			if _, ok := database.ParseSaveRules(value); !ok {
+				return errors.New("Invalid save parameters")
+			}
+			return nil
+		},
+	},
+	"dbfilename": {
+		validate: func(value string) error {
+			if value == "" || strings.ContainsAny(value, "/\\") {
+				return errors.New("dbfilename can't be a path, just a filename")
+			}
+			return nil
+		},
+	},
+	"appendfsync": {
+		validate: func(value string) error {
+			if !aof.IsFsyncPolicy(strings.ToLower(value)) {
+				return errors.New("argument(s) must be one of the following: always, everysec, no")
+			}
+			return nil
+		},
+		apply: func(h *RespHandler) error {
+			return config.Set("appendfsync", strings.ToLower(config.Properties().AppendFsync))
+		},
+	},
+	"aof-load-truncated": {},
+	"auto-aof-rewrite-percentage": {
+		validate: func(value string) error {
+			if n, err := strconv.Atoi(value); err == nil && n < 0 {
+				return errors.New("argument must be between 0 and 2147483647 inclusive")
+			}
+			return nil
+		},
+	},
+	"auto-aof-rewrite-min-size": {},
+	"maxmemory-samples": {
+		validate: func(value string) error {
+			if n, err := strconv.Atoi(value); err == nil && n <= 0 {
+				return errors.New("argument must be between 1 and 64 inclusive")
+			}
+			return nil
+		},
+	},
+}
+
+// applyLogLevel 按照 loglevel 配置设置日志级别，没有配置或者配置有误时使用 notice
+func applyLogLevel() {
+	level := strings.ToLower(config.Properties().LogLevel)
+	setLevel, ok := logLevels[level]
+	if !ok {
+		if level != "" {
+			logger.Warn("unknown loglevel '" + config.Properties().LogLevel + "', using notice")
+		}
+		_ = config.Set("loglevel", "notice")
+		setLevel = logLevels["notice"]
+	}
+	setLevel()
+}
+
+// execConfig CONFIG GET|SET|REWRITE|RESETSTAT
+func (h *RespHandler) execConfig(args [][]byte) resp.Reply {
+	if len(args) == 0 {
+		return reply.MakeArgNumErrReply("config")
+	}
+	subCmd := strings.ToLower(string(args[0]))
+	args = args[1:]
+	switch subCmd {
+	case "get":
+		if len(args) == 0 {
+			return reply.MakeArgNumErrReply("config|get")
+		}
+		return execConfigGet(args)
+	case "set":
+		if len(args) == 0 || len(args)%2 != 0 {
+			return reply.MakeArgNumErrReply("config|set")
+		}
+		return h.execConfigSet(args)
+	case "rewrite":
+		if len(args) != 0 {
+			return reply.MakeArgNumErrReply("config|rewrite")
+		}
+		if err := config.Rewrite(); err != nil {
+			if err == config.ErrNoConfigFile {
+				return reply.MakeErrReply("ERR " + err.Error())
+			}
+			logger.Warn("CONFIG REWRITE failed: ", err)
+			return reply.MakeErrReply("ERR Rewriting config file: " + err.Error())
+		}
+		logger.Info("CONFIG REWRITE executed with success.")
+		return reply.MakeOkReply()
+	case "resetstat":
+		if len(args) != 0 {
+			return reply.MakeArgNumErrReply("config|resetstat")
+		}
+		h.resetStats()
+		return reply.MakeOkReply()
+	}
+	return reply.MakeErrReply("ERR unknown subcommand '" + subCmd + "'. Try CONFIG HELP.")
+}
+
+// execConfigGet CONFIG GET pattern [pattern ...]
+func execConfigGet(patterns [][]byte) resp.Reply {
+	matched := make(map[string]struct{})
+	for _, pattern := range patterns {
+		for _, name := range config.MatchNames(string(pattern)) {
+			matched[name] = struct{}{}
+		}
+	}
+	names := make([]string, 0, len(matched))
+	for name := range matched {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	result := make([][]byte, 0, len(names)*2)
+	for _, name := range names {
+		value, _ := config.Get(name)
+		result = append(result, []byte(name), []byte(value))
+	}
+	return reply.MakeMultiBulkReply(result)
+}
+
+// execConfigSet CONFIG SET parameter value [parameter value ...]
+// 所有配置项都修改成功才返回 OK，否则恢复已经修改的配置项
+func (h *RespHandler) execConfigSet(args [][]byte) resp.Reply {
+	names := make([]string, 0, len(args)/2)
+	values := make([]string, 0, len(args)/2)
+	seen := make(map[string]bool)
+	for i := 0; i < len(args); i += 2 {
+		name := strings.ToLower(string(args[i]))
+		value := string(args[i+1])
+		if _, ok := config.Get(name); !ok {
+			return reply.MakeErrReply("ERR Unknown option or number of arguments for CONFIG SET - '" + name + "'")
+		}
+		param, ok := mutableConfigs[name]
+		if !ok {
+			return configSetErrReply(name, "can't set immutable config")
+		}
+		if seen[name] {
+			return configSetErrReply(name, "duplicate parameter")
+		}
+		seen[name] = true
+		if param.validate != nil {
+			if err := param.validate(value); err != nil {
+				return configSetErrReply(name, err.Error())
+			}
+		}
+		names = append(names, name)
+		values = append(values, value)
+	}
+
+	oldValues := make([]string, 0, len(names))
+	for i, name := range names {
+		oldValue, _ := config.Get(name)
+		err := config.Set(name, values[i])
+		if err == nil && mutableConfigs[name].apply != nil {
+			err = mutableConfigs[name].apply(h)
+			if err != nil {
+				_ = config.Set(name, oldValue)
+			}
+		}
+		if err != nil {
+			h.revertConfigs(names[:i], oldValues)
+			return configSetErrReply(name, err.Error())
+		}
+		oldValues = append(oldValues, oldValue)
+	}
+	return reply.MakeOkReply()
+}
+
+// revertConfigs 逆序恢复已经修改的配置项
+func (h *RespHandler) revertConfigs(names []string, oldValues []string) {
+	for i := len(names) - 1; i >= 0; i-- {
+		_ = config.Set(names[i], oldValues[i])
+		if apply := mutableConfigs[names[i]].apply; apply != nil {
+			if err := apply(h); err != nil {
+				logger.Warn("revert config " + names[i] + " failed: " + err.Error())
+			}
+		}
+	}
+}
+
+func configSetErrReply(name string, msg string) resp.Reply {
+	return reply.MakeErrReply("ERR CONFIG SET failed (possibly related to argument '" + name + "') - " + msg)
+}
+
+// resetStats 清空 INFO 中的统计信息
+func (h *RespHandler) resetStats() {
+	h.stats.totalConns.Set(0)
+	h.stats.totalCommands.Set(0)
+	h.stats.rejectedConns.Set(0)
+	h.db.ResetStats()
+}
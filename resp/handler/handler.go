@@ -6,10 +6,12 @@ package handler
 
 import (
 	"context"
+	"github.com/jujunwang/Mudis/acl"
 	"github.com/jujunwang/Mudis/cluster"
 	"github.com/jujunwang/Mudis/config"
 	"github.com/jujunwang/Mudis/database"
 	databaseface "github.com/jujunwang/Mudis/interface/database"
+	"github.com/jujunwang/Mudis/interface/resp"
 	"github.com/jujunwang/Mudis/lib/logger"
 	"github.com/jujunwang/Mudis/lib/sync/atomic"
 	"github.com/jujunwang/Mudis/resp/connection"
@@ -17,12 +19,15 @@ import (
 	"github.com/jujunwang/Mudis/resp/reply"
 	"io"
 	"net"
+	"os"
 	"strings"
 	"sync"
+	"time"
 )
 
 var (
 	unknownErrReplyBytes = []byte("-ERR unknown\r\n")
+	maxClientsErrBytes   = []byte("-ERR max number of clients reached\r\n")
 )
 
 // RespHandler 实现了 tcp.Handler 并且作为一个 redis 服务器提供服务
@@ -30,27 +35,56 @@ type RespHandler struct {
 	activeConn sync.Map // *client -> placeholder
 	db         databaseface.Database
 	closing    atomic.Boolean // refusing new client and new request
+	// clusterMode 表示 db 是集群的一个节点
+	clusterMode bool
+	// 关闭时通知后台的统计 goroutine 退出
+	closeChan chan struct{}
+	closeOnce sync.Once
+	stats     serverStats
+	pause     clientPause
+	// acl 保存 ACL 用户，用于 AUTH 认证和权限检查
+	acl *acl.Manager
+	// monitors 保存执行了 MONITOR 的客户端
+	monitors monitorHub
 }
 
 // MakeHandler 新建一个 RespHandler 实例
 func MakeHandler() *RespHandler {
+	applyLogLevel()
 	var db databaseface.Database
-	if config.Properties.Self != "" &&
-		len(config.Properties.Peers) > 0 {
+	clusterMode := config.Properties().Self != "" &&
+		len(config.Properties().Peers) > 0
+	if clusterMode {
 		db = cluster.MakeClusterDatabase()
 	} else {
 		db = database.NewStandaloneDatabase()
 	}
 
-	return &RespHandler{
-		db: db,
+	h := &RespHandler{
+		db:          db,
+		clusterMode: clusterMode,
+		closeChan:   make(chan struct{}),
+		acl:         acl.MakeManager(config.Properties().RequirePass),
 	}
+	if aclFile := config.Properties().AclFile; aclFile != "" {
+		// aclfile 不存在时使用默认的用户，ACL SAVE 会创建它
+		err := h.acl.LoadFile(aclFile, config.Properties().RequirePass)
+		if err != nil && !os.IsNotExist(err) {
+			logger.Fatal("load aclfile failed: ", err)
+		}
+	}
+	h.stats.startTime = time.Now()
+	go h.trackOps()
+	return h
 }
 
+// closeClient 清理连接的状态，需要在连接的最后一条命令执行结束后调用，
+// 否则之后执行的 SUBSCRIBE、MONITOR 等命令会在已经关闭的连接上留下注册
 func (h *RespHandler) closeClient(client *connection.Connection) {
-	_ = client.Close()
 	h.db.AfterClientClose(client)
+	h.removeMonitor(client)
 	h.activeConn.Delete(client)
+	h.stats.connectedClients.Add(-1)
 }
 
 // Handle 接收并执行redis命令
@@ -58,30 +92,60 @@ func (h *RespHandler) Handle(ctx context.Context, conn net.Conn) {
 	if h.closing.Get() {
 		// 关闭处理程序拒绝新的连接
 		_ = conn.Close()
+		h.stats.rejectedConns.Add(1)
+		return
+	}
+	// 连接数达到 maxclients 时拒绝新的连接，每次都读取当前生效的配置，CONFIG SET 修改后立即生效
+	connected := h.stats.connectedClients.Add(1)
+	if maxClients := config.Properties().MaxClients; maxClients > 0 && connected > int64(maxClients) {
+		h.stats.connectedClients.Add(-1)
+		h.stats.rejectedConns.Add(1)
+		_, _ = conn.Write(maxClientsErrBytes)
+		_ = conn.Close()
+		return
 	}
+	h.stats.totalConns.Add(1)
 
 	client := connection.NewConn(conn)
+	// default 用户不需要密码时新连接自动认证为 default 用户，之后设置 requirepass 也不影响已有的连接
+	if h.currentUser(client) != nil {
+		client.SetUser(acl.DefaultUser)
+	}
 	h.activeConn.Store(client, 1)
 
 	ch := parser.ParseStream(conn)
-	for payload := range ch {
+	payloads := makePayloadQueue()
+	// 由单独的 goroutine 接收解析结果，以便在执行 BLPOP 等阻塞命令期间也能及时感知连接断开
+	go func() {
+		defer payloads.close()
+		for payload := range ch {
+			if payload.Err != nil && isClosedErr(payload.Err) {
+				break
+			}
+			payloads.push(payload)
+		}
+		// connection closed，标记连接关闭以唤醒正在阻塞的命令，其余的清理在执行结束后进行
+		_ = client.Close()
+		logger.Info("connection closed: " + client.RemoteAddr().String())
+	}()
+	defer h.closeClient(client)
+	for {
+		payload, ok := payloads.pop()
+		if !ok {
+			break
+		}
+		if client.CloseAfterReply() {
+			// 连接已经关闭，丢弃剩余的请求直到接收 goroutine 退出
+			continue
+		}
 		//Err
 		if payload.Err != nil {
-			if payload.Err == io.EOF ||
-				payload.Err == io.ErrUnexpectedEOF ||
-				strings.Contains(payload.Err.Error(), "use of closed network connection") {
-				// connection closed
-				h.closeClient(client)
-				logger.Info("connection closed: " + client.RemoteAddr().String())
-				return
-			}
 			// protocol err
 			errReply := reply.MakeErrReply(payload.Err.Error())
 			err := client.Write(errReply.ToBytes())
 			if err != nil {
-				h.closeClient(client)
-				logger.Info("connection closed: " + client.RemoteAddr().String())
-				return
+				// 关闭连接后由接收 goroutine 负责清理
+				_ = client.Close()
 			}
 			continue
 		}
@@ -94,19 +158,80 @@ func (h *RespHandler) Handle(ctx context.Context, conn net.Conn) {
 			logger.Error("require multi bulk reply")
 			continue
 		}
-		result := h.db.Exec(client, r.Args)
+		result := h.exec(client, r.Args)
 		if result != nil {
 			_ = client.Write(result.ToBytes())
 		} else {
 			_ = client.Write(unknownErrReplyBytes)
 		}
+		if client.CloseAfterReply() {
+			// 关闭连接后由接收 goroutine 负责清理
+			_ = client.Close()
+		}
 	}
 }
 
+// serverCommands 是由 RespHandler 而不是 db 执行的命令，它们无法加入事务队列，在事务中被拒绝
+var serverCommands = map[string]struct{}{
+	"auth":    {},
+	"info":    {},
+	"client":  {},
+	"acl":     {},
+	"config":  {},
+	"monitor": {},
+}
+
+var notAllowedInMultiErrReply = reply.MakeErrReply("ERR Command not allowed inside a transaction")
+
+// exec 执行服务器级别的命令，其余命令交给 db 执行
+func (h *RespHandler) exec(client *connection.Connection, cmdLine [][]byte) resp.Reply {
+	cmdName := strings.ToLower(string(cmdLine[0]))
+	now := time.Now()
+	client.SetLastCmd(lastCmdName(cmdName, cmdLine), now)
+	if _, ok := serverCommands[cmdName]; ok && client.InMultiState() {
+		// 与其它入队失败的命令一样，让 EXEC 放弃整个事务
+		client.AddTxError(notAllowedInMultiErrReply)
+		return notAllowedInMultiErrReply
+	}
+	if cmdName == "auth" {
+		h.stats.totalCommands.Add(1)
+		h.feedMonitors(client, cmdName, cmdLine, now)
+		return h.execAuth(client, cmdLine[1:])
+	}
+	if errReply := h.checkPermission(client, cmdName, cmdLine); errReply != nil {
+		return errReply
+	}
+	h.waitIfPaused(client, cmdName, cmdLine)
+	h.stats.totalCommands.Add(1)
+	h.feedMonitors(client, cmdName, cmdLine, now)
+	switch cmdName {
+	case "info":
+		return h.execInfo(cmdLine[1:])
+	case "client":
+		return h.execClient(client, cmdLine[1:])
+	case "acl":
+		return h.execAcl(client, cmdLine[1:])
+	case "config":
+		return h.execConfig(cmdLine[1:])
+	case "monitor":
+		return h.execMonitor(client, cmdLine[1:])
+	}
+	return h.db.Exec(client, cmdLine)
+}
+
+func isClosedErr(err error) bool {
+	return err == io.EOF ||
+		err == io.ErrUnexpectedEOF ||
+		strings.Contains(err.Error(), "use of closed network connection")
+}
+
 // Close 停止处理器
 func (h *RespHandler) Close() error {
 	logger.Info("handler shutting down...")
 	h.closing.Set(true)
+	h.closeOnce.Do(func() {
+		close(h.closeChan)
+	})
 	// TODO: concurrent wait
 	h.activeConn.Range(func(key interface{}, val interface{}) bool {
 		client := key.(*connection.Connection)
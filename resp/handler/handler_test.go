@@ -0,0 +1,166 @@
+package handler
+
+import (
+	"bufio"
+	"context"
+	"github.com/jujunwang/Mudis/acl"
+	"github.com/jujunwang/Mudis/config"
+	"github.com/jujunwang/Mudis/database"
+	"github.com/jujunwang/Mudis/lib/utils"
+	"github.com/jujunwang/Mudis/resp/reply"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// makeTestHandler 新建一个使用单机数据库的 RespHandler，测试结束时关闭
+func makeTestHandler(t *testing.T) *RespHandler {
+	h := &RespHandler{
+		db:        database.NewStandaloneDatabase(),
+		closeChan: make(chan struct{}),
+		acl:       acl.MakeManager(""),
+	}
+	t.Cleanup(func() { _ = h.Close() })
+	return h
+}
+
+// testClient 是连接到 RespHandler 的客户端
+type testClient struct {
+	t      *testing.T
+	conn   net.Conn
+	reader *bufio.Reader
+	// done 在 Handle 返回后关闭
+	done chan struct{}
+}
+
+// connect 通过 net.Pipe 建立一个由 h 处理的连接
+func connect(t *testing.T, h *RespHandler) *testClient {
+	server, conn := net.Pipe()
+	c := &testClient{
+		t:      t,
+		conn:   conn,
+		reader: bufio.NewReader(conn),
+		done:   make(chan struct{}),
+	}
+	go func() {
+		defer close(c.done)
+		h.Handle(context.Background(), server)
+	}()
+	t.Cleanup(func() { _ = conn.Close() })
+	return c
+}
+
+// send 发送命令，不等待回复
+func (c *testClient) send(args ...string) {
+	_ = c.conn.SetWriteDeadline(time.Now().Add(time.Second))
+	if _, err := c.conn.Write(reply.MakeMultiBulkReply(utils.ToCmdLine(args...)).ToBytes()); err != nil {
+		c.t.Fatalf("write %v: %v", args, err)
+	}
+}
+
+// readLine 读取一行回复，不包含末尾的 CRLF
+func (c *testClient) readLine() string {
+	_ = c.conn.SetReadDeadline(time.Now().Add(time.Second))
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		c.t.Fatalf("read reply: %v", err)
+	}
+	return strings.TrimSuffix(line, "\r\n")
+}
+
+// do 发送命令并返回回复的第一行
+func (c *testClient) do(args ...string) string {
+	c.send(args...)
+	return c.readLine()
+}
+
+func TestConfigSetMaxClients(t *testing.T) {
+	h := makeTestHandler(t)
+	defer func() { _ = config.Set("maxclients", "0") }()
+
+	first := connect(t, h)
+	if got := first.do("config", "set", "maxclients", "1"); got != "+OK" {
+		t.Fatalf("CONFIG SET maxclients = %q", got)
+	}
+	second := connect(t, h)
+	if got := second.readLine(); got != "-ERR max number of clients reached" {
+		t.Fatalf("second connection got %q", got)
+	}
+	if got := h.stats.rejectedConns.Get(); got != 1 {
+		t.Fatalf("rejected_connections = %d, want 1", got)
+	}
+
+	if got := first.do("config", "set", "maxclients", "2"); got != "+OK" {
+		t.Fatalf("CONFIG SET maxclients = %q", got)
+	}
+	third := connect(t, h)
+	if got := third.do("ping"); got != "+PONG" {
+		t.Fatalf("third connection got %q", got)
+	}
+}
+
+func TestBlockedClientDisconnectWithPipelinedCommand(t *testing.T) {
+	h := makeTestHandler(t)
+	blocked := connect(t, h)
+	// BLPOP 阻塞时客户端已经发送了下一条命令
+	_ = blocked.conn.SetWriteDeadline(time.Now().Add(time.Second))
+	pipeline := append(reply.MakeMultiBulkReply(utils.ToCmdLine("blpop", "queue", "0")).ToBytes(),
+		reply.MakeMultiBulkReply(utils.ToCmdLine("ping")).ToBytes()...)
+	if _, err := blocked.conn.Write(pipeline); err != nil {
+		t.Fatalf("write pipeline: %v", err)
+	}
+	_ = blocked.conn.Close()
+	select {
+	case <-blocked.done:
+	case <-time.After(time.Second):
+		t.Fatal("blocked client was not cleaned up after disconnecting")
+	}
+
+	// 断开的客户端不再等待，新写入的元素不会丢失
+	other := connect(t, h)
+	if got := other.do("rpush", "queue", "a"); got != ":1" {
+		t.Fatalf("RPUSH = %q", got)
+	}
+	if got := other.do("llen", "queue"); got != ":1" {
+		t.Fatalf("LLEN = %q, want :1", got)
+	}
+}
+
+func TestServerCommandsInMulti(t *testing.T) {
+	h := makeTestHandler(t)
+	c := connect(t, h)
+	cmds := [][]string{
+		{"auth", "secret"},
+		{"config", "set", "maxmemory", "1mb"},
+		{"client", "setname", "tx"},
+		{"acl", "setuser", "tx", "on"},
+		{"info"},
+		{"monitor"},
+	}
+	for _, cmd := range cmds {
+		if got := c.do("multi"); got != "+OK" {
+			t.Fatalf("MULTI = %q", got)
+		}
+		if got := c.do(cmd...); got != "-ERR Command not allowed inside a transaction" {
+			t.Fatalf("%s in MULTI = %q", cmd[0], got)
+		}
+		if got := c.do("exec"); !strings.HasPrefix(got, "-EXECABORT") {
+			t.Fatalf("EXEC after %s = %q", cmd[0], got)
+		}
+	}
+	if got := c.do("config", "get", "maxmemory"); got != "*2" {
+		t.Fatalf("CONFIG GET = %q", got)
+	}
+	if got := c.readLine(); got != "$9" {
+		t.Fatalf("CONFIG GET name = %q", got)
+	}
+	c.readLine()
+	if got := c.readLine(); got != "$1" {
+		t.Fatalf("maxmemory changed inside MULTI, got length line %q", got)
+	}
+	c.readLine()
+	if got := c.do("client", "getname"); got != "$-1" {
+		t.Fatalf("CLIENT GETNAME = %q", got)
+	}
+}
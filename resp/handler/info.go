@@ -0,0 +1,150 @@
+package handler
+
+import (
+	"github.com/jujunwang/Mudis/config"
+	"github.com/jujunwang/Mudis/interface/resp"
+	"github.com/jujunwang/Mudis/lib/sync/atomic"
+	"github.com/jujunwang/Mudis/resp/reply"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// redisVersion 是 INFO 中报告的兼容的 redis 版本
+const redisVersion = "7.0.0"
+
+const (
+	// opsSampleInterval 是统计每秒命令数的采样间隔
+	opsSampleInterval = 100 * time.Millisecond
+	// opsSampleCount 是计算每秒命令数时取平均的采样个数
+	opsSampleCount = 16
+)
+
+// serverStats 记录服务器级别的统计信息
+type serverStats struct {
+	startTime        time.Time
+	connectedClients atomic.Int64
+	totalConns       atomic.Int64
+	rejectedConns    atomic.Int64
+	totalCommands    atomic.Int64
+	// opsPerSec 是最近若干次采样的平均每秒命令数
+	opsPerSec atomic.Int64
+}
+
+// infoSections 是 INFO 支持的 section，按照输出顺序排列
+var infoSections = []string{"server", "clients", "memory", "persistence", "stats", "commandstats", "cluster", "keyspace"}
+
+// nonDefaultSections 只有在 INFO all/everything 或者明确指定时才输出
+var nonDefaultSections = map[string]bool{
+	"commandstats": true,
+}
+
+// execInfo INFO [section [section ...]]
+func (h *RespHandler) execInfo(args [][]byte) resp.Reply {
+	selected := make(map[string]bool)
+	for _, arg := range args {
+		section := strings.ToLower(string(arg))
+		switch section {
+		case "all", "everything":
+			for _, name := range infoSections {
+				selected[name] = true
+			}
+		case "default":
+			for _, name := range infoSections {
+				if !nonDefaultSections[name] {
+					selected[name] = true
+				}
+			}
+		default:
+			selected[section] = true
+		}
+	}
+	var builder strings.Builder
+	for _, section := range infoSections {
+		if (len(args) > 0 && !selected[section]) || (len(args) == 0 && nonDefaultSections[section]) {
+			continue
+		}
+		if builder.Len() > 0 {
+			builder.WriteString("\r\n")
+		}
+		builder.WriteString("# " + strings.ToUpper(section[:1]) + section[1:] + "\r\n")
+		lines := append(h.infoSection(section), h.db.Info(section)...)
+		for _, line := range lines {
+			builder.WriteString(line + "\r\n")
+		}
+	}
+	return reply.MakeBulkReply([]byte(builder.String()))
+}
+
+// infoSection 返回由 RespHandler 统计的字段
+func (h *RespHandler) infoSection(section string) []string {
+	switch section {
+	case "server":
+		mode := "standalone"
+		if h.clusterMode {
+			mode = "cluster"
+		}
+		uptime := int64(time.Since(h.stats.startTime) / time.Second)
+		return []string{
+			"redis_version:" + redisVersion,
+			"redis_mode:" + mode,
+			"os:" + runtime.GOOS,
+			"arch_bits:" + strconv.Itoa(32<<(^uint(0)>>63)),
+			"go_version:" + runtime.Version(),
+			"process_id:" + strconv.Itoa(os.Getpid()),
+			"tcp_port:" + strconv.Itoa(config.Properties().Port),
+			"uptime_in_seconds:" + strconv.FormatInt(uptime, 10),
+			"uptime_in_days:" + strconv.FormatInt(uptime/(24*3600), 10),
+		}
+	case "clients":
+		return []string{
+			"connected_clients:" + strconv.FormatInt(h.stats.connectedClients.Get(), 10),
+			"maxclients:" + strconv.Itoa(config.Properties().MaxClients),
+		}
+	case "stats":
+		return []string{
+			"total_connections_received:" + strconv.FormatInt(h.stats.totalConns.Get(), 10),
+			"total_commands_processed:" + strconv.FormatInt(h.stats.totalCommands.Get(), 10),
+			"instantaneous_ops_per_sec:" + strconv.FormatInt(h.stats.opsPerSec.Get(), 10),
+			"rejected_connections:" + strconv.FormatInt(h.stats.rejectedConns.Get(), 10),
+		}
+	case "cluster":
+		enabled := "0"
+		if h.clusterMode {
+			enabled = "1"
+		}
+		return []string{"cluster_enabled:" + enabled}
+	}
+	return nil
+}
+
+// trackOps 定期采样已执行的命令数，用最近 opsSampleCount 次采样的平均值作为每秒命令数
+func (h *RespHandler) trackOps() {
+	ticker := time.NewTicker(opsSampleInterval)
+	defer ticker.Stop()
+	samples := make([]int64, opsSampleCount)
+	index := 0
+	lastCount := h.stats.totalCommands.Get()
+	lastTime := time.Now()
+	for {
+		select {
+		case now := <-ticker.C:
+			count := h.stats.totalCommands.Get()
+			elapsed := now.Sub(lastTime).Milliseconds()
+			if elapsed > 0 {
+				samples[index%opsSampleCount] = (count - lastCount) * 1000 / elapsed
+				index++
+			}
+			lastCount, lastTime = count, now
+			sum := int64(0)
+			for _, sample := range samples {
+				sum += sample
+			}
+			h.stats.opsPerSec.Set(sum / opsSampleCount)
+		case <-h.closeChan:
+			return
+		}
+	}
+}
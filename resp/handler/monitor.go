@@ -0,0 +1,170 @@
+package handler
+
+import (
+	"fmt"
+	"github.com/jujunwang/Mudis/interface/resp"
+	"github.com/jujunwang/Mudis/lib/logger"
+	"github.com/jujunwang/Mudis/lib/sync/atomic"
+	"github.com/jujunwang/Mudis/resp/connection"
+	"github.com/jujunwang/Mudis/resp/reply"
+	"strings"
+	"sync"
+	"time"
+)
+
+// monitorBufferSize 是每个 MONITOR 客户端最多缓冲的命令数
+// 客户端接收过慢导致缓冲区满时断开连接，避免拖慢命令的执行
+const monitorBufferSize = 1024
+
+// monitorHiddenCommands 是不输出到 MONITOR 的管理命令
+var monitorHiddenCommands = map[string]struct{}{
+	"acl":     {},
+	"client":  {},
+	"config":  {},
+	"slowlog": {},
+	"monitor": {},
+}
+
+// monitor 是一个执行了 MONITOR 的客户端，由单独的 goroutine 将 feed 中的命令发送给客户端
+type monitor struct {
+	client   *connection.Connection
+	feed     chan []byte
+	overflow atomic.Boolean
+}
+
+// monitorHub 保存所有的 MONITOR 客户端
+type monitorHub struct {
+	// mu 保护 monitors，向 feed 发送命令时持有读锁，注册和注销时持有写锁
+	mu       sync.RWMutex
+	monitors map[*connection.Connection]*monitor
+	// count 是 MONITOR 客户端的数量，没有 MONITOR 客户端时执行命令不需要加锁
+	count atomic.Int64
+}
+
+// execMonitor MONITOR
+func (h *RespHandler) execMonitor(client *connection.Connection, args [][]byte) resp.Reply {
+	if len(args) != 0 {
+		return reply.MakeArgNumErrReply("monitor")
+	}
+	if client.InMultiState() {
+		return reply.MakeErrReply("ERR Command not allowed inside a transaction")
+	}
+	hub := &h.monitors
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	if _, ok := hub.monitors[client]; ok {
+		return reply.MakeOkReply()
+	}
+	if hub.monitors == nil {
+		hub.monitors = make(map[*connection.Connection]*monitor)
+	}
+	m := &monitor{
+		client: client,
+		feed:   make(chan []byte, monitorBufferSize),
+	}
+	// OK 也由 monitor 的 goroutine 发送，保证它在所有命令之前
+	m.feed <- reply.MakeOkReply().ToBytes()
+	hub.monitors[client] = m
+	hub.count.Add(1)
+	client.SetMonitor()
+	go m.run()
+	return &reply.NoReply{}
+}
+
+// run 将命令发送给客户端，直到 feed 被关闭
+func (m *monitor) run() {
+	for b := range m.feed {
+		_ = m.client.Write(b)
+	}
+}
+
+// removeMonitor 在连接断开时注销 MONITOR 客户端
+func (h *RespHandler) removeMonitor(client *connection.Connection) {
+	hub := &h.monitors
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	m, ok := hub.monitors[client]
+	if !ok {
+		return
+	}
+	delete(hub.monitors, client)
+	hub.count.Add(-1)
+	close(m.feed)
+}
+
+// feedMonitors 将客户端执行的命令发送给所有的 MONITOR 客户端
+// AOF 加载时由 FakeConn 执行的命令直接交给 db，不会经过这里
+func (h *RespHandler) feedMonitors(client *connection.Connection, cmdName string, cmdLine [][]byte, now time.Time) {
+	hub := &h.monitors
+	if hub.count.Get() == 0 {
+		return
+	}
+	if _, ok := monitorHiddenCommands[cmdName]; ok {
+		return
+	}
+	line := []byte(formatMonitorLine(client, cmdName, cmdLine, now))
+	hub.mu.RLock()
+	defer hub.mu.RUnlock()
+	for _, m := range hub.monitors {
+		select {
+		case m.feed <- line:
+		default:
+			if !m.overflow.Get() {
+				m.overflow.Set(true)
+				logger.Warn("monitor client " + m.client.RemoteAddr().String() + " is too slow, closing it")
+				// 发送中的回复可能一直阻塞，不能等待它完成
+				_ = m.client.ForceClose()
+			}
+		}
+	}
+}
+
+// formatMonitorLine 按照 redis 的格式生成一行 MONITOR 输出，例如:
+// +1339518083.107412 [0 127.0.0.1:60866] "set" "key" "value"
+func formatMonitorLine(client *connection.Connection, cmdName string, cmdLine [][]byte, now time.Time) string {
+	var builder strings.Builder
+	builder.WriteString(fmt.Sprintf("+%d.%06d [%d %s]", now.Unix(), now.Nanosecond()/1000,
+		client.GetDBIndex(), client.RemoteAddr()))
+	for i, arg := range cmdLine {
+		builder.WriteByte(' ')
+		// AUTH 的参数包含密码，不能输出
+		if cmdName == "auth" && i > 0 {
+			builder.WriteString(`"(redacted)"`)
+			continue
+		}
+		builder.WriteString(quoteMonitorArg(arg))
+	}
+	builder.WriteString("\r\n")
+	return builder.String()
+}
+
+// quoteMonitorArg 为参数加上引号并转义其中的特殊字符和不可打印字符
+func quoteMonitorArg(arg []byte) string {
+	var builder strings.Builder
+	builder.WriteByte('"')
+	for _, b := range arg {
+		switch b {
+		case '\\', '"':
+			builder.WriteByte('\\')
+			builder.WriteByte(b)
+		case '\n':
+			builder.WriteString(`\n`)
+		case '\r':
+			builder.WriteString(`\r`)
+		case '\t':
+			builder.WriteString(`\t`)
+		case '\a':
+			builder.WriteString(`\a`)
+		case '\b':
+			builder.WriteString(`\b`)
+		default:
+			if b < 0x20 || b >= 0x7f {
+				builder.WriteString(fmt.Sprintf(`\x%02x`, b))
+			} else {
+				builder.WriteByte(b)
+			}
+		}
+	}
+	builder.WriteByte('"')
+	return builder.String()
+}
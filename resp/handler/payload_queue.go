@@ -0,0 +1,102 @@
+package handler
+
+import (
+	"github.com/jujunwang/Mudis/resp/parser"
+	"github.com/jujunwang/Mudis/resp/reply"
+	"sync"
+)
+
+const (
+	// maxPendingPayloads 是一个连接最多缓存的未执行命令数
+	maxPendingPayloads = 1024
+	// maxPendingBytes 是一个连接缓存的未执行命令的参数总长度上限，队列为空时单条命令不受限制
+	maxPendingBytes = 1 << 20
+)
+
+// payloadQueue 缓存连接已经解析但尚未执行的命令
+// 执行 BLPOP 等阻塞命令期间，接收 goroutine 仍然可以继续读取少量流水线命令，从而及时读到 EOF 并标记连接关闭。
+// 队列满时接收 goroutine 阻塞，不再读取连接，由 TCP 的流量控制让发送过快的客户端等待
+type payloadQueue struct {
+	mu     sync.Mutex
+	items  []*parser.Payload
+	size   int
+	closed bool
+	// ready 在写入命令或关闭队列时收到通知
+	ready chan struct{}
+	// space 在取出命令后收到通知
+	space chan struct{}
+}
+
+func makePayloadQueue() *payloadQueue {
+	return &payloadQueue{
+		ready: make(chan struct{}, 1),
+		space: make(chan struct{}, 1),
+	}
+}
+
+// payloadSize 返回命令参数的总长度
+func payloadSize(payload *parser.Payload) int {
+	r, ok := payload.Data.(*reply.MultiBulkReply)
+	if !ok {
+		return 0
+	}
+	size := 0
+	for _, arg := range r.Args {
+		size += len(arg)
+	}
+	return size
+}
+
+// push 向队列末尾写入命令，队列已满时阻塞直到执行 goroutine 取出命令
+func (q *payloadQueue) push(payload *parser.Payload) {
+	size := payloadSize(payload)
+	for {
+		q.mu.Lock()
+		if len(q.items) == 0 || (len(q.items) < maxPendingPayloads && q.size+size <= maxPendingBytes) {
+			q.items = append(q.items, payload)
+			q.size += size
+			q.mu.Unlock()
+			notify(q.ready)
+			return
+		}
+		q.mu.Unlock()
+		<-q.space
+	}
+}
+
+// close 关闭队列，队列中剩余的命令仍然可以被取出
+func (q *payloadQueue) close() {
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+	notify(q.ready)
+}
+
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// pop 取出队列头部的命令，队列为空时阻塞，队列为空且已关闭时返回 false
+func (q *payloadQueue) pop() (*parser.Payload, bool) {
+	for {
+		q.mu.Lock()
+		if len(q.items) > 0 {
+			payload := q.items[0]
+			q.items[0] = nil
+			q.items = q.items[1:]
+			q.size -= payloadSize(payload)
+			q.mu.Unlock()
+			notify(q.space)
+			return payload, true
+		}
+		closed := q.closed
+		q.mu.Unlock()
+		if closed {
+			return nil, false
+		}
+		<-q.ready
+	}
+}
@@ -0,0 +1,67 @@
+package handler
+
+import (
+	"github.com/jujunwang/Mudis/lib/utils"
+	"github.com/jujunwang/Mudis/resp/parser"
+	"github.com/jujunwang/Mudis/resp/reply"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPayloadQueueBlocksWhenFull(t *testing.T) {
+	makePayload := func(size int) *parser.Payload {
+		return &parser.Payload{Data: reply.MakeMultiBulkReply(utils.ToCmdLine("set", "k", strings.Repeat("v", size)))}
+	}
+	tests := []struct {
+		name string
+		// fill 中的命令写入后队列已满
+		fill []*parser.Payload
+	}{
+		{
+			name: "command count",
+			fill: func() []*parser.Payload {
+				payloads := make([]*parser.Payload, maxPendingPayloads)
+				for i := range payloads {
+					payloads[i] = makePayload(0)
+				}
+				return payloads
+			}(),
+		},
+		{
+			name: "payload bytes",
+			// 参数总长度恰好达到上限，SET 和 key 共占 4 字节
+			fill: []*parser.Payload{makePayload(maxPendingBytes/2 - 4), makePayload(maxPendingBytes/2 - 4)},
+		},
+		{
+			name: "single large payload",
+			fill: []*parser.Payload{makePayload(maxPendingBytes * 2)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := makePayloadQueue()
+			for _, payload := range tt.fill {
+				q.push(payload)
+			}
+			pushed := make(chan struct{})
+			go func() {
+				q.push(makePayload(1))
+				close(pushed)
+			}()
+			select {
+			case <-pushed:
+				t.Fatal("push did not block on a full queue")
+			case <-time.After(20 * time.Millisecond):
+			}
+			if _, ok := q.pop(); !ok {
+				t.Fatal("pop failed")
+			}
+			select {
+			case <-pushed:
+			case <-time.After(time.Second):
+				t.Fatal("push was not resumed after pop")
+			}
+		})
+	}
+}
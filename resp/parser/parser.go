@@ -32,6 +32,8 @@ type readState struct {
 	msgType           byte
 	args              [][]byte
 	bulkLen           int64
+	// readingBody 表示下一行是批量字符串的内容，而不是 $ 开头的长度
+	readingBody bool
 }
 
 // 用来表示解析是否完成
@@ -200,11 +202,13 @@ func parseBulkHeader(msg []byte, state *readState) error {
 	}
 	if state.bulkLen == -1 { // null bulk
 		return nil
-	} else if state.bulkLen > 0 {
+	} else if state.bulkLen >= 0 {
+		// 长度为 0 时内容是一个空行
 		state.msgType = msg[0]
 		state.readingMultiLine = true
 		state.expectedArgsCount = 1
 		state.args = make([][]byte, 0, 1)
+		state.readingBody = true
 		return nil
 	} else {
 		return errors.New("protocol error: " + string(msg))
@@ -241,15 +245,22 @@ func parseSingleLineReply(msg []byte) (resp.Reply, error) {
 func readBody(msg []byte, state *readState) error {
 	line := msg[0 : len(msg)-2]
 	var err error
-	if line[0] == '$' {
+	if state.readingBody {
+		// 内容本身可能以 $ 开头，由读取状态而不是首字节决定
+		state.readingBody = false
+		state.args = append(state.args, line)
+	} else if len(line) > 0 && line[0] == '$' {
 		// 多行回复
 		state.bulkLen, err = strconv.ParseInt(string(line[1:]), 10, 64)
 		if err != nil {
 			return errors.New("protocol error: " + string(msg))
 		}
-		if state.bulkLen <= 0 { // null bulk in multi bulks
+		if state.bulkLen < 0 { // null bulk in multi bulks
 			state.args = append(state.args, []byte{})
 			state.bulkLen = 0
+		} else {
+			// 长度为 0 时内容是一个空行
+			state.readingBody = true
 		}
 	} else {
 		state.args = append(state.args, line)
@@ -0,0 +1,77 @@
+package parser
+
+import (
+	"bytes"
+	"github.com/jujunwang/Mudis/resp/reply"
+	"strings"
+	"testing"
+)
+
+func TestParseStreamBulkStrings(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		// args 是解析出的参数，nil 表示应当解析为空值
+		args []string
+	}{
+		{
+			name:  "dollar prefixed argument",
+			input: "*5\r\n$5\r\nXREAD\r\n$7\r\nSTREAMS\r\n$1\r\nk\r\n$1\r\n$\r\n$2\r\n$5\r\n",
+			args:  []string{"XREAD", "STREAMS", "k", "$", "$5"},
+		},
+		{
+			name:  "empty argument",
+			input: "*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$0\r\n\r\n",
+			args:  []string{"SET", "k", ""},
+		},
+		{
+			name:  "null argument",
+			input: "*2\r\n$-1\r\n$1\r\nk\r\n",
+			args:  []string{"", "k"},
+		},
+		{
+			name:  "dollar prefixed bulk",
+			input: "$4\r\n$abc\r\n",
+			args:  []string{"$abc"},
+		},
+		{
+			name:  "empty bulk",
+			input: "$0\r\n\r\n",
+			args:  []string{""},
+		},
+		{
+			name:  "null bulk",
+			input: "$-1\r\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 第二条命令检查前一条命令之后的读取状态已经重置
+			ch := ParseStream(bytes.NewReader([]byte(tt.input + "*1\r\n$4\r\nPING\r\n")))
+			payload := <-ch
+			if payload.Err != nil {
+				t.Fatalf("parse error: %v", payload.Err)
+			}
+			var args []string
+			switch data := payload.Data.(type) {
+			case *reply.MultiBulkReply:
+				for _, arg := range data.Args {
+					args = append(args, string(arg))
+				}
+			case *reply.BulkReply:
+				args = []string{string(data.Arg)}
+			case *reply.NullBulkReply:
+			default:
+				t.Fatalf("unexpected reply %T", payload.Data)
+			}
+			if strings.Join(args, ",") != strings.Join(tt.args, ",") || len(args) != len(tt.args) {
+				t.Fatalf("args = %q, want %q", args, tt.args)
+			}
+			next := <-ch
+			ping, ok := next.Data.(*reply.MultiBulkReply)
+			if next.Err != nil || !ok || len(ping.Args) != 1 || string(ping.Args[0]) != "PING" {
+				t.Fatalf("next payload = %+v", next)
+			}
+		})
+	}
+}
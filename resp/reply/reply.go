@@ -7,7 +7,7 @@ import (
 )
 
 var (
-	nullBulkReplyBytes = []byte("$-1")
+	nullBulkReplyBytes = []byte("$-1\r\n")
 
 	// CRLF 是redis序列化协议的行分隔符
 	CRLF = "\r\n"
@@ -27,9 +27,9 @@ func MakeBulkReply(arg []byte) *BulkReply {
 	}
 }
 
-// ToBytes 解析 redis.Reply
+// ToBytes 解析 redis.Reply，Arg 为 nil 时返回空值，长度为 0 时返回空字符串
 func (r *BulkReply) ToBytes() []byte {
-	if len(r.Arg) == 0 {
+	if r.Arg == nil {
 		return nullBulkReplyBytes
 	}
 	return []byte("$" + strconv.Itoa(len(r.Arg)) + CRLF + string(r.Arg) + CRLF)
@@ -64,6 +64,31 @@ func (r *MultiBulkReply) ToBytes() []byte {
 	return buf.Bytes()
 }
 
+/* ---- Multi Raw Reply ---- */
+
+// MultiRawReply 存储一个 reply 列表，其中的元素可以是任意类型的 reply，例如 EXEC 的结果
+type MultiRawReply struct {
+	Replies []resp.Reply
+}
+
+// MakeMultiRawReply 新建一个 MultiRawReply
+func MakeMultiRawReply(replies []resp.Reply) *MultiRawReply {
+	return &MultiRawReply{
+		Replies: replies,
+	}
+}
+
+// ToBytes 解析 redis.Reply
+func (r *MultiRawReply) ToBytes() []byte {
+	argLen := len(r.Replies)
+	var buf bytes.Buffer
+	buf.WriteString("*" + strconv.Itoa(argLen) + CRLF)
+	for _, arg := range r.Replies {
+		buf.Write(arg.ToBytes())
+	}
+	return buf.Bytes()
+}
+
 /* ---- Status Reply ---- */
 
 // StatusReply 存储一个string来表示状态
@@ -0,0 +1,22 @@
+package reply
+
+import "testing"
+
+func TestBulkReplyToBytes(t *testing.T) {
+	tests := []struct {
+		name string
+		arg  []byte
+		want string
+	}{
+		{"nil", nil, "$-1\r\n"},
+		{"empty", []byte{}, "$0\r\n\r\n"},
+		{"value", []byte("$5"), "$2\r\n$5\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(MakeBulkReply(tt.arg).ToBytes()); got != tt.want {
+				t.Fatalf("ToBytes() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}